@@ -1,6 +1,10 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -8,17 +12,93 @@ import (
 // TODO: If ClientOptions will no longer be common to use in all the version, it will need to be
 //  implemented in version packages
 type ClientOptions struct {
-	Uri             string
-	Platform        string
-	AuthnMode       string
-	RequestFile     string
-	RequestYaml     string
-	Jwt             string
-	ConfigFile      string
-	MonitorInterval time.Duration
-	Visualization   bool
-	FilterMode      string
-	FilterPattern   string
+	Uri                string
+	Platform           string
+	AuthnMode          string
+	RequestFile        string
+	RequestYaml        string
+	Jwt                string
+	ConfigFile         string
+	MonitorInterval    time.Duration
+	Visualization      bool
+	FilterMode         string
+	FilterPattern      string
+	OtelEndpoint       string
+	OutputFormat       string
+	DrainStream        bool
+	DrainTimeout       time.Duration
+	MetaMissing        string
+	Verbose            bool
+	MonitorOutputDir   string
+	OnlyLastCycle      bool
+	SelfDiff           time.Duration
+	SelfDiffFail       bool
+	RouteTable         bool
+	ProbePath          string
+	ProbeMethod        string
+	ProbeHeaders       []string
+	SqliteOut          string
+	AssertConsistent   bool
+	Transform          string
+	RequestMode        string
+	Color              string
+	FailOn             string
+	SummaryOnly        bool
+	XdsType            string
+	FilterInvert       bool
+	FilterIgnoreCase   bool
+	MonitorCount       int
+	MonitorDiff        bool
+	ClientCert         string
+	ClientKey          string
+	CaCert             string
+	ServiceAccountFile string
+	ConnectTimeout     time.Duration
+	Proxy              string
+	StrictTypes        bool
+	UTC                bool
+	ShowErrors         bool
+	DumpRaw            bool
+	MaxRetries         int
+	Sort               string
+	Limit              int
+	Offset             int
+	InputFile          string
+	MetricsAddr        string
+	Timing             bool
+	Concurrency        int
+	Stream             bool
+	Quiet              bool
+	JwtEnv             string
+	DryRun             bool
+	WaitForClients     time.Duration
+	WaitInterval       time.Duration
+	FailOnNoClients    bool
+	StatusFilter       string
+	Watch              bool
+	RawAny             bool
+	Redact             bool
+	Columns            string
+	FixedWidth         bool
+	NoTruncate         bool
+	Compress           bool
+	MaxRecvBytes       int
+	KeepaliveInterval  time.Duration
+	KeepaliveTimeout   time.Duration
+	CheckConnectivity  bool
+	RequestTimeout     time.Duration
+	ExcludeContents    bool
+	ShowVersion        bool
+	VersionSkew        bool
+	DumpDir            string
+	ResourceName       string
+	ResourceNameMode   string
+	LenientYaml        bool
+	Template           string
+	TolerateStaleFor   time.Duration
+	NodeId             string
+	RequestRetries     int
+	ShowLocality       bool
 }
 
 // Client implements CSDS Client of a particular version. Upon creation of the new client it is
@@ -27,4 +107,98 @@ type Client interface {
 	// Run must send an CSDS request to the server and output the response according to the
 	// options provided during Client creation.
 	Run() error
+	// RunContext must behave as Run, and stop the request when ctx is done.
+	RunContext(ctx context.Context) error
+}
+
+// ErrChangesDetected is returned by Run when -self_diff detected changes between the two
+// responses and SelfDiffFail is set
+var ErrChangesDetected = errors.New("changes detected between the two responses")
+
+// ErrInconsistentVersions is returned by Run when -assert_consistent found matched clients whose
+// config versions diverge
+var ErrInconsistentVersions = errors.New("config versions are inconsistent across the matched clients")
+
+// ErrNoClients is returned by Run when -fail_on_no_clients is set and the response has no client,
+// including once -wait_for_clients timed out
+var ErrNoClients = errors.New("no xDS clients connected")
+
+// ErrAuthFailed is returned by Run when -check_connectivity reached the server but failed to authenticate:
+// the credentials couldn't be loaded, the TLS handshake failed, or the server rejected the request with the
+// PERMISSION_DENIED or UNAUTHENTICATED code
+var ErrAuthFailed = errors.New("authentication to the server failed")
+
+// ErrUnreachable is returned by Run when -check_connectivity couldn't connect to the server, or got no
+// response within -connect_timeout
+var ErrUnreachable = errors.New("the server is unreachable")
+
+// StatusError is returned by Run when a matched client reports one of the config statuses of -fail_on.
+// Library callers can get it with errors.As.
+type StatusError struct {
+	// Statuses are the config statuses of -fail_on that were found, in sorted order
+	Statuses []string
+}
+
+// Error implements error
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("found clients with config status %s", strings.Join(e.Statuses, ", "))
+}
+
+// RequestError is returned by New when the csds request fails more than one validation, so that all the
+// problems can be fixed at once. Library callers can get it with errors.As and inspect Errs.
+type RequestError struct {
+	// Errs are the problems found in the request, in the order they were checked
+	Errs []error
+}
+
+// Error implements error, listing each problem on its own line
+func (e *RequestError) Error() string {
+	lines := []string{fmt.Sprintf("found %d problems in the request:", len(e.Errs))}
+	for _, err := range e.Errs {
+		lines = append(lines, "  - "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Is reports whether any of the problems of the request matches target, so that errors.Is matches each of them
+func (e *RequestError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the problems of the request that matches target, so that errors.As matches each of them
+func (e *RequestError) As(target interface{}) bool {
+	for _, err := range e.Errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// JoinRequestErrors returns nil if errs holds no error, its error if it holds only one, and a *RequestError
+// of all of them otherwise. The problems of a nested *RequestError are listed individually.
+func JoinRequestErrors(errs ...error) error {
+	var flat []error
+	for _, err := range errs {
+		var requestErr *RequestError
+		switch {
+		case err == nil:
+		case errors.As(err, &requestErr):
+			flat = append(flat, requestErr.Errs...)
+		default:
+			flat = append(flat, err)
+		}
+	}
+	switch len(flat) {
+	case 0:
+		return nil
+	case 1:
+		return flat[0]
+	}
+	return &RequestError{Errs: flat}
 }
@@ -0,0 +1,114 @@
+package util
+
+import (
+	// the TypedStruct configs of the filters are decoded once their types are registered
+	_ "github.com/cncf/xds/go/udpa/type/v1"
+	_ "github.com/cncf/xds/go/xds/type/v3"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// unresolvedAnyType is the message the google.protobuf.Any values whose type can't be decoded are rendered as:
+// their value is wrapped into the single bytes field of an UnresolvedAny, which protojson prints as base64
+// next to the @type of the original type url
+var unresolvedAnyType = newUnresolvedAnyType()
+
+// newUnresolvedAnyType builds the dynamic type of unresolvedAnyType
+func newUnresolvedAnyType() protoreflect.MessageType {
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("csds_client/unresolved_any.proto"),
+		Package: proto.String("csds_client"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("UnresolvedAny"),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("value"),
+				JsonName: proto.String("value"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_BYTES.Enum(),
+			}},
+		}},
+	}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return dynamicpb.NewMessageType(file.Messages().Get(0))
+}
+
+// rawAnyResolver renders every google.protobuf.Any as an UnresolvedAny, for -resolve_any=false
+type rawAnyResolver struct {
+	TypeResolver
+}
+
+// FindMessageByURL returns unresolvedAnyType whatever the url
+func (r *rawAnyResolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	return unresolvedAnyType, nil
+}
+
+// anyResolver returns the resolver of the google.protobuf.Any types in the detailed config
+func anyResolver(resolveAny bool) interface {
+	protoregistry.MessageTypeResolver
+	protoregistry.ExtensionTypeResolver
+} {
+	if resolveAny {
+		return &TypeResolver{}
+	}
+	return &rawAnyResolver{}
+}
+
+// prepareAny rewrites the google.protobuf.Any values of msg in place so that resolver renders them: the
+// values of the types it decodes are prepared recursively, and the others are wrapped into an UnresolvedAny.
+// msg must not be shared with the caller of the marshaling.
+func prepareAny(msg protoreflect.Message, resolver protoregistry.MessageTypeResolver) {
+	if msg.Descriptor().FullName() == "google.protobuf.Any" {
+		prepareAnyValue(msg, resolver)
+		return
+	}
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				prepareAny(list.Get(i).Message(), resolver)
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, value protoreflect.Value) bool {
+				prepareAny(value.Message(), resolver)
+				return true
+			})
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil:
+			prepareAny(v.Message(), resolver)
+		}
+		return true
+	})
+}
+
+// prepareAnyValue rewrites the value of the google.protobuf.Any any, see prepareAny. A value that doesn't
+// decode as its type is wrapped too, so that a corrupt resource is still printed.
+func prepareAnyValue(any protoreflect.Message, resolver protoregistry.MessageTypeResolver) {
+	fields := any.Descriptor().Fields()
+	typeUrl, value := fields.ByName("type_url"), fields.ByName("value")
+
+	url := any.Get(typeUrl).String()
+	raw := any.Get(value).Bytes()
+	if mt, err := resolver.FindMessageByURL(url); err == nil && mt != unresolvedAnyType {
+		inner := mt.New()
+		if err := proto.Unmarshal(raw, inner.Interface()); err == nil {
+			prepareAny(inner, resolver)
+			if out, err := (proto.MarshalOptions{Deterministic: true}).Marshal(inner.Interface()); err == nil {
+				any.Set(value, protoreflect.ValueOfBytes(out))
+				return
+			}
+		}
+	}
+	wrapped := unresolvedAnyType.New()
+	wrapped.Set(wrapped.Descriptor().Fields().ByNumber(1), protoreflect.ValueOfBytes(raw))
+	out, _ := proto.Marshal(wrapped.Interface())
+	any.Set(value, protoreflect.ValueOfBytes(out))
+}
@@ -0,0 +1,192 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"runtime"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// splitDepth is how many levels of messages MarshalDetailedConfig splits into parts marshaled concurrently:
+// the client configs of a response, and the resources of each client config
+const splitDepth = 2
+
+// jsonPart is a piece of the JSON document of a message: a compact fragment marshaled by a worker, either a
+// whole message or a `"name":value` member, or an object or an array of members and elements to assemble
+type jsonPart struct {
+	fragment []byte
+	member   bool
+	name     string
+	members  []*jsonPart
+	elements []*jsonPart
+	array    bool
+}
+
+// marshalTask marshals msg into the fragment of part. Member parts hold a copy of the message with a single
+// field set, whose braces are stripped from the fragment.
+type marshalTask struct {
+	part *jsonPart
+	msg  proto.Message
+}
+
+// MarshalDetailedConfig marshals response to JSON indented by 2 spaces, resolving the google.protobuf.Any
+// types with TypeResolver if resolveAny is set. The values of the other Any types are printed in base64 next
+// to their type url. Unlike the multiline format of protojson, the whitespace is stable across builds.
+// The client configs and their resources are marshaled by up to concurrency workers, GOMAXPROCS if it isn't
+// positive, since a large response carries thousands of them. The parts are assembled in field and list
+// order, so that the output is the same whatever the concurrency.
+func MarshalDetailedConfig(response proto.Message, concurrency int, resolveAny bool) ([]byte, error) {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	// the Any values are rewritten by the workers
+	response = proto.Clone(response)
+	var tasks []marshalTask
+	root := splitMessage(response.ProtoReflect(), splitDepth, &tasks)
+
+	resolver := anyResolver(resolveAny)
+	m := protojson.MarshalOptions{Resolver: resolver}
+	errs := make([]error, len(tasks))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(tasks); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range next {
+				task := tasks[j]
+				prepareAny(task.msg.ProtoReflect(), resolver)
+				out, err := m.Marshal(task.msg)
+				if err != nil {
+					errs[j] = err
+					continue
+				}
+				if task.part.member {
+					// strip the braces of the message holding the single member
+					out = bytes.TrimSpace(out)
+					out = bytes.TrimSpace(out[1 : len(out)-1])
+				}
+				task.part.fragment = out
+			}
+		}()
+	}
+	for j := range tasks {
+		next <- j
+	}
+	close(next)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var compact bytes.Buffer
+	root.writeTo(&compact)
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, compact.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	return indented.Bytes(), nil
+}
+
+// splittable reports whether the fields of msg can be marshaled separately. The well-known types have
+// JSON mappings of their own, e.g. google.protobuf.Any, so they're marshaled whole.
+func splittable(msg protoreflect.Message) bool {
+	return msg.Descriptor().FullName().Parent() != "google.protobuf"
+}
+
+// splitMessage splits msg into the parts marshaled by tasks, its fields and list items down to depth levels
+func splitMessage(msg protoreflect.Message, depth int, tasks *[]marshalTask) *jsonPart {
+	if depth == 0 || !splittable(msg) {
+		part := &jsonPart{}
+		*tasks = append(*tasks, marshalTask{part: part, msg: msg.Interface()})
+		return part
+	}
+	object := &jsonPart{}
+	fields := msg.Descriptor().Fields()
+	// protojson marshals the fields in the order they're declared in
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if !msg.Has(fd) {
+			continue
+		}
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			array := &jsonPart{name: fd.JSONName(), array: true}
+			list := msg.Get(fd).List()
+			for j := 0; j < list.Len(); j++ {
+				array.elements = append(array.elements, splitMessage(list.Get(j).Message(), depth-1, tasks))
+			}
+			object.members = append(object.members, array)
+		case !fd.IsList() && !fd.IsMap() && fd.Message() != nil && splittable(msg.Get(fd).Message()):
+			member := splitMessage(msg.Get(fd).Message(), depth-1, tasks)
+			member.name = fd.JSONName()
+			object.members = append(object.members, member)
+		default:
+			// a copy of msg holding only this field is marshaled to get the JSON of its value
+			single := msg.Type().New()
+			single.Set(fd, msg.Get(fd))
+			member := &jsonPart{member: true}
+			*tasks = append(*tasks, marshalTask{part: member, msg: single.Interface()})
+			object.members = append(object.members, member)
+		}
+	}
+	return object
+}
+
+// writeTo writes the compact JSON of p to buf
+func (p *jsonPart) writeTo(buf *bytes.Buffer) {
+	if p.name != "" {
+		name, _ := json.Marshal(p.name)
+		buf.Write(name)
+		buf.WriteByte(':')
+	}
+	switch {
+	case p.fragment != nil:
+		buf.Write(p.fragment)
+	case p.array:
+		buf.WriteByte('[')
+		for i, element := range p.elements {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+			element.writeTo(buf)
+		}
+		buf.WriteByte(']')
+	default:
+		buf.WriteByte('{')
+		for i, member := range p.members {
+			if i != 0 {
+				buf.WriteByte(',')
+			}
+			member.writeTo(buf)
+		}
+		buf.WriteByte('}')
+	}
+}
+
+// prototextNameSpaces matches the field name starting a line of the multiline text format and the spaces
+// following it, a single one unless the encoder added the random one that makes its output unstable
+var prototextNameSpaces = regexp.MustCompile(`(?m)^( *(?:[\w.]+|\[[^\]]+\]):) +`)
+
+// MarshalPrototext marshals msg to the multiline protobuf text format indented by 2 spaces, resolving the
+// google.protobuf.Any types like MarshalDetailedConfig. The fields are printed in field number order and the
+// map entries sorted by key, and the random extra space of the text format is removed, so that the output
+// of the same message is stable across runs and builds and diffs cleanly.
+func MarshalPrototext(msg proto.Message, resolveAny bool) ([]byte, error) {
+	msg = proto.Clone(msg)
+	resolver := anyResolver(resolveAny)
+	prepareAny(msg.ProtoReflect(), resolver)
+	out, err := prototext.MarshalOptions{Multiline: true, Indent: "  ", Resolver: resolver}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return prototextNameSpaces.ReplaceAll(out, []byte("$1 ")), nil
+}
@@ -0,0 +1,213 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+	"google.golang.org/grpc"
+)
+
+// proxyConnError is the failure to reach the proxy itself, as opposed to the proxy refusing to reach the server
+type proxyConnError struct {
+	proxy string
+	err   error
+}
+
+func (e *proxyConnError) Error() string {
+	return fmt.Sprintf("unable to connect to proxy %s: %v", e.proxy, e.err)
+}
+
+func (e *proxyConnError) Unwrap() error {
+	return e.err
+}
+
+// Temporary tells gRPC not to retry the dial, as the proxy is not expected to come back within the connect timeout
+func (e *proxyConnError) Temporary() bool {
+	return false
+}
+
+// proxyRefusedError is the refusal of the proxy to reach the server
+type proxyRefusedError struct {
+	proxy  string
+	addr   string
+	reason string
+}
+
+func (e *proxyRefusedError) Error() string {
+	return fmt.Sprintf("proxy %s refused to connect to %s: %s", e.proxy, e.addr, e.reason)
+}
+
+// Temporary tells gRPC not to retry the dial, as the proxy would refuse it again
+func (e *proxyRefusedError) Temporary() bool {
+	return false
+}
+
+// ParseProxy parses the url of -proxy, which must be an http:// or a socks5:// url
+func ParseProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %s: %v", SanitizeUri(proxy), err)
+	}
+	switch u.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("%s proxy scheme is not supported, list of supported proxy schemes: http, socks5", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host in proxy %s", SanitizeUri(proxy))
+	}
+	return u, nil
+}
+
+// ProxyDialOption returns the dial option tunneling the connections through the proxy of each server address.
+// The proxy is proxyUri if it's set, otherwise HTTPS_PROXY or ALL_PROXY excluding the hosts of NO_PROXY.
+// Tunneled connections still negotiate TLS with the server itself, as the proxy only relays the bytes.
+func ProxyDialOption(proxyUri string) (grpc.DialOption, error) {
+	var fixed *url.URL
+	if proxyUri != "" {
+		var err error
+		if fixed, err = ParseProxy(proxyUri); err != nil {
+			return nil, err
+		}
+	}
+	environment := environmentProxy()
+
+	return grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		proxyUrl := fixed
+		if proxyUrl == nil {
+			var err error
+			if proxyUrl, err = environment(&url.URL{Scheme: "https", Host: addr}); err != nil {
+				return nil, err
+			}
+		}
+		if proxyUrl == nil {
+			return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		}
+		if proxyUrl.Scheme == "http" {
+			return dialHttpProxy(ctx, proxyUrl, addr)
+		}
+		return dialSocksProxy(ctx, proxyUrl, addr)
+	}), nil
+}
+
+// environmentProxy returns the proxy of HTTPS_PROXY, falling back to ALL_PROXY, for each server url
+func environmentProxy() func(*url.URL) (*url.URL, error) {
+	config := httpproxy.FromEnvironment()
+	for _, key := range []string{"ALL_PROXY", "all_proxy"} {
+		if config.HTTPSProxy == "" {
+			config.HTTPSProxy = os.Getenv(key)
+		}
+	}
+	proxyFunc := config.ProxyFunc()
+	return func(server *url.URL) (*url.URL, error) {
+		proxyUrl, err := proxyFunc(server)
+		if err != nil || proxyUrl == nil {
+			return proxyUrl, err
+		}
+		return ParseProxy(proxyUrl.String())
+	}
+}
+
+// dialHttpProxy opens a tunnel to addr with an HTTP CONNECT request to the proxy proxyUrl
+func dialHttpProxy(ctx context.Context, proxyUrl *url.URL, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", proxyUrl.Host)
+	if err != nil {
+		return nil, &proxyConnError{proxy: proxyUrl.Host, err: err}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := proxyUrl.User; user != nil {
+		password, _ := user.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, &proxyConnError{proxy: proxyUrl.Host, err: err}
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, &proxyConnError{proxy: proxyUrl.Host, err: err}
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, &proxyRefusedError{proxy: proxyUrl.Host, addr: addr, reason: resp.Status}
+	}
+	if reader.Buffered() > 0 {
+		// the server spoke first, keep what was read with the response
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// dialSocksProxy opens a tunnel to addr through the SOCKS5 proxy proxyUrl
+func dialSocksProxy(ctx context.Context, proxyUrl *url.URL, addr string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if user := proxyUrl.User; user != nil {
+		password, _ := user.Password()
+		auth = &proxy.Auth{User: user.Username(), Password: password}
+	}
+	dialer, err := proxy.SOCKS5("tcp", proxyUrl.Host, auth, &proxyForward{proxy: proxyUrl.Host})
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		var connErr *proxyConnError
+		if errors.As(err, &connErr) {
+			return nil, connErr
+		}
+		return nil, &proxyRefusedError{proxy: proxyUrl.Host, addr: addr, reason: err.Error()}
+	}
+	return conn, nil
+}
+
+// proxyForward dials the SOCKS5 proxy, marking the failures as failures to reach the proxy
+type proxyForward struct {
+	proxy string
+}
+
+func (f *proxyForward) Dial(network, addr string) (net.Conn, error) {
+	return f.DialContext(context.Background(), network, addr)
+}
+
+func (f *proxyForward) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, &proxyConnError{proxy: f.proxy, err: err}
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were already read into reader
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,196 @@
+package util
+
+import (
+	udpa_annotations "github.com/cncf/xds/go/udpa/annotations"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// RedactedValue replaces the values of the sensitive fields redacted by -redact
+const RedactedValue = "***REDACTED***"
+
+// secretName is the message of the SDS resources, whose inline data sources are all sensitive
+const secretName protoreflect.FullName = "envoy.extensions.transport_sockets.tls.v3.Secret"
+
+// sensitiveFieldNames are the fields redacted even if they aren't annotated as sensitive, e.g. in the
+// google.protobuf.Struct of a TypedStruct
+var sensitiveFieldNames = map[string]bool{
+	"private_key":   true,
+	"password":      true,
+	"client_secret": true,
+	"api_key":       true,
+}
+
+// Redact returns a copy of msg whose sensitive fields are replaced by RedactedValue: the fields annotated
+// with (udpa.annotations.sensitive), the ones of sensitiveFieldNames, and the inline data sources of the
+// secrets. The google.protobuf.Any values are decoded to be redacted, and the value of the ones whose type
+// isn't known is dropped, since it can't be inspected.
+func Redact(msg proto.Message) proto.Message {
+	clone := proto.Clone(msg)
+	redactMessage(clone.ProtoReflect(), false)
+	return clone
+}
+
+// redactMessage redacts the sensitive fields of msg in place. inSecret is set within a secret.
+func redactMessage(msg protoreflect.Message, inSecret bool) {
+	if msg.Descriptor().FullName() == "google.protobuf.Any" {
+		redactAny(msg, inSecret)
+		return
+	}
+	inSecret = inSecret || msg.Descriptor().FullName() == secretName
+	for _, fd := range populatedFields(msg) {
+		v := msg.Get(fd)
+		switch {
+		case sensitive(fd, inSecret):
+			redactField(msg, fd)
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				redactMessage(list.Get(i).Message(), inSecret)
+			}
+		case fd.IsMap():
+			m := msg.Mutable(fd).Map()
+			for _, key := range mapKeys(m) {
+				switch {
+				// e.g. the fields of a google.protobuf.Struct
+				case fd.MapKey().Kind() == protoreflect.StringKind && sensitiveFieldNames[key.String()]:
+					redactMapValue(m, key, fd.MapValue())
+				case fd.MapValue().Message() != nil:
+					redactMessage(m.Get(key).Message(), inSecret)
+				}
+			}
+		case fd.Message() != nil:
+			redactMessage(v.Message(), inSecret)
+		}
+	}
+}
+
+// redactAny redacts the value of the google.protobuf.Any any once decoded, or drops it if its type isn't known
+func redactAny(any protoreflect.Message, inSecret bool) {
+	fields := any.Descriptor().Fields()
+	typeUrl, value := fields.ByName("type_url"), fields.ByName("value")
+
+	resolver := &TypeResolver{}
+	if mt, err := resolver.FindMessageByURL(any.Get(typeUrl).String()); err == nil && mt != unresolvedAnyType {
+		inner := mt.New()
+		if err := proto.Unmarshal(any.Get(value).Bytes(), inner.Interface()); err == nil {
+			redactMessage(inner, inSecret)
+			if out, err := (proto.MarshalOptions{Deterministic: true}).Marshal(inner.Interface()); err == nil {
+				any.Set(value, protoreflect.ValueOfBytes(out))
+				return
+			}
+		}
+	}
+	any.Clear(value)
+}
+
+// sensitive reports whether the value of fd must be redacted
+func sensitive(fd protoreflect.FieldDescriptor, inSecret bool) bool {
+	if sensitiveFieldNames[string(fd.Name())] {
+		return true
+	}
+	if inSecret && (fd.Name() == "inline_bytes" || fd.Name() == "inline_string") {
+		return true
+	}
+	sensitive, _ := proto.GetExtension(fd.Options(), udpa_annotations.E_Sensitive).(bool)
+	return sensitive
+}
+
+// redactField replaces the value of the field fd of msg, and of its elements for a list or a map
+func redactField(msg protoreflect.Message, fd protoreflect.FieldDescriptor) {
+	switch {
+	case fd.IsList():
+		list := msg.Mutable(fd).List()
+		for i := 0; i < list.Len(); i++ {
+			if fd.Message() != nil {
+				redactAll(list.Get(i).Message())
+			} else {
+				list.Set(i, redactedScalar(fd))
+			}
+		}
+	case fd.IsMap():
+		m := msg.Mutable(fd).Map()
+		for _, key := range mapKeys(m) {
+			redactMapValue(m, key, fd.MapValue())
+		}
+	case fd.Message() != nil:
+		redactAll(msg.Mutable(fd).Message())
+	case fd.Kind() == protoreflect.BytesKind && redactOneofString(msg, fd):
+	default:
+		msg.Set(fd, redactedScalar(fd))
+	}
+}
+
+// redactMapValue replaces the value of key in m, whose values are of type fd
+func redactMapValue(m protoreflect.Map, key protoreflect.MapKey, fd protoreflect.FieldDescriptor) {
+	if fd.Message() != nil {
+		redactAll(m.Mutable(key).Message())
+	} else {
+		m.Set(key, redactedScalar(fd))
+	}
+}
+
+// redactAll redacts every field of msg, a message held by a sensitive field
+func redactAll(msg protoreflect.Message) {
+	switch msg.Descriptor().FullName() {
+	case "google.protobuf.Any":
+		msg.Clear(msg.Descriptor().Fields().ByName("value"))
+		return
+	case "google.protobuf.Value":
+		// a Value must hold one of its kinds
+		msg.Set(msg.Descriptor().Fields().ByName("string_value"), protoreflect.ValueOfString(RedactedValue))
+		return
+	}
+	for _, fd := range populatedFields(msg) {
+		redactField(msg, fd)
+	}
+}
+
+// redactOneofString replaces the bytes field fd of msg by RedactedValue in a string field of the same oneof
+// if there's one, e.g. the inline_string of a DataSource for its inline_bytes, so that it stays readable
+func redactOneofString(msg protoreflect.Message, fd protoreflect.FieldDescriptor) bool {
+	oneof := fd.ContainingOneof()
+	if oneof == nil {
+		return false
+	}
+	for i := 0; i < oneof.Fields().Len(); i++ {
+		if alternative := oneof.Fields().Get(i); alternative.Kind() == protoreflect.StringKind {
+			msg.Set(alternative, protoreflect.ValueOfString(RedactedValue))
+			return true
+		}
+	}
+	return false
+}
+
+// redactedScalar returns RedactedValue as a value of fd if it's a string or bytes field, and the default
+// value of fd otherwise
+func redactedScalar(fd protoreflect.FieldDescriptor) protoreflect.Value {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(RedactedValue)
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(RedactedValue))
+	}
+	return fd.Default()
+}
+
+// populatedFields returns the fields set in msg, so that they can be changed while iterating over them
+func populatedFields(msg protoreflect.Message) []protoreflect.FieldDescriptor {
+	var fields []protoreflect.FieldDescriptor
+	msg.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, fd)
+		return true
+	})
+	return fields
+}
+
+// mapKeys returns the keys of m, so that its values can be changed while iterating over them
+func mapKeys(m protoreflect.Map) []protoreflect.MapKey {
+	var keys []protoreflect.MapKey
+	m.Range(func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMaxRetries is the number of times a failed request is retried if -max_retries is not set
+const DefaultMaxRetries = 5
+
+// Backoff is the exponential backoff between the retries of a request: the n-th retry waits
+// Base * Multiplier^n, at most Max, of which a random half is taken off as jitter
+type Backoff struct {
+	Base       time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// DefaultBackoff is the backoff of the clients, which tests can shorten
+var DefaultBackoff = Backoff{Base: 500 * time.Millisecond, Max: 30 * time.Second, Multiplier: 2}
+
+// Delay returns the delay before the retry following attempt retries, uniformly random in the upper half
+// of the exponential delay, so that the clients that failed at once don't retry at once
+func (b Backoff) Delay(attempt int) time.Duration {
+	delay := float64(b.Base)
+	for i := 0; i < attempt && delay < float64(b.Max); i++ {
+		delay *= b.Multiplier
+	}
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	half := int64(delay / 2)
+	if half <= 0 {
+		return time.Duration(delay)
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// IsRetryable reports whether err of a request is transient, so that the request can be retried on a new
+// stream: the server is unavailable, the request timed out on the server, or the stream was closed by the
+// security policy of the server. Only gRPC status errors are, and not the ones caused by ctx being done,
+// as the caller gave up.
+func IsRetryable(ctx context.Context, err error) bool {
+	if IsTransient(ctx, err) {
+		return true
+	}
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	st, ok := status.FromError(err)
+	return ok && isSecurityPolicyClosure(st)
+}
+
+// IsTransient reports whether the gRPC status code of err is a transient failure of the server, unavailable
+// or timed out, which a request retried right away on the same connection can recover from. Unlike
+// IsRetryable, it doesn't look at the message of err.
+func IsTransient(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// securityPolicyMarker is the name of the control plane policy found in the message of the streams it closes
+const securityPolicyMarker = "RpcSecurityPolicy"
+
+// isSecurityPolicyClosure reports whether st closed a stream because of the RpcSecurityPolicy of the control
+// plane. Traffic Director times out long-lived streams under this policy and accepts a new stream on the same
+// connection. It sends no dedicated code or status detail for it, so the name of the policy in the message
+// is the only signal, and a rewording upstream would turn these closures into non-retryable errors.
+func isSecurityPolicyClosure(st *status.Status) bool {
+	return strings.Contains(st.Message(), securityPolicyMarker)
+}
+
+// Retry handles err of a request with up to maxRetries retries: as long as err is retryable and retries
+// are left, it waits the backoff of the next retry and calls reopen to open a new stream, until reopen
+// succeeds. attempt counts the retries since the last successful request, which the caller resets.
+// Each retry is noted to w, e.g. the InfoWriter of the options so that -quiet silences it.
+// It returns nil once the stream is reopened, and the last error otherwise.
+func Retry(ctx context.Context, w io.Writer, err error, maxRetries int, backoff Backoff, attempt *int, reopen func() error) error {
+	for IsRetryable(ctx, err) && *attempt < maxRetries {
+		if err := Wait(ctx, backoff, *attempt); err != nil {
+			return err
+		}
+		*attempt++
+		fmt.Fprintf(w, "retrying the request (%d/%d) after error: %v\n", *attempt, maxRetries, err)
+		if err = reopen(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Wait waits the backoff of the retry following attempt retries, and returns the error of ctx if it's done first
+func Wait(ctx context.Context, backoff Backoff, attempt int) error {
+	timer := time.NewTimer(backoff.Delay(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
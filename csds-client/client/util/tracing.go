@@ -0,0 +1,81 @@
+package util
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of all spans emitted by the client
+const tracerName = "envoy-tools/csds-client"
+
+// Span attribute keys
+const (
+	PlatformKey    = attribute.Key("csds.platform")
+	UriKey         = attribute.Key("csds.uri")
+	ClientCountKey = attribute.Key("csds.client_count")
+)
+
+// InitTracing installs a global tracer provider exporting spans via OTLP/gRPC to endpoint.
+// If endpoint is empty, the default no-op tracer provider is kept so tracing has no overhead.
+// The returned function flushes and shuts down the exporter, and must be called before exiting.
+func InitTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// TLS and headers of the exporter can be configured by the standard OTEL_EXPORTER_OTLP_* env vars
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpoint(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("csds-client"))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// StartSpan starts a span with the given name and attributes as a child of the span in ctx
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan records err on span if it is not nil and ends the span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// SanitizeUri removes user info and query parameters from uri so that it is safe to record. A uri that
+// fails to parse can't be sanitized, so RedactedValue is returned instead of it.
+func SanitizeUri(uri string) string {
+	if !strings.Contains(uri, "://") {
+		// plain host:port targets can only carry user info before '@'
+		if i := strings.LastIndex(uri, "@"); i >= 0 {
+			return uri[i+1:]
+		}
+		return uri
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return RedactedValue
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
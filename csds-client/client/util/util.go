@@ -3,6 +3,7 @@ package util
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"envoy-tools/csds-client/client"
@@ -12,11 +13,15 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/awalterschulze/gographviz"
 	"github.com/emirpasic/gods/sets/treeset"
@@ -41,15 +46,19 @@ import (
 	envoy_extensions_load_balancing_policies_round_robin_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/load_balancing_policies/round_robin/v3"
 	envoy_extensions_load_balancing_policies_wrr_locality_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/load_balancing_policies/wrr_locality/v3"
 	envoy_extensions_transport_sockets_tls_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	envoy_service_runtime_v3 "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
 	"github.com/ghodss/yaml"
+	"golang.org/x/oauth2/google"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/credentials/oauth"
-	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
-	"google.golang.org/protobuf/types/known/anypb"
 )
 
 // IsJson checks if str is a valid json format string
@@ -75,8 +84,8 @@ func (r *TypeResolver) FindMessageByName(message protoreflect.FullName) (protore
 	return nil, protoregistry.NotFound
 }
 
-// FindMessageByURL links the message type url to the specific message type
-// TODO: If there's other message type can be passed in google.protobuf.Any, the typeUrl and messageType need to be added to this method to make sure it can be parsed and output correctly.
+// FindMessageByURL links the message type url to the specific message type. The other types are looked up in
+// the proto registry, and the ones that aren't linked into the client are rendered as an UnresolvedAny.
 func (r *TypeResolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
 	switch url {
 	case "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy":
@@ -151,9 +160,14 @@ func (r *TypeResolver) FindMessageByURL(url string) (protoreflect.MessageType, e
 	case "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.DownstreamTlsContext":
 		downstreamTlsContext := envoy_extensions_transport_sockets_tls_v3.DownstreamTlsContext{}
 		return downstreamTlsContext.ProtoReflect().Type(), nil
+	case "type.googleapis.com/envoy.service.runtime.v3.Runtime":
+		runtime := envoy_service_runtime_v3.Runtime{}
+		return runtime.ProtoReflect().Type(), nil
 	default:
-		dummy := anypb.Any{}
-		return dummy.ProtoReflect().Type(), nil
+		if mt, err := protoregistry.GlobalTypes.FindMessageByURL(url); err == nil {
+			return mt, nil
+		}
+		return unresolvedAnyType, nil
 	}
 }
 
@@ -367,32 +381,32 @@ func OpenBrowser(url string) error {
 	return nil
 }
 
-// PrintDetailedConfig prints out the detailed xDS config and calls visualize() if it is enabled
-func PrintDetailedConfig(response proto.Message, opts client.ClientOptions) error {
+// PrintDetailedConfig prints out the detailed xDS config to w and calls visualize() if it is enabled
+func PrintDetailedConfig(w io.Writer, response proto.Message, opts client.ClientOptions) error {
+	if opts.Redact {
+		// before the snapshots, the output formats and the visualization are derived from it
+		response = Redact(response)
+	}
 	// parse response to json
 	// format the json and resolve google.protobuf.Any types
-	m := protojson.MarshalOptions{Multiline: true, Indent: "  ", Resolver: &TypeResolver{}}
-	out, err := m.Marshal(response)
+	out, err := MarshalDetailedConfig(response, opts.Concurrency, !opts.RawAny)
 	if err != nil {
 		return err
 	}
 
-	if opts.ConfigFile == "" {
-		// output the configuration to stdout by default
-		fmt.Println("Detailed Config:")
-		fmt.Println(string(out))
-	} else {
-		// write the configuration to the file
-		f, err := os.Create(opts.ConfigFile)
+	if opts.MonitorOutputDir != "" && opts.MonitorInterval != 0 {
+		// save a snapshot of the configuration of each monitor cycle
+		path, err := WriteMonitorSnapshot(opts.MonitorOutputDir, out, opts.OnlyLastCycle, time.Now())
 		if err != nil {
 			return err
 		}
-		defer f.Close()
-		_, err = f.Write(out)
-		if err != nil {
-			return err
+		fmt.Fprintf(InfoWriter(opts), "Config has been saved to %v\n", path)
+	} else {
+		// the structured output formats carry the resources of the clients in their own document, if any
+		if !IsStructuredOutput(opts) {
+			fmt.Fprintln(w, "Detailed Config:")
+			fmt.Fprintln(w, string(out))
 		}
-		fmt.Printf("Config has been saved to %v\n", opts.ConfigFile)
 	}
 
 	// call visualize to enable visualization
@@ -404,43 +418,326 @@ func PrintDetailedConfig(response proto.Message, opts client.ClientOptions) erro
 	return nil
 }
 
-// ConnToGCPWithJwt connects to uri on gcp with jwt authentication
-func ConnToGCPWithJwt(jwt string, uri string) (*grpc.ClientConn, error) {
-	if jwt == "" {
+// OpenOutput opens where the responses are rendered: the -output_file if it's set, stdout otherwise.
+// The file is truncated, unless in monitor mode where the responses of each cycle are appended to it.
+// It isn't buffered, so that the cycles written before the client is interrupted are kept.
+// With -quiet, the output is discarded. The returned function closes the file.
+func OpenOutput(opts client.ClientOptions) (io.Writer, func() error, error) {
+	if opts.Quiet {
+		return ioutil.Discard, func() error { return nil }, nil
+	}
+	if opts.ConfigFile == "" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if opts.MonitorInterval != 0 {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(opts.ConfigFile, flags, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+// clearScreen moves the cursor to the top left corner of the terminal and clears the screen
+const clearScreen = "\x1b[H\x1b[2J"
+
+// IsTerminal reports whether w is a terminal, rather than e.g. a pipe or a regular file
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// PrintWatchHeader starts a cycle of -watch: the screen is cleared if w is a terminal, so that the output
+// is redrawn in place, and a header with the time of the cycle and the monitor interval is printed. When w
+// isn't a terminal, the header only separates the cycles.
+func PrintWatchHeader(w io.Writer, now time.Time, interval time.Duration) {
+	if IsTerminal(w) {
+		fmt.Fprint(w, clearScreen)
+	}
+	fmt.Fprintf(w, "Every %v: %s\n\n", interval, now.UTC().Format(time.RFC3339))
+}
+
+// PrintCycleSeparator separates the responses of the monitor cycles appended to -output_file
+func PrintCycleSeparator(w io.Writer, now time.Time) {
+	fmt.Fprintf(w, "=== %s ===\n", now.UTC().Format(time.RFC3339))
+}
+
+// NotifyMonitorStop returns a channel that is closed on the first SIGINT or SIGTERM, so that monitor mode
+// can stop after the current cycle. Later signals get their default behavior back, so that a second Ctrl-C
+// still kills a hung request. The returned function stops the notification.
+func NotifyMonitorStop() (<-chan struct{}, func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			close(stop)
+		case <-done:
+		}
+		signal.Stop(signals)
+	}()
+	return stop, func() { close(done) }
+}
+
+// WaitMonitorInterval waits for interval between two monitor cycles. It returns true if stop was closed first,
+// or the error of ctx if it was done first.
+func WaitMonitorInterval(ctx context.Context, stop <-chan struct{}, interval time.Duration) (bool, error) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-stop:
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-timer.C:
+		return false, nil
+	}
+}
+
+// IsStructuredOutput reports whether the client status is printed in a structured output format,
+// in which case stdout only carries the structured document so that it can be parsed
+func IsStructuredOutput(opts client.ClientOptions) bool {
+	switch opts.OutputFormat {
+	case "json", "jsonl", "yaml", "csv", "prototext":
+		return true
+	}
+	return false
+}
+
+// InfoWriter returns where informational messages, e.g. "Config has been saved to ...", are written:
+// stderr for the structured output formats or if -output_file is set, nowhere with -quiet, stdout otherwise
+func InfoWriter(opts client.ClientOptions) io.Writer {
+	if opts.Quiet {
+		return ioutil.Discard
+	}
+	if IsStructuredOutput(opts) || opts.ConfigFile != "" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// WriteMonitorSnapshot saves the config of a monitor cycle under dir, named by the time of the cycle.
+// If onlyLast is set, the config is saved as latest.json instead, overwriting the one of the
+// previous cycle atomically so that readers never see a partial file.
+func WriteMonitorSnapshot(dir string, config []byte, onlyLast bool, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if !onlyLast {
+		path := filepath.Join(dir, now.UTC().Format("20060102T150405.000Z")+".json")
+		if err := ioutil.WriteFile(path, config, 0644); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+
+	path := filepath.Join(dir, "latest.json")
+	tmp, err := ioutil.TempFile(dir, ".latest-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(config); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// DefaultConnectTimeout is the timeout of connecting to the server if -connect_timeout is not set
+const DefaultConnectTimeout = 10 * time.Second
+
+// ConnectTimeout returns the timeout of connecting to the server of opts
+func ConnectTimeout(opts client.ClientOptions) time.Duration {
+	if opts.ConnectTimeout == 0 {
+		return DefaultConnectTimeout
+	}
+	return opts.ConnectTimeout
+}
+
+// DefaultRequestTimeout is the timeout of the request to each endpoint of several uris once connected, if
+// -request_timeout is not set
+const DefaultRequestTimeout = 30 * time.Second
+
+// RequestTimeout returns the timeout of the request to each endpoint of several uris of opts
+func RequestTimeout(opts client.ClientOptions) time.Duration {
+	if opts.RequestTimeout == 0 {
+		return DefaultRequestTimeout
+	}
+	return opts.RequestTimeout
+}
+
+// DefaultMaxRecvBytes is the maximum size of a response if -max_recv_bytes is not set, above the 4MB default
+// of gRPC that the whole ClientStatusResponse of a large mesh exceeds
+const DefaultMaxRecvBytes = 64 << 20
+
+// MaxRecvBytes returns the maximum size of a response of opts
+func MaxRecvBytes(opts client.ClientOptions) int {
+	if opts.MaxRecvBytes == 0 {
+		return DefaultMaxRecvBytes
+	}
+	return opts.MaxRecvBytes
+}
+
+// DefaultKeepaliveInterval is the time after which an idle connection is pinged if -keepalive_interval is not
+// set. It's the minimum time between pings that gRPC servers allow by default, so that they don't close the
+// connection for pinging too often.
+const DefaultKeepaliveInterval = 5 * time.Minute
+
+// DefaultKeepaliveTimeout is the time waited for the acknowledgement of a ping if -keepalive_timeout is not set
+const DefaultKeepaliveTimeout = 20 * time.Second
+
+// KeepaliveDialOption returns the dial option pinging the idle connection of opts, including between the
+// requests of the monitor mode when no stream is open, so that the proxies along the way don't drop it
+func KeepaliveDialOption(opts client.ClientOptions) grpc.DialOption {
+	params := keepalive.ClientParameters{
+		Time:                opts.KeepaliveInterval,
+		Timeout:             opts.KeepaliveTimeout,
+		PermitWithoutStream: true,
+	}
+	if params.Time == 0 {
+		params.Time = DefaultKeepaliveInterval
+	}
+	if params.Timeout == 0 {
+		params.Timeout = DefaultKeepaliveTimeout
+	}
+	return grpc.WithKeepaliveParams(params)
+}
+
+// RecvError suggests raising -max_recv_bytes if err is the failure of receiving a response larger than the
+// maximum size of opts. gRPC has no dedicated code for it, ResourceExhausted is shared with the quotas of the
+// server, so its message tells them apart.
+func RecvError(err error, opts client.ClientOptions) error {
+	if st, ok := status.FromError(err); ok && st.Code() == codes.ResourceExhausted && strings.Contains(st.Message(), "received message larger than max") {
+		return fmt.Errorf("%w: the response exceeds the %d bytes of max_recv_bytes, raise it to receive the response", err, MaxRecvBytes(opts))
+	}
+	return err
+}
+
+// dial connects to uri and waits until the connection is ready, so that a server that can't be reached fails
+// after timeout instead of hanging the first request. Only the dial is bound to timeout, not the connection.
+func dial(ctx context.Context, uri string, timeout time.Duration, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	opts = append(opts, grpc.WithBlock(), grpc.WithReturnConnectionError(), grpc.FailOnNonTempDialError(true))
+	clientConn, err := grpc.DialContext(dialCtx, uri, opts...)
+	if err != nil {
+		if dialCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			return nil, &DialError{Err: fmt.Errorf("dial to %s timed out after %v: %w", SanitizeUri(uri), timeout, err)}
+		}
+		return nil, &DialError{Err: err}
+	}
+	return clientConn, nil
+}
+
+// DialError is the failure of connecting to the server, as opposed to the failures of loading the credentials
+// of the authn mode before connecting. It has the message of Err, and can be told apart with errors.As.
+type DialError struct {
+	Err error
+}
+
+// Error implements error
+func (e *DialError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns Err
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+// ReadJwt reads the jwt key of the jwt authentication mode from its single source: the environment
+// variable named env, stdin if path is "-", or the file at path. The environment variable and stdin keep
+// the key out of the command line, and the key is never included in the errors.
+func ReadJwt(path string, env string) ([]byte, error) {
+	switch {
+	case path != "" && env != "":
+		return nil, errors.New("jwt_file and jwt_env cannot both be set, the jwt must have a single source")
+	case env != "":
+		jwt := os.Getenv(env)
+		if jwt == "" {
+			return nil, fmt.Errorf("jwt_env %s is not set", env)
+		}
+		return []byte(jwt), nil
+	case path == "-":
+		jwt, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the jwt from stdin: %v", err)
+		}
+		if len(bytes.TrimSpace(jwt)) == 0 {
+			return nil, errors.New("the jwt read from stdin is empty")
+		}
+		return jwt, nil
+	case path != "":
+		jwt, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read jwt_file: %w", err)
+		}
+		return jwt, nil
+	default:
+		return nil, errors.New("missing jwt file, required by authn_mode jwt: set jwt_file or jwt_env")
+	}
+}
+
+// ConnToGCPWithJwt connects to uri on gcp with jwt authentication, jwt being the key read by ReadJwt.
+// The server is verified with the CA certificates of caFile, or with the system roots if caFile is empty,
+// and so are the servers of the other GCP helpers.
+func ConnToGCPWithJwt(ctx context.Context, jwt []byte, uri string, caFile string, timeout time.Duration, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if len(jwt) == 0 {
 		return nil, errors.New("missing jwt file")
 	}
 	scope := "https://www.googleapis.com/auth/cloud-platform"
-	pool, err := x509.SystemCertPool()
+	pool, err := RootCAs(caFile)
 	if err != nil {
 		return nil, err
 	}
 	creds := credentials.NewClientTLSFromCert(pool, "")
-	perRPC, err := oauth.NewServiceAccountFromFile(jwt, scope)
+	_, authSpan := StartSpan(ctx, "auth")
+	perRPC, err := oauth.NewServiceAccountFromKey(jwt, scope)
+	EndSpan(authSpan, err)
 	if err != nil {
 		return nil, err
 	}
 
-	clientConn, err := grpc.Dial(uri, grpc.WithTransportCredentials(creds), grpc.WithPerRPCCredentials(perRPC))
+	clientConn, err := dial(ctx, uri, timeout, append([]grpc.DialOption{grpc.WithTransportCredentials(creds), grpc.WithPerRPCCredentials(perRPC)}, opts...)...)
 	if err != nil {
 		return nil, err
 	}
 	return clientConn, nil
 }
 
-// ConnToGCPWithAuto connects to uri on gcp with auto authentication
-func ConnToGCPWithAuto(uri string) (*grpc.ClientConn, error) {
+// ConnWithAuto connects to uri with auto authentication, the Application Default Credentials found in the
+// environment. They aren't tied to gcp: besides the metadata server of GCP, they can be a credentials file
+// or the workload identity federation of another platform.
+func ConnWithAuto(ctx context.Context, uri string, caFile string, timeout time.Duration, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	scope := "https://www.googleapis.com/auth/cloud-platform"
-	pool, err := x509.SystemCertPool()
+	pool, err := RootCAs(caFile)
 	if err != nil {
 		return nil, err
 	}
 	creds := credentials.NewClientTLSFromCert(pool, "")
-	perRPC, err := oauth.NewApplicationDefault(context.Background(), scope) // Application Default Credentials (ADC)
+	authCtx, authSpan := StartSpan(ctx, "auth")
+	perRPC, err := oauth.NewApplicationDefault(authCtx, scope) // Application Default Credentials (ADC)
+	EndSpan(authSpan, err)
 	if err != nil {
 		return nil, err
 	}
 
-	clientConn, err := grpc.Dial(uri, grpc.WithTransportCredentials(creds), grpc.WithPerRPCCredentials(perRPC))
+	clientConn, err := dial(ctx, uri, timeout, append([]grpc.DialOption{grpc.WithTransportCredentials(creds), grpc.WithPerRPCCredentials(perRPC)}, opts...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -448,18 +745,201 @@ func ConnToGCPWithAuto(uri string) (*grpc.ClientConn, error) {
 	return clientConn, nil
 }
 
-// ParseYamlFileToMap parses yaml file to map
+// ConnWithMtls connects to uri with mutual TLS, presenting the client certificate certFile and its key keyFile,
+// and verifying the server with the CA certificates of caFile, or with the system roots if caFile is empty
+func ConnWithMtls(ctx context.Context, uri string, timeout time.Duration, certFile string, keyFile string, caFile string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	_, authSpan := StartSpan(ctx, "auth")
+	config, err := loadMtlsConfig(certFile, keyFile, caFile)
+	EndSpan(authSpan, err)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConn, err := dial(ctx, uri, timeout, append([]grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(config))}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return clientConn, nil
+}
+
+// ConnToGCPWithAdc connects to uri on gcp with the Application Default Credentials of the environment,
+// e.g. from `gcloud auth application-default login` or the metadata server
+func ConnToGCPWithAdc(ctx context.Context, uri string, caFile string, timeout time.Duration, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	scope := "https://www.googleapis.com/auth/cloud-platform"
+	pool, err := RootCAs(caFile)
+	if err != nil {
+		return nil, err
+	}
+	creds := credentials.NewClientTLSFromCert(pool, "")
+	authCtx, authSpan := StartSpan(ctx, "auth")
+	adc, err := google.FindDefaultCredentials(authCtx, scope)
+	EndSpan(authSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("no application default credentials found, run `gcloud auth application-default login` or set GOOGLE_APPLICATION_CREDENTIALS: %v", err)
+	}
+	perRPC := oauth.TokenSource{TokenSource: adc.TokenSource}
+
+	clientConn, err := dial(ctx, uri, timeout, append([]grpc.DialOption{grpc.WithTransportCredentials(creds), grpc.WithPerRPCCredentials(perRPC)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return clientConn, nil
+}
+
+// ConnToGCPWithServiceAccount connects to uri on gcp with the service account JSON key file path.
+// The file is read once, and its content is never included in the errors.
+func ConnToGCPWithServiceAccount(ctx context.Context, path string, uri string, caFile string, timeout time.Duration, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if path == "" {
+		return nil, errors.New("missing service_account_file, required by authn_mode sa")
+	}
+	scope := "https://www.googleapis.com/auth/cloud-platform"
+	pool, err := RootCAs(caFile)
+	if err != nil {
+		return nil, err
+	}
+	creds := credentials.NewClientTLSFromCert(pool, "")
+	authCtx, authSpan := StartSpan(ctx, "auth")
+	perRPC, err := serviceAccountCredentials(authCtx, path, scope)
+	EndSpan(authSpan, err)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConn, err := dial(ctx, uri, timeout, append([]grpc.DialOption{grpc.WithTransportCredentials(creds), grpc.WithPerRPCCredentials(perRPC)}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return clientConn, nil
+}
+
+// serviceAccountCredentials loads the service account JSON key file path as per-RPC credentials of scope
+func serviceAccountCredentials(ctx context.Context, path string, scope string) (credentials.PerRPCCredentials, error) {
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service_account_file: %w", err)
+	}
+	sa, err := google.CredentialsFromJSON(ctx, key, scope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse service_account_file %s: %w", path, err)
+	}
+	return oauth.TokenSource{TokenSource: sa.TokenSource}, nil
+}
+
+// ConnInsecure connects to uri in plaintext and without credentials, e.g. to a local xDS server in tests.
+// It warns on stderr if uri is a googleapis.com host, as it is then most likely used by mistake.
+func ConnInsecure(ctx context.Context, uri string, timeout time.Duration, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if strings.HasSuffix(UriHost(uri), ".googleapis.com") {
+		fmt.Fprintf(os.Stderr, "WARNING: authn_mode insecure connects to the googleapis.com host %s in plaintext and without credentials\n", SanitizeUri(uri))
+	}
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	clientConn, err := dial(ctx, uri, timeout, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return clientConn, nil
+}
+
+// UriHost returns the host of uri, without the scheme, the path or the port
+func UriHost(uri string) string {
+	host := uri
+	if i := strings.LastIndex(host, "/"); i >= 0 {
+		host = host[i+1:]
+	}
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	return host
+}
+
+// loadMtlsConfig reads the files of the mtls authn_mode into a TLS config, naming the file that is missing or invalid
+func loadMtlsConfig(certFile string, keyFile string, caFile string) (*tls.Config, error) {
+	files := []struct {
+		flag string
+		path string
+	}{{"client_cert", certFile}, {"client_key", keyFile}}
+	contents := make([][]byte, len(files))
+	for i, file := range files {
+		if file.path == "" {
+			return nil, fmt.Errorf("missing %s file, required by authn_mode mtls", file.flag)
+		}
+		content, err := ioutil.ReadFile(file.path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s file: %v", file.flag, err)
+		}
+		contents[i] = content
+	}
+
+	cert, err := tls.X509KeyPair(contents[0], contents[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid client_cert %s or client_key %s: %v", certFile, keyFile, err)
+	}
+	pool, err := RootCAs(caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool}, nil
+}
+
+// RootCAs returns the pool of the PEM CA certificates of caFile that the server is verified with,
+// or the system roots if caFile is empty
+func RootCAs(caFile string) (*x509.CertPool, error) {
+	if caFile == "" {
+		return x509.SystemCertPool()
+	}
+	content, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ca_cert file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificate found in ca_cert file %s", caFile)
+	}
+	return pool, nil
+}
+
+// PlatformIndependent reports whether authnMode connects without the credentials of a platform, so that
+// -platform may name any platform and the request is not checked for platform-specific fields
+func PlatformIndependent(authnMode string) bool {
+	return authnMode == "mtls" || authnMode == "insecure"
+}
+
+// envVarReference matches the ${VAR} and ${VAR:-default} references to environment variables
+var envVarReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// ExpandEnv substitutes the ${VAR} references of a request yaml with the value of the environment variable VAR,
+// e.g. to keep the GCP project number out of committed files. ${VAR:-default} expands to default if VAR is
+// unset or empty, while an unset VAR without a default is an error rather than an empty value.
+func ExpandEnv(yamlStr string) (string, error) {
+	var missing []string
+	expanded := envVarReference.ReplaceAllStringFunc(yamlStr, func(reference string) string {
+		match := envVarReference.FindStringSubmatch(reference)
+		value, ok := os.LookupEnv(match[1])
+		if match[2] != "" && value == "" {
+			return match[3]
+		}
+		if !ok {
+			missing = append(missing, match[1])
+		}
+		return value
+	})
+	if len(missing) != 0 {
+		return "", fmt.Errorf("environment variable %s referenced by the request yaml is not set, use ${%s:-<default>} to allow it", missing[0], missing[0])
+	}
+	return expanded, nil
+}
+
+// ParseYamlFileToMap parses yaml file to map. If path is "-", the yaml is read from stdin until EOF instead.
 func ParseYamlFileToMap(path string) (map[string]interface{}, error) {
-	// parse yaml to json
-	filename, err := filepath.Abs(path)
+	yamlFile, err := readYamlFile(path)
 	if err != nil {
 		return nil, err
 	}
-	yamlFile, err := ioutil.ReadFile(filename)
+	expanded, err := ExpandEnv(string(yamlFile))
 	if err != nil {
 		return nil, err
 	}
-	js, err := yaml.YAMLToJSON(yamlFile)
+	// parse yaml to json
+	js, err := yaml.YAMLToJSON([]byte(expanded))
 	if err != nil {
 		return nil, err
 	}
@@ -472,10 +952,32 @@ func ParseYamlFileToMap(path string) (map[string]interface{}, error) {
 	return data, nil
 }
 
+// readYamlFile reads the yaml file path, or stdin if path is "-"
+func readYamlFile(path string) ([]byte, error) {
+	if path != "-" {
+		filename, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadFile(filename)
+	}
+	yamlFile, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the request yaml from stdin: %v", err)
+	}
+	if len(bytes.TrimSpace(yamlFile)) == 0 {
+		return nil, errors.New("empty request yaml read from stdin, pipe the request in or set -request_file to a file")
+	}
+	return yamlFile, nil
+}
+
 // ParseYamlStrToMap parses yaml string to map
 func ParseYamlStrToMap(yamlStr string) (map[string]interface{}, error) {
+	yamlStr, err := ExpandEnv(yamlStr)
+	if err != nil {
+		return nil, err
+	}
 	var js []byte
-	var err error
 	// json input
 	if IsJson(yamlStr) {
 		js = []byte(yamlStr)
@@ -495,22 +997,168 @@ func ParseYamlStrToMap(yamlStr string) (map[string]interface{}, error) {
 	return data, nil
 }
 
-func FilterNodeId(id string, filterMode string, filterPattern string) (bool, error) {
-	switch filterMode {
-	case "prefix":
-		if strings.HasPrefix(id, filterPattern) {
-			return true, nil
+// SplitFilterPattern splits the comma-separated patterns of -filter_pattern, ignoring empty ones.
+// In regex mode, commas inside braces or brackets, e.g. "node{1,3}", are part of the pattern.
+func SplitFilterPattern(filterMode string, filterPattern string) []string {
+	var patterns []string
+	var depth, start int
+	for i := 0; i < len(filterPattern); i++ {
+		switch c := filterPattern[i]; {
+		case filterMode == "regex" && c == '\\':
+			i++
+		case filterMode == "regex" && (c == '{' || c == '['):
+			depth++
+		case filterMode == "regex" && (c == '}' || c == ']') && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			if filterPattern[start:i] != "" {
+				patterns = append(patterns, filterPattern[start:i])
+			}
+			start = i + 1
 		}
-	case "suffix":
-		if strings.HasSuffix(id, filterPattern) {
-			return true, nil
+	}
+	if filterPattern[start:] != "" {
+		patterns = append(patterns, filterPattern[start:])
+	}
+	return patterns
+}
+
+// ValidateFilterPattern checks that each pattern of -filter_pattern compiles in regex and glob modes
+func ValidateFilterPattern(filterMode string, filterPattern string) error {
+	for _, pattern := range SplitFilterPattern(filterMode, filterPattern) {
+		var err error
+		switch filterMode {
+		case "regex":
+			_, err = regexp.Compile(pattern)
+		case "glob":
+			_, err = compileGlob(pattern, false)
 		}
-	case "regex":
-		matched, err := regexp.MatchString(filterPattern, id)
 		if err != nil {
-			return false, err
+			return fmt.Errorf("invalid filter pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// compileGlob compiles a pattern of the glob filter mode to a regexp matching the whole id: "*" matches
+// any characters but "/", so within a single segment of the id, "**" matches any characters across
+// segments, "?" matches a single character but "/", "[...]" matches a character of the class, "[!...]"
+// one that isn't in it, and "\" escapes the next character
+func compileGlob(pattern string, ignoreCase bool) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	if ignoreCase {
+		expr.WriteString("(?i)")
+	}
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, errors.New("missing closing ] of the character class")
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			if class == "" || class == "^" {
+				return nil, errors.New("empty character class")
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 == len(pattern) {
+				return nil, errors.New("trailing \\ escapes nothing")
+			}
+			expr.WriteString(regexp.QuoteMeta(pattern[i+1 : i+2]))
+			i++
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// FilterNodeId returns whether id matches any of the comma-separated patterns of filterPattern.
+// If ignoreCase is set, prefixes, suffixes and exact ids are compared in lower case and regexes and globs are compiled with (?i).
+func FilterNodeId(id string, filterMode string, filterPattern string, ignoreCase bool) (bool, error) {
+	return MatchPattern(id, filterMode, filterPattern, ignoreCase)
+}
+
+// MatchPattern returns whether s matches any of the comma-separated patterns of filterPattern in filterMode,
+// one of prefix, suffix, exact, regex and glob. It's the matcher of both the node ids and the resource names.
+func MatchPattern(s string, filterMode string, filterPattern string, ignoreCase bool) (bool, error) {
+	if ignoreCase && filterMode != "regex" && filterMode != "glob" {
+		s = strings.ToLower(s)
+		filterPattern = strings.ToLower(filterPattern)
+	}
+	for _, pattern := range SplitFilterPattern(filterMode, filterPattern) {
+		switch filterMode {
+		case "prefix":
+			if strings.HasPrefix(s, pattern) {
+				return true, nil
+			}
+		case "suffix":
+			if strings.HasSuffix(s, pattern) {
+				return true, nil
+			}
+		case "exact":
+			if s == pattern {
+				return true, nil
+			}
+		case "regex":
+			expr := pattern
+			if ignoreCase {
+				expr = "(?i)" + pattern
+			}
+			matched, err := regexp.MatchString(expr, s)
+			if err != nil {
+				return false, fmt.Errorf("invalid filter pattern %q: %v", pattern, err)
+			}
+			if matched {
+				return true, nil
+			}
+		case "glob":
+			glob, err := compileGlob(pattern, ignoreCase)
+			if err != nil {
+				return false, fmt.Errorf("invalid filter pattern %q: %v", pattern, err)
+			}
+			if glob.MatchString(s) {
+				return true, nil
+			}
 		}
-		return matched, nil
 	}
 	return false, nil
 }
+
+// GoControlPlaneModule is the module of the xDS protos the client is built with
+const GoControlPlaneModule = "github.com/envoyproxy/go-control-plane"
+
+// ModuleVersion returns the version of the dependency path the binary is built with, or unknown if the
+// binary has no build info
+func ModuleVersion(path string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != path {
+			continue
+		}
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			return dep.Replace.Version
+		}
+		return dep.Version
+	}
+	return "unknown"
+}
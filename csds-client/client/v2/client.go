@@ -9,6 +9,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -28,6 +30,13 @@ type ClientV2 struct {
 	nodeMatcher []*envoy_type_matcher_v2.NodeMatcher
 	metadata    metadata.MD
 	opts        client.ClientOptions
+
+	// out is where the responses are rendered, nil for stdout
+	out io.Writer
+	// backoff is the backoff between the retries of -max_retries, which tests shorten
+	backoff clientutil.Backoff
+	// jwt is the key of the jwt authentication mode, read once by New from -jwt_file or -jwt_env
+	jwt []byte
 }
 
 // Field keys that must be presented in the NodeMatcher
@@ -52,41 +61,80 @@ func (c *ClientV2) parseNodeMatcher() error {
 
 	c.nodeMatcher = nodematchers
 
-	// check if required fields exist in NodeMatcher
+	var errs []error
+	// check if required fields exist in NodeMatcher, collecting every problem to report them at once
 	switch c.opts.Platform {
 	case "gcp":
 		// Project Number is necessary
 		if value := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpProjectNumberKey); value == "" {
-			return fmt.Errorf("missing field %v in NodeMatcher", gcpProjectNumberKey)
+			errs = append(errs, fmt.Errorf("missing field %v in NodeMatcher", gcpProjectNumberKey))
 		}
 
 		// Only one of these must be set.
 		networkNameValue := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpNetworkNameKey)
 		meshScopeValue := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpMeshScopeKey)
 		if len(networkNameValue) == 0 && len(meshScopeValue) == 0 {
-			return fmt.Errorf("must set either %v or %v", gcpNetworkNameKey, gcpMeshScopeKey)
+			errs = append(errs, fmt.Errorf("must set either %v or %v", gcpNetworkNameKey, gcpMeshScopeKey))
 		} else if len(networkNameValue) > 0 && len(meshScopeValue) > 0 {
-			return fmt.Errorf("cannot set both %v or %v", gcpNetworkNameKey, gcpMeshScopeKey)
+			errs = append(errs, fmt.Errorf("cannot set both %v or %v", gcpNetworkNameKey, gcpMeshScopeKey))
 		}
 	default:
-		return fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
+		if !clientutil.PlatformIndependent(c.opts.AuthnMode) {
+			errs = append(errs, fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform))
+		}
 	}
 
-	if c.opts.FilterMode != "" && c.opts.FilterMode != "prefix" && c.opts.FilterMode != "suffix" && c.opts.FilterMode != "regex" {
-		return fmt.Errorf("%s filter mode is not supported, list of supported filter modes: prefix, suffix, regex", c.opts.FilterMode)
+	switch c.opts.FilterMode {
+	case "", "prefix", "suffix", "exact", "regex", "glob":
+	default:
+		errs = append(errs, fmt.Errorf("%s filter mode is not supported, list of supported filter modes: prefix, suffix, exact, regex, glob", c.opts.FilterMode))
+	}
+	if c.opts.FilterInvert && c.opts.FilterPattern == "" {
+		errs = append(errs, errors.New("filter_invert can only be used with filter_pattern"))
+	}
+	if err := clientutil.ValidateFilterPattern(c.opts.FilterMode, c.opts.FilterPattern); err != nil {
+		errs = append(errs, err)
 	}
 
-	return nil
+	return client.JoinRequestErrors(errs...)
+}
+
+// setUserProject parses the GCP project number of the NodeMatcher as the x-goog-user-project header for authentication
+func (c *ClientV2) setUserProject() {
+	if projectNum := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpProjectNumberKey); projectNum != "" {
+		c.metadata = metadata.Pairs("x-goog-user-project", projectNum)
+	}
 }
 
 // connWithAuth connects to uri with authentication
-func (c *ClientV2) connWithAuth() error {
-	var err error
+func (c *ClientV2) connWithAuth(ctx context.Context) (err error) {
+	ctx, span := clientutil.StartSpan(ctx, "connect",
+		clientutil.PlatformKey.String(c.opts.Platform), clientutil.UriKey.String(clientutil.SanitizeUri(c.opts.Uri)))
+	defer func() { clientutil.EndSpan(span, err) }()
+
+	proxyOption, err := clientutil.ProxyDialOption(c.opts.Proxy)
+	if err != nil {
+		return err
+	}
+	opts := []grpc.DialOption{proxyOption, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(clientutil.MaxRecvBytes(c.opts))), clientutil.KeepaliveDialOption(c.opts)}
+
 	switch c.opts.AuthnMode {
+	case "mtls":
+		c.clientConn, err = clientutil.ConnWithMtls(ctx, c.opts.Uri, clientutil.ConnectTimeout(c.opts), c.opts.ClientCert, c.opts.ClientKey, c.opts.CaCert, opts...)
+		if err != nil {
+			return err
+		}
+		return nil
+	case "insecure":
+		c.clientConn, err = clientutil.ConnInsecure(ctx, c.opts.Uri, clientutil.ConnectTimeout(c.opts), opts...)
+		if err != nil {
+			return err
+		}
+		return nil
 	case "jwt":
 		switch c.opts.Platform {
 		case "gcp":
-			c.clientConn, err = clientutil.ConnToGCPWithJwt(c.opts.Jwt, c.opts.Uri)
+			c.clientConn, err = clientutil.ConnToGCPWithJwt(ctx, c.jwt, c.opts.Uri, c.opts.CaCert, clientutil.ConnectTimeout(c.opts), opts...)
 			if err != nil {
 				return err
 			}
@@ -98,11 +146,8 @@ func (c *ClientV2) connWithAuth() error {
 	case "auto":
 		switch c.opts.Platform {
 		case "gcp":
-			// parse GCP project number as header for authentication
-			if projectNum := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpProjectNumberKey); projectNum != "" {
-				c.metadata = metadata.Pairs("x-goog-user-project", projectNum)
-			}
-			c.clientConn, err = clientutil.ConnToGCPWithAuto(c.opts.Uri)
+			c.setUserProject()
+			c.clientConn, err = clientutil.ConnWithAuto(ctx, c.opts.Uri, c.opts.CaCert, clientutil.ConnectTimeout(c.opts), opts...)
 			if err != nil {
 				return err
 			}
@@ -110,6 +155,32 @@ func (c *ClientV2) connWithAuth() error {
 		default:
 			return errors.New("auto authentication mode for this platform is not supported. Please use jwt_file instead")
 		}
+
+	case "adc":
+		switch c.opts.Platform {
+		case "gcp":
+			c.setUserProject()
+			c.clientConn, err = clientutil.ConnToGCPWithAdc(ctx, c.opts.Uri, c.opts.CaCert, clientutil.ConnectTimeout(c.opts), opts...)
+			if err != nil {
+				return err
+			}
+			return nil
+		default:
+			return errors.New("adc authentication mode for this platform is not supported. Please use jwt_file instead")
+		}
+
+	case "sa":
+		switch c.opts.Platform {
+		case "gcp":
+			c.setUserProject()
+			c.clientConn, err = clientutil.ConnToGCPWithServiceAccount(ctx, c.opts.ServiceAccountFile, c.opts.Uri, c.opts.CaCert, clientutil.ConnectTimeout(c.opts), opts...)
+			if err != nil {
+				return err
+			}
+			return nil
+		default:
+			return fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
+		}
 	default:
 		return errors.New("invalid authn_mode")
 	}
@@ -118,32 +189,298 @@ func (c *ClientV2) connWithAuth() error {
 // New creates a new client with v2 api version
 func New(option client.ClientOptions) (*ClientV2, error) {
 	c := &ClientV2{
-		opts: option,
+		opts:    option,
+		backoff: clientutil.DefaultBackoff,
 	}
-	if c.opts.Platform != "gcp" {
+	if c.opts.Platform != "gcp" && !clientutil.PlatformIndependent(c.opts.AuthnMode) {
 		return nil, fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
 	}
 
+	if c.opts.ConnectTimeout < 0 {
+		return nil, errors.New("connect_timeout must not be negative")
+	}
+	if c.opts.KeepaliveInterval < 0 {
+		return nil, errors.New("keepalive_interval must not be negative")
+	}
+	if c.opts.KeepaliveTimeout < 0 {
+		return nil, errors.New("keepalive_timeout must not be negative")
+	}
+	if c.opts.MaxRecvBytes < 0 {
+		return nil, errors.New("max_recv_bytes must not be negative")
+	}
+	if c.opts.MaxRetries < 0 {
+		return nil, errors.New("max_retries must not be negative")
+	}
+	if c.opts.Proxy != "" {
+		if _, err := clientutil.ParseProxy(c.opts.Proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.opts.OutputFormat != "" && c.opts.OutputFormat != "text" {
+		return nil, fmt.Errorf("%s output format is not supported by the v2 api version, list of supported output formats: text", c.opts.OutputFormat)
+	}
+
+	if c.opts.FailOn != "" {
+		return nil, errors.New("fail_on is not supported by the v2 api version")
+	}
+
+	if c.opts.SummaryOnly {
+		return nil, errors.New("summary_only is not supported by the v2 api version")
+	}
+
+	if c.opts.XdsType != "" {
+		return nil, errors.New("xds_type is not supported by the v2 api version")
+	}
+
+	if c.opts.StatusFilter != "" {
+		return nil, errors.New("status_filter is not supported by the v2 api version")
+	}
+
+	if c.opts.Watch {
+		return nil, errors.New("watch is not supported by the v2 api version")
+	}
+
+	if c.opts.Columns != "" {
+		return nil, errors.New("columns is not supported by the v2 api version")
+	}
+
+	if c.opts.FixedWidth {
+		return nil, errors.New("fixed_width is not supported by the v2 api version")
+	}
+
+	if c.opts.NoTruncate {
+		return nil, errors.New("no_truncate is not supported by the v2 api version")
+	}
+
+	if c.opts.Compress {
+		return nil, errors.New("compress is not supported by the v2 api version")
+	}
+
+	if c.opts.CheckConnectivity {
+		return nil, errors.New("check_connectivity is not supported by the v2 api version")
+	}
+	if c.opts.ExcludeContents {
+		return nil, errors.New("exclude_contents is not supported by the v2 api version")
+	}
+	if c.opts.ShowVersion {
+		return nil, errors.New("show_version is not supported by the v2 api version")
+	}
+	if c.opts.VersionSkew {
+		return nil, errors.New("version_skew is not supported by the v2 api version")
+	}
+	if c.opts.DumpDir != "" {
+		return nil, errors.New("dump_dir is not supported by the v2 api version")
+	}
+	if c.opts.ResourceName != "" {
+		return nil, errors.New("resource_name is not supported by the v2 api version")
+	}
+	if c.opts.Template != "" {
+		return nil, errors.New("template is not supported by the v2 api version")
+	}
+	if c.opts.TolerateStaleFor != 0 {
+		return nil, errors.New("tolerate_stale_for is not supported by the v2 api version")
+	}
+	if c.opts.NodeId != "" {
+		return nil, errors.New("node_id is not supported by the v2 api version")
+	}
+	if c.opts.RequestRetries != 0 {
+		return nil, errors.New("request_retries is not supported by the v2 api version")
+	}
+	if c.opts.ShowLocality {
+		return nil, errors.New("show_locality is not supported by the v2 api version")
+	}
+
+	if c.opts.InputFile != "" {
+		return nil, errors.New("input_file is not supported by the v2 api version")
+	}
+
+	if c.opts.MetricsAddr != "" {
+		return nil, errors.New("metrics_addr is not supported by the v2 api version")
+	}
+
+	if c.opts.Timing {
+		return nil, errors.New("timing is not supported by the v2 api version")
+	}
+
+	if c.opts.Stream {
+		return nil, errors.New("stream is not supported by the v2 api version")
+	}
+
+	if c.opts.DryRun {
+		return nil, errors.New("dry_run is not supported by the v2 api version")
+	}
+
+	if c.opts.WaitForClients != 0 || c.opts.FailOnNoClients {
+		return nil, errors.New("wait_for_clients and fail_on_no_clients are not supported by the v2 api version")
+	}
+
+	if c.opts.AssertConsistent {
+		return nil, errors.New("assert_consistent is not supported by the v2 api version")
+	}
+
+	if c.opts.SelfDiff != 0 || c.opts.SelfDiffFail {
+		return nil, errors.New("self_diff and self_diff_fail are not supported by the v2 api version")
+	}
+
+	if c.opts.SqliteOut != "" {
+		return nil, errors.New("sqlite_out is not supported by the v2 api version")
+	}
+
+	if c.opts.MetaMissing != "" {
+		return nil, errors.New("meta_missing is not supported by the v2 api version")
+	}
+
+	if c.opts.DrainStream || c.opts.DrainTimeout != 0 {
+		return nil, errors.New("drain_stream and drain_timeout are not supported by the v2 api version")
+	}
+
+	if c.opts.RouteTable {
+		return nil, errors.New("route_table is not supported by the v2 api version")
+	}
+
+	if c.opts.ProbePath != "" || c.opts.ProbeMethod != "" || len(c.opts.ProbeHeaders) > 0 {
+		return nil, errors.New("probe_path, probe_method and probe_header are not supported by the v2 api version")
+	}
+
+	if c.opts.Transform != "" {
+		return nil, errors.New("transform is not supported by the v2 api version")
+	}
+
+	if c.opts.Verbose {
+		return nil, errors.New("verbose is not supported by the v2 api version")
+	}
+
+	if c.opts.Color != "" {
+		return nil, errors.New("color is not supported by the v2 api version")
+	}
+
+	if c.opts.RequestTimeout != 0 {
+		return nil, errors.New("request_timeout is not supported by the v2 api version")
+	}
+
+	if c.opts.LenientYaml {
+		return nil, errors.New("lenient_yaml is not supported by the v2 api version")
+	}
+
+	// the output is discarded, so it can't be rendered anywhere else
+	if c.opts.Quiet && (c.opts.ConfigFile != "" || c.opts.Visualization) {
+		return nil, errors.New("quiet cannot be used with output_file or visualization")
+	}
+	// the relationships of the resources are read from their decoded configs
+	if c.opts.RawAny && c.opts.Visualization {
+		return nil, errors.New("visualization cannot be used with resolve_any disabled")
+	}
+
+	if c.opts.Limit > 0 || c.opts.Offset != 0 {
+		return nil, errors.New("limit and offset are not supported by the v2 api version")
+	}
+
+	switch c.opts.Sort {
+	case "", "id", "none":
+	default:
+		return nil, fmt.Errorf("%s sort mode is not supported by the v2 api version, list of supported sort modes: id, none", c.opts.Sort)
+	}
+
+	if c.opts.StrictTypes {
+		return nil, errors.New("strict_types is not supported by the v2 api version")
+	}
+
+	if c.opts.UTC {
+		return nil, errors.New("utc is not supported by the v2 api version")
+	}
+
+	if c.opts.ShowErrors {
+		return nil, errors.New("show_errors is not supported by the v2 api version")
+	}
+
+	if c.opts.DumpRaw {
+		return nil, errors.New("dump_raw is not supported by the v2 api version")
+	}
+
+	if strings.Contains(c.opts.Uri, ",") {
+		return nil, errors.New("several uris are not supported by the v2 api version")
+	}
+
+	// v2 requests have no node, so they always carry only the node matchers
+	if c.opts.RequestMode == "node_only" {
+		return nil, errors.New("node_only request mode is not supported by the v2 api version")
+	}
+
+	if c.opts.MonitorDiff {
+		return nil, errors.New("monitor_diff is not supported by the v2 api version")
+	}
+
+	if c.opts.MonitorCount < 0 {
+		return nil, errors.New("monitor_count must not be negative")
+	}
+	if c.opts.MonitorCount != 0 && c.opts.MonitorInterval == 0 {
+		return nil, errors.New("monitor_count can only be used in monitor mode")
+	}
+	if c.opts.MonitorOutputDir != "" && c.opts.MonitorInterval == 0 {
+		return nil, errors.New("monitor_output_dir can only be used in monitor mode")
+	}
+	if c.opts.OnlyLastCycle && c.opts.MonitorOutputDir == "" {
+		return nil, errors.New("only_last_cycle can only be used with monitor_output_dir")
+	}
+
 	if err := c.parseNodeMatcher(); err != nil {
 		return nil, err
 	}
 
+	if err := c.readJwt(); err != nil {
+		return nil, err
+	}
+
 	return c, nil
 }
 
+// readJwt reads the key of the jwt authentication mode once, as stdin can't be read again on reconnects
+func (c *ClientV2) readJwt() (err error) {
+	if c.opts.AuthnMode != "jwt" {
+		if c.opts.JwtEnv != "" {
+			return errors.New("jwt_env can only be used with the jwt authentication mode")
+		}
+		return nil
+	}
+	if c.opts.Jwt == "-" && c.opts.RequestFile == "-" {
+		return errors.New("jwt_file and request_file cannot both be read from stdin")
+	}
+	c.jwt, err = clientutil.ReadJwt(c.opts.Jwt, c.opts.JwtEnv)
+	return err
+}
+
 // Run connects the client to the uri and calls doRequest
 func (c *ClientV2) Run() error {
-	if err := c.connWithAuth(); err != nil {
+	return c.RunContext(context.Background())
+}
+
+// RunContext is Run with a context that the connection, the stream and its requests are bound to,
+// so that canceling ctx or exceeding its deadline tears down a hung request
+func (c *ClientV2) RunContext(ctx context.Context) (err error) {
+	ctx, span := clientutil.StartSpan(ctx, "Run",
+		clientutil.PlatformKey.String(c.opts.Platform), clientutil.UriKey.String(clientutil.SanitizeUri(c.opts.Uri)))
+	defer func() { clientutil.EndSpan(span, err) }()
+
+	if err := c.connWithAuth(ctx); err != nil {
 		return err
 	}
 	defer c.clientConn.Close()
 
+	out, closeOut, err := clientutil.OpenOutput(c.opts)
+	if err != nil {
+		return err
+	}
+	c.out = out
+	defer func() {
+		if closeErr := closeOut(); err == nil {
+			err = closeErr
+		}
+	}()
+
 	c.csdsClient = csdspb_v2.NewClientStatusDiscoveryServiceClient(c.clientConn)
-	var ctx context.Context
 	if c.metadata != nil {
-		ctx = metadata.NewOutgoingContext(context.Background(), c.metadata)
-	} else {
-		ctx = context.Background()
+		ctx = metadata.NewOutgoingContext(ctx, c.metadata)
 	}
 
 	streamClientStatus, err := c.csdsClient.StreamClientStatus(ctx)
@@ -151,46 +488,82 @@ func (c *ClientV2) Run() error {
 		return err
 	}
 
+	var stop <-chan struct{}
+	if c.opts.MonitorInterval != 0 {
+		var stopNotify func()
+		stop, stopNotify = clientutil.NotifyMonitorStop()
+		defer stopNotify()
+	}
+
 	// run once or run with monitor mode
+	var cycles, retries int
 	for {
-		if err := c.doRequest(streamClientStatus); err != nil {
-			// timeout error
-			// retry to connect
-			if strings.Contains(err.Error(), "RpcSecurityPolicy") {
+		if err := c.doRequest(ctx, streamClientStatus); err != nil {
+			// transient errors, e.g. the server restarting, are retried on a new stream with backoff
+			if err := clientutil.Retry(ctx, clientutil.InfoWriter(c.opts), err, c.opts.MaxRetries, c.backoff, &retries, func() (err error) {
 				streamClientStatus, err = c.csdsClient.StreamClientStatus(ctx)
-				if err != nil {
-					return err
-				}
-				continue
-			} else {
+				return err
+			}); err != nil {
 				return err
 			}
+			continue
 		}
-		if c.opts.MonitorInterval != 0 {
-			time.Sleep(c.opts.MonitorInterval)
-		} else {
-			if err = streamClientStatus.CloseSend(); err != nil {
+		retries = 0
+		cycles++
+		if c.opts.MonitorInterval != 0 && (c.opts.MonitorCount == 0 || cycles < c.opts.MonitorCount) {
+			// SIGINT and SIGTERM stop the monitor after the current cycle, closing the stream cleanly
+			stopped, err := clientutil.WaitMonitorInterval(ctx, stop, c.opts.MonitorInterval)
+			if err != nil {
 				return err
 			}
-			return nil
+			if !stopped {
+				continue
+			}
+			fmt.Fprintln(os.Stderr, "monitor stopped")
+		}
+		if err = streamClientStatus.CloseSend(); err != nil {
+			return err
 		}
+		return nil
 	}
 }
 
+// output returns where the responses are rendered: the -output_file opened by Run, or stdout
+func (c *ClientV2) output() io.Writer {
+	if c.out == nil {
+		return os.Stdout
+	}
+	return c.out
+}
+
 // doRequest sends request and prints out the parsed response
-func (c *ClientV2) doRequest(streamClientStatus csdspb_v2.ClientStatusDiscoveryService_StreamClientStatusClient) error {
+func (c *ClientV2) doRequest(ctx context.Context, streamClientStatus csdspb_v2.ClientStatusDiscoveryService_StreamClientStatusClient) (err error) {
+	ctx, span := clientutil.StartSpan(ctx, "doRequest")
+	defer func() { clientutil.EndSpan(span, err) }()
 
 	req := &csdspb_v2.ClientStatusRequest{NodeMatchers: c.nodeMatcher}
-	if err := streamClientStatus.Send(req); err != nil {
+	_, sendSpan := clientutil.StartSpan(ctx, "send")
+	err = streamClientStatus.Send(req)
+	clientutil.EndSpan(sendSpan, err)
+	if err != nil {
 		return err
 	}
 
+	_, recvSpan := clientutil.StartSpan(ctx, "receive")
 	resp, err := streamClientStatus.Recv()
 	if err != nil && err != io.EOF {
+		err = clientutil.RecvError(err, c.opts)
+		clientutil.EndSpan(recvSpan, err)
 		return err
 	}
+	recvSpan.SetAttributes(clientutil.ClientCountKey.Int(len(resp.GetConfig())))
+	clientutil.EndSpan(recvSpan, nil)
+	w := c.output()
+	if c.opts.ConfigFile != "" && c.opts.MonitorInterval != 0 {
+		clientutil.PrintCycleSeparator(w, time.Now())
+	}
 	// post process response
-	if err := printOutResponse(resp, c.opts); err != nil {
+	if err := printOutResponse(w, resp, c.opts); err != nil {
 		return err
 	}
 
@@ -220,17 +593,25 @@ func parseConfigStatus(xdsConfig []*csdspb_v2.PerXdsConfig) []string {
 }
 
 // printOutResponse processes response and print
-func printOutResponse(response *csdspb_v2.ClientStatusResponse, opts client.ClientOptions) error {
+func printOutResponse(w io.Writer, response *csdspb_v2.ClientStatusResponse, opts client.ClientOptions) error {
 	if response.GetConfig() == nil || len(response.GetConfig()) == 0 {
-		fmt.Printf("No xDS clients connected.\n")
+		fmt.Fprintf(w, "No xDS clients connected.\n")
 		return nil
 	} else {
-		fmt.Printf("%-50s %-30s %-30s \n", "Client ID", "xDS stream type", "Config Status")
+		fmt.Fprintf(w, "%-50s %-30s %-30s \n", "Client ID", "xDS stream type", "Config Status")
 	}
 
 	var hasXdsConfig bool
 
-	for _, config := range response.GetConfig() {
+	configs := response.GetConfig()
+	if opts.Sort != "none" {
+		// sort by client id, so that the output is stable across runs
+		configs = append([]*csdspb_v2.ClientConfig{}, configs...)
+		sort.SliceStable(configs, func(i, j int) bool {
+			return configs[i].GetNode().GetId() < configs[j].GetNode().GetId()
+		})
+	}
+	for _, config := range configs {
 		var id string
 		var xdsType string
 		if config.GetNode() != nil {
@@ -245,11 +626,11 @@ func printOutResponse(response *csdspb_v2.ClientStatusResponse, opts client.Clie
 
 			// filter node id
 			if opts.FilterPattern != "" {
-				matched, err := clientutil.FilterNodeId(id, opts.FilterMode, opts.FilterPattern)
+				matched, err := clientutil.FilterNodeId(id, opts.FilterMode, opts.FilterPattern, opts.FilterIgnoreCase)
 				if err != nil {
 					return err
 				}
-				if !matched {
+				if matched == opts.FilterInvert {
 					continue
 				}
 			}
@@ -257,30 +638,30 @@ func printOutResponse(response *csdspb_v2.ClientStatusResponse, opts client.Clie
 
 		if config.GetXdsConfig() == nil {
 			if config.GetNode() != nil {
-				fmt.Printf("%-50s %-30s %-30s \n", id, xdsType, "N/A")
+				fmt.Fprintf(w, "%-50s %-30s %-30s \n", id, xdsType, "N/A")
 			}
 		} else {
 			hasXdsConfig = true
 
 			// parse config status
 			configStatus := parseConfigStatus(config.GetXdsConfig())
-			fmt.Printf("%-50s %-30s ", id, xdsType)
+			fmt.Fprintf(w, "%-50s %-30s ", id, xdsType)
 
 			for i := 0; i < len(configStatus); i++ {
 				if i == 0 {
-					fmt.Printf("%-30s \n", configStatus[i])
+					fmt.Fprintf(w, "%-30s \n", configStatus[i])
 				} else {
-					fmt.Printf("%-50s %-30s %-30s \n", "", "", configStatus[i])
+					fmt.Fprintf(w, "%-50s %-30s %-30s \n", "", "", configStatus[i])
 				}
 			}
 			if len(configStatus) == 0 {
-				fmt.Printf("\n")
+				fmt.Fprintf(w, "\n")
 			}
 		}
 	}
 
 	if hasXdsConfig {
-		if err := clientutil.PrintDetailedConfig(response, opts); err != nil {
+		if err := clientutil.PrintDetailedConfig(w, response, opts); err != nil {
 			return err
 		}
 	}
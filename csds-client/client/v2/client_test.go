@@ -5,8 +5,11 @@ import (
 	"envoy-tools/csds-client/client"
 	clientUtil "envoy-tools/csds-client/client/util"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	csdspb_v2 "github.com/envoyproxy/go-control-plane/envoy/service/status/v2"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -104,7 +107,7 @@ func TestParseResponseWithoutNodeId(t *testing.T) {
 		t.Errorf("Read From File Failure: %v", err)
 	}
 	out := clientUtil.CaptureOutput(func() {
-		if err := printOutResponse(&response, c.opts); err != nil {
+		if err := printOutResponse(os.Stdout, &response, c.opts); err != nil {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
@@ -118,7 +121,7 @@ test_node_3                                        test_stream_type3
 	}
 }
 
-// TestParseResponseWithNodeId tests post processing response with node_id
+// TestParseResponseWithNodeId tests post processing response with node_id to -output_file
 func TestParseResponseWithNodeId(t *testing.T) {
 	c := ClientV2{
 		opts: client.ClientOptions{
@@ -135,26 +138,40 @@ func TestParseResponseWithNodeId(t *testing.T) {
 	if err = protojson.Unmarshal(responsejson, &response); err != nil {
 		t.Errorf("Read From File Failure: %v", err)
 	}
+	w, closeOut, err := clientUtil.OpenOutput(c.opts)
+	if err != nil {
+		t.Fatalf("Open output file failure: %v", err)
+	}
 	out := clientUtil.CaptureOutput(func() {
-		if err := printOutResponse(&response, c.opts); err != nil {
+		if err := printOutResponse(w, &response, c.opts); err != nil {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
-	want := `Client ID                                          xDS stream type                Config Status                  
-test_nodeid                                        test_stream_type1              RDS   STALE                    
-                                                                                  CDS   STALE                    
-Config has been saved to test_config.json
-`
-	if out != want {
-		t.Errorf("want\n%vout\n%v", want, out)
+	if err := closeOut(); err != nil {
+		t.Errorf("Close output file failure: %v", err)
+	}
+	if out != "" {
+		t.Errorf("want nothing printed to stdout, got\n%v", out)
 	}
 
 	outfile, _ := filepath.Abs("./test_config.json")
-	outputjson, err := ioutil.ReadFile(outfile)
+	output, err := ioutil.ReadFile(outfile)
 	if err != nil {
 		t.Errorf("Write config to file failure: %v", err)
 	}
-	ok, err := clientUtil.EqualJSONBytes(outputjson, responsejson)
+	parts := strings.SplitN(string(output), "Detailed Config:\n", 2)
+	if len(parts) != 2 {
+		t.Fatalf("want the detailed config in the output file, got\n%v", string(output))
+	}
+	want := `Client ID                                          xDS stream type                Config Status                  
+test_nodeid                                        test_stream_type1              RDS   STALE                    
+                                                                                  CDS   STALE                    
+`
+	if parts[0] != want {
+		t.Errorf("want\n%vout\n%v", want, parts[0])
+	}
+
+	ok, err := clientUtil.EqualJSONBytes([]byte(parts[1]), responsejson)
 	if err != nil {
 		t.Errorf("failed to parse json")
 	}
@@ -212,7 +229,7 @@ func TestNodeIdPrefixFilter(t *testing.T) {
 		t.Errorf("Read From File Failure: %v", err)
 	}
 	out := clientUtil.CaptureOutput(func() {
-		if err := printOutResponse(&response, c.opts); err != nil {
+		if err := printOutResponse(os.Stdout, &response, c.opts); err != nil {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
@@ -245,13 +262,13 @@ func TestNodeIdSuffixFilter(t *testing.T) {
 		t.Errorf("Read From File Failure: %v", err)
 	}
 	out := clientUtil.CaptureOutput(func() {
-		if err := printOutResponse(&response, c.opts); err != nil {
+		if err := printOutResponse(os.Stdout, &response, c.opts); err != nil {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
 	want := `Client ID                                          xDS stream type                Config Status                  
-test_node_3                                        test_stream_type3              N/A                            
 node_3                                             test_stream_type4              N/A                            
+test_node_3                                        test_stream_type3              N/A                            
 `
 	if out != want {
 		t.Errorf("want\n%vout\n%v", want, out)
@@ -277,7 +294,7 @@ func TestNodeIdRegexFilter(t *testing.T) {
 		t.Errorf("Read From File Failure: %v", err)
 	}
 	out := clientUtil.CaptureOutput(func() {
-		if err := printOutResponse(&response, c.opts); err != nil {
+		if err := printOutResponse(os.Stdout, &response, c.opts); err != nil {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
@@ -326,3 +343,36 @@ func TestParseNodeMatcherMeshScopeAndNetworkShouldFail(t *testing.T) {
 		t.Errorf("Parse NodeMatcher should fail since network name and meshScope are provided.")
 	}
 }
+
+// TestV3OnlyOptions tests that the options only implemented by the v3 api version are rejected, rather
+// than silently ignored
+func TestV3OnlyOptions(t *testing.T) {
+	tests := []struct {
+		opts client.ClientOptions
+		want string
+	}{
+		{opts: client.ClientOptions{AssertConsistent: true}, want: "assert_consistent is not supported by the v2 api version"},
+		{opts: client.ClientOptions{SelfDiff: time.Second}, want: "self_diff and self_diff_fail are not supported by the v2 api version"},
+		{opts: client.ClientOptions{SelfDiffFail: true}, want: "self_diff and self_diff_fail are not supported by the v2 api version"},
+		{opts: client.ClientOptions{SqliteOut: "out.db"}, want: "sqlite_out is not supported by the v2 api version"},
+		{opts: client.ClientOptions{MetaMissing: "key"}, want: "meta_missing is not supported by the v2 api version"},
+		{opts: client.ClientOptions{DrainStream: true}, want: "drain_stream and drain_timeout are not supported by the v2 api version"},
+		{opts: client.ClientOptions{DrainTimeout: time.Second}, want: "drain_stream and drain_timeout are not supported by the v2 api version"},
+		{opts: client.ClientOptions{RouteTable: true}, want: "route_table is not supported by the v2 api version"},
+		{opts: client.ClientOptions{ProbePath: "example.com/"}, want: "probe_path, probe_method and probe_header are not supported by the v2 api version"},
+		{opts: client.ClientOptions{ProbeMethod: "GET"}, want: "probe_path, probe_method and probe_header are not supported by the v2 api version"},
+		{opts: client.ClientOptions{ProbeHeaders: []string{"name=value"}}, want: "probe_path, probe_method and probe_header are not supported by the v2 api version"},
+		{opts: client.ClientOptions{Transform: "cat"}, want: "transform is not supported by the v2 api version"},
+		{opts: client.ClientOptions{Verbose: true}, want: "verbose is not supported by the v2 api version"},
+		{opts: client.ClientOptions{Color: "always"}, want: "color is not supported by the v2 api version"},
+		{opts: client.ClientOptions{RequestTimeout: time.Second}, want: "request_timeout is not supported by the v2 api version"},
+		{opts: client.ClientOptions{LenientYaml: true}, want: "lenient_yaml is not supported by the v2 api version"},
+	}
+	for _, tt := range tests {
+		tt.opts.Platform = "gcp"
+		tt.opts.RequestYaml = "{node_matchers: [{node_id: {exact: fake_node_id}}]}"
+		if _, err := New(tt.opts); err == nil || err.Error() != tt.want {
+			t.Errorf("want error %q, got %v", tt.want, err)
+		}
+	}
+}
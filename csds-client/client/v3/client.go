@@ -2,6 +2,7 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"envoy-tools/csds-client/client"
@@ -9,14 +10,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"sort"
 	"strings"
 	"time"
 
+	envoy_admin_v3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
 	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
 	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 )
@@ -27,9 +34,36 @@ type ClientV3 struct {
 	csdsClient csdspb_v3.ClientStatusDiscoveryServiceClient
 
 	nodeMatcher []*envoy_type_matcher_v3.NodeMatcher
-	node envoy_config_core_v3.Node
+	node        *envoy_config_core_v3.Node
 	metadata    metadata.MD
 	opts        client.ClientOptions
+
+	// receiver is only used by -drain_stream to receive from the current stream with a timeout
+	receiver *streamReceiver
+	// sqlite is only used by -sqlite_out to record each response
+	sqlite *sqliteExporter
+	// metrics is only used by -metrics_addr to export each response, nil otherwise
+	metrics *monitorMetrics
+	// timing is only used by -timing to report the duration of the dial and of each request, nil otherwise
+	timing *requestTiming
+	// compression is only used by -compress with -verbose to report the size of each response, nil otherwise
+	compression *compressionStats
+	// out is where the responses are rendered, nil for stdout
+	out io.Writer
+	// baseline is only used by -monitor_diff to compare each response with the previous one
+	baseline snapshot
+	// dialOptions are added to the options of the connection, e.g. to dial an in-process server in tests
+	dialOptions []grpc.DialOption
+	// backoff is the backoff between the retries of -max_retries, which tests shorten
+	backoff clientutil.Backoff
+	// jwt is the key of the jwt authentication mode, read once by New from -jwt_file or -jwt_env
+	jwt []byte
+	// request is the request sent to the server, built by BuildRequest
+	request *csdspb_v3.ClientStatusRequest
+	// waitDeadline is only used by -wait_for_clients to stop polling, zero until the first poll
+	waitDeadline time.Time
+	// dials is the number of connections dialed by the client, which -verbose reports on each redial
+	dials int
 }
 
 // Field keys that must be presented in the NodeMatcher
@@ -41,328 +75,2369 @@ const (
 
 // parseNodeMatcher parses the csds request yaml from -request_file and -request_yaml to nodematcher
 // if -request_file and -request_yaml are both set, the values in this yaml string will override and
-// merge with the request loaded from -request_file
+// merge with the request loaded from -request_file. The id of the node is then overridden by -node_id, which
+// is enough of a request with -request_mode node_only.
 func (c *ClientV3) parseNodeMatcher() error {
-	if c.opts.RequestFile == "" && c.opts.RequestYaml == "" {
+	if c.opts.RequestFile == "" && c.opts.RequestYaml == "" && (c.opts.NodeId == "" || c.opts.RequestMode != "node_only") {
 		return errors.New("missing request yaml")
 	}
 
 	var nodematchers []*envoy_type_matcher_v3.NodeMatcher
-	var node envoy_config_core_v3.Node
-	if err := parseYaml(c.opts.RequestFile, c.opts.RequestYaml, &nodematchers, &node); err != nil {
+	node := &envoy_config_core_v3.Node{}
+	if err := parseYaml(c.opts.RequestFile, c.opts.RequestYaml, &nodematchers, node, c.opts.LenientYaml); err != nil {
 		return err
 	}
 
 	c.nodeMatcher = nodematchers
 	c.node = node
+	c.overrideNodeId()
+
+	var errs []error
+	switch c.opts.RequestMode {
+	case "node_only":
+		if c.node.GetId() == "" {
+			errs = append(errs, errors.New("missing node id in the request yaml or node_id, required by request_mode node_only"))
+		}
+		// the NodeMatchers are not sent
+		return client.JoinRequestErrors(append(errs, c.validateFilterMode())...)
+	case "matchers_only":
+		if len(c.nodeMatcher) == 0 {
+			errs = append(errs, errors.New("missing node_matchers in the request yaml, required by request_mode matchers_only"))
+		}
+	}
 
-	// check if required fields exist in NodeMatcher
+	// check if required fields exist in NodeMatcher, collecting every problem to report them at once
 	switch c.opts.Platform {
 	case "gcp":
 		// Project Number is necessary
 		if value := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpProjectNumberKey); value == "" {
-			return fmt.Errorf("missing field %v in NodeMatcher", gcpProjectNumberKey)
+			errs = append(errs, fmt.Errorf("missing field %v in NodeMatcher", gcpProjectNumberKey))
 		}
 
 		// Only one of these must be set.
 		networkNameValue := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpNetworkNameKey)
 		meshScopeValue := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpMeshScopeKey)
 		if len(networkNameValue) == 0 && len(meshScopeValue) == 0 {
-			return fmt.Errorf("must set either %v or %v", gcpNetworkNameKey, gcpMeshScopeKey)
+			errs = append(errs, fmt.Errorf("must set either %v or %v", gcpNetworkNameKey, gcpMeshScopeKey))
 		} else if len(networkNameValue) > 0 && len(meshScopeValue) > 0 {
-			return fmt.Errorf("cannot set both %v or %v", gcpNetworkNameKey, gcpMeshScopeKey)
+			errs = append(errs, fmt.Errorf("cannot set both %v or %v", gcpNetworkNameKey, gcpMeshScopeKey))
 		}
 	default:
-		return fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
+		if !supportsPlatform(c.opts.AuthnMode, c.opts.Platform) {
+			errs = append(errs, fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform))
+		}
 	}
 
-	if c.opts.FilterMode != "" && c.opts.FilterMode != "prefix" && c.opts.FilterMode != "suffix" && c.opts.FilterMode != "regex" {
-		return fmt.Errorf("%s filter mode is not supported, list of supported filter modes: prefix, suffix, regex", c.opts.FilterMode)
+	return client.JoinRequestErrors(append(errs, c.validateFilterMode())...)
+}
+
+// overrideNodeId sets the id of the node of the request to -node_id, which wins over the id of the request yaml
+func (c *ClientV3) overrideNodeId() {
+	if c.opts.NodeId == "" {
+		return
 	}
+	if c.opts.Verbose && c.node.GetId() != "" && c.node.GetId() != c.opts.NodeId {
+		fmt.Fprintf(os.Stderr, "Node id %s of the request yaml is overridden by node_id %s\n", c.node.GetId(), c.opts.NodeId)
+	}
+	c.node.Id = c.opts.NodeId
+}
 
-	return nil
+// validateFilterMode checks if -filter_mode is supported, and that -filter_pattern and -filter_invert are valid with it
+func (c *ClientV3) validateFilterMode() error {
+	var errs []error
+	switch c.opts.FilterMode {
+	case "", "prefix", "suffix", "exact", "regex", "glob":
+	default:
+		errs = append(errs, fmt.Errorf("%s filter mode is not supported, list of supported filter modes: prefix, suffix, exact, regex, glob", c.opts.FilterMode))
+	}
+	if c.opts.FilterInvert && c.opts.FilterPattern == "" {
+		errs = append(errs, errors.New("filter_invert can only be used with filter_pattern"))
+	}
+	if err := clientutil.ValidateFilterPattern(c.opts.FilterMode, c.opts.FilterPattern); err != nil {
+		errs = append(errs, err)
+	}
+	return client.JoinRequestErrors(errs...)
 }
 
-// connWithAuth connects to uri with authentication
-func (c *ClientV3) connWithAuth() error {
-	var err error
-	switch c.opts.AuthnMode {
-	case "jwt":
-		switch c.opts.Platform {
-		case "gcp":
-			c.clientConn, err = clientutil.ConnToGCPWithJwt(c.opts.Jwt, c.opts.Uri)
-			if err != nil {
-				return err
-			}
-			return nil
-		default:
-			return fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
+// endpoint is a control plane to connect to, and the platform whose credentials are used for it
+type endpoint struct {
+	uri      string
+	platform string
+}
+
+// parseEndpoint selects the platform of a -service_uri value. The platform can be set explicitly by
+// prefixing the uri with "<platform>=", otherwise it is derived from the host of the uri, falling
+// back to defaultPlatform.
+func parseEndpoint(uri string, defaultPlatform string) endpoint {
+	if i := strings.Index(uri, "="); i > 0 && !strings.Contains(uri[:i], "/") {
+		return endpoint{uri: uri[i+1:], platform: uri[:i]}
+	}
+	if strings.HasSuffix(clientutil.UriHost(uri), ".googleapis.com") {
+		return endpoint{uri: uri, platform: "gcp"}
+	}
+	return endpoint{uri: uri, platform: defaultPlatform}
+}
+
+// setUserProject parses the GCP project number of the NodeMatcher as the x-goog-user-project header for authentication
+func (c *ClientV3) setUserProject() {
+	if projectNum := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpProjectNumberKey); projectNum != "" {
+		c.metadata = metadata.Pairs("x-goog-user-project", projectNum)
+	}
+}
+
+// connWithAuth connects to the uri of ep with the authentication of its platform
+func (c *ClientV3) connWithAuth(ctx context.Context, ep endpoint) (err error) {
+	ctx, span := clientutil.StartSpan(ctx, "connect",
+		clientutil.PlatformKey.String(ep.platform), clientutil.UriKey.String(clientutil.SanitizeUri(ep.uri)))
+	defer func() {
+		// attribute the error to the platform of the endpoint
+		if err != nil {
+			err = fmt.Errorf("%s endpoint %s: %w", ep.platform, clientutil.SanitizeUri(ep.uri), err)
 		}
+		clientutil.EndSpan(span, err)
+	}()
 
+	proxyOption, err := clientutil.ProxyDialOption(c.opts.Proxy)
+	if err != nil {
+		return err
+	}
+	opts := []grpc.DialOption{proxyOption}
+	opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(clientutil.MaxRecvBytes(c.opts))), clientutil.KeepaliveDialOption(c.opts))
+	opts = append(opts, compressDialOptions(c.opts.Compress, c.compression)...)
+	opts = append(opts, c.dialOptions...)
+
+	if !supportsPlatform(c.opts.AuthnMode, ep.platform) {
+		return fmt.Errorf("%s platform is not supported by %s authentication mode, list of supported platforms: gcp", ep.platform, c.opts.AuthnMode)
+	}
+	switch c.opts.AuthnMode {
+	case "mtls":
+		c.clientConn, err = clientutil.ConnWithMtls(ctx, ep.uri, clientutil.ConnectTimeout(c.opts), c.opts.ClientCert, c.opts.ClientKey, c.opts.CaCert, opts...)
+	case "insecure":
+		c.clientConn, err = clientutil.ConnInsecure(ctx, ep.uri, clientutil.ConnectTimeout(c.opts), opts...)
+	case "jwt":
+		c.clientConn, err = clientutil.ConnToGCPWithJwt(ctx, c.jwt, ep.uri, c.opts.CaCert, clientutil.ConnectTimeout(c.opts), opts...)
 	case "auto":
-		switch c.opts.Platform {
-		case "gcp":
-			// parse GCP project number as header for authentication
-			if projectNum := getValueByKeyFromNodeMatcher(c.nodeMatcher, gcpProjectNumberKey); projectNum != "" {
-				c.metadata = metadata.Pairs("x-goog-user-project", projectNum)
-			}
-			c.clientConn, err = clientutil.ConnToGCPWithAuto(c.opts.Uri)
-			if err != nil {
-				return err
-			}
-			return nil
-		default:
-			return errors.New("auto authentication mode for this platform is not supported. Please use jwt_file instead")
+		// the ambient credentials work on any platform, but only gcp knows the x-goog-user-project header
+		if ep.platform == "gcp" {
+			c.setUserProject()
 		}
+		c.clientConn, err = clientutil.ConnWithAuto(ctx, ep.uri, c.opts.CaCert, clientutil.ConnectTimeout(c.opts), opts...)
+	case "adc":
+		c.setUserProject()
+		c.clientConn, err = clientutil.ConnToGCPWithAdc(ctx, ep.uri, c.opts.CaCert, clientutil.ConnectTimeout(c.opts), opts...)
+	case "sa":
+		c.setUserProject()
+		c.clientConn, err = clientutil.ConnToGCPWithServiceAccount(ctx, c.opts.ServiceAccountFile, ep.uri, c.opts.CaCert, clientutil.ConnectTimeout(c.opts), opts...)
 	default:
 		return errors.New("invalid authn_mode")
 	}
+	return err
+}
+
+// supportsPlatform reports whether authnMode can connect to the endpoints of platform. mtls and insecure
+// don't use the credentials of any platform, auto uses the ambient credentials of the environment whatever
+// the platform, and jwt, adc and sa the credentials of gcp only.
+func supportsPlatform(authnMode string, platform string) bool {
+	return platform == "gcp" || authnMode == "auto" || clientutil.PlatformIndependent(authnMode)
 }
 
 // New creates a new client with v3 api version
 func New(option client.ClientOptions) (*ClientV3, error) {
 	c := &ClientV3{
-		opts: option,
+		opts:    option,
+		backoff: clientutil.DefaultBackoff,
+	}
+	if c.opts.Timing {
+		c.timing = newRequestTiming("")
 	}
-	if c.opts.Platform != "gcp" {
+	if c.opts.Compress && c.opts.Verbose {
+		c.compression = newCompressionStats("")
+	}
+	if !supportsPlatform(c.opts.AuthnMode, c.opts.Platform) {
 		return nil, fmt.Errorf("%s platform is not supported, list of supported platforms: gcp", c.opts.Platform)
 	}
 
-	if err := c.parseNodeMatcher(); err != nil {
-		return nil, err
+	if c.opts.ConnectTimeout < 0 {
+		return nil, errors.New("connect_timeout must not be negative")
+	}
+	if c.opts.RequestTimeout < 0 {
+		return nil, errors.New("request_timeout must not be negative")
+	}
+	if c.opts.KeepaliveInterval < 0 {
+		return nil, errors.New("keepalive_interval must not be negative")
+	}
+	if c.opts.KeepaliveTimeout < 0 {
+		return nil, errors.New("keepalive_timeout must not be negative")
 	}
 
-	return c, nil
-}
+	if c.opts.MaxRecvBytes < 0 {
+		return nil, errors.New("max_recv_bytes must not be negative")
+	}
+	if c.opts.MaxRetries < 0 {
+		return nil, errors.New("max_retries must not be negative")
+	}
+	if c.opts.RequestRetries < 0 {
+		return nil, errors.New("request_retries must not be negative")
+	}
+	if c.opts.Proxy != "" {
+		if _, err := clientutil.ParseProxy(c.opts.Proxy); err != nil {
+			return nil, err
+		}
+	}
 
-// Run connects the client to the uri and calls doRequest
-func (c *ClientV3) Run() error {
-	if err := c.connWithAuth(); err != nil {
-		return err
+	if c.opts.DrainStream && c.opts.DrainTimeout <= 0 {
+		return nil, errors.New("drain_timeout must be greater than 0 when drain_stream is enabled")
+	}
+	if c.opts.Stream && !c.opts.DrainStream {
+		return nil, errors.New("stream can only be used with drain_stream")
 	}
-	defer c.clientConn.Close()
 
-	c.csdsClient = csdspb_v3.NewClientStatusDiscoveryServiceClient(c.clientConn)
-	var ctx context.Context
-	if c.metadata != nil {
-		ctx = metadata.NewOutgoingContext(context.Background(), c.metadata)
-	} else {
-		ctx = context.Background()
+	switch c.opts.RequestMode {
+	case "", "both", "matchers_only", "node_only":
+	default:
+		return nil, fmt.Errorf("%s request mode is not supported, list of supported request modes: both, matchers_only, node_only", c.opts.RequestMode)
 	}
 
-	streamClientStatus, err := c.csdsClient.StreamClientStatus(ctx)
-	if err != nil {
-		return err
+	switch c.opts.Color {
+	case "", "auto", "always", "never":
+	default:
+		return nil, fmt.Errorf("%s color mode is not supported, list of supported color modes: auto, always, never", c.opts.Color)
 	}
 
-	// run once or run with monitor mode
-	for {
-		if err := c.doRequest(streamClientStatus); err != nil {
-			// timeout error
-			// retry to connect
-			if strings.Contains(err.Error(), "RpcSecurityPolicy") {
-				streamClientStatus, err = c.csdsClient.StreamClientStatus(ctx)
-				if err != nil {
-					return err
-				}
-				continue
-			} else {
-				return err
-			}
+	if c.opts.Offset < 0 {
+		return nil, errors.New("offset must not be negative")
+	}
+
+	if !isSortMode(c.opts.Sort) {
+		return nil, fmt.Errorf("%s sort mode is not supported, list of supported sort modes: %s", c.opts.Sort, strings.Join(sortModes, ", "))
+	}
+
+	switch c.opts.OutputFormat {
+	case "", "text", "compact", "matrix", "json", "jsonl", "yaml", "csv", "prototext":
+	default:
+		return nil, fmt.Errorf("%s output format is not supported, list of supported output formats: text, compact, matrix, json, jsonl, yaml, csv, prototext", c.opts.OutputFormat)
+	}
+
+	if c.opts.SelfDiff < 0 {
+		return nil, errors.New("self_diff must not be negative")
+	}
+	if c.opts.SelfDiff != 0 && c.opts.MonitorInterval != 0 {
+		return nil, errors.New("self_diff cannot be used in monitor mode")
+	}
+
+	if c.opts.AssertConsistent && (c.opts.MonitorInterval != 0 || c.opts.SelfDiff != 0) {
+		return nil, errors.New("assert_consistent cannot be used in monitor mode or with self_diff")
+	}
+
+	if c.opts.FailOn != "" {
+		if c.opts.MonitorInterval != 0 || c.opts.SelfDiff != 0 {
+			return nil, errors.New("fail_on cannot be used in monitor mode or with self_diff")
 		}
-		if c.opts.MonitorInterval != 0 {
-			time.Sleep(c.opts.MonitorInterval)
-		} else {
-			if err = streamClientStatus.CloseSend(); err != nil {
-				return err
+		for _, status := range parseStatusList(c.opts.FailOn) {
+			if _, ok := csdspb_v3.ConfigStatus_value[status]; !ok {
+				return nil, fmt.Errorf("%s config status is not supported by fail_on, list of supported config statuses: UNKNOWN, SYNCED, NOT_SENT, STALE, ERROR", status)
 			}
-			return nil
 		}
 	}
-}
 
-// doRequest sends request and prints out the parsed response
-func (c *ClientV3) doRequest(streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient) error {
+	if c.opts.NodeId != "" && strings.TrimSpace(c.opts.NodeId) == "" {
+		return nil, errors.New("node_id must not be blank")
+	}
+	if c.opts.NodeId != "" && c.opts.RequestMode == "matchers_only" {
+		return nil, errors.New("node_id cannot be used with request_mode matchers_only, which doesn't send the node")
+	}
+
+	if c.opts.TolerateStaleFor < 0 {
+		return nil, errors.New("tolerate_stale_for must not be negative")
+	}
+	if c.opts.TolerateStaleFor > 0 && c.opts.FailOn == "" {
+		return nil, errors.New("tolerate_stale_for can only be used with fail_on")
+	}
 
-	req := &csdspb_v3.ClientStatusRequest{NodeMatchers: c.nodeMatcher, Node: &envoy_config_core_v3.Node{Id: c.node.Id}}
-	if err := streamClientStatus.Send(req); err != nil {
-		return err
+	if c.opts.FailOnNoClients && (c.opts.MonitorInterval != 0 || c.opts.SelfDiff != 0) {
+		return nil, errors.New("fail_on_no_clients cannot be used in monitor mode or with self_diff")
 	}
 
-	resp, err := streamClientStatus.Recv()
-	if err != nil && err != io.EOF {
-		return err
+	if c.opts.CheckConnectivity {
+		// only a single request is sent, and nothing of its response is rendered
+		switch {
+		case c.opts.MonitorInterval != 0 || c.opts.SelfDiff != 0:
+			return nil, errors.New("check_connectivity cannot be used in monitor mode or with self_diff")
+		case c.opts.InputFile != "" || c.opts.DryRun || len(splitUris(c.opts.Uri)) > 1:
+			return nil, errors.New("check_connectivity cannot be used with input_file, dry_run or several uris")
+		}
 	}
-	// post process response
-	if err := printOutResponse(resp, c.opts); err != nil {
-		return err
+
+	if c.opts.WaitForClients < 0 {
+		return nil, errors.New("wait_for_clients must not be negative")
+	}
+	if c.opts.WaitForClients != 0 {
+		// the wait is a single request polled until it has clients
+		switch {
+		case c.opts.MonitorInterval != 0 || c.opts.SelfDiff != 0:
+			return nil, errors.New("wait_for_clients cannot be used in monitor mode or with self_diff")
+		case c.opts.Stream || c.opts.InputFile != "" || len(splitUris(c.opts.Uri)) > 1:
+			return nil, errors.New("wait_for_clients cannot be used with stream, input_file or several uris")
+		case c.opts.WaitInterval <= 0:
+			return nil, errors.New("wait_interval must be greater than 0 when wait_for_clients is enabled")
+		}
 	}
 
-	return nil
-}
+	if c.opts.SummaryOnly {
+		if clientutil.IsStructuredOutput(c.opts) {
+			return nil, fmt.Errorf("summary_only cannot be used with the %s output format", c.opts.OutputFormat)
+		}
+		if c.opts.RouteTable || c.opts.ProbePath != "" {
+			return nil, errors.New("summary_only cannot be used with route_table or probe_path")
+		}
+	}
 
-// parseConfigStatus parses each xds config status to string
-func parseConfigStatus(xdsConfig []*csdspb_v3.ClientConfig_GenericXdsConfig) ([]string, error) {
-	var configStatus []string
-	for _, genericXdsConfig := range xdsConfig {
-		status := genericXdsConfig.GetConfigStatus().String()
-		var xds string
-		switch genericXdsConfig.GetTypeUrl() {
-		case "type.googleapis.com/envoy.config.cluster.v3.Cluster":
-			xds = "CDS"
-		case "type.googleapis.com/envoy.config.listener.v3.Listener":
-			xds = "LDS"
-		case "type.googleapis.com/envoy.config.route.v3.RouteConfiguration":
-			xds = "RDS"
-		case "type.googleapis.com/envoy.config.route.v3.ScopedRouteConfiguration":
-			xds = "SRDS"
-		case "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment":
-			xds = "EDS"
+	// the raw response replaces the whole output, so nothing else can shape it
+	if c.opts.DumpRaw {
+		switch {
+		case c.opts.OutputFormat != "" && c.opts.OutputFormat != "text":
+			return nil, fmt.Errorf("dump_raw cannot be used with the %s output format", c.opts.OutputFormat)
+		case c.opts.SummaryOnly || c.opts.MonitorDiff || c.opts.RouteTable || c.opts.ProbePath != "":
+			return nil, errors.New("dump_raw cannot be used with summary_only, monitor_diff, route_table or probe_path")
+		}
+	}
+
+	if c.opts.Columns != "" {
+		if c.opts.OutputFormat != "" && c.opts.OutputFormat != "text" {
+			return nil, fmt.Errorf("columns cannot be used with the %s output format", c.opts.OutputFormat)
+		}
+		if _, err := parseColumns(c.opts.Columns); err != nil {
+			return nil, err
+		}
+	}
+
+	// the table of the former releases has none of the columns added since
+	if c.opts.FixedWidth {
+		switch {
+		case c.opts.OutputFormat != "" && c.opts.OutputFormat != "text":
+			return nil, fmt.Errorf("fixed_width cannot be used with the %s output format", c.opts.OutputFormat)
+		case c.opts.Columns != "" || c.opts.NoTruncate || c.opts.ShowVersion || c.opts.ShowLocality || c.opts.ShowErrors || c.opts.Stream:
+			return nil, errors.New("fixed_width cannot be used with columns, no_truncate, show_version, show_locality, show_errors or stream")
+		case len(splitUris(c.opts.Uri)) > 1:
+			return nil, errors.New("fixed_width cannot be used with several uris")
+		}
+	}
+
+	if c.opts.ShowVersion {
+		// the multiline protobuf text format has the version_info of the resources already
+		switch {
+		case c.opts.OutputFormat == "compact" || c.opts.OutputFormat == "matrix" || c.opts.OutputFormat == "prototext":
+			return nil, fmt.Errorf("show_version cannot be used with the %s output format", c.opts.OutputFormat)
+		case c.opts.Columns != "":
+			return nil, errors.New("show_version cannot be used with columns, add the version column instead")
+		}
+	}
+	if c.opts.ShowLocality {
+		// the multiline protobuf text format has the locality of the nodes already
+		switch {
+		case c.opts.OutputFormat == "compact" || c.opts.OutputFormat == "matrix" || c.opts.OutputFormat == "prototext":
+			return nil, fmt.Errorf("show_locality cannot be used with the %s output format", c.opts.OutputFormat)
+		case c.opts.Columns != "":
+			return nil, errors.New("show_locality cannot be used with columns, add the locality column instead")
+		}
+	}
+
+	// the report replaces the table, so only the text output format can hold it
+	if c.opts.VersionSkew {
+		switch {
+		case c.opts.OutputFormat != "" && c.opts.OutputFormat != "text":
+			return nil, fmt.Errorf("version_skew cannot be used with the %s output format", c.opts.OutputFormat)
+		case c.opts.SummaryOnly || c.opts.DumpRaw || c.opts.Columns != "" || c.opts.RouteTable || c.opts.ProbePath != "":
+			return nil, errors.New("version_skew cannot be used with summary_only, dump_raw, columns, route_table or probe_path")
+		}
+	}
+
+	// the template replaces the table, the summary and the detailed config
+	if c.opts.Template != "" {
+		switch {
+		case c.opts.OutputFormat != "" && c.opts.OutputFormat != "text":
+			return nil, fmt.Errorf("template cannot be used with the %s output format", c.opts.OutputFormat)
+		case c.opts.SummaryOnly || c.opts.Columns != "" || c.opts.VersionSkew || c.opts.DumpRaw || c.opts.RouteTable || c.opts.ProbePath != "":
+			return nil, errors.New("template cannot be used with summary_only, columns, version_skew, dump_raw, route_table or probe_path")
+		case c.opts.MonitorDiff || c.opts.DumpDir != "" || c.opts.Visualization || c.opts.MonitorOutputDir != "":
+			return nil, errors.New("template cannot be used with monitor_diff, dump_dir, visualization or monitor_output_dir")
+		}
+		text, err := loadTemplate(c.opts.Template)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := parseTemplate(text, false, c.opts.UTC); err != nil {
+			return nil, err
+		}
+	}
+
+	// the error details are printed beneath the rows of the table only
+	if c.opts.ShowErrors && c.opts.OutputFormat != "" && c.opts.OutputFormat != "text" {
+		return nil, fmt.Errorf("show_errors cannot be used with the %s output format", c.opts.OutputFormat)
+	}
+
+	for _, xds := range parseXdsTypes(c.opts.XdsType) {
+		if !isKnownXds(xds) {
+			return nil, fmt.Errorf("%s xDS type is not supported by xds_type, list of supported xDS types: %s", xds, strings.Join(knownXds, ", "))
+		}
+	}
+	for _, status := range parseStatusList(c.opts.StatusFilter) {
+		if _, ok := csdspb_v3.ConfigStatus_value[status]; !ok {
+			return nil, fmt.Errorf("%s config status is not supported by status_filter, list of supported config statuses: UNKNOWN, SYNCED, NOT_SENT, STALE, ERROR", status)
+		}
+	}
+	if c.opts.ResourceName != "" {
+		switch c.opts.ResourceNameMode {
+		case "prefix", "suffix", "exact", "regex", "glob":
 		default:
-			return nil, fmt.Errorf("Unsupported XDS type")
+			return nil, fmt.Errorf("%s filter mode is not supported by resource_name_mode, list of supported filter modes: prefix, suffix, exact, regex, glob", c.opts.ResourceNameMode)
 		}
-		if status != "" && xds != "" {
-			configStatus = append(configStatus, xds+"   "+status)
+		if err := clientutil.ValidateFilterPattern(c.opts.ResourceNameMode, c.opts.ResourceName); err != nil {
+			return nil, fmt.Errorf("resource_name: %v", err)
 		}
 	}
-	return configStatus, nil
-}
 
-// printOutResponse processes response and print
-func printOutResponse(response *csdspb_v3.ClientStatusResponse, opts client.ClientOptions) error {
-	if response.GetConfig() == nil || len(response.GetConfig()) == 0 {
-		fmt.Printf("No xDS clients connected.\n")
-		return nil
-	} else {
-		fmt.Printf("%-50s %-30s %-30s \n", "Client ID", "xDS stream type", "Config Status")
+	if c.opts.MonitorDiff {
+		if c.opts.MonitorInterval == 0 {
+			return nil, errors.New("monitor_diff can only be used in monitor mode")
+		}
+		if clientutil.IsStructuredOutput(c.opts) {
+			return nil, fmt.Errorf("monitor_diff cannot be used with the %s output format", c.opts.OutputFormat)
+		}
+	}
+	if c.opts.Watch {
+		if c.opts.MonitorInterval == 0 {
+			return nil, errors.New("watch can only be used in monitor mode")
+		}
+		if clientutil.IsStructuredOutput(c.opts) {
+			return nil, fmt.Errorf("watch cannot be used with the %s output format", c.opts.OutputFormat)
+		}
+	}
+	if c.opts.MonitorCount < 0 {
+		return nil, errors.New("monitor_count must not be negative")
+	}
+	if c.opts.MonitorCount != 0 && c.opts.MonitorInterval == 0 {
+		return nil, errors.New("monitor_count can only be used in monitor mode")
+	}
+	if c.opts.MetricsAddr != "" && c.opts.MonitorInterval == 0 {
+		return nil, errors.New("metrics_addr can only be used in monitor mode")
+	}
+	if c.opts.MonitorOutputDir != "" && c.opts.MonitorInterval == 0 {
+		return nil, errors.New("monitor_output_dir can only be used in monitor mode")
+	}
+	if c.opts.OnlyLastCycle && c.opts.MonitorOutputDir == "" {
+		return nil, errors.New("only_last_cycle can only be used with monitor_output_dir")
 	}
 
-	var hasXdsConfig bool
+	// the output is discarded, so it can't be rendered anywhere else
+	if c.opts.Quiet && (c.opts.ConfigFile != "" || c.opts.Visualization) {
+		return nil, errors.New("quiet cannot be used with output_file or visualization")
+	}
+	// the relationships of the resources are read from their decoded configs
+	if c.opts.RawAny && c.opts.Visualization {
+		return nil, errors.New("visualization cannot be used with resolve_any disabled")
+	}
+	// these read the contents of the resources, which exclude_contents asks the server to omit
+	if c.opts.ExcludeContents && (c.opts.Visualization || c.opts.MonitorOutputDir != "" || c.opts.RouteTable || c.opts.ProbePath != "" || c.opts.DumpRaw) {
+		return nil, errors.New("exclude_contents cannot be used with visualization, monitor_output_dir, route_table, probe_path or dump_raw")
+	}
+	// the files replace the detailed config, so nothing else can render it or leave it out
+	if c.opts.DumpDir != "" {
+		switch {
+		case c.opts.ExcludeContents || c.opts.Visualization || c.opts.MonitorOutputDir != "":
+			return nil, errors.New("dump_dir cannot be used with exclude_contents, visualization or monitor_output_dir")
+		case c.opts.SummaryOnly || c.opts.MonitorDiff || c.opts.DumpRaw || c.opts.RouteTable || c.opts.ProbePath != "" || c.opts.VersionSkew:
+			return nil, errors.New("dump_dir cannot be used with summary_only, monitor_diff, dump_raw, route_table, probe_path or version_skew")
+		}
+	}
 
-	for _, config := range response.GetConfig() {
-		var id string
-		var xdsType string
-		if config.GetNode() != nil {
-			id = config.GetNode().GetId()
-			metadata := config.GetNode().GetMetadata().AsMap()
+	if c.opts.ProbePath != "" {
+		if c.opts.RouteTable {
+			return nil, errors.New("probe_path cannot be used with route_table")
+		}
+		if _, err := parseProbeRequest(c.opts.ProbePath, c.opts.ProbeHeaders, c.opts.ProbeMethod); err != nil {
+			return nil, err
+		}
+	} else if len(c.opts.ProbeHeaders) != 0 {
+		return nil, errors.New("probe_header can only be used with probe_path")
+	}
 
-			// control plane is expected to use "XDS_STREAM_TYPE" to communicate
-			// the stream type of the connected client in the response.
-			if metadata["XDS_STREAM_TYPE"] != nil {
-				xdsType = metadata["XDS_STREAM_TYPE"].(string)
-			}
+	// the outputs that can't show which endpoint each client was received from
+	if len(splitUris(c.opts.Uri)) > 1 {
+		switch {
+		case c.opts.OutputFormat == "matrix" || c.opts.OutputFormat == "prototext":
+			return nil, fmt.Errorf("the %s output format cannot be used with several uris", c.opts.OutputFormat)
+		case c.opts.SelfDiff != 0 || c.opts.MonitorDiff || c.opts.DumpRaw:
+			return nil, errors.New("self_diff, monitor_diff and dump_raw cannot be used with several uris")
+		case c.opts.RouteTable || c.opts.ProbePath != "":
+			return nil, errors.New("route_table and probe_path cannot be used with several uris")
+		}
+	}
 
-			// filter node id
-			if opts.FilterPattern != "" {
-				matched, err := clientutil.FilterNodeId(id, opts.FilterMode, opts.FilterPattern)
-				if err != nil {
-					return err
-				}
-				if !matched {
-					continue
-				}
-			}
+	if c.opts.InputFile != "" {
+		if c.opts.DryRun {
+			return nil, errors.New("dry_run cannot be used with input_file, no request is sent")
+		}
+		if err := c.validateInputFile(); err != nil {
+			return nil, err
 		}
+		return c, nil
+	}
 
-		if config.GetGenericXdsConfigs() == nil {
-			if config.GetNode() != nil {
-				fmt.Printf("%-50s %-30s %-30s \n", id, xdsType, "N/A")
-			}
-		} else {
-			hasXdsConfig = true
+	if err := c.parseNodeMatcher(); err != nil {
+		return nil, err
+	}
 
-			// parse config status
-			configStatus, err := parseConfigStatus(config.GetGenericXdsConfigs())
-			if err != nil {
-				fmt.Printf("Unable to parse config status: %v", err)
-			}
-			fmt.Printf("%-50s %-30s ", id, xdsType)
+	if err := c.readJwt(); err != nil {
+		return nil, err
+	}
 
-			for i := 0; i < len(configStatus); i++ {
-				if i == 0 {
-					fmt.Printf("%-30s \n", configStatus[i])
-				} else {
-					fmt.Printf("%-50s %-30s %-30s \n", "", "", configStatus[i])
-				}
-			}
-			if len(configStatus) == 0 {
-				fmt.Printf("\n")
-			}
+	// warned once here rather than by BuildRequest, which each endpoint of several uris calls
+	if c.opts.ExcludeContents {
+		fmt.Fprintf(os.Stderr, "WARNING: exclude_contents is not sent to the server: the ClientStatusRequest of go-control-plane %s has no exclude_resource_contents field, which requires %s or later. The resource contents are still received, and only left out of the output.\n",
+			clientutil.ModuleVersion(clientutil.GoControlPlaneModule), excludeContentsGoControlPlane)
+	}
+
+	return c, nil
+}
+
+// readJwt reads the key of the jwt authentication mode once, as stdin can't be read again on reconnects
+func (c *ClientV3) readJwt() (err error) {
+	if c.opts.AuthnMode != "jwt" {
+		if c.opts.JwtEnv != "" {
+			return errors.New("jwt_env can only be used with the jwt authentication mode")
 		}
+		return nil
+	}
+	if c.opts.Jwt == "-" && c.opts.RequestFile == "-" {
+		return errors.New("jwt_file and request_file cannot both be read from stdin")
+	}
+	c.jwt, err = clientutil.ReadJwt(c.opts.Jwt, c.opts.JwtEnv)
+	return err
+}
+
+// Run connects the client to the uri and calls doRequest
+func (c *ClientV3) Run() error {
+	return c.RunContext(context.Background())
+}
+
+// RunContext is Run with a context that the connection, the stream and its requests are bound to,
+// so that canceling ctx or exceeding its deadline tears down a hung request
+func (c *ClientV3) RunContext(ctx context.Context) (err error) {
+	if c.opts.InputFile != "" {
+		return c.runInputFile(ctx)
+	}
+	if c.opts.DryRun {
+		return printRequest(c.output(), c.BuildRequest())
+	}
+	if uris := splitUris(c.opts.Uri); len(uris) > 1 {
+		return c.runEndpoints(ctx, uris)
+	}
+	ep := parseEndpoint(c.opts.Uri, c.opts.Platform)
+	ctx, span := clientutil.StartSpan(ctx, "Run",
+		clientutil.PlatformKey.String(ep.platform), clientutil.UriKey.String(clientutil.SanitizeUri(ep.uri)))
+	defer func() { clientutil.EndSpan(span, err) }()
+
+	if c.opts.CheckConnectivity {
+		return c.checkConnectivity(ctx, ep)
+	}
+
+	// the connection is kept across the requests of the monitor mode, and replaced only if reopen redials it
+	defer c.Close()
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+	streamClientStatus, err := c.openStream(ctx)
+	if err != nil {
+		return err
+	}
+
+	out, closeOut, err := clientutil.OpenOutput(c.opts)
+	if err != nil {
+		return err
 	}
+	c.out = out
+	defer func() {
+		if closeErr := closeOut(); err == nil {
+			err = closeErr
+		}
+	}()
 
-	if hasXdsConfig {
-		if err := clientutil.PrintDetailedConfig(response, opts); err != nil {
+	if c.opts.SqliteOut != "" {
+		if c.sqlite, err = newSqliteExporter(c.opts.SqliteOut); err != nil {
 			return err
 		}
+		defer c.sqlite.Close()
 	}
-	return nil
-}
 
-// parseYaml is a helper method for parsing csds request yaml to NodeMatchers
-func parseYaml(path string, yamlStr string, nms *[]*envoy_type_matcher_v3.NodeMatcher, node *envoy_config_core_v3.Node) error {
-	if path != "" {
-		data, err := clientutil.ParseYamlFileToMap(path)
+	if c.opts.MetricsAddr != "" {
+		stopMetrics, err := c.startMetrics()
 		if err != nil {
 			return err
 		}
+		defer stopMetrics()
+	}
 
-		// parse each json object to proto
-		for _, n := range data["node_matchers"].([]interface{}) {
-			x := &envoy_type_matcher_v3.NodeMatcher{}
+	if c.metadata != nil {
+		ctx = metadata.NewOutgoingContext(ctx, c.metadata)
+	}
+	if c.opts.SelfDiff != 0 {
+		return c.selfDiff(ctx, streamClientStatus)
+	}
 
-			jsonString, err := json.Marshal(n)
-			if err != nil {
+	var stop <-chan struct{}
+	if c.opts.MonitorInterval != 0 {
+		var stopNotify func()
+		stop, stopNotify = clientutil.NotifyMonitorStop()
+		defer stopNotify()
+	}
+
+	// run once or run with monitor mode
+	var cycles, retries int
+	for {
+		if streamClientStatus, err = c.doRequest(ctx, streamClientStatus); err != nil {
+			// transient errors, e.g. the server restarting, are retried on a new stream with backoff
+			if err := clientutil.Retry(ctx, clientutil.InfoWriter(c.opts), err, c.opts.MaxRetries, c.backoff, &retries, func() (err error) {
+				c.metrics.retried()
+				streamClientStatus, err = c.reopen(ctx, ep)
 				return err
-			}
-			if err = protojson.Unmarshal(jsonString, x); err != nil {
+			}); err != nil {
 				return err
 			}
-			*nms = append(*nms, x)
+			continue
 		}
-
-		// Extract the node id from the request YAML
-		n := &envoy_config_core_v3.Node{}
-		if nv, ok := data["node"]; ok {
-			jsonString, err := json.Marshal(nv)
+		retries = 0
+		cycles++
+		if c.opts.MonitorInterval != 0 && (c.opts.MonitorCount == 0 || cycles < c.opts.MonitorCount) {
+			// SIGINT and SIGTERM stop the monitor after the current cycle, closing the stream cleanly
+			stopped, err := clientutil.WaitMonitorInterval(ctx, stop, c.opts.MonitorInterval)
 			if err != nil {
 				return err
 			}
-			if err = protojson.Unmarshal(jsonString, n); err != nil {
-				return err
+			if !stopped {
+				continue
 			}
-			*node = *n
+			fmt.Fprintln(os.Stderr, "monitor stopped")
 		}
-	}
-	if yamlStr != "" {
-		data, err := clientutil.ParseYamlStrToMap(yamlStr)
-		if err != nil {
+		if err = streamClientStatus.CloseSend(); err != nil {
 			return err
 		}
+		return nil
+	}
+}
+
+// Fetch sends a single request to the uri and returns the response without rendering it, for callers that
+// process the response themselves. If the client was connected with Connect, the request is sent on a new
+// stream of its connection, which is kept for the next calls until Close. Otherwise Fetch connects the client
+// and closes the connection before it returns, so it can be called repeatedly. With -input_file, the saved
+// response is returned instead.
+func (c *ClientV3) Fetch(ctx context.Context) (resp *csdspb_v3.ClientStatusResponse, err error) {
+	if len(splitUris(c.opts.Uri)) > 1 {
+		return nil, errors.New("Fetch cannot be used with several uris, call it once per uri instead")
+	}
+	if c.opts.InputFile != "" {
+		return loadResponse(c.opts.InputFile)
+	}
+	ep := parseEndpoint(c.opts.Uri, c.opts.Platform)
+	ctx, span := clientutil.StartSpan(ctx, "Fetch",
+		clientutil.PlatformKey.String(ep.platform), clientutil.UriKey.String(clientutil.SanitizeUri(ep.uri)))
+	defer func() { clientutil.EndSpan(span, err) }()
+
+	var streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient
+	if c.clientConn != nil {
+		streamClientStatus, err = c.openStream(ctx)
+	} else {
+		defer c.Close()
+		streamClientStatus, err = c.connect(ctx, ep)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp, err = c.fetch(ctx, streamClientStatus); err != nil {
+		return nil, err
+	}
+	if err := streamClientStatus.CloseSend(); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Connect connects the client to the uri with the authn mode, so that the following calls of Fetch share the
+// connection instead of dialing their own, e.g. when embedding the client in a long-running process. A
+// connection still open is closed first. The caller must call Close once it's done with the connection.
+// With -input_file, there is nothing to connect to and Connect does nothing.
+func (c *ClientV3) Connect(ctx context.Context) error {
+	if len(splitUris(c.opts.Uri)) > 1 {
+		return errors.New("Connect cannot be used with several uris, create a client per uri instead")
+	}
+	if c.opts.InputFile != "" {
+		return nil
+	}
+	return c.dial(ctx, parseEndpoint(c.opts.Uri, c.opts.Platform))
+}
+
+// Close closes the connection of the client if it has one. Whichever path drops the connection, a failed
+// stream, a redial, the end of the run or the caller of Connect, goes through it, so that each connection is
+// closed exactly once. It can be called several times, and after a failed Connect.
+func (c *ClientV3) Close() error {
+	if c.clientConn == nil {
+		return nil
+	}
+	err := c.clientConn.Close()
+	c.clientConn = nil
+	return err
+}
+
+// connect connects the client to ep and opens the stream the requests are sent on. The caller
+// must call Close once it's done with the stream.
+func (c *ClientV3) connect(ctx context.Context, ep endpoint) (csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient, error) {
+	if err := c.dial(ctx, ep); err != nil {
+		return nil, err
+	}
+	streamClientStatus, err := c.openStream(ctx)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return streamClientStatus, nil
+}
+
+// dial connects the client to ep with the authn mode. A connection still open is closed first, so that the
+// client never holds more than one.
+func (c *ClientV3) dial(ctx context.Context, ep endpoint) error {
+	c.Close()
+	start := time.Now()
+	if err := c.connWithAuth(ctx, ep); err != nil {
+		return err
+	}
+	c.dials++
+	c.timing.dialed(time.Since(start))
+	c.csdsClient = csdspb_v3.NewClientStatusDiscoveryServiceClient(c.clientConn)
+	return nil
+}
+
+// openStream opens a stream on the connection of the client, with the metadata of the client
+func (c *ClientV3) openStream(ctx context.Context) (csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient, error) {
+	if c.metadata != nil {
+		ctx = metadata.NewOutgoingContext(ctx, c.metadata)
+	}
+	return c.csdsClient.StreamClientStatus(ctx)
+}
+
+// reopen opens a new stream to ep after a transient failure of the request. If the connection itself failed,
+// e.g. because the control plane restarted, a new stream on it keeps failing until gRPC reconnects after its
+// own backoff, so ep is redialed with the authn mode and the metadata of the client instead. A connection
+// still connecting is waited for up to -connect_timeout to tell. A failed redial is retried like the request,
+// with the backoff of -max_retries.
+func (c *ClientV3) reopen(ctx context.Context, ep endpoint) (csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient, error) {
+	// the connection is nil if the previous redial failed
+	previous := "closed"
+	if c.clientConn != nil {
+		state := c.clientConn.GetState()
+		if state == connectivity.Connecting {
+			waitCtx, cancel := context.WithTimeout(ctx, clientutil.ConnectTimeout(c.opts))
+			c.clientConn.WaitForStateChange(waitCtx, state)
+			cancel()
+			state = c.clientConn.GetState()
+		}
+		if state != connectivity.TransientFailure && state != connectivity.Shutdown {
+			return c.csdsClient.StreamClientStatus(ctx)
+		}
+		previous = state.String()
+		c.Close()
+	}
+	fmt.Fprintf(clientutil.InfoWriter(c.opts), "reconnecting to %s after the connection failed\n", clientutil.SanitizeUri(ep.uri))
+	if c.opts.Verbose {
+		// the connections are numbered, so that the connections dialed over a long monitor run are observable
+		fmt.Fprintf(os.Stderr, "Redialing %s: connection %d is %s, dialing connection %d\n", clientutil.SanitizeUri(ep.uri), c.dials, previous, c.dials+1)
+	}
+	streamClientStatus, err := c.connect(ctx, ep)
+	if err != nil {
+		if _, ok := status.FromError(err); !ok {
+			// e.g. the endpoint is still down, which is as transient as the failed request
+			err = status.Error(codes.Unavailable, err.Error())
+		}
+		return nil, err
+	}
+	return streamClientStatus, nil
+}
+
+// startMetrics serves the metrics of the monitor mode on -metrics_addr, until the returned function is called
+func (c *ClientV3) startMetrics() (func(), error) {
+	c.metrics = newMonitorMetrics()
+	return c.metrics.serve(c.opts.MetricsAddr)
+}
+
+// output returns where the responses are rendered: the -output_file opened by Run, or stdout
+func (c *ClientV3) output() io.Writer {
+	if c.out == nil {
+		return os.Stdout
+	}
+	return c.out
+}
+
+// printCycleHeader starts the output of a monitor cycle: -watch redraws the screen, and the cycles appended
+// to -output_file are separated, except in the jsonl output format whose lines have the time of their cycle
+func (c *ClientV3) printCycleHeader(w io.Writer) {
+	switch {
+	case c.opts.Watch:
+		clientutil.PrintWatchHeader(w, time.Now(), c.opts.MonitorInterval)
+	case c.opts.ConfigFile != "" && c.opts.MonitorInterval != 0 && c.opts.OutputFormat != "jsonl":
+		clientutil.PrintCycleSeparator(w, time.Now())
+	}
+}
+
+// labelByMatcher reports whether the response is printed in one section per NodeMatcher, which is done if
+// the request sends several of them. The structured output formats keep a single document to stay parseable.
+func (c *ClientV3) labelByMatcher() bool {
+	return len(c.nodeMatcher) > 1 && c.opts.RequestMode != "node_only" && !clientutil.IsStructuredOutput(c.opts)
+}
+
+// doRequest sends request and prints out the parsed response. It returns the stream the response was
+// received on, which is a new one if -request_retries retried the request, for the following requests.
+func (c *ClientV3) doRequest(ctx context.Context, streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient) (_ csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient, err error) {
+	ctx, span := clientutil.StartSpan(ctx, "doRequest")
+	defer func() { clientutil.EndSpan(span, err) }()
+
+	if c.streamable() {
+		return streamClientStatus, c.streamRequest(ctx, streamClientStatus)
+	}
+	resp, streamClientStatus, err := c.fetchWithRetries(ctx, streamClientStatus)
+	if err != nil {
+		return streamClientStatus, err
+	}
+	if c.opts.WaitForClients != 0 {
+		if resp, err = c.waitForClients(ctx, streamClientStatus, resp); err != nil {
+			return streamClientStatus, err
+		}
+	}
+	w := c.output()
+	c.printCycleHeader(w)
+	identity := parseServerIdentity(streamClientStatus)
+	if !c.identityInDocument() {
+		printServerIdentity(c.identityOutput(w), identity)
+	}
+	// post process response
+	if c.opts.DumpRaw {
+		if err := printRawResponse(w, resp, c.opts); err != nil {
+			return streamClientStatus, err
+		}
+	} else if c.opts.MonitorDiff {
+		if err := c.printMonitorDiff(w, resp); err != nil {
+			return streamClientStatus, err
+		}
+	} else if c.labelByMatcher() {
+		if err := printOutResponseByMatcher(w, resp, c.opts, c.nodeMatcher); err != nil {
+			return streamClientStatus, err
+		}
+	} else if err := printOutMergedResponse(w, resp, c.opts, nil, identity); err != nil {
+		return streamClientStatus, err
+	}
+	return streamClientStatus, c.checkResponse(w, resp)
+}
+
+// waitForClients polls the server every -wait_interval while resp has no client, until clients appear or
+// -wait_for_clients elapses, and returns the last response received. The deadline is kept across the
+// retries of the request, so that a transient error doesn't extend the wait.
+func (c *ClientV3) waitForClients(ctx context.Context, streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient, resp *csdspb_v3.ClientStatusResponse) (*csdspb_v3.ClientStatusResponse, error) {
+	if c.waitDeadline.IsZero() {
+		c.waitDeadline = time.Now().Add(c.opts.WaitForClients)
+	}
+	for len(resp.GetConfig()) == 0 {
+		remaining := time.Until(c.waitDeadline)
+		if remaining <= 0 {
+			if !c.opts.Quiet {
+				fmt.Fprintf(os.Stderr, "no xDS clients connected after waiting %v\n", c.opts.WaitForClients)
+			}
+			return resp, nil
+		}
+		interval := c.opts.WaitInterval
+		if interval > remaining {
+			interval = remaining
+		}
+		if !c.opts.Quiet {
+			fmt.Fprintf(os.Stderr, "no xDS clients connected yet, polling again in %v\n", interval.Round(time.Millisecond))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		var err error
+		if resp, err = c.fetch(ctx, streamClientStatus); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// identityInDocument reports whether the server identity is carried by the document of the json and yaml
+// output formats, rather than printed before the response
+func (c *ClientV3) identityInDocument() bool {
+	return (c.opts.OutputFormat == "json" || c.opts.OutputFormat == "yaml") && !c.opts.RouteTable && c.opts.ProbePath == ""
+}
+
+// identityOutput returns where the server identity is printed, stderr for the outputs meant to be parsed
+// that can't carry it, e.g. csv, and w otherwise, which discards it with -quiet
+func (c *ClientV3) identityOutput(w io.Writer) io.Writer {
+	if !c.opts.Quiet && (clientutil.IsStructuredOutput(c.opts) || c.opts.DumpRaw) {
+		return os.Stderr
+	}
+	return w
+}
+
+// checkResponse records resp to -sqlite_out and -metrics_addr and checks it against -assert_consistent
+// and -fail_on, once it is printed to w
+func (c *ClientV3) checkResponse(w io.Writer, resp *csdspb_v3.ClientStatusResponse) error {
+	if c.opts.FailOnNoClients && len(resp.GetConfig()) == 0 {
+		return client.ErrNoClients
+	}
+	if c.sqlite == nil && c.metrics == nil && !c.opts.AssertConsistent && c.opts.FailOn == "" {
+		return nil
+	}
+	configs, _, err := filterClientConfigs(resp.GetConfig(), c.opts)
+	if err != nil {
+		return err
+	}
+	c.metrics.record(configs)
+	if c.sqlite != nil {
+		if err := c.sqlite.writeCapture(configs, time.Now()); err != nil {
+			return fmt.Errorf("unable to write to sqlite_out %s: %v", c.opts.SqliteOut, err)
+		}
+	}
+	if c.opts.AssertConsistent {
+		outliers := findVersionOutliers(configs)
+		printVersionOutliers(w, configs, outliers)
+		if len(outliers) != 0 {
+			return client.ErrInconsistentVersions
+		}
+	}
+	if c.opts.FailOn != "" {
+		if c.opts.TolerateStaleFor > 0 {
+			configs = tolerateStale(configs, c.opts.TolerateStaleFor, time.Now())
+		}
+		if statuses := tallyConfigs(configs).matchStatuses(parseStatusList(c.opts.FailOn)); len(statuses) != 0 {
+			return &client.StatusError{Statuses: statuses}
+		}
+	}
+
+	return nil
+}
+
+// BuildRequest returns the request sent to the server, built from the node matchers and the node of the
+// request yaml according to -request_mode. It's built on the first call, and the same request is sent by
+// each later request of Run, RunContext and Fetch, so that a caller can inspect it or modify it before, e.g.
+// to set more fields of its Node.
+func (c *ClientV3) BuildRequest() *csdspb_v3.ClientStatusRequest {
+	if c.request != nil {
+		return c.request
+	}
+	switch c.opts.RequestMode {
+	case "matchers_only":
+		c.request = &csdspb_v3.ClientStatusRequest{NodeMatchers: c.nodeMatcher}
+	case "node_only":
+		c.request = &csdspb_v3.ClientStatusRequest{Node: c.requestNode()}
+	default:
+		c.request = &csdspb_v3.ClientStatusRequest{NodeMatchers: c.nodeMatcher, Node: c.requestNode()}
+	}
+	return c.request
+}
+
+// excludeContentsGoControlPlane is the first version of go-control-plane whose ClientStatusRequest has the
+// exclude_resource_contents field that -exclude_contents sets
+const excludeContentsGoControlPlane = "v0.11.0"
+
+// requestNode returns a copy of the node of the request yaml with all its fields, e.g. the cluster, the
+// metadata or the locality that some control planes key on besides the id
+func (c *ClientV3) requestNode() *envoy_config_core_v3.Node {
+	if c.node == nil {
+		return &envoy_config_core_v3.Node{}
+	}
+	return proto.Clone(c.node).(*envoy_config_core_v3.Node)
+}
+
+// printRequest prints req as indented JSON, for -dry_run to show the request that would be sent
+func printRequest(w io.Writer, req *csdspb_v3.ClientStatusRequest) error {
+	out, err := protojson.Marshal(req)
+	if err != nil {
+		return err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, out, "", "  "); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, indented.String())
+	return nil
+}
+
+// fetchWithRetries fetches the response of the request, retrying up to -request_retries times the requests
+// whose Send or Recv failed with a transient status code. A stream is done once it failed, so each retry is
+// sent on a new stream of the same connection, after the backoff of -max_retries. It returns the stream the
+// response was received on. A failed connection keeps failing the new streams, and is left to the redial of
+// -max_retries once the retries are exhausted.
+func (c *ClientV3) fetchWithRetries(ctx context.Context, streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient) (*csdspb_v3.ClientStatusResponse, csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.fetch(ctx, streamClientStatus)
+		if err == nil || attempt >= c.opts.RequestRetries || c.csdsClient == nil || !clientutil.IsTransient(ctx, err) {
+			return resp, streamClientStatus, err
+		}
+		if err := clientutil.Wait(ctx, c.backoff, attempt); err != nil {
+			return nil, streamClientStatus, err
+		}
+		fmt.Fprintf(clientutil.InfoWriter(c.opts), "retrying the request on a new stream (%d/%d) after error: %v\n", attempt+1, c.opts.RequestRetries, err)
+		retried, err := c.csdsClient.StreamClientStatus(ctx)
+		if err != nil {
+			return nil, streamClientStatus, err
+		}
+		streamClientStatus = retried
+	}
+}
+
+// fetch sends request and receives the response
+func (c *ClientV3) fetch(ctx context.Context, streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient) (*csdspb_v3.ClientStatusResponse, error) {
+	req := c.BuildRequest()
+	// the round trip is timed from sending the request to receiving the response, excluding the transform
+	start := time.Now()
+	_, sendSpan := clientutil.StartSpan(ctx, "send")
+	err := sendRequest(streamClientStatus, req)
+	clientutil.EndSpan(sendSpan, err)
+	if err != nil {
+		return nil, err
+	}
+
+	_, recvSpan := clientutil.StartSpan(ctx, "receive")
+	var resp *csdspb_v3.ClientStatusResponse
+	if c.opts.DrainStream {
+		resp, err = c.drainStream(streamClientStatus)
+	} else {
+		resp, err = streamClientStatus.Recv()
+	}
+	if err != nil && err != io.EOF {
+		err = clientutil.RecvError(err, c.opts)
+		clientutil.EndSpan(recvSpan, err)
+		return nil, err
+	}
+	recvSpan.SetAttributes(clientutil.ClientCountKey.Int(len(resp.GetConfig())))
+	clientutil.EndSpan(recvSpan, nil)
+	roundTrip := time.Since(start)
+	c.metrics.observeLatency(roundTrip)
+	c.timing.observe(roundTrip)
+
+	if c.opts.Transform != "" {
+		_, transformSpan := clientutil.StartSpan(ctx, "transform")
+		resp, err = transformResponse(ctx, c.opts.Transform, resp)
+		clientutil.EndSpan(transformSpan, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// sendRequest sends req on streamClientStatus. If the stream was closed, e.g. by a restart of the server, Send
+// only returns io.EOF, and the status of the stream that tells whether the request can be retried is
+// returned by Recv instead.
+func sendRequest(streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient, req *csdspb_v3.ClientStatusRequest) error {
+	err := streamClientStatus.Send(req)
+	if err == io.EOF {
+		_, err = streamClientStatus.Recv()
+	}
+	return err
+}
+
+// parseServerIdentity extracts the headers identifying the control plane instance from the response
+// metadata of a stream, e.g. "server" or "x-control-plane-version". It returns nil if the server sent none.
+func parseServerIdentity(streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient) metadata.MD {
+	header, err := streamClientStatus.Header()
+	if err != nil {
+		return nil
+	}
+	identity := metadata.MD{}
+	for key, values := range header {
+		if key == "server" || strings.HasPrefix(key, "x-control-plane-") || strings.HasPrefix(key, "x-server-") {
+			identity[key] = values
+		}
+	}
+	if len(identity) == 0 {
+		return nil
+	}
+	return identity
+}
+
+// printServerIdentity prints the identity of the control plane, if any, to w before the response
+func printServerIdentity(w io.Writer, identity metadata.MD) {
+	if len(identity) == 0 {
+		return
+	}
+	values := identityValues(identity)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fields := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, key+"="+values[key])
+	}
+	fmt.Fprintf(w, "Control plane: %s\n", strings.Join(fields, " "))
+}
+
+// identityValues returns the value of each header of identity, its values joined with commas, or nil if it
+// has none
+func identityValues(identity metadata.MD) map[string]string {
+	if len(identity) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(identity))
+	for key, value := range identity {
+		values[key] = strings.Join(value, ",")
+	}
+	return values
+}
+
+// selfDiff fetches two responses -self_diff apart and prints the changes between them
+func (c *ClientV3) selfDiff(ctx context.Context, streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient) error {
+	var snapshots []snapshot
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			timer := time.NewTimer(c.opts.SelfDiff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		resp, err := c.fetch(ctx, streamClientStatus)
+		if err != nil {
+			return err
+		}
+		configs, _, err := filterClientConfigs(resp.GetConfig(), c.opts)
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, newSnapshot(configs))
+	}
+	if err := streamClientStatus.CloseSend(); err != nil {
+		return err
+	}
+
+	w := c.output()
+	changes := diffSnapshots(snapshots[0], snapshots[1])
+	if len(changes) == 0 {
+		fmt.Fprintf(w, "No changes detected in %v.\n", c.opts.SelfDiff)
+		return nil
+	}
+	fmt.Fprintf(w, "Changes detected in %v:\n", c.opts.SelfDiff)
+	printDiff(w, changes)
+	if c.opts.SelfDiffFail {
+		return client.ErrChangesDetected
+	}
+	return nil
+}
+
+// printMonitorDiff prints the changes of resp since the response of the previous monitor cycle. The first
+// cycle has no baseline to compare with, so its response is printed in full instead.
+func (c *ClientV3) printMonitorDiff(w io.Writer, resp *csdspb_v3.ClientStatusResponse) error {
+	configs, _, err := filterClientConfigs(resp.GetConfig(), c.opts)
+	if err != nil {
+		return err
+	}
+	baseline := c.baseline
+	c.baseline = newSnapshot(configs)
+	if baseline == nil {
+		fmt.Fprintln(w, "Baseline:")
+		return printOutResponse(w, resp, c.opts)
+	}
+
+	changes := diffSnapshots(baseline, c.baseline)
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes since the previous request.")
+	} else {
+		fmt.Fprintln(w, "Changes since the previous request:")
+		printDiff(w, changes)
+	}
+	if c.opts.MonitorOutputDir != "" {
+		// keep saving the configs of each cycle
+		return clientutil.PrintDetailedConfig(w, resp, c.opts)
+	}
+	return nil
+}
+
+// recvResult is the result of a single Recv on a stream
+type recvResult struct {
+	resp *csdspb_v3.ClientStatusResponse
+	err  error
+}
+
+// streamReceiver keeps receiving from a stream in the background so that responses can be awaited with a timeout
+// without leaving a pending Recv that would race with the next request
+type streamReceiver struct {
+	stream  csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient
+	results chan recvResult
+}
+
+// newStreamReceiver starts receiving from stream until it returns an error
+func newStreamReceiver(stream csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient) *streamReceiver {
+	r := &streamReceiver{
+		stream:  stream,
+		results: make(chan recvResult),
+	}
+	go func() {
+		for {
+			resp, err := stream.Recv()
+			r.results <- recvResult{resp: resp, err: err}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return r
+}
+
+// drainStream receives responses from the stream until EOF or until no further response arrives within
+// -drain_timeout, and merges all of them into a single response
+func (c *ClientV3) drainStream(streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient) (*csdspb_v3.ClientStatusResponse, error) {
+	var resps []*csdspb_v3.ClientStatusResponse
+	err := c.drain(streamClientStatus, func(resp *csdspb_v3.ClientStatusResponse) error {
+		resps = append(resps, resp)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeResponses(resps), nil
+}
+
+// drain receives responses from the stream until EOF or until no further response arrives within
+// -drain_timeout, and passes each of them to handle as soon as it's received
+func (c *ClientV3) drain(streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient, handle func(resp *csdspb_v3.ClientStatusResponse) error) error {
+	if c.receiver == nil || c.receiver.stream != streamClientStatus {
+		c.receiver = newStreamReceiver(streamClientStatus)
+	}
+
+	// wait for the first response as long as a single Recv would
+	first := <-c.receiver.results
+	if first.err != nil {
+		return first.err
+	}
+	if err := handle(first.resp); err != nil {
+		return err
+	}
+	for {
+		select {
+		case result := <-c.receiver.results:
+			if result.err == io.EOF {
+				return nil
+			}
+			if result.err != nil {
+				return result.err
+			}
+			if err := handle(result.resp); err != nil {
+				return err
+			}
+		case <-time.After(c.opts.DrainTimeout):
+			return nil
+		}
+	}
+}
+
+// mergeResponses merges the client configs of several responses into one response.
+// If a client is reported more than once, the config from the latest response wins and keeps the position of the first one.
+func mergeResponses(resps []*csdspb_v3.ClientStatusResponse) *csdspb_v3.ClientStatusResponse {
+	merged := &csdspb_v3.ClientStatusResponse{}
+	index := make(map[string]int)
+	for _, resp := range resps {
+		for _, config := range resp.GetConfig() {
+			if config.GetNode() == nil {
+				merged.Config = append(merged.Config, config)
+				continue
+			}
+			if i, ok := index[config.GetNode().GetId()]; ok {
+				merged.Config[i] = config
+				continue
+			}
+			index[config.GetNode().GetId()] = len(merged.Config)
+			merged.Config = append(merged.Config, config)
+		}
+	}
+	return merged
+}
+
+// xdsShortName maps the type url of a xds resource to the short name of its xDS service
+func xdsShortName(typeUrl string) (string, error) {
+	switch typeUrl {
+	case "type.googleapis.com/envoy.config.cluster.v3.Cluster":
+		return "CDS", nil
+	case "type.googleapis.com/envoy.config.listener.v3.Listener":
+		return "LDS", nil
+	case "type.googleapis.com/envoy.config.route.v3.RouteConfiguration":
+		return "RDS", nil
+	case "type.googleapis.com/envoy.config.route.v3.ScopedRouteConfiguration":
+		return "SRDS", nil
+	case "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment":
+		return "EDS", nil
+	case "type.googleapis.com/envoy.config.route.v3.VirtualHost":
+		return "VHDS", nil
+	case "type.googleapis.com/envoy.config.core.v3.TypedExtensionConfig":
+		return "ECDS", nil
+	case "type.googleapis.com/envoy.service.runtime.v3.Runtime":
+		return "RTDS", nil
+	default:
+		return "", fmt.Errorf("Unsupported XDS type")
+	}
+}
+
+// xdsDisplayName returns the short name of the xDS service of typeUrl, falling back to the type url
+// without its "type.googleapis.com/" prefix for the types that are not supported yet
+func xdsDisplayName(typeUrl string) string {
+	if xds, err := xdsShortName(typeUrl); err == nil {
+		return xds
+	}
+	return typeUrl[strings.LastIndex(typeUrl, "/")+1:]
+}
+
+// checkXdsTypes returns an error naming the first resource of configs whose xDS type is not supported,
+// for strict_types to fail the whole response before anything is printed
+func checkXdsTypes(configs []*csdspb_v3.ClientConfig) error {
+	for _, config := range configs {
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			if _, err := xdsShortName(genericXdsConfig.GetTypeUrl()); err != nil {
+				id, _ := parseNode(config)
+				return fmt.Errorf("unsupported xDS type %s of resource %s of client %s", genericXdsConfig.GetTypeUrl(), genericXdsConfig.GetName(), id)
+			}
+		}
+	}
+	return nil
+}
+
+// parseConfigStatus parses each xds config status to string.
+// Resources of unsupported xDS types are shown with their type url instead of failing the whole client.
+func parseConfigStatus(xdsConfig []*csdspb_v3.ClientConfig_GenericXdsConfig) []string {
+	var configStatus []string
+	for _, genericXdsConfig := range xdsConfig {
+		status := genericXdsConfig.GetConfigStatus().String()
+		xds := xdsDisplayName(genericXdsConfig.GetTypeUrl())
+		if status != "" && xds != "" {
+			configStatus = append(configStatus, xds+"   "+status)
+		}
+	}
+	return configStatus
+}
+
+// parseLastUpdated formats the last_updated time of each xds resource, in the local time zone unless utc is set.
+// Resources without a time are shown as "-".
+func parseLastUpdated(xdsConfig []*csdspb_v3.ClientConfig_GenericXdsConfig, utc bool) []string {
+	lastUpdated := make([]string, 0, len(xdsConfig))
+	for _, genericXdsConfig := range xdsConfig {
+		ts := genericXdsConfig.GetLastUpdated()
+		if ts.GetSeconds() == 0 && ts.GetNanos() == 0 {
+			lastUpdated = append(lastUpdated, "-")
+			continue
+		}
+		t := ts.AsTime().Local()
+		if utc {
+			t = t.UTC()
+		}
+		lastUpdated = append(lastUpdated, t.Format(time.RFC3339))
+	}
+	return lastUpdated
+}
+
+// formatClientStatus returns the client_status the client reports for an xds resource, e.g. ACKED or NACKED,
+// or "-" if it's unset
+func formatClientStatus(genericXdsConfig *csdspb_v3.ClientConfig_GenericXdsConfig) string {
+	if genericXdsConfig.GetClientStatus() == envoy_admin_v3.ClientResourceStatus_UNKNOWN {
+		return "-"
+	}
+	return genericXdsConfig.GetClientStatus().String()
+}
+
+// parseVersionSkew finds the xDS types whose resources report differing version_info within a single client.
+// It returns the sorted distinct versions keyed by the short name of each skewed xDS type.
+func parseVersionSkew(xdsConfig []*csdspb_v3.ClientConfig_GenericXdsConfig) map[string][]string {
+	versions := make(map[string]map[string]bool)
+	for _, genericXdsConfig := range xdsConfig {
+		xds, err := xdsShortName(genericXdsConfig.GetTypeUrl())
+		if err != nil {
+			continue
+		}
+		if versions[xds] == nil {
+			versions[xds] = make(map[string]bool)
+		}
+		versions[xds][genericXdsConfig.GetVersionInfo()] = true
+	}
+
+	skew := make(map[string][]string)
+	for xds, set := range versions {
+		if len(set) < 2 {
+			continue
+		}
+		for version := range set {
+			skew[xds] = append(skew[xds], version)
+		}
+		sort.Strings(skew[xds])
+	}
+	return skew
+}
+
+// printRawResponse prints response as it was received, as JSON with every field of the clients,
+// e.g. their metadata and locality, rather than the parsed client status or detailed config.
+// The sensitive fields are redacted with -redact.
+func printRawResponse(w io.Writer, response *csdspb_v3.ClientStatusResponse, opts client.ClientOptions) error {
+	var msg proto.Message = response
+	if opts.Redact {
+		msg = clientutil.Redact(response)
+	}
+	out, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("unable to marshal the response: %v", err)
+	}
+	fmt.Fprintln(w, string(out))
+	return nil
+}
+
+// printOutResponse processes response and print
+func printOutResponse(w io.Writer, response *csdspb_v3.ClientStatusResponse, opts client.ClientOptions) error {
+	return printOutMergedResponse(w, response, opts, nil, nil)
+}
+
+// printOutMergedResponse is printOutResponse for the response merged from several endpoints, printing the
+// Endpoint column of view in the outputs and the endpoints that failed beneath the rows of the text outputs.
+// The server identity of a single server, if any, is carried by the document of the json and yaml output formats.
+func printOutMergedResponse(w io.Writer, response *csdspb_v3.ClientStatusResponse, opts client.ClientOptions, view *endpointView, identity metadata.MD) error {
+	if response.GetConfig() == nil || len(response.GetConfig()) == 0 {
+		switch opts.OutputFormat {
+		case "csv":
+			return printCsv(w, nil, view, opts.ShowVersion, opts.ShowLocality)
+		case "json", "yaml":
+			// every enabled filter stage is counted, left with no client
+			_, counts, _ := filterClientConfigs(nil, opts)
+			if opts.OutputFormat == "json" {
+				return printJson(w, nil, counts, nil, view, identity, opts)
+			}
+			return printYaml(w, nil, counts, nil, view, identity, opts)
+		case "jsonl":
+			// no line at all, so that the output only holds clients
+			return nil
+		case "prototext":
+			return nil
+		}
+		if opts.SummaryOnly {
+			view.printFailures(w)
+			printSummary(w, tallyConfigs(nil))
+			return nil
+		}
+		if opts.Template != "" {
+			if err := printTemplate(w, nil, view, opts, time.Now()); err != nil {
+				return err
+			}
+			view.printFailures(w)
+			return nil
+		}
+		fmt.Fprintf(w, "No xDS clients connected.\n")
+		view.printFailures(w)
+		return nil
+	}
+
+	configs, counts, err := filterClientConfigs(response.GetConfig(), opts)
+	if err != nil {
+		return err
+	}
+	if opts.Verbose {
+		printFilterCounts(counts)
+	}
+	if opts.StrictTypes {
+		if err := checkXdsTypes(configs); err != nil {
+			return err
+		}
+	}
+	configs = sortClientConfigs(configs, opts.Sort)
+	// the summary counts all the matched clients, and the rest of the output only the page of them
+	page := pageClientConfigs(configs, opts.Offset, opts.Limit)
+
+	if opts.RouteTable {
+		return printRouteTable(w, page)
+	}
+	if opts.ProbePath != "" {
+		req, err := parseProbeRequest(opts.ProbePath, opts.ProbeHeaders, opts.ProbeMethod)
+		if err != nil {
+			return err
+		}
+		return printProbe(w, page, req)
+	}
+
+	if opts.SummaryOnly {
+		view.printFailures(w)
+		printSummary(w, tallyConfigs(configs))
+		return nil
+	}
+	if opts.VersionSkew {
+		view.printFailures(w)
+		printVersionSkew(w, configs)
+		return nil
+	}
+	if opts.Template != "" {
+		if err := printTemplate(w, page, view, opts, time.Now()); err != nil {
+			return err
+		}
+		view.printFailures(w)
+		return nil
+	}
+
+	color := useColor(opts.Color, w)
+	switch opts.OutputFormat {
+	case "compact":
+		printCompact(w, page, color, view)
+	case "matrix":
+		printMatrix(w, page, color)
+	case "json":
+		if err := printJson(w, configs, counts, page, view, identity, opts); err != nil {
+			return err
+		}
+	case "jsonl":
+		if err := printJsonl(w, page, view, time.Now(), opts.ShowVersion, opts.ShowLocality); err != nil {
+			return err
+		}
+	case "yaml":
+		if err := printYaml(w, configs, counts, page, view, identity, opts); err != nil {
+			return err
+		}
+	case "prototext":
+		if err := printPrototext(w, page, opts); err != nil {
+			return err
+		}
+	case "csv":
+		if err := printCsv(w, page, view, opts.ShowVersion, opts.ShowLocality); err != nil {
+			return err
+		}
+	default:
+		if opts.FixedWidth {
+			// the table of the former releases had neither the summary nor the other lines beneath it
+			printFixedWidthTable(w, page)
+			return printDetailedConfig(w, response, configs, opts)
+		}
+		if opts.Columns != "" {
+			// validated by New
+			columns, _ := parseColumns(opts.Columns)
+			printColumns(w, page, columns, color, opts.UTC, opts.ShowErrors, view)
+		} else {
+			printTable(w, page, color, opts.UTC, opts.ShowErrors, view, parseTableWidths(page, opts.NoTruncate, opts.ShowVersion, opts.ShowLocality))
+		}
+	}
+	if !clientutil.IsStructuredOutput(opts) {
+		view.printFailures(w)
+		if len(page) != len(configs) {
+			fmt.Fprintf(w, "Showing %d of %d clients from offset %d\n", len(page), len(configs), opts.Offset)
+		}
+		printSummary(w, tallyConfigs(configs))
+	}
+
+	return printDetailedConfig(w, response, configs, opts)
+}
+
+// printDetailedConfig prints the detailed config of response after its client status, if any of the
+// matched configs has xDS resources and -exclude_contents isn't set. With -resource_name, only the resources
+// whose name matches are kept, and nothing is printed if none does. With -dump_dir, the resources are
+// written to files instead, and only the number of files is printed.
+func printDetailedConfig(w io.Writer, response *csdspb_v3.ClientStatusResponse, configs []*csdspb_v3.ClientConfig, opts client.ClientOptions) error {
+	if opts.ExcludeContents {
+		return nil
+	}
+	var hasXdsConfig bool
+	for _, config := range configs {
+		if config.GetGenericXdsConfigs() != nil {
+			hasXdsConfig = true
+		}
+	}
+	if !hasXdsConfig && opts.DumpDir == "" {
+		return nil
+	}
+	// keep the detailed config focused on the same xDS types and config statuses as the client status
+	if opts.XdsType != "" {
+		response = &csdspb_v3.ClientStatusResponse{Config: filterXdsTypes(response.GetConfig(), parseXdsTypes(opts.XdsType))}
+	}
+	if opts.StatusFilter != "" {
+		response = &csdspb_v3.ClientStatusResponse{Config: filterConfigStatuses(response.GetConfig(), parseStatusList(opts.StatusFilter))}
+	}
+	if opts.ResourceName != "" {
+		configs, err := filterResourceNames(response.GetConfig(), opts.ResourceNameMode, opts.ResourceName)
+		if err != nil {
+			return err
+		}
+		if len(configs) == 0 && opts.DumpDir == "" {
+			return nil
+		}
+		response = &csdspb_v3.ClientStatusResponse{Config: configs}
+	}
+	if opts.DumpDir != "" {
+		files, err := writeDumpDir(opts.DumpDir, response, opts)
+		if err != nil {
+			return fmt.Errorf("unable to write to dump_dir %s: %v", opts.DumpDir, err)
+		}
+		fmt.Fprintf(clientutil.InfoWriter(opts), "%d files have been written to %s\n", files, opts.DumpDir)
+		return nil
+	}
+	return clientutil.PrintDetailedConfig(w, response, opts)
+}
+
+// clientFilter is a single stage of filtering client configs
+type clientFilter struct {
+	stage   string
+	enabled bool
+	match   func(config *csdspb_v3.ClientConfig) (bool, error)
+}
 
-		// parse each json object to proto
-		for i, n := range data["node_matchers"].([]interface{}) {
-			x := &envoy_type_matcher_v3.NodeMatcher{}
+// filterCount is the number of clients left after a filter stage
+type filterCount struct {
+	stage string
+	count int
+}
+
+// clientFilters returns the filter stages in the order they are applied
+func clientFilters(opts client.ClientOptions) []clientFilter {
+	return []clientFilter{
+		{
+			stage:   "node_id",
+			enabled: opts.FilterPattern != "",
+			match: func(config *csdspb_v3.ClientConfig) (bool, error) {
+				if config.GetNode() == nil {
+					return true, nil
+				}
+				matched, err := clientutil.FilterNodeId(config.GetNode().GetId(), opts.FilterMode, opts.FilterPattern, opts.FilterIgnoreCase)
+				return matched != opts.FilterInvert, err
+			},
+		},
+		{
+			// keep only nodes lacking the metadata key
+			stage:   "meta_missing",
+			enabled: opts.MetaMissing != "",
+			match: func(config *csdspb_v3.ClientConfig) (bool, error) {
+				_, ok := config.GetNode().GetMetadata().GetFields()[opts.MetaMissing]
+				return !ok, nil
+			},
+		},
+	}
+}
 
-			jsonString, err := json.Marshal(n)
+// filterClientConfigs returns the client configs that match all the filters in opts,
+// and the number of clients in the response and left after each enabled filter stage
+func filterClientConfigs(configs []*csdspb_v3.ClientConfig, opts client.ClientOptions) ([]*csdspb_v3.ClientConfig, []filterCount, error) {
+	counts := []filterCount{{stage: "response", count: len(configs)}}
+	filtered := configs
+	for _, filter := range clientFilters(opts) {
+		if !filter.enabled {
+			continue
+		}
+		var matched []*csdspb_v3.ClientConfig
+		for _, config := range filtered {
+			ok, err := filter.match(config)
 			if err != nil {
-				return err
+				return nil, nil, err
 			}
-			if err = protojson.Unmarshal(jsonString, x); err != nil {
-				return err
+			if ok {
+				matched = append(matched, config)
+			}
+		}
+		filtered = matched
+		counts = append(counts, filterCount{stage: filter.stage, count: len(filtered)})
+	}
+	if opts.XdsType != "" {
+		filtered = filterXdsTypes(filtered, parseXdsTypes(opts.XdsType))
+		counts = append(counts, filterCount{stage: "xds_type", count: len(filtered)})
+	}
+	if opts.StatusFilter != "" {
+		filtered = filterConfigStatuses(filtered, parseStatusList(opts.StatusFilter))
+		counts = append(counts, filterCount{stage: "status_filter", count: len(filtered)})
+	}
+	return filtered, counts, nil
+}
+
+// pageClientConfigs returns the page of configs selected by -offset and -limit: at most limit configs after
+// skipping the first offset ones. A limit that isn't positive selects all the configs after offset.
+func pageClientConfigs(configs []*csdspb_v3.ClientConfig, offset int, limit int) []*csdspb_v3.ClientConfig {
+	if offset >= len(configs) {
+		return nil
+	}
+	page := configs[offset:]
+	if limit > 0 && limit < len(page) {
+		page = page[:limit]
+	}
+	return page
+}
+
+// parseXdsTypes parses the comma-separated xDS types of -xds_type, ignoring case and spaces
+func parseXdsTypes(xdsType string) []string {
+	var types []string
+	for _, xds := range strings.Split(xdsType, ",") {
+		if xds = strings.ToUpper(strings.TrimSpace(xds)); xds != "" {
+			types = append(types, xds)
+		}
+	}
+	return types
+}
+
+// isKnownXds returns whether xds is the short name of a supported xDS type
+func isKnownXds(xds string) bool {
+	for _, known := range knownXds {
+		if xds == known {
+			return true
+		}
+	}
+	return false
+}
+
+// filterXdsTypes returns copies of configs that only keep the generic xds configs of types.
+// Clients left without any config by the filter are dropped, clients that had none are kept.
+func filterXdsTypes(configs []*csdspb_v3.ClientConfig, types []string) []*csdspb_v3.ClientConfig {
+	var filtered []*csdspb_v3.ClientConfig
+	for _, config := range configs {
+		if len(config.GetGenericXdsConfigs()) == 0 {
+			filtered = append(filtered, config)
+			continue
+		}
+		var xdsConfigs []*csdspb_v3.ClientConfig_GenericXdsConfig
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			xds, err := xdsShortName(genericXdsConfig.GetTypeUrl())
+			if err != nil {
+				continue
+			}
+			for _, t := range types {
+				if xds == t {
+					xdsConfigs = append(xdsConfigs, genericXdsConfig)
+					break
+				}
+			}
+		}
+		if len(xdsConfigs) == 0 {
+			continue
+		}
+		filtered = append(filtered, &csdspb_v3.ClientConfig{
+			Node:              config.GetNode(),
+			XdsConfig:         config.GetXdsConfig(),
+			GenericXdsConfigs: xdsConfigs,
+		})
+	}
+	return filtered
+}
+
+// filterConfigStatuses returns copies of configs that only keep the generic xds configs reporting one of
+// statuses. Unlike filterXdsTypes, clients without any config left, including the ones that had none, are dropped.
+func filterConfigStatuses(configs []*csdspb_v3.ClientConfig, statuses []string) []*csdspb_v3.ClientConfig {
+	var filtered []*csdspb_v3.ClientConfig
+	for _, config := range configs {
+		var xdsConfigs []*csdspb_v3.ClientConfig_GenericXdsConfig
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			for _, status := range statuses {
+				if genericXdsConfig.GetConfigStatus().String() == status {
+					xdsConfigs = append(xdsConfigs, genericXdsConfig)
+					break
+				}
+			}
+		}
+		if len(xdsConfigs) == 0 {
+			continue
+		}
+		filtered = append(filtered, &csdspb_v3.ClientConfig{
+			Node:              config.GetNode(),
+			XdsConfig:         config.GetXdsConfig(),
+			GenericXdsConfigs: xdsConfigs,
+		})
+	}
+	return filtered
+}
+
+// filterResourceNames returns copies of configs that only keep the generic xds configs whose name matches
+// pattern in mode. Like filterConfigStatuses, clients without any config left are dropped.
+func filterResourceNames(configs []*csdspb_v3.ClientConfig, mode string, pattern string) ([]*csdspb_v3.ClientConfig, error) {
+	var filtered []*csdspb_v3.ClientConfig
+	for _, config := range configs {
+		var xdsConfigs []*csdspb_v3.ClientConfig_GenericXdsConfig
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			matched, err := clientutil.MatchPattern(genericXdsConfig.GetName(), mode, pattern, false)
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				xdsConfigs = append(xdsConfigs, genericXdsConfig)
+			}
+		}
+		if len(xdsConfigs) == 0 {
+			continue
+		}
+		filtered = append(filtered, &csdspb_v3.ClientConfig{
+			Node:              config.GetNode(),
+			XdsConfig:         config.GetXdsConfig(),
+			GenericXdsConfigs: xdsConfigs,
+		})
+	}
+	return filtered, nil
+}
+
+// printFilterCounts prints the number of clients left after each filter stage to stderr
+func printFilterCounts(counts []filterCount) {
+	fields := make([]string, 0, len(counts))
+	for _, count := range counts {
+		fields = append(fields, fmt.Sprintf("%s=%d", count.stage, count.count))
+	}
+	fmt.Fprintf(os.Stderr, "Clients per filter stage: %s\n", strings.Join(fields, " "))
+}
+
+// parseNode returns the id and the xDS stream type of the node of a client config
+func parseNode(config *csdspb_v3.ClientConfig) (string, string) {
+	var xdsType string
+	metadata := config.GetNode().GetMetadata().AsMap()
+
+	// control plane is expected to use "XDS_STREAM_TYPE" to communicate
+	// the stream type of the connected client in the response.
+	if metadata["XDS_STREAM_TYPE"] != nil {
+		xdsType = metadata["XDS_STREAM_TYPE"].(string)
+	}
+	return config.GetNode().GetId(), xdsType
+}
+
+// parseStatusList parses the comma-separated config statuses of -fail_on and -status_filter, ignoring case and spaces
+func parseStatusList(list string) []string {
+	var statuses []string
+	for _, status := range strings.Split(list, ",") {
+		if status = strings.ToUpper(strings.TrimSpace(status)); status != "" {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// errorDetailsWidth is the width the error details are wrapped at beneath the config status column
+const errorDetailsWidth = 80
+
+// printErrorState prints the version and the details of the failed update of a resource beneath its row,
+// indented under the config status column so that the columns of the next rows stay aligned
+func printErrorState(w io.Writer, errorState *envoy_admin_v3.UpdateFailureState, view *endpointView, widths tableWidths) {
+	printErrorLines(w, errorState, widths.indent(view))
+}
+
+// printErrorLines prints the failed version and the details of errorState on lines starting with indent
+func printErrorLines(w io.Writer, errorState *envoy_admin_v3.UpdateFailureState, indent string) {
+	if errorState == nil {
+		return
+	}
+	version := errorState.GetVersionInfo()
+	if version == "" {
+		version = "-"
+	}
+	details := errorState.GetDetails()
+	if details == "" {
+		details = "-"
+	}
+	fmt.Fprintf(w, "%s   failed version: %s\n", indent, version)
+	for i, line := range wrapText(details, errorDetailsWidth) {
+		label := "details:"
+		if i != 0 {
+			label = ""
+		}
+		fmt.Fprintf(w, "%s   %-8s %s\n", indent, label, line)
+	}
+}
+
+// wrapText splits text into lines of at most width characters at spaces, keeping its line breaks.
+// Words longer than width are kept whole on their own line.
+func wrapText(text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			if line != "" && len(line)+1+len(word) > width {
+				lines = append(lines, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// maxColumnWidth is the width the auto-sized columns of the table are capped at, wider cells are truncated
+// unless -no_truncate is set. It fits the GCP-style node ids, e.g. "projects/<number>/networks/<network>/nodes/<uuid>".
+const maxColumnWidth = 100
+
+// tableWidths are the widths of the Client ID, Locality, xDS stream type, Config Status, Client Status and
+// Version columns of the table, the Last Updated column isn't padded. The Locality column of -show_locality
+// and the Version column of -show_version are left out if their width is 0. Cells wider than their column
+// are truncated with "..." if truncate is set.
+type tableWidths struct {
+	id           int
+	locality     int
+	xdsType      int
+	configStatus int
+	clientStatus int
+	version      int
+	truncate     bool
+}
+
+// fixedTableWidths are the widths of -stream, so that the rows of several responses printed one after
+// another line up
+var fixedTableWidths = tableWidths{id: 50, xdsType: 30, configStatus: 30, clientStatus: 15}
+
+// fixedVersionWidth is the width of the Version column with -stream
+const fixedVersionWidth = 30
+
+// fixedLocalityWidth is the width of the Locality column with -stream
+const fixedLocalityWidth = 40
+
+// streamTableWidths returns fixedTableWidths, with the Version column if showVersion is set and the Locality
+// column if showLocality is set
+func streamTableWidths(showVersion bool, showLocality bool) tableWidths {
+	widths := fixedTableWidths
+	if showVersion {
+		widths.version = fixedVersionWidth
+	}
+	if showLocality {
+		widths.locality = fixedLocalityWidth
+	}
+	return widths
+}
+
+// parseTableWidths returns the widths of the table of configs, the width of the widest cell of each column,
+// capped at maxColumnWidth unless noTruncate is set
+func parseTableWidths(configs []*csdspb_v3.ClientConfig, noTruncate bool, showVersion bool, showLocality bool) tableWidths {
+	widths := tableWidths{
+		id:           len("Client ID"),
+		xdsType:      len("xDS stream type"),
+		configStatus: len("Config Status"),
+		clientStatus: len("Client Status"),
+		truncate:     !noTruncate,
+	}
+	if showVersion {
+		widths.version = len("Version")
+	}
+	if showLocality {
+		widths.locality = len("Locality")
+	}
+	fit := func(width *int, cell string) {
+		if len(cell) > *width {
+			*width = len(cell)
+		}
+	}
+	for _, config := range configs {
+		if config.GetGenericXdsConfigs() == nil && config.GetNode() == nil {
+			continue
+		}
+		id, xdsType := parseNode(config)
+		fit(&widths.id, id)
+		if showLocality {
+			fit(&widths.locality, formatLocality(config.GetNode()))
+		}
+		fit(&widths.xdsType, xdsType)
+		configStatus := parseConfigStatus(config.GetGenericXdsConfigs())
+		for _, cell := range configStatus {
+			fit(&widths.configStatus, cell)
+		}
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			fit(&widths.clientStatus, formatClientStatus(genericXdsConfig))
+			if showVersion {
+				fit(&widths.version, formatVersion(genericXdsConfig))
+			}
+		}
+	}
+	if widths.truncate {
+		for _, width := range []*int{&widths.id, &widths.locality, &widths.xdsType, &widths.configStatus, &widths.clientStatus, &widths.version} {
+			if *width > maxColumnWidth {
+				*width = maxColumnWidth
+			}
+		}
+	}
+	return widths
+}
+
+// cell returns cell truncated to width if it's wider and truncation is enabled
+func (t tableWidths) cell(cell string, width int) string {
+	if !t.truncate {
+		return cell
+	}
+	return truncateId(cell, width)
+}
+
+// indent returns the blank Client ID, Locality and xDS stream type columns the lines beneath a row start with
+func (t tableWidths) indent(view *endpointView) string {
+	return fmt.Sprintf("%s%-*s %s%-*s", view.blank(), t.id, "", t.localityCell(""), t.xdsType, "")
+}
+
+// printTable prints the config status of each client as a table, led by the Endpoint column of view if any
+func printTable(w io.Writer, configs []*csdspb_v3.ClientConfig, color bool, utc bool, showErrors bool, view *endpointView, widths tableWidths) {
+	printTableHeader(w, view, widths)
+	printTableRows(w, configs, color, utc, showErrors, view, widths)
+}
+
+// printTableHeader prints the header row of the table
+func printTableHeader(w io.Writer, view *endpointView, widths tableWidths) {
+	fmt.Fprintf(w, "%s%-*s %s%-*s %-*s %-*s %s%s\n", view.header(), widths.id, "Client ID", widths.localityCell("Locality"), widths.xdsType, "xDS stream type",
+		widths.configStatus, "Config Status", widths.clientStatus, "Client Status", widths.versionCell("Version"), "Last Updated")
+}
+
+// versionCell returns cell padded to the Version column followed by its separator, or nothing without the column
+func (t tableWidths) versionCell(cell string) string {
+	if t.version == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%-*s ", t.version, t.cell(cell, t.version))
+}
+
+// localityCell returns cell padded to the Locality column followed by its separator, or nothing without the column
+func (t tableWidths) localityCell(cell string) string {
+	if t.locality == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%-*s ", t.locality, t.cell(cell, t.locality))
+}
+
+// formatLocality returns the locality of node as region/zone/sub_zone, each part being "-" if it's unset,
+// e.g. "us-central1/us-central1-a/-"
+func formatLocality(node *envoy_config_core_v3.Node) string {
+	parts := []string{node.GetLocality().GetRegion(), node.GetLocality().GetZone(), node.GetLocality().GetSubZone()}
+	for i, part := range parts {
+		if part == "" {
+			parts[i] = "-"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// formatVersion returns the version_info of a resource, or "-" if it has none
+func formatVersion(genericXdsConfig *csdspb_v3.ClientConfig_GenericXdsConfig) string {
+	if genericXdsConfig.GetVersionInfo() == "" {
+		return "-"
+	}
+	return genericXdsConfig.GetVersionInfo()
+}
+
+// printTableRows prints the rows of configs beneath the header of the table, with the columns of widths
+func printTableRows(w io.Writer, configs []*csdspb_v3.ClientConfig, color bool, utc bool, showErrors bool, view *endpointView, widths tableWidths) {
+	for _, config := range configs {
+		id, xdsType := parseNode(config)
+		id, xdsType = widths.cell(id, widths.id), widths.cell(xdsType, widths.xdsType)
+
+		if config.GetGenericXdsConfigs() == nil {
+			if config.GetNode() != nil {
+				fmt.Fprintf(w, "%s%-*s %s%-*s %-*s \n", view.cell(config), widths.id, id, widths.localityCell(formatLocality(config.GetNode())), widths.xdsType, xdsType, widths.configStatus, "N/A")
+			}
+		} else {
+			// parse config status
+			configStatus := parseConfigStatus(config.GetGenericXdsConfigs())
+			lastUpdated := parseLastUpdated(config.GetGenericXdsConfigs(), utc)
+			fmt.Fprintf(w, "%s%-*s %s%-*s ", view.cell(config), widths.id, id, widths.localityCell(formatLocality(config.GetNode())), widths.xdsType, xdsType)
+
+			for i := 0; i < len(configStatus); i++ {
+				// configStatus has one entry per resource if it was parsed
+				var statusColor string
+				if color {
+					statusColor = resourceColor(config.GetGenericXdsConfigs()[i])
+				}
+				cell := colorizeCell(widths.cell(configStatus[i], widths.configStatus), config.GetGenericXdsConfigs()[i].GetConfigStatus().String(), widths.configStatus, statusColor)
+				clientStatus := widths.cell(formatClientStatus(config.GetGenericXdsConfigs()[i]), widths.clientStatus)
+				version := widths.versionCell(formatVersion(config.GetGenericXdsConfigs()[i]))
+				if i == 0 {
+					fmt.Fprintf(w, "%s %-*s %s%s\n", cell, widths.clientStatus, clientStatus, version, lastUpdated[i])
+				} else {
+					fmt.Fprintf(w, "%s %s %-*s %s%s\n", widths.indent(view), cell, widths.clientStatus, clientStatus, version, lastUpdated[i])
+				}
+				if showErrors && config.GetGenericXdsConfigs()[i].GetConfigStatus() == csdspb_v3.ConfigStatus_ERROR {
+					printErrorState(w, config.GetGenericXdsConfigs()[i].GetErrorState(), view, widths)
+				}
+			}
+			if len(configStatus) == 0 {
+				fmt.Fprintf(w, "\n")
+			}
+
+			// resources of the same type with different versions often indicate an in-progress or stuck update
+			skew := parseVersionSkew(config.GetGenericXdsConfigs())
+			skewedXds := make([]string, 0, len(skew))
+			for xds := range skew {
+				skewedXds = append(skewedXds, xds)
+			}
+			sort.Strings(skewedXds)
+			for _, xds := range skewedXds {
+				fmt.Fprintf(w, "%s WARNING: %s version skew: %s\n", widths.indent(view), xds, strings.Join(skew[xds], ", "))
+			}
+		}
+	}
+}
+
+// printFixedWidthTable prints the config status of each client as the table of the former releases did
+// for -fixed_width, byte for byte, so that the scripts parsing its columns positionally keep working
+func printFixedWidthTable(w io.Writer, configs []*csdspb_v3.ClientConfig) {
+	fmt.Fprintf(w, "%-50s %-30s %-30s \n", "Client ID", "xDS stream type", "Config Status")
+	for _, config := range configs {
+		id, xdsType := parseNode(config)
+		if config.GetGenericXdsConfigs() == nil {
+			if config.GetNode() != nil {
+				fmt.Fprintf(w, "%-50s %-30s %-30s \n", id, xdsType, "N/A")
+			}
+			continue
+		}
+		configStatus := parseConfigStatus(config.GetGenericXdsConfigs())
+		fmt.Fprintf(w, "%-50s %-30s ", id, xdsType)
+		for i := 0; i < len(configStatus); i++ {
+			if i == 0 {
+				fmt.Fprintf(w, "%-30s \n", configStatus[i])
+			} else {
+				fmt.Fprintf(w, "%-50s %-30s %-30s \n", "", "", configStatus[i])
+			}
+		}
+		if len(configStatus) == 0 {
+			fmt.Fprintf(w, "\n")
+		}
+	}
+}
+
+// compactXds are the xDS types shown by the compact output format, in display order
+var compactXds = []string{"CDS", "LDS", "RDS", "SRDS", "EDS"}
+
+// knownXds are the short names of all the xDS types known by xdsShortName
+var knownXds = append(append([]string{}, compactXds...), "VHDS", "ECDS", "RTDS")
+
+// compactStatus maps each config status to its single-character form and its severity.
+// STALE is abbreviated as T to not collide with SYNCED.
+var compactStatus = map[csdspb_v3.ConfigStatus]struct {
+	initial  string
+	severity int
+}{
+	csdspb_v3.ConfigStatus_SYNCED:   {"S", 0},
+	csdspb_v3.ConfigStatus_NOT_SENT: {"N", 1},
+	csdspb_v3.ConfigStatus_UNKNOWN:  {"U", 2},
+	csdspb_v3.ConfigStatus_STALE:    {"T", 3},
+	csdspb_v3.ConfigStatus_ERROR:    {"E", 4},
+}
+
+// parseWorstStatus returns the most severe config status of each xDS type of a client
+func parseWorstStatus(xdsConfig []*csdspb_v3.ClientConfig_GenericXdsConfig) map[string]csdspb_v3.ConfigStatus {
+	worst := make(map[string]csdspb_v3.ConfigStatus)
+	for _, genericXdsConfig := range xdsConfig {
+		xds, err := xdsShortName(genericXdsConfig.GetTypeUrl())
+		if err != nil {
+			continue
+		}
+		status := genericXdsConfig.GetConfigStatus()
+		if current, ok := worst[xds]; !ok || compactStatus[status].severity > compactStatus[current].severity {
+			worst[xds] = status
+		}
+	}
+	return worst
+}
+
+// printCompact prints one line per client with the worst config status of each xDS type,
+// e.g. "C:S L:S R:E S:- E:S". Types without any resource are shown as "-".
+func printCompact(w io.Writer, configs []*csdspb_v3.ClientConfig, color bool, view *endpointView) {
+	for _, config := range configs {
+		if config.GetNode() == nil {
+			continue
+		}
+		id, _ := parseNode(config)
+		worst := parseWorstStatus(config.GetGenericXdsConfigs())
+
+		fields := make([]string, 0, len(compactXds))
+		for _, xds := range compactXds {
+			initial := "-"
+			if status, ok := worst[xds]; ok {
+				initial = compactStatus[status].initial
+				if color && configStatusColor(status) != "" {
+					initial = configStatusColor(status) + initial + colorReset
+				}
+			}
+			fields = append(fields, xds[:1]+":"+initial)
+		}
+		fmt.Fprintf(w, "%s%-50s %s\n", view.cell(config), id, strings.Join(fields, " "))
+	}
+}
+
+// requestYamlFields are the top-level fields of the csds request yaml
+var requestYamlFields = []string{"node_matchers", "node"}
+
+// parseYaml is a helper method for parsing csds request yaml to NodeMatchers. Unless lenient is set,
+// the fields of the yaml that aren't fields of the request, e.g. a misspelled node_matcher, are rejected
+// naming the bad field, instead of being silently dropped. The NodeMatchers of yamlStr are merged into
+// the ones of path as selected by mergeTarget.
+func parseYaml(path string, yamlStr string, nms *[]*envoy_type_matcher_v3.NodeMatcher, node *envoy_config_core_v3.Node, lenient bool) error {
+	if path != "" {
+		data, err := clientutil.ParseYamlFileToMap(path)
+		if err != nil {
+			return err
+		}
+		nodeMatchers, err := requestNodeMatchers(data, lenient)
+		if err != nil {
+			return fmt.Errorf("invalid request_file %s: %v", path, err)
+		}
+
+		// parse each json object to proto, node_matchers can be omitted with -request_mode node_only
+		for i, n := range nodeMatchers {
+			x := &envoy_type_matcher_v3.NodeMatcher{}
+			if err := unmarshalRequestField(fmt.Sprintf("node_matchers[%d]", i), n, x, lenient); err != nil {
+				return fmt.Errorf("invalid request_file %s: %v", path, err)
+			}
+			*nms = append(*nms, x)
+		}
+
+		// Extract the node id from the request YAML
+		if nv, ok := data["node"]; ok {
+			if err := unmarshalRequestField("node", nv, node, lenient); err != nil {
+				return fmt.Errorf("invalid request_file %s: %v", path, err)
+			}
+		}
+	}
+	if yamlStr != "" {
+		data, err := clientutil.ParseYamlStrToMap(yamlStr)
+		if err != nil {
+			return err
+		}
+		nodeMatchers, err := requestNodeMatchers(data, lenient)
+		if err != nil {
+			return fmt.Errorf("invalid request_yaml: %v", err)
+		}
+		// only the NodeMatchers of request_file are merged into, not the ones appended below
+		fileMatchers := len(*nms)
+
+		// parse each json object to proto
+		for i, n := range nodeMatchers {
+			x := &envoy_type_matcher_v3.NodeMatcher{}
+			if err := unmarshalRequestField(fmt.Sprintf("node_matchers[%d]", i), n, x, lenient); err != nil {
+				return fmt.Errorf("invalid request_yaml: %v", err)
 			}
 
 			// merge the proto with existing proto from request_file
-			if i < len(*nms) {
-				proto.Merge((*nms)[i], x)
+			if j := mergeTarget(*nms, fileMatchers, x, i, len(nodeMatchers)); j >= 0 {
+				mergeNodeMatcher((*nms)[j], x)
 			} else {
 				*nms = append(*nms, x)
 			}
 		}
+
+		// merge the node with the node from request_file
+		if nv, ok := data["node"]; ok {
+			x := &envoy_config_core_v3.Node{}
+			if err := unmarshalRequestField("node", nv, x, lenient); err != nil {
+				return fmt.Errorf("invalid request_yaml: %v", err)
+			}
+			proto.Merge(node, x)
+		}
+	}
+	return nil
+}
+
+// requestNodeMatchers returns the node_matchers of the parsed request yaml. Unless lenient is set, the
+// top-level fields other than requestYamlFields and node_matchers that aren't a list are rejected.
+func requestNodeMatchers(data map[string]interface{}, lenient bool) ([]interface{}, error) {
+	nodeMatchers, ok := data["node_matchers"].([]interface{})
+	if lenient {
+		return nodeMatchers, nil
+	}
+	var unknown []string
+	for field := range data {
+		known := false
+		for _, f := range requestYamlFields {
+			known = known || field == f
+		}
+		if !known {
+			unknown = append(unknown, field)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown field %s, list of supported fields: %s", strings.Join(unknown, ", "), strings.Join(requestYamlFields, ", "))
+	}
+	if n, found := data["node_matchers"]; found && !ok && n != nil {
+		return nil, errors.New("node_matchers must be a list")
+	}
+	return nodeMatchers, nil
+}
+
+// unmarshalRequestField parses the value of field of the request yaml to m, through its json. Unless
+// lenient is set, the unknown fields of the value are rejected, naming the field of the yaml they are in.
+func unmarshalRequestField(field string, value interface{}, m proto.Message, lenient bool) error {
+	jsonString, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: lenient}).Unmarshal(jsonString, m); err != nil {
+		return fmt.Errorf("%s: %v", field, err)
 	}
 	return nil
 }
 
+// mergeTarget returns the index of the NodeMatcher among the first fileMatchers of nms, the ones of
+// -request_file, that the NodeMatcher x at index i of the yamlMatchers of -request_yaml is merged into, or
+// -1 if x is appended. A NodeMatcher keyed by a mesh scope or a network name is merged into the one with
+// the same key, wherever it is. A NodeMatcher without a key, or whose key overrides the one of the file,
+// is merged by position, as it can't be told apart otherwise. The latter only applies when both list the
+// same number of NodeMatchers, a keyed NodeMatcher being appended otherwise.
+func mergeTarget(nms []*envoy_type_matcher_v3.NodeMatcher, fileMatchers int, x *envoy_type_matcher_v3.NodeMatcher, i int, yamlMatchers int) int {
+	key := nodeMatcherKey(x)
+	if key != "" {
+		for j := 0; j < fileMatchers; j++ {
+			if nodeMatcherKey(nms[j]) == key {
+				return j
+			}
+		}
+		if yamlMatchers != fileMatchers {
+			return -1
+		}
+	}
+	if i < fileMatchers {
+		return i
+	}
+	return -1
+}
+
+// mergeNodeMatcher merges src into dst. Unlike proto.Merge, which appends the node metadatas, a node
+// metadata of src replaces the one of dst with the same path, so that -request_yaml can override its value.
+func mergeNodeMatcher(dst *envoy_type_matcher_v3.NodeMatcher, src *envoy_type_matcher_v3.NodeMatcher) {
+	if src.GetNodeId() != nil {
+		dst.NodeId = src.GetNodeId()
+	}
+	for _, metadata := range src.GetNodeMetadatas() {
+		replaced := false
+		for i, m := range dst.GetNodeMetadatas() {
+			if samePath(m.GetPath(), metadata.GetPath()) {
+				dst.NodeMetadatas[i] = metadata
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			dst.NodeMetadatas = append(dst.NodeMetadatas, metadata)
+		}
+	}
+}
+
+// samePath returns whether the metadata paths a and b are made of the same segments
+func samePath(a []*envoy_type_matcher_v3.StructMatcher_PathSegment, b []*envoy_type_matcher_v3.StructMatcher_PathSegment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !proto.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// nodeMatcherKey returns the key NodeMatchers are merged by, the mesh scope or else the network name of
+// nm, or "" if it has neither
+func nodeMatcherKey(nm *envoy_type_matcher_v3.NodeMatcher) string {
+	nms := []*envoy_type_matcher_v3.NodeMatcher{nm}
+	if value := getValueByKeyFromNodeMatcher(nms, gcpMeshScopeKey); value != "" {
+		return gcpMeshScopeKey + "=" + value
+	}
+	if value := getValueByKeyFromNodeMatcher(nms, gcpNetworkNameKey); value != "" {
+		return gcpNetworkNameKey + "=" + value
+	}
+	return ""
+}
+
 // getValueByKeyFromNodeMatcher gets the first value by key from the metadata of a set of NodeMatchers
 func getValueByKeyFromNodeMatcher(nms []*envoy_type_matcher_v3.NodeMatcher, key string) string {
 	for _, nm := range nms {
@@ -2,16 +2,94 @@
 package client
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"envoy-tools/csds-client/client"
 	clientUtil "envoy-tools/csds-client/client/util"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	xds_type_v3 "github.com/cncf/xds/go/xds/type/v3"
+	envoy_admin_v3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	envoy_config_listener_v3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	envoy_extensions_transport_sockets_tls_v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"github.com/ghodss/yaml"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// fakeStream is a CSDS stream that records the sent requests and returns the given responses followed by EOF
+type fakeStream struct {
+	csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient
+	requests  []*csdspb_v3.ClientStatusRequest
+	responses []*csdspb_v3.ClientStatusResponse
+	closed    bool
+	header    metadata.MD
+}
+
+func (s *fakeStream) Header() (metadata.MD, error) {
+	return s.header, nil
+}
+
+func (s *fakeStream) CloseSend() error {
+	s.closed = true
+	return nil
+}
+
+func (s *fakeStream) Send(req *csdspb_v3.ClientStatusRequest) error {
+	s.requests = append(s.requests, req)
+	return nil
+}
+
+func (s *fakeStream) Recv() (*csdspb_v3.ClientStatusResponse, error) {
+	if len(s.responses) == 0 {
+		return nil, io.EOF
+	}
+	resp := s.responses[0]
+	s.responses = s.responses[1:]
+	return resp, nil
+}
+
+// newClientConfig creates a client config of a node without any xds config
+func newClientConfig(id string) *csdspb_v3.ClientConfig {
+	return &csdspb_v3.ClientConfig{Node: &envoy_config_core_v3.Node{Id: id}}
+}
+
 // TestParseNodeMatcherWithFile tests parsing -request_file to nodematcher.
 func TestParseNodeMatcherWithFile(t *testing.T) {
 	c := ClientV3{
@@ -36,7 +114,7 @@ func TestParseNodeMatcherWithFile(t *testing.T) {
 		t.Errorf("NodeMatcher = \n%v\n, want: \n%v\n", string(get), want)
 	}
 	wantNode := "{\"id\":\"fake_client_node_id\"}"
-	getNode, errNode := protojson.Marshal(&c.node)
+	getNode, errNode := protojson.Marshal(c.node)
 	if err != nil {
 		t.Errorf("Parse Node Error: %v", errNode)
 	}
@@ -96,6 +174,286 @@ func TestParseNodeMatcherWithFileAndString(t *testing.T) {
 	}
 }
 
+// TestParseNodeMatcherMergeByKey tests that the NodeMatchers of -request_yaml are merged into the ones of
+// -request_file with the same mesh scope or network name, whatever their order, and appended otherwise
+func TestParseNodeMatcherMergeByKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds-merge")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "request.yaml")
+	requestFile := `node_matchers:
+  - node_id: {exact: node_a}
+    node_metadatas:
+      - {path: [{key: TRAFFICDIRECTOR_GCP_PROJECT_NUMBER}], value: {string_match: {exact: "1"}}}
+      - {path: [{key: TRAFFICDIRECTOR_MESH_SCOPE_NAME}], value: {string_match: {exact: scope_a}}}
+  - node_id: {exact: node_b}
+    node_metadatas:
+      - {path: [{key: TRAFFICDIRECTOR_GCP_PROJECT_NUMBER}], value: {string_match: {exact: "1"}}}
+      - {path: [{key: TRAFFICDIRECTOR_NETWORK_NAME}], value: {string_match: {exact: network_b}}}
+`
+	if err := ioutil.WriteFile(path, []byte(requestFile), 0600); err != nil {
+		t.Fatalf("Write request file failure: %v", err)
+	}
+	// reordered, with a NodeMatcher of another mesh scope and one without a key
+	requestYaml := `node_matchers:
+  - node_id: {exact: node_b_from_cli}
+    node_metadatas:
+      - {path: [{key: TRAFFICDIRECTOR_NETWORK_NAME}], value: {string_match: {exact: network_b}}}
+  - node_metadatas:
+      - {path: [{key: TRAFFICDIRECTOR_GCP_PROJECT_NUMBER}], value: {string_match: {exact: "2"}}}
+      - {path: [{key: TRAFFICDIRECTOR_MESH_SCOPE_NAME}], value: {string_match: {exact: scope_a}}}
+  - node_id: {exact: node_c}
+    node_metadatas:
+      - {path: [{key: TRAFFICDIRECTOR_MESH_SCOPE_NAME}], value: {string_match: {exact: scope_c}}}
+`
+	var nms []*envoy_type_matcher_v3.NodeMatcher
+	if err := parseYaml(path, requestYaml, &nms, &envoy_config_core_v3.Node{}, false); err != nil {
+		t.Fatalf("Parse yaml error: %v", err)
+	}
+	want := []struct {
+		nodeId, project, key string
+	}{
+		{nodeId: "node_a", project: "2", key: "TRAFFICDIRECTOR_MESH_SCOPE_NAME=scope_a"},
+		{nodeId: "node_b_from_cli", project: "1", key: "TRAFFICDIRECTOR_NETWORK_NAME=network_b"},
+		{nodeId: "node_c", key: "TRAFFICDIRECTOR_MESH_SCOPE_NAME=scope_c"},
+	}
+	if len(nms) != len(want) {
+		t.Fatalf("want %d NodeMatchers, got %v", len(want), nms)
+	}
+	for i, w := range want {
+		nm := []*envoy_type_matcher_v3.NodeMatcher{nms[i]}
+		if nms[i].GetNodeId().GetExact() != w.nodeId || getValueByKeyFromNodeMatcher(nm, gcpProjectNumberKey) != w.project || nodeMatcherKey(nms[i]) != w.key {
+			t.Errorf("NodeMatcher %d: want %+v, got %v", i, w, nms[i])
+		}
+		if len(nms[i].GetNodeMetadatas()) > 2 {
+			t.Errorf("NodeMatcher %d: want the node metadatas of the same path replaced, got %v", i, nms[i].GetNodeMetadatas())
+		}
+	}
+
+	// a NodeMatcher without a key is merged by position
+	nms = nil
+	if err := parseYaml(path, `{"node_matchers": [{}, {"node_id": {"exact": "node_b_from_cli"}}]}`, &nms, &envoy_config_core_v3.Node{}, false); err != nil {
+		t.Fatalf("Parse yaml error: %v", err)
+	}
+	if len(nms) != 2 || nms[0].GetNodeId().GetExact() != "node_a" || nms[1].GetNodeId().GetExact() != "node_b_from_cli" {
+		t.Errorf("want the NodeMatchers without a key merged by position, got %v", nms)
+	}
+
+	// a NodeMatcher overriding the network name itself is merged by position, as both list as many
+	nms = nil
+	requestYaml = `node_matchers:
+  - node_id: {exact: node_a_from_cli}
+  - node_metadatas:
+      - {path: [{key: TRAFFICDIRECTOR_NETWORK_NAME}], value: {string_match: {exact: network_c}}}
+`
+	if err := parseYaml(path, requestYaml, &nms, &envoy_config_core_v3.Node{}, false); err != nil {
+		t.Fatalf("Parse yaml error: %v", err)
+	}
+	if len(nms) != 2 || nms[0].GetNodeId().GetExact() != "node_a_from_cli" || nms[1].GetNodeId().GetExact() != "node_b" || nodeMatcherKey(nms[1]) != "TRAFFICDIRECTOR_NETWORK_NAME=network_c" || len(nms[1].GetNodeMetadatas()) != 2 {
+		t.Errorf("want the network name of the NodeMatcher overridden by position, got %v", nms)
+	}
+}
+
+// TestParseNodeMatcherUnknownField tests that the misspelled fields of the request yaml are rejected
+// naming the bad field, unless -lenient_yaml is set
+func TestParseNodeMatcherUnknownField(t *testing.T) {
+	tests := []struct {
+		requestYaml string
+		want        []string
+	}{
+		{
+			requestYaml: `{"node_matcher": [{"node_id": {"exact": "fake_node_id"}}]}`,
+			want:        []string{"unknown field node_matcher"},
+		},
+		{
+			requestYaml: `{"node_matchers": [{"node_id": {"exact": "fake_node_id"}}, {"node_metadata": []}]}`,
+			want:        []string{"node_matchers[1]: ", `unknown field "node_metadata"`},
+		},
+		{
+			requestYaml: `{"node_matchers": [], "node": {"idd": "fake_client_node_id"}}`,
+			want:        []string{"node: ", `unknown field "idd"`},
+		},
+		{
+			requestYaml: `{"node_matchers": {"node_id": {"exact": "fake_node_id"}}}`,
+			want:        []string{"node_matchers must be a list"},
+		},
+	}
+	for _, test := range tests {
+		c := ClientV3{
+			opts: client.ClientOptions{
+				Platform:    "gcp",
+				RequestYaml: test.requestYaml,
+			},
+		}
+		err := c.parseNodeMatcher()
+		if err == nil {
+			t.Errorf("request_yaml %s: want error %q, got none", test.requestYaml, test.want)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("request_yaml %s: want error %q, got %v", test.requestYaml, want, err)
+			}
+		}
+	}
+
+	// the unknown fields are dropped with -lenient_yaml, leaving the NodeMatchers empty
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:    "gcp",
+			RequestYaml: tests[0].requestYaml,
+			LenientYaml: true,
+		},
+	}
+	if err := c.parseNodeMatcher(); err == nil || strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("want only the missing fields of the NodeMatcher to be reported, got %v", err)
+	}
+	if len(c.nodeMatcher) != 0 {
+		t.Errorf("want no NodeMatcher, got %v", c.nodeMatcher)
+	}
+}
+
+// TestParseNodeMatcherWithStdin tests that -request_file - reads the request from stdin like from a file
+func TestParseNodeMatcherWithStdin(t *testing.T) {
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	requestYaml := "{\"node_matchers\": [{\"node_id\": {\"exact\": \"fake_node_id_from_cli\"}}]}"
+
+	fromFile := ClientV3{opts: client.ClientOptions{Platform: "gcp", RequestFile: "./test_request.yaml", RequestYaml: requestYaml}}
+	if err := fromFile.parseNodeMatcher(); err != nil {
+		t.Fatalf("Parse NodeMatcher Error: %v", err)
+	}
+	f, err := os.Open("./test_request.yaml")
+	if err != nil {
+		t.Fatalf("Open request file failure: %v", err)
+	}
+	defer f.Close()
+	os.Stdin = f
+	fromStdin := ClientV3{opts: client.ClientOptions{Platform: "gcp", RequestFile: "-", RequestYaml: requestYaml}}
+	if err := fromStdin.parseNodeMatcher(); err != nil {
+		t.Fatalf("Parse NodeMatcher Error: %v", err)
+	}
+	if !proto.Equal(fromStdin.nodeMatcher[0], fromFile.nodeMatcher[0]) || !proto.Equal(fromStdin.node, fromFile.node) {
+		t.Errorf("want the request of stdin to be parsed like the request file, got %v and %v", fromStdin.nodeMatcher[0], fromStdin.node)
+	}
+
+	dir, err := ioutil.TempDir("", "csds-stdin")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	emptyPath := filepath.Join(dir, "empty.yaml")
+	if err := ioutil.WriteFile(emptyPath, []byte("\n"), 0600); err != nil {
+		t.Fatalf("Write empty file failure: %v", err)
+	}
+	empty, err := os.Open(emptyPath)
+	if err != nil {
+		t.Fatalf("Open empty file failure: %v", err)
+	}
+	defer empty.Close()
+	os.Stdin = empty
+	c := ClientV3{opts: client.ClientOptions{Platform: "gcp", RequestFile: "-"}}
+	if err := c.parseNodeMatcher(); err == nil || !strings.Contains(err.Error(), "empty request yaml read from stdin") {
+		t.Errorf("want an error for the empty stdin, got %v", err)
+	}
+}
+
+// TestParseNodeMatcherWithEnv tests expanding the environment variables referenced by the request yaml
+func TestParseNodeMatcherWithEnv(t *testing.T) {
+	os.Setenv("CSDS_TEST_PROJECT_NUMBER", "123456789")
+	defer os.Unsetenv("CSDS_TEST_PROJECT_NUMBER")
+	os.Unsetenv("CSDS_TEST_UNSET")
+
+	tests := []struct {
+		yaml    string
+		want    string
+		wantErr string
+	}{
+		{yaml: `project: "${CSDS_TEST_PROJECT_NUMBER}"`, want: "123456789"},
+		{yaml: `project: "${CSDS_TEST_PROJECT_NUMBER:-0}"`, want: "123456789"},
+		{yaml: "project: ${CSDS_TEST_UNSET:-fallback}", want: "fallback"},
+		{yaml: "project: ${CSDS_TEST_UNSET}", wantErr: "environment variable CSDS_TEST_UNSET referenced by the request yaml is not set"},
+		{yaml: "project: $CSDS_TEST_PROJECT_NUMBER", want: "$CSDS_TEST_PROJECT_NUMBER"},
+	}
+	for _, test := range tests {
+		data, err := clientUtil.ParseYamlStrToMap(test.yaml)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: want error %q, got %v", test.yaml, test.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parse error: %v", test.yaml, err)
+			continue
+		}
+		if got := fmt.Sprint(data["project"]); got != test.want {
+			t.Errorf("%s: want %q, got %q", test.yaml, test.want, got)
+		}
+	}
+
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform: "gcp",
+			RequestYaml: `{"node_matchers": [{"node_metadatas": [{"path": [{"key": "TRAFFICDIRECTOR_GCP_PROJECT_NUMBER"}], "value": {"string_match": {"exact": "${CSDS_TEST_PROJECT_NUMBER}"}}},
+				{"path": [{"key": "TRAFFICDIRECTOR_NETWORK_NAME"}], "value": {"string_match": {"exact": "${CSDS_TEST_UNSET:-default}"}}}]}]}`,
+		},
+	}
+	if err := c.parseNodeMatcher(); err != nil {
+		t.Fatalf("Parse NodeMatcher Error: %v", err)
+	}
+	if got := c.nodeMatcher[0].GetNodeMetadatas()[0].GetValue().GetStringMatch().GetExact(); got != "123456789" {
+		t.Errorf("want the project number of the environment, got %q", got)
+	}
+}
+
+// TestParseNodeMatcherReportsAllProblems tests that every problem of the request is reported at once
+func TestParseNodeMatcherReportsAllProblems(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:    "gcp",
+			RequestYaml: `{"node_matchers": [{"node_metadatas": [{"path": [{"key": "TRAFFICDIRECTOR_NETWORK_NAME"}], "value": {"string_match": {"exact": "default"}}}, {"path": [{"key": "TRAFFICDIRECTOR_MESH_SCOPE_NAME"}], "value": {"string_match": {"exact": "mesh"}}}]}]}`,
+			FilterMode:  "wildcard",
+		},
+	}
+	err := c.parseNodeMatcher()
+	var requestErr *client.RequestError
+	if !errors.As(err, &requestErr) {
+		t.Fatalf("want a RequestError, got %v", err)
+	}
+	want := []string{
+		"missing field TRAFFICDIRECTOR_GCP_PROJECT_NUMBER in NodeMatcher",
+		"cannot set both TRAFFICDIRECTOR_NETWORK_NAME or TRAFFICDIRECTOR_MESH_SCOPE_NAME",
+		"wildcard filter mode is not supported, list of supported filter modes: prefix, suffix, exact, regex, glob",
+	}
+	var got []string
+	for _, err := range requestErr.Errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want problems\n%v\ngot\n%v", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	if !strings.HasPrefix(err.Error(), "found 3 problems in the request:\n  - missing field") {
+		t.Errorf("want the problems listed one per line, got\n%v", err)
+	}
+	// errors.Is and errors.As match each of the problems
+	sentinel := errors.New("sentinel")
+	var statusErr *client.StatusError
+	err = client.JoinRequestErrors(err, fmt.Errorf("wrapped: %w", sentinel), &client.StatusError{Statuses: []string{"STALE"}})
+	if !errors.Is(err, sentinel) || !errors.As(err, &statusErr) || statusErr.Statuses[0] != "STALE" || errors.Is(err, errors.New("sentinel")) {
+		t.Errorf("want errors.Is and errors.As to match each problem of %v", err)
+	}
+
+	// a single problem is returned as is
+	c.opts.FilterMode = ""
+	c.opts.RequestYaml = `{"node_matchers": [{"node_metadatas": [{"path": [{"key": "TRAFFICDIRECTOR_NETWORK_NAME"}], "value": {"string_match": {"exact": "default"}}}]}]}`
+	if err := c.parseNodeMatcher(); err == nil || errors.As(err, &requestErr) || err.Error() != want[0] {
+		t.Errorf("want the single problem %q, got %v", want[0], err)
+	}
+}
+
 // TestParseResponseWithoutNodeId tests post processing response without node_id.
 func TestParseResponseWithoutNodeId(t *testing.T) {
 	c := ClientV3{
@@ -113,21 +471,22 @@ func TestParseResponseWithoutNodeId(t *testing.T) {
 		t.Errorf("Read From File Failure: %v", err)
 	}
 	out := clientUtil.CaptureOutput(func() {
-		if err := printOutResponse(&response, c.opts); err != nil {
+		if err := printOutResponse(os.Stdout, &response, c.opts); err != nil {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
-	want := `Client ID                                          xDS stream type                Config Status                  
-test_node_1                                        test_stream_type1              N/A                            
-test_node_2                                        test_stream_type2              N/A                            
-test_node_3                                        test_stream_type3              N/A                            
+	want := `Client ID   xDS stream type   Config Status Client Status Last Updated
+test_node_1 test_stream_type1 N/A           
+test_node_2 test_stream_type2 N/A           
+test_node_3 test_stream_type3 N/A           
+Clients: 3
 `
 	if out != want {
 		t.Errorf("want\n%vout\n%v", want, out)
 	}
 }
 
-// TestParseResponseWithNodeId tests post processing response with node_id
+// TestParseResponseWithNodeId tests post processing response with node_id to -output_file
 func TestParseResponseWithNodeId(t *testing.T) {
 	c := ClientV3{
 		opts: client.ClientOptions{
@@ -144,26 +503,42 @@ func TestParseResponseWithNodeId(t *testing.T) {
 	if err = protojson.Unmarshal(responsejson, &response); err != nil {
 		t.Errorf("Read From File Failure: %v", err)
 	}
+	w, closeOut, err := clientUtil.OpenOutput(c.opts)
+	if err != nil {
+		t.Fatalf("Open output file failure: %v", err)
+	}
 	out := clientUtil.CaptureOutput(func() {
-		if err := printOutResponse(&response, c.opts); err != nil {
+		if err := printOutResponse(w, &response, c.opts); err != nil {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
-	want := `Client ID                                          xDS stream type                Config Status                  
-test_nodeid                                        test_stream_type1              RDS   STALE                    
-                                                                                  CDS   STALE                    
-Config has been saved to test_config.json
-`
-	if out != want {
-		t.Errorf("want\n%vout\n%v", want, out)
+	if err := closeOut(); err != nil {
+		t.Errorf("Close output file failure: %v", err)
+	}
+	if out != "" {
+		t.Errorf("want nothing printed to stdout, got\n%v", out)
 	}
 
 	outfile, _ := filepath.Abs("./test_config.json")
-	outputjson, err := ioutil.ReadFile(outfile)
+	output, err := ioutil.ReadFile(outfile)
 	if err != nil {
 		t.Errorf("Write config to file failure: %v", err)
 	}
-	ok, err := clientUtil.EqualJSONBytes(outputjson, responsejson)
+	parts := strings.SplitN(string(output), "Detailed Config:\n", 2)
+	if len(parts) != 2 {
+		t.Fatalf("want the detailed config in the output file, got\n%v", string(output))
+	}
+	want := `Client ID   xDS stream type   Config Status Client Status Last Updated
+test_nodeid test_stream_type1 RDS   STALE   -             -
+                              CDS   STALE   -             -
+Clients: 1  STALE: 2
+CDS:1 RDS:1
+`
+	if parts[0] != want {
+		t.Errorf("want\n%vout\n%v", want, parts[0])
+	}
+
+	ok, err := clientUtil.EqualJSONBytes([]byte(parts[1]), responsejson)
 	if err != nil {
 		t.Errorf("failed to parse json")
 	}
@@ -223,14 +598,15 @@ func TestNodeIdPrefixFilter(t *testing.T) {
 		t.Errorf("Read From File Failure: %v", err)
 	}
 	out := clientUtil.CaptureOutput(func() {
-		if err := printOutResponse(&response, c.opts); err != nil {
+		if err := printOutResponse(os.Stdout, &response, c.opts); err != nil {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
-	want := `Client ID                                          xDS stream type                Config Status                  
-test_node_1                                        test_stream_type1              N/A                            
-test_node_2                                        test_stream_type2              N/A                            
-test_node_3                                        test_stream_type3              N/A                            
+	want := `Client ID   xDS stream type   Config Status Client Status Last Updated
+test_node_1 test_stream_type1 N/A           
+test_node_2 test_stream_type2 N/A           
+test_node_3 test_stream_type3 N/A           
+Clients: 3
 `
 	if out != want {
 		t.Errorf("want\n%vout\n%v", want, out)
@@ -256,13 +632,14 @@ func TestNodeIdSuffixFilter(t *testing.T) {
 		t.Errorf("Read From File Failure: %v", err)
 	}
 	out := clientUtil.CaptureOutput(func() {
-		if err := printOutResponse(&response, c.opts); err != nil {
+		if err := printOutResponse(os.Stdout, &response, c.opts); err != nil {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
-	want := `Client ID                                          xDS stream type                Config Status                  
-test_node_3                                        test_stream_type3              N/A                            
-node_3                                             test_stream_type4              N/A                            
+	want := `Client ID   xDS stream type   Config Status Client Status Last Updated
+node_3      test_stream_type4 N/A           
+test_node_3 test_stream_type3 N/A           
+Clients: 2
 `
 	if out != want {
 		t.Errorf("want\n%vout\n%v", want, out)
@@ -288,14 +665,15 @@ func TestNodeIdRegexFilter(t *testing.T) {
 		t.Errorf("Read From File Failure: %v", err)
 	}
 	out := clientUtil.CaptureOutput(func() {
-		if err := printOutResponse(&response, c.opts); err != nil {
+		if err := printOutResponse(os.Stdout, &response, c.opts); err != nil {
 			t.Errorf("Print out response error: %v", err)
 		}
 	})
-	want := `Client ID                                          xDS stream type                Config Status                  
-test_node_1                                        test_stream_type1              N/A                            
-test_node_2                                        test_stream_type2              N/A                            
-test_node_3                                        test_stream_type3              N/A                            
+	want := `Client ID   xDS stream type   Config Status Client Status Last Updated
+test_node_1 test_stream_type1 N/A           
+test_node_2 test_stream_type2 N/A           
+test_node_3 test_stream_type3 N/A           
+Clients: 3
 `
 	if out != want {
 		t.Errorf("want\n%vout\n%v", want, out)
@@ -337,3 +715,4820 @@ func TestParseNodeMatcherMeshScopeAndNetworkShouldFail(t *testing.T) {
 		t.Errorf("Parse NodeMatcher should fail since network name and meshScope are provided.")
 	}
 }
+
+// TestParseResponseWithVersionSkew tests warning on resources of the same type with differing version_info
+func TestParseResponseWithVersionSkew(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform: "gcp",
+		},
+	}
+	filename, _ := filepath.Abs("./response_with_version_skew.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	var response csdspb_v3.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, &response, c.opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := `Client ID   xDS stream type   Config Status Client Status Last Updated
+test_nodeid test_stream_type1 LDS   SYNCED  -             -
+                              CDS   SYNCED  -             -
+                              CDS   STALE   -             -
+                              WARNING: CDS version skew: fake_cluster_version1, fake_cluster_version2
+Clients: 1  SYNCED: 2  STALE: 1
+CDS:2 LDS:1
+`
+	// the detailed config follows the table
+	out = strings.SplitN(out, "Detailed Config:\n", 2)[0]
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
+
+// TestCompactOutputFormat tests printing one line of worst status per xDS type for each client
+func TestCompactOutputFormat(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:     "gcp",
+			OutputFormat: "compact",
+		},
+	}
+	filename, _ := filepath.Abs("./response_with_version_skew.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	var response csdspb_v3.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, &response, c.opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := `test_nodeid                                        C:T L:S R:- S:- E:-
+Clients: 1  SYNCED: 2  STALE: 1
+CDS:2 LDS:1
+`
+	// the detailed config follows the table
+	out = strings.SplitN(out, "Detailed Config:\n", 2)[0]
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
+
+// TestDrainStream tests merging multiple responses received for a single request
+func TestDrainStream(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:     "gcp",
+			DrainStream:  true,
+			DrainTimeout: time.Second,
+		},
+	}
+	stream := &fakeStream{
+		responses: []*csdspb_v3.ClientStatusResponse{
+			{Config: []*csdspb_v3.ClientConfig{newClientConfig("test_node_1"), newClientConfig("test_node_2")}},
+			{Config: []*csdspb_v3.ClientConfig{newClientConfig("test_node_3"), newClientConfig("test_node_1")}},
+		},
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if _, err := c.doRequest(context.Background(), stream); err != nil {
+			t.Errorf("Do request error: %v", err)
+		}
+	})
+	want := `Client ID   xDS stream type Config Status Client Status Last Updated
+test_node_1                 N/A           
+test_node_2                 N/A           
+test_node_3                 N/A           
+Clients: 3
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+	if len(stream.requests) != 1 {
+		t.Errorf("want 1 request sent, got %v", len(stream.requests))
+	}
+}
+
+// TestWaitForClients tests polling the server until the response has clients, or until the wait times out
+func TestWaitForClients(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:       "gcp",
+			FilterMode:     "prefix",
+			WaitForClients: time.Second,
+			WaitInterval:   time.Millisecond,
+		},
+	}
+	stream := &fakeStream{
+		responses: []*csdspb_v3.ClientStatusResponse{
+			{},
+			{},
+			{Config: []*csdspb_v3.ClientConfig{newClientConfig("test_node_1")}},
+		},
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if _, err := c.doRequest(context.Background(), stream); err != nil {
+			t.Errorf("Do request error: %v", err)
+		}
+	})
+	want := `Client ID   xDS stream type Config Status Client Status Last Updated
+test_node_1                 N/A           
+Clients: 1
+`
+	// the notes of the polls are printed to stderr first
+	if strings.Count(out, "no xDS clients connected yet") != 2 || !strings.HasSuffix(out, want) {
+		t.Errorf("want 2 polls followed by\n%vout\n%v", want, out)
+	}
+	if len(stream.requests) != 3 {
+		t.Errorf("want 3 requests sent, got %v", len(stream.requests))
+	}
+
+	// the empty response is printed once the wait times out, and fails with -fail_on_no_clients
+	c = ClientV3{
+		opts: client.ClientOptions{
+			Platform:        "gcp",
+			FilterMode:      "prefix",
+			WaitForClients:  20 * time.Millisecond,
+			WaitInterval:    5 * time.Millisecond,
+			FailOnNoClients: true,
+		},
+	}
+	var err error
+	out = clientUtil.CaptureOutput(func() {
+		_, err = c.doRequest(context.Background(), &fakeStream{})
+	})
+	if !errors.Is(err, client.ErrNoClients) {
+		t.Errorf("want ErrNoClients, got %v", err)
+	}
+	if !strings.Contains(out, "no xDS clients connected after waiting 20ms") || !strings.HasSuffix(out, "No xDS clients connected.\n") {
+		t.Errorf("want the timeout followed by the empty response, got\n%v", out)
+	}
+}
+
+// TestStreamResponses tests rendering the clients of each response as soon as it's received
+func TestStreamResponses(t *testing.T) {
+	tests := []struct {
+		name         string
+		outputFormat string
+		want         string
+	}{
+		{
+			name: "table",
+			want: `Client ID                                          xDS stream type                Config Status                  Client Status   Last Updated
+test_node_2                                                                       N/A                            
+test_node_1                                                                       N/A                            
+test_node_3                                                                       N/A                            
+Clients: 3
+`,
+		},
+		{
+			name:         "csv",
+			outputFormat: "csv",
+			want: `client_id,xds_stream_type,xds,config_status,client_status,type_url
+test_node_2,,,,,
+test_node_1,,,,,
+test_node_3,,,,,
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &renderedWriter{want: "test_node_1", rendered: make(chan struct{})}
+			c := ClientV3{
+				opts: client.ClientOptions{
+					Platform:     "gcp",
+					FilterMode:   "prefix",
+					OutputFormat: tt.outputFormat,
+					Sort:         "none",
+					DrainStream:  true,
+					DrainTimeout: time.Second,
+					Stream:       true,
+				},
+				out: out,
+			}
+			// the second response is only sent once the clients of the first one are printed
+			stream := &gatedStream{
+				fakeStream: fakeStream{
+					responses: []*csdspb_v3.ClientStatusResponse{
+						{Config: []*csdspb_v3.ClientConfig{newClientConfig("test_node_2"), newClientConfig("test_node_1")}},
+						{Config: []*csdspb_v3.ClientConfig{newClientConfig("test_node_3")}},
+					},
+				},
+				gate: out.rendered,
+			}
+			if _, err := c.doRequest(context.Background(), stream); err != nil {
+				t.Fatalf("Do request error: %v", err)
+			}
+			out.mu.Lock()
+			defer out.mu.Unlock()
+			if got := out.buf.String(); got != tt.want {
+				t.Errorf("want\n%vout\n%v", tt.want, got)
+			}
+		})
+	}
+}
+
+// renderedWriter closes rendered once want is written to it
+type renderedWriter struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	want     string
+	rendered chan struct{}
+	closed   bool
+}
+
+func (w *renderedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := w.buf.Write(p)
+	if !w.closed && strings.Contains(w.buf.String(), w.want) {
+		close(w.rendered)
+		w.closed = true
+	}
+	return n, err
+}
+
+// gatedStream is a fakeStream that waits for gate to be closed before receiving its second response
+type gatedStream struct {
+	fakeStream
+	gate     chan struct{}
+	received int
+}
+
+func (s *gatedStream) Recv() (*csdspb_v3.ClientStatusResponse, error) {
+	s.received++
+	if s.received == 2 {
+		select {
+		case <-s.gate:
+		case <-time.After(time.Second):
+			return nil, errors.New("the first response wasn't rendered before receiving the second one")
+		}
+	}
+	return s.fakeStream.Recv()
+}
+
+// TestMetaMissingFilter tests keeping only the nodes lacking a metadata key, combined with node_id filter
+func TestMetaMissingFilter(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:      "gcp",
+			FilterMode:    "prefix",
+			FilterPattern: "test",
+			MetaMissing:   "TEAM",
+		},
+	}
+	filename, _ := filepath.Abs("./response_for_meta_filter.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	var response csdspb_v3.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, &response, c.opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := `Client ID   xDS stream type   Config Status Client Status Last Updated
+test_node_2 test_stream_type2 N/A           
+Clients: 1
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
+
+// TestFilterCounts tests counting the clients left after each filter stage
+func TestFilterCounts(t *testing.T) {
+	opts := client.ClientOptions{
+		Platform:      "gcp",
+		FilterMode:    "prefix",
+		FilterPattern: "test",
+		MetaMissing:   "TEAM",
+	}
+	filename, _ := filepath.Abs("./response_for_meta_filter.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	var response csdspb_v3.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	_, counts, err := filterClientConfigs(response.GetConfig(), opts)
+	if err != nil {
+		t.Errorf("Filter client configs error: %v", err)
+	}
+	want := []filterCount{{"response", 3}, {"node_id", 2}, {"meta_missing", 1}}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("want %v, got %v", want, counts)
+	}
+}
+
+// TestWriteMonitorSnapshotOnlyLastCycle tests overwriting latest.json in each monitor cycle
+func TestWriteMonitorSnapshotOnlyLastCycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds-monitor")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, config := range []string{`{"cycle": 1}`, `{"cycle": 2}`} {
+		if _, err := clientUtil.WriteMonitorSnapshot(dir, []byte(config), true, time.Now()); err != nil {
+			t.Errorf("Write monitor snapshot failure: %v", err)
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Read dir failure: %v", err)
+	}
+	if len(files) != 1 || files[0].Name() != "latest.json" {
+		t.Errorf("want only latest.json in %v, got %v", dir, files)
+	}
+	latest, err := ioutil.ReadFile(filepath.Join(dir, "latest.json"))
+	if err != nil {
+		t.Errorf("Read latest.json failure: %v", err)
+	}
+	if string(latest) != `{"cycle": 2}` {
+		t.Errorf("want config of the last cycle, got %v", string(latest))
+	}
+}
+
+// TestParseEndpoint tests selecting the platform of each endpoint
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want endpoint
+	}{
+		{"trafficdirector.googleapis.com:443", endpoint{uri: "trafficdirector.googleapis.com:443", platform: "gcp"}},
+		{"dns:///trafficdirector.googleapis.com:443", endpoint{uri: "dns:///trafficdirector.googleapis.com:443", platform: "gcp"}},
+		{"aws=example.com:443", endpoint{uri: "example.com:443", platform: "aws"}},
+		{"example.com:443", endpoint{uri: "example.com:443", platform: "default_platform"}},
+	}
+	for _, tt := range tests {
+		if got := parseEndpoint(tt.uri, "default_platform"); got != tt.want {
+			t.Errorf("parseEndpoint(%v) = %v, want %v", tt.uri, got, tt.want)
+		}
+	}
+}
+
+// parseResponse parses a ClientStatusResponse from json, failing the test on error
+func parseResponse(t *testing.T, js string) *csdspb_v3.ClientStatusResponse {
+	t.Helper()
+	response := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(js), response); err != nil {
+		t.Fatalf("Parse response failure: %v", err)
+	}
+	return response
+}
+
+// TestSelfDiff tests printing the changes between two responses
+func TestSelfDiff(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:     "gcp",
+			SelfDiff:     time.Millisecond,
+			SelfDiffFail: true,
+		},
+	}
+	stream := &fakeStream{
+		responses: []*csdspb_v3.ClientStatusResponse{
+			parseResponse(t, `{"config": [
+				{"node": {"id": "test_node_1"}, "genericXdsConfigs": [
+					{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "versionInfo": "v1", "configStatus": "SYNCED"},
+					{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "versionInfo": "v1", "configStatus": "SYNCED"}]},
+				{"node": {"id": "test_node_2"}}]}`),
+			parseResponse(t, `{"config": [
+				{"node": {"id": "test_node_1"}, "genericXdsConfigs": [
+					{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "versionInfo": "v2", "configStatus": "STALE"},
+					{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "versionInfo": "v1", "configStatus": "SYNCED"}]},
+				{"node": {"id": "test_node_3"}}]}`),
+		},
+	}
+	var err error
+	out := clientUtil.CaptureOutput(func() {
+		err = c.selfDiff(context.Background(), stream)
+	})
+	if err != client.ErrChangesDetected {
+		t.Errorf("want ErrChangesDetected, got %v", err)
+	}
+	want := `Changes detected in 1ms:
+~ test_node_1                                        CDS    SYNCED (v1) -> STALE (v2)
+- test_node_2                                        N/A
++ test_node_3                                        N/A
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
+
+// TestSelfDiffCanceled tests that canceling the context interrupts the wait between the two responses
+func TestSelfDiffCanceled(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform: "gcp",
+			SelfDiff: time.Hour,
+		},
+	}
+	stream := &fakeStream{
+		responses: []*csdspb_v3.ClientStatusResponse{{}, {}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.selfDiff(ctx, stream); err != context.Canceled {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+}
+
+// TestServerIdentity tests printing the control plane identity from the response headers
+func TestServerIdentity(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform: "gcp",
+		},
+	}
+	stream := &fakeStream{
+		responses: []*csdspb_v3.ClientStatusResponse{{}},
+		header: metadata.Pairs(
+			"server", "fake_server",
+			"x-control-plane-version", "fake_version",
+			"content-type", "application/grpc",
+		),
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if _, err := c.doRequest(context.Background(), stream); err != nil {
+			t.Errorf("Do request error: %v", err)
+		}
+	})
+	want := `Control plane: server=fake_server x-control-plane-version=fake_version
+No xDS clients connected.
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	// the json and yaml output formats carry the identity in their document instead
+	for _, format := range []string{"json", "yaml"} {
+		c.opts.OutputFormat = format
+		stream.responses = []*csdspb_v3.ClientStatusResponse{parseResponse(t, `{"config": [{"node": {"id": "node_1"}}]}`)}
+		out = clientUtil.CaptureOutput(func() {
+			if _, err := c.doRequest(context.Background(), stream); err != nil {
+				t.Errorf("Do request error: %v", err)
+			}
+		})
+		if format == "yaml" {
+			js, err := yaml.YAMLToJSON([]byte(out))
+			if err != nil {
+				t.Fatalf("unable to parse the yaml output: %v\n%v", err, out)
+			}
+			out = string(js)
+		}
+		var doc jsonDocument
+		if err := json.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatalf("%s: want only the document, got %v\n%v", format, err, out)
+		}
+		if want := map[string]string{"server": "fake_server", "x-control-plane-version": "fake_version"}; !reflect.DeepEqual(doc.Identity, want) {
+			t.Errorf("%s: want the identity %v, got %v", format, want, doc.Identity)
+		}
+	}
+
+	// the csv output format can't carry it, so it's still printed, to stderr
+	c.opts.OutputFormat = "csv"
+	out = clientUtil.CaptureOutput(func() {
+		if _, err := c.doRequest(context.Background(), stream); err != nil {
+			t.Errorf("Do request error: %v", err)
+		}
+	})
+	if !strings.HasPrefix(out, "Control plane: server=fake_server") {
+		t.Errorf("want the identity printed with the csv output format, got\n%v", out)
+	}
+}
+
+// TestRouteTable tests printing the flattened route table of the selected client
+func TestRouteTable(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:      "gcp",
+			FilterMode:    "suffix",
+			FilterPattern: "test_nodeid",
+			RouteTable:    true,
+		},
+	}
+	filename, _ := filepath.Abs("./response_for_route_table.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	var response csdspb_v3.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, &response, c.opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := `Route table of test_nodeid:
+Route Config                   Virtual Host                   Domains                        Match                                    Target
+fake_route                     fake_vhost                     example.com,*.example.com      prefix:/api header:x-canary=true         weighted:fake_cluster_canary:10,fake_cluster_stable:90
+fake_route                     fake_vhost                     example.com,*.example.com      prefix:/api                              fake_cluster_stable
+fake_route                     fake_vhost                     example.com,*.example.com      path:/old                                redirect:new.example.com/new
+fake_route                     fake_vhost                     example.com,*.example.com      prefix:/                                 fake_cluster_default
+fake_route                     fake_vhost_fallback            *                              regex:/health.*                          direct_response:200
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	// more than one client matched
+	c.opts.FilterMode = "prefix"
+	if err := printOutResponse(os.Stdout, &response, c.opts); err == nil {
+		t.Errorf("Print out route table should fail since two clients are matched")
+	}
+}
+
+func TestProbePath(t *testing.T) {
+	filename, _ := filepath.Abs("./response_for_route_table.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	var response csdspb_v3.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+
+	tests := []struct {
+		probePath    string
+		probeHeaders []string
+		want         string
+	}{
+		{
+			probePath:    "www.example.com/api/users",
+			probeHeaders: []string{"X-Canary=true"},
+			want: `Probe of www.example.com/api/users for test_nodeid:
+Route Config:   fake_route
+Virtual Host:   fake_vhost
+Route:          prefix:/api header:x-canary=true
+Target:         weighted:fake_cluster_canary:10,fake_cluster_stable:90
+`,
+		},
+		{
+			probePath: "example.com/api/users?limit=1",
+			want: `Probe of example.com/api/users for test_nodeid:
+Route Config:   fake_route
+Virtual Host:   fake_vhost
+Route:          prefix:/api
+Target:         fake_cluster_stable
+Rewrite:        path /api/users -> /v2/api/users
+`,
+		},
+		{
+			probePath: "other.com/healthz",
+			want: `Probe of other.com/healthz for test_nodeid:
+Route Config:   fake_route
+Virtual Host:   fake_vhost_fallback
+Route:          regex:/health.*
+Target:         direct_response:200
+`,
+		},
+		{
+			probePath: "other.com/api",
+			want: `Probe of other.com/api for test_nodeid:
+Route Config:   fake_route
+Virtual Host:   fake_vhost_fallback
+Route:          no route matches /api
+`,
+		},
+	}
+	for _, test := range tests {
+		opts := client.ClientOptions{
+			FilterMode:    "suffix",
+			FilterPattern: "test_nodeid",
+			ProbePath:     test.probePath,
+			ProbeMethod:   "GET",
+			ProbeHeaders:  test.probeHeaders,
+		}
+		out := clientUtil.CaptureOutput(func() {
+			if err := printOutResponse(os.Stdout, &response, opts); err != nil {
+				t.Errorf("Print out response error: %v", err)
+			}
+		})
+		if out != test.want {
+			t.Errorf("probe %s: want\n%vout\n%v", test.probePath, test.want, out)
+		}
+	}
+
+	if _, err := parseProbeRequest("/api", nil, "GET"); err == nil {
+		t.Errorf("Parse probe request should fail since the host is missing")
+	}
+	if _, err := parseProbeRequest("example.com/api", []string{"x-canary"}, "GET"); err == nil {
+		t.Errorf("Parse probe request should fail since the header has no value")
+	}
+}
+
+func TestSqliteExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds-sqlite")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename, _ := filepath.Abs("./response_with_version_skew.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	var response csdspb_v3.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+
+	exporter, err := newSqliteExporter(filepath.Join(dir, "captures.db"))
+	if err != nil {
+		t.Fatalf("Open sqlite_out failure: %v", err)
+	}
+	defer exporter.Close()
+	start := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if err := exporter.writeCapture(response.GetConfig(), start.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("Write capture failure: %v", err)
+		}
+	}
+
+	var captures, clients, configs int
+	if err := exporter.db.QueryRow("SELECT COUNT(*) FROM captures").Scan(&captures); err != nil {
+		t.Fatalf("Query captures failure: %v", err)
+	}
+	if err := exporter.db.QueryRow("SELECT COUNT(*) FROM clients WHERE metadata IS NOT NULL").Scan(&clients); err != nil {
+		t.Fatalf("Query clients failure: %v", err)
+	}
+	if err := exporter.db.QueryRow("SELECT COUNT(*) FROM configs WHERE name = 'fake_cluster_2' AND config_status = 'STALE'").Scan(&configs); err != nil {
+		t.Fatalf("Query configs failure: %v", err)
+	}
+	if captures != 2 || clients != 2 || configs != 2 {
+		t.Errorf("want 2 captures, clients and stale configs, got %d, %d and %d", captures, clients, configs)
+	}
+
+	rows, err := exporter.db.Query(`SELECT c.captured_at, s.xds, s.config_status FROM statuses s
+		JOIN captures c ON c.id = s.capture_id ORDER BY c.captured_at, s.xds`)
+	if err != nil {
+		t.Fatalf("Query statuses failure: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var capturedAt, xds, status string
+		if err := rows.Scan(&capturedAt, &xds, &status); err != nil {
+			t.Fatalf("Scan statuses failure: %v", err)
+		}
+		got = append(got, capturedAt+" "+xds+" "+status)
+	}
+	want := []string{
+		"2021-01-02T03:04:05.000Z CDS STALE",
+		"2021-01-02T03:04:05.000Z LDS SYNCED",
+		"2021-01-02T03:05:05.000Z CDS STALE",
+		"2021-01-02T03:05:05.000Z LDS SYNCED",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+// TestAssertConsistent tests finding the clients whose config versions diverge
+func TestAssertConsistent(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "versionInfo": "v2"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "versionInfo": "v1"}]},
+		{"node": {"id": "node_2"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "versionInfo": "v2"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "versionInfo": "v1"}]},
+		{"node": {"id": "node_3"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "versionInfo": "v1"},
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c2", "versionInfo": "v2"}]}]}`)
+
+	outliers := findVersionOutliers(response.GetConfig())
+	want := []versionOutlier{{id: "node_3", xds: "CDS", version: "v1,v2", expected: "v2"}}
+	if !reflect.DeepEqual(outliers, want) {
+		t.Errorf("want %v, got %v", want, outliers)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		printVersionOutliers(os.Stdout, response.GetConfig(), outliers)
+	})
+	wantOut := fmt.Sprintf("Config versions are inconsistent across 3 clients:\n%-50s %-6s %-30s %s\n%-50s %-6s %-30s %s\n",
+		"Client ID", "xDS", "Version", "Expected", "node_3", "CDS", "v1,v2", "v2")
+	if out != wantOut {
+		t.Errorf("want\n%vout\n%v", wantOut, out)
+	}
+
+	// LDS is only reported by two clients agreeing on it
+	response.GetConfig()[2].GenericXdsConfigs = response.GetConfig()[2].GenericXdsConfigs[1:]
+	if outliers := findVersionOutliers(response.GetConfig()); len(outliers) != 0 {
+		t.Errorf("want no outliers, got %v", outliers)
+	}
+}
+
+// TestShowVersion tests printing the version_info of each resource with -show_version
+func TestShowVersion(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED", "versionInfo": "v2"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "SYNCED"}]}]}`)
+	opts := client.ClientOptions{Platform: "gcp", ShowVersion: true}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := `Client ID xDS stream type Config Status Client Status Version Last Updated
+node_1                    CDS   SYNCED  -             v2      -
+                          LDS   SYNCED  -             -       -
+Clients: 1  SYNCED: 2
+CDS:1 LDS:1
+`
+	if !strings.HasPrefix(out, want) {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	opts.OutputFormat = "json"
+	out = clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	var doc jsonDocument
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("unable to parse the json output: %v\n%v", err, out)
+	}
+	statuses := doc.Clients
+	if got := []string{statuses[0].Configs[0].Version, statuses[0].Configs[1].Version}; !reflect.DeepEqual(got, []string{"v2", ""}) {
+		t.Errorf("want the versions [v2 ], got %v", got)
+	}
+	// once in the clients section and once in the resources section
+	if strings.Count(out, `"version"`) != 2 {
+		t.Errorf("want the version of the resource without version_info left out, got\n%v", out)
+	}
+
+	opts.OutputFormat = "csv"
+	out = clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	wantCsv := `client_id,xds_stream_type,xds,config_status,client_status,type_url,version
+node_1,,CDS,SYNCED,,type.googleapis.com/envoy.config.cluster.v3.Cluster,v2
+node_1,,LDS,SYNCED,,type.googleapis.com/envoy.config.listener.v3.Listener,
+`
+	if out != wantCsv {
+		t.Errorf("want\n%vout\n%v", wantCsv, out)
+	}
+
+	for _, opts := range []client.ClientOptions{
+		{Platform: "gcp", ShowVersion: true, OutputFormat: "compact"},
+		{Platform: "gcp", ShowVersion: true, Columns: "id,xds"},
+	} {
+		if _, err := New(opts); err == nil || !strings.Contains(err.Error(), "show_version cannot be used") {
+			t.Errorf("want show_version to be rejected with %+v, got %v", opts, err)
+		}
+	}
+}
+
+// TestShowLocality tests printing the locality of each client with -show_locality, with dashes for its unset parts
+func TestShowLocality(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1", "locality": {"region": "us-central1", "zone": "us-central1-a", "subZone": "rack1"}}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "SYNCED"}]},
+		{"node": {"id": "node_2", "locality": {"zone": "europe-west1-b"}}},
+		{"node": {"id": "node_3"}}]}`)
+	opts := client.ClientOptions{Platform: "gcp", ShowLocality: true, Sort: "none"}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := `Client ID Locality                        xDS stream type Config Status Client Status Last Updated
+node_1    us-central1/us-central1-a/rack1                 CDS   SYNCED  -             -
+                                                          LDS   SYNCED  -             -
+node_2    -/europe-west1-b/-                              N/A           
+node_3    -/-/-                                           N/A           
+Clients: 3  SYNCED: 2
+`
+	if !strings.HasPrefix(out, want) {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	opts.OutputFormat = "json"
+	out = clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	var doc jsonDocument
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("unable to parse the json output: %v\n%v", err, out)
+	}
+	wantLocalities := []nodeLocality{{Region: "us-central1", Zone: "us-central1-a", SubZone: "rack1"}, {Zone: "europe-west1-b"}, {}}
+	for i, status := range doc.Clients {
+		if status.Locality == nil || *status.Locality != wantLocalities[i] {
+			t.Errorf("%s: want the locality %+v, got %+v", status.ClientId, wantLocalities[i], status.Locality)
+		}
+	}
+
+	opts.OutputFormat = "csv"
+	out = clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want = `client_id,xds_stream_type,xds,config_status,client_status,type_url,region,zone,sub_zone
+node_1,,CDS,SYNCED,,type.googleapis.com/envoy.config.cluster.v3.Cluster,us-central1,us-central1-a,rack1
+node_1,,LDS,SYNCED,,type.googleapis.com/envoy.config.listener.v3.Listener,us-central1,us-central1-a,rack1
+node_2,,,,,,,europe-west1-b,
+node_3,,,,,,,,
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	// without -show_locality, the structured formats leave the locality out
+	opts.ShowLocality = false
+	opts.OutputFormat = "json"
+	out = clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	if strings.Contains(out, `"locality"`) {
+		t.Errorf("want no locality, got\n%v", out)
+	}
+
+	// the locality column of -columns is filled the same way
+	out = clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, client.ClientOptions{Platform: "gcp", Columns: "id,locality", Sort: "none"}); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	for _, want := range []string{"node_1    us-central1/us-central1-a/rack1\n", "node_2    -/europe-west1-b/-\n", "node_3    -/-/-\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q in\n%v", want, out)
+		}
+	}
+
+	for _, test := range []struct {
+		opts client.ClientOptions
+		want string
+	}{
+		{opts: client.ClientOptions{Platform: "gcp", ShowLocality: true, OutputFormat: "compact"}, want: "show_locality cannot be used with the compact output format"},
+		{opts: client.ClientOptions{Platform: "gcp", ShowLocality: true, Columns: "id,locality"}, want: "show_locality cannot be used with columns, add the locality column instead"},
+	} {
+		if _, err := New(test.opts); err == nil || err.Error() != test.want {
+			t.Errorf("want error %q, got %v", test.want, err)
+		}
+	}
+}
+
+// TestTemplate tests printing the matched clients with -template
+func TestTemplate(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "SYNCED", "lastUpdated": "2021-01-02T03:04:05Z"},
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "STALE", "versionInfo": "v2"}]},
+		{"node": {"id": "node_2"}}]}`)
+	text := `{{range .Clients}}{{.ClientId}}:{{range .Configs}} {{.Xds}}/{{.Name}}={{status .Status}}@{{time .LastUpdated}}{{with .Version}}#{{.}}{{end}}{{end}}
+{{end}}{{len .Clients}} clients`
+	opts := client.ClientOptions{Platform: "gcp", Template: text, Color: "always", UTC: true}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := "node_1: LDS/l1=" + colorGreen + "SYNCED" + colorReset + "@2021-01-02T03:04:05Z CDS/c1=" + colorYellow + "STALE" + colorReset + "@-#v2\nnode_2:\n2 clients"
+	if out != want {
+		t.Errorf("want %q, got %q", want, out)
+	}
+
+	// the template is read from the file after @, and is executed without any client too
+	dir, err := ioutil.TempDir("", "csds-template")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.tmpl")
+	if err := ioutil.WriteFile(path, []byte(`{{len .Clients}} clients at {{.PollTime | formatTime "2006"}}`), 0600); err != nil {
+		t.Fatalf("Write template file failure: %v", err)
+	}
+	out = clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, &csdspb_v3.ClientStatusResponse{}, client.ClientOptions{Platform: "gcp", Template: "@" + path}); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	if want := fmt.Sprintf("0 clients at %d", time.Now().Year()); out != want {
+		t.Errorf("want %q, got %q", want, out)
+	}
+
+	// the errors name the line of the template they occurred at, and nothing is printed
+	for _, test := range []struct {
+		text string
+		want string
+	}{
+		{text: "{{range .Clients}}\n{{.ClientId}\n{{end}}", want: "\n   2 | {{.ClientId}"},
+		{text: "{{range .Clients}}\n{{.ClientId}}\n{{.Missing}}{{end}}", want: "\n   3 | {{.Missing}}{{end}}"},
+		{text: "{{nofunc .Clients}}", want: `function "nofunc" not defined` + "\n   1 | {{nofunc .Clients}}"},
+	} {
+		var err error
+		out := clientUtil.CaptureOutput(func() {
+			err = printOutResponse(os.Stdout, response, client.ClientOptions{Platform: "gcp", Template: test.text})
+		})
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("template %q: want error %q, got %v", test.text, test.want, err)
+		}
+		if out != "" {
+			t.Errorf("template %q: want nothing printed, got %q", test.text, out)
+		}
+	}
+
+	for _, opts := range []client.ClientOptions{
+		{Platform: "gcp", Template: "{{.Clients}", RequestYaml: "{}"},
+		{Platform: "gcp", Template: "{{.Clients}}", OutputFormat: "json"},
+		{Platform: "gcp", Template: "{{.Clients}}", SummaryOnly: true},
+		{Platform: "gcp", Template: "@" + filepath.Join(dir, "missing.tmpl")},
+	} {
+		if _, err := New(opts); err == nil || !strings.Contains(err.Error(), "template") {
+			t.Errorf("want template %q to be rejected with %+v, got %v", opts.Template, opts, err)
+		}
+	}
+}
+
+// TestVersionSkew tests reporting the distinct versions of each xDS type across the clients
+func TestVersionSkew(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "versionInfo": "v2"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "versionInfo": "v1"}]},
+		{"node": {"id": "node_2"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "versionInfo": "v2"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "versionInfo": "v1"}]},
+		{"node": {"id": "node_3"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "versionInfo": "v1"},
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c2", "versionInfo": "v2"}]}]}`)
+
+	want := map[string][]versionCount{
+		"CDS": {{version: "v2", clients: 3}, {version: "v1", clients: 1}},
+		"LDS": {{version: "v1", clients: 2}},
+	}
+	if counts := parseVersionCounts(response.GetConfig()); !reflect.DeepEqual(counts, want) {
+		t.Errorf("want %v, got %v", want, counts)
+	}
+
+	opts := client.ClientOptions{Platform: "gcp", VersionSkew: true}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	wantOut := `Config versions across 3 clients:
+xDS    Versions Skew Clients per version
+CDS    2        yes  v2 (3), v1 (1)
+LDS    1        no   v1 (2)
+WARNING: version skew in 1 of 2 xDS types: CDS
+`
+	if out != wantOut {
+		t.Errorf("want\n%vout\n%v", wantOut, out)
+	}
+
+	response.GetConfig()[2].GenericXdsConfigs = response.GetConfig()[2].GenericXdsConfigs[1:]
+	out = clientUtil.CaptureOutput(func() {
+		printVersionSkew(os.Stdout, response.GetConfig())
+	})
+	if !strings.HasSuffix(out, "CDS    1        no   v2 (3)\nLDS    1        no   v1 (2)\nNo version skew.\n") {
+		t.Errorf("want no version skew, got\n%v", out)
+	}
+
+	for _, opts := range []client.ClientOptions{
+		{Platform: "gcp", VersionSkew: true, OutputFormat: "json"},
+		{Platform: "gcp", VersionSkew: true, SummaryOnly: true},
+	} {
+		if _, err := New(opts); err == nil || !strings.Contains(err.Error(), "version_skew cannot be used") {
+			t.Errorf("want version_skew to be rejected with %+v, got %v", opts, err)
+		}
+	}
+}
+
+// TestTransform tests replacing the response by the output of -transform
+func TestTransform(t *testing.T) {
+	c := ClientV3{
+		node: &envoy_config_core_v3.Node{},
+		opts: client.ClientOptions{
+			Platform:  "gcp",
+			Transform: "sed 's/test_node_1/redacted/'",
+		},
+	}
+	stream := &fakeStream{
+		responses: []*csdspb_v3.ClientStatusResponse{
+			{Config: []*csdspb_v3.ClientConfig{newClientConfig("test_node_1"), newClientConfig("test_node_2")}},
+		},
+	}
+	resp, err := c.fetch(context.Background(), stream)
+	if err != nil {
+		t.Fatalf("Fetch error: %v", err)
+	}
+	var ids []string
+	for _, config := range resp.GetConfig() {
+		ids = append(ids, config.GetNode().GetId())
+	}
+	if want := []string{"redacted", "test_node_2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("want %v, got %v", want, ids)
+	}
+
+	for _, command := range []string{`echo '{"clients": []}'`, "true", "exit 1"} {
+		c.opts.Transform = command
+		stream := &fakeStream{responses: []*csdspb_v3.ClientStatusResponse{{}}}
+		if _, err := c.fetch(context.Background(), stream); err == nil {
+			t.Errorf("Fetch should fail since transform %q doesn't return a valid response", command)
+		}
+	}
+}
+
+// TestRequestMode tests building the request according to -request_mode
+func TestRequestMode(t *testing.T) {
+	tests := []struct {
+		mode         string
+		wantMatchers bool
+		wantNode     bool
+	}{
+		{mode: "", wantMatchers: true, wantNode: true},
+		{mode: "both", wantMatchers: true, wantNode: true},
+		{mode: "matchers_only", wantMatchers: true},
+		{mode: "node_only", wantNode: true},
+	}
+	for _, test := range tests {
+		c := ClientV3{
+			opts: client.ClientOptions{
+				Platform:    "gcp",
+				RequestFile: "./test_request.yaml",
+				RequestMode: test.mode,
+			},
+		}
+		if err := c.parseNodeMatcher(); err != nil {
+			t.Fatalf("Parse NodeMatcher Error: %v", err)
+		}
+		req := c.BuildRequest()
+		if got := len(req.GetNodeMatchers()) != 0; got != test.wantMatchers {
+			t.Errorf("request mode %q: want node matchers %v, got %v", test.mode, test.wantMatchers, got)
+		}
+		if got := req.GetNode().GetId() == "fake_client_node_id"; got != test.wantNode {
+			t.Errorf("request mode %q: want node %v, got %v", test.mode, test.wantNode, got)
+		}
+	}
+
+	// node_only only requires the node id
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:    "gcp",
+			RequestYaml: "{\"node\": {\"id\": \"fake_client_node_id\"}}",
+			RequestMode: "node_only",
+		},
+	}
+	if err := c.parseNodeMatcher(); err != nil {
+		t.Errorf("Parse NodeMatcher Error: %v", err)
+	}
+	c.opts.RequestYaml = "{\"node_matchers\": []}"
+	c.nodeMatcher = nil
+	if err := c.parseNodeMatcher(); err == nil {
+		t.Errorf("Parse NodeMatcher should fail since request_mode node_only requires a node id")
+	}
+	c.opts.RequestMode = "matchers_only"
+	if err := c.parseNodeMatcher(); err == nil {
+		t.Errorf("Parse NodeMatcher should fail since request_mode matchers_only requires node matchers")
+	}
+}
+
+// TestNodeIdOverride tests that -node_id overrides the id of the node of the request yaml in the request sent
+func TestNodeIdOverride(t *testing.T) {
+	for _, test := range []struct {
+		requestFile string
+		mode        string
+		wantLog     bool
+	}{
+		{requestFile: "./test_request.yaml", wantLog: true},
+		{requestFile: "./test_request.yaml", mode: "node_only", wantLog: true},
+		// the node id is enough of a request with node_only
+		{mode: "node_only"},
+	} {
+		var c *ClientV3
+		var err error
+		// the override is logged with -verbose when the request yaml has another id
+		out := clientUtil.CaptureOutput(func() {
+			c, err = New(client.ClientOptions{Platform: "gcp", FilterMode: "prefix", RequestFile: test.requestFile, RequestMode: test.mode, NodeId: "cli_node_id", Verbose: true})
+		})
+		if err != nil {
+			t.Fatalf("request_file %q, request mode %q: New client error: %v", test.requestFile, test.mode, err)
+		}
+		stream := &fakeStream{responses: []*csdspb_v3.ClientStatusResponse{{}}}
+		clientUtil.CaptureOutput(func() {
+			if _, err := c.doRequest(context.Background(), stream); err != nil {
+				t.Errorf("Do request error: %v", err)
+			}
+		})
+		if got := stream.requests[0].GetNode().GetId(); got != "cli_node_id" {
+			t.Errorf("request_file %q, request mode %q: want the node id of node_id, got %q", test.requestFile, test.mode, got)
+		}
+		if got := strings.Contains(out, "Node id fake_client_node_id of the request yaml is overridden by node_id cli_node_id"); got != test.wantLog {
+			t.Errorf("request_file %q, request mode %q: want the override logged %v, got %q", test.requestFile, test.mode, test.wantLog, out)
+		}
+	}
+
+	for _, opts := range []client.ClientOptions{
+		{Platform: "gcp", RequestFile: "./test_request.yaml", NodeId: " "},
+		{Platform: "gcp", RequestFile: "./test_request.yaml", NodeId: "cli_node_id", RequestMode: "matchers_only"},
+	} {
+		if _, err := New(opts); err == nil || !strings.Contains(err.Error(), "node_id") {
+			t.Errorf("want node_id %q to be rejected with %+v, got %v", opts.NodeId, opts, err)
+		}
+	}
+	// the node matchers are still required by the default request mode
+	if _, err := New(client.ClientOptions{Platform: "gcp", NodeId: "cli_node_id"}); err == nil || !strings.Contains(err.Error(), "missing request yaml") {
+		t.Errorf("want the request yaml to be required without node_only, got %v", err)
+	}
+}
+
+// TestRequestNodeFields tests that the node fields of the request yaml besides the id are sent
+func TestRequestNodeFields(t *testing.T) {
+	requestYaml := `{"node": {"id": "fake_client_node_id", "cluster": "fake_cluster", "metadata": {"TEAM": "fake_team"}, "locality": {"zone": "fake_zone"}}}`
+	for _, mode := range []string{"both", "node_only"} {
+		c, err := New(client.ClientOptions{Platform: "gcp", FilterMode: "prefix", RequestFile: "./test_request.yaml", RequestYaml: requestYaml, RequestMode: mode})
+		if err != nil {
+			t.Fatalf("New client error: %v", err)
+		}
+		stream := &fakeStream{responses: []*csdspb_v3.ClientStatusResponse{{}}}
+		clientUtil.CaptureOutput(func() {
+			if _, err := c.doRequest(context.Background(), stream); err != nil {
+				t.Errorf("Do request error: %v", err)
+			}
+		})
+		if len(stream.requests) != 1 {
+			t.Fatalf("request mode %q: want 1 request sent, got %v", mode, len(stream.requests))
+		}
+		node := stream.requests[0].GetNode()
+		if node.GetId() != "fake_client_node_id" || node.GetCluster() != "fake_cluster" ||
+			node.GetMetadata().GetFields()["TEAM"].GetStringValue() != "fake_team" || node.GetLocality().GetZone() != "fake_zone" {
+			t.Errorf("request mode %q: want the node of the request yaml, got %v", mode, node)
+		}
+		// the request holds a copy, so that changing it leaves the parsed node alone
+		if node == c.node {
+			t.Errorf("request mode %q: want a copy of the node", mode)
+		}
+	}
+}
+
+// TestBuildRequest tests that the request returned by BuildRequest is the one sent, with the changes of the caller
+func TestBuildRequest(t *testing.T) {
+	c, err := New(client.ClientOptions{Platform: "gcp", FilterMode: "prefix", RequestFile: "./test_request.yaml"})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	req := c.BuildRequest()
+	if req.GetNode().GetId() != "fake_client_node_id" || len(req.GetNodeMatchers()) != 1 {
+		t.Fatalf("want the node id and the node matcher of the request yaml, got %v", req)
+	}
+	if c.BuildRequest() != req {
+		t.Errorf("want the same request on each call")
+	}
+	req.Node.Cluster = "fake_cluster"
+
+	stream := &fakeStream{responses: []*csdspb_v3.ClientStatusResponse{{}}}
+	clientUtil.CaptureOutput(func() {
+		if _, err := c.doRequest(context.Background(), stream); err != nil {
+			t.Errorf("Do request error: %v", err)
+		}
+	})
+	if len(stream.requests) != 1 || stream.requests[0].GetNode().GetCluster() != "fake_cluster" {
+		t.Errorf("want the modified request to be sent, got %v", stream.requests)
+	}
+}
+
+// TestMatrixOutputFormat tests printing the per-type status of each client as a matrix
+func TestMatrixOutputFormat(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.route.v3.RouteConfiguration", "name": "r1", "configStatus": "STALE"},
+			{"typeUrl": "type.googleapis.com/envoy.config.route.v3.RouteConfiguration", "name": "r2", "configStatus": "ERROR"}]},
+		{"node": {"id": "node_with_a_very_long_id_that_does_not_fit_in_the_column"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "NOT_SENT"}]}]}`)
+	out := clientUtil.CaptureOutput(func() {
+		printMatrix(os.Stdout, response.GetConfig(), false)
+	})
+	want := `Client ID                                          CDS        RDS
+node_1                                             SYNCED     ERROR
+node_with_a_very_long_id_that_does_not_fit_in_t... NOT_SENT   -
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	// the types out of the compact output format get their column too, and the unknown ones share OTHER
+	response = parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.core.v3.TypedExtensionConfig", "name": "e1", "configStatus": "STALE"}]},
+		{"node": {"id": "node_2"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.core.v3.TypedExtensionConfig", "name": "e1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/fake.Unknown", "name": "u1", "configStatus": "NOT_SENT"},
+			{"typeUrl": "type.googleapis.com/fake.Unknown", "name": "u2", "configStatus": "ERROR"}]}]}`)
+	out = clientUtil.CaptureOutput(func() {
+		printMatrix(os.Stdout, response.GetConfig(), false)
+	})
+	want = `Client ID CDS        ECDS       OTHER
+node_1    SYNCED     STALE      -
+node_2    -          SYNCED     ERROR
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	// the structured form is keyed by client and type, without the types a client has no resource of
+	wantCells := map[string]map[string]string{
+		"node_1": {"CDS": "SYNCED", "ECDS": "STALE"},
+		"node_2": {"ECDS": "SYNCED", "OTHER": "ERROR"},
+	}
+	if cells := parseMatrixCells(response.GetConfig()); !reflect.DeepEqual(cells, wantCells) {
+		t.Errorf("want the cells %v, got %v", wantCells, cells)
+	}
+}
+
+// TestJsonOutputFormat tests printing the summary, the client status and the resources as a single JSON document
+func TestJsonOutputFormat(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1", "metadata": {"XDS_STREAM_TYPE": "ADS"}}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED", "versionInfo": "v1",
+				"xdsConfig": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1"}},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE"}]},
+		{"node": {"id": "node_2"}}]}`)
+	opts := client.ClientOptions{Platform: "gcp", OutputFormat: "json"}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := `{
+  "summary": {
+    "clients": 2,
+    "statuses": {
+      "STALE": 1,
+      "SYNCED": 1
+    },
+    "types": {
+      "CDS": 1,
+      "LDS": 1
+    },
+    "has_errors": false
+  },
+  "counts": {
+    "response": 2
+  },
+  "clients": [
+    {
+      "client_id": "node_1",
+      "xds_stream_type": "ADS",
+      "configs": [
+        {
+          "xds": "CDS",
+          "status": "SYNCED",
+          "type_url": "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+        },
+        {
+          "xds": "LDS",
+          "status": "STALE",
+          "type_url": "type.googleapis.com/envoy.config.listener.v3.Listener"
+        }
+      ]
+    },
+    {
+      "client_id": "node_2",
+      "xds_stream_type": "",
+      "configs": []
+    }
+  ],
+  "matrix": {
+    "node_1": {
+      "CDS": "SYNCED",
+      "LDS": "STALE"
+    },
+    "node_2": {}
+  },
+  "resources": [
+    {
+      "client_id": "node_1",
+      "resources": [
+        {
+          "name": "c1",
+          "xds": "CDS",
+          "status": "SYNCED",
+          "type_url": "type.googleapis.com/envoy.config.cluster.v3.Cluster",
+          "version": "v1",
+          "config": {
+            "@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster",
+            "name": "c1"
+          }
+        },
+        {
+          "name": "l1",
+          "xds": "LDS",
+          "status": "STALE",
+          "type_url": "type.googleapis.com/envoy.config.listener.v3.Listener"
+        }
+      ]
+    }
+  ]
+}
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	// the filters apply to all the sections alike, -resource_name only to the resources like the detailed config
+	opts.FilterPattern = "node_1"
+	opts.FilterMode = "exact"
+	opts.ResourceName = "l1"
+	opts.ResourceNameMode = "exact"
+	out = clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	var doc jsonDocument
+	if err := json.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("unable to parse the json output: %v\n%v", err, out)
+	}
+	if doc.Summary.Clients != 1 || len(doc.Clients) != 1 || doc.Clients[0].ClientId != "node_1" {
+		t.Errorf("want node_1 alone in the summary and the clients, got\n%v", out)
+	}
+	if want := map[string]int{"response": 2, "node_id": 1}; !reflect.DeepEqual(doc.Counts, want) {
+		t.Errorf("want the counts %v of the filter stages, got %v", want, doc.Counts)
+	}
+	if len(doc.Resources) != 1 || doc.Resources[0].ClientId != "node_1" || len(doc.Resources[0].Resources) != 1 || doc.Resources[0].Resources[0].Name != "l1" {
+		t.Errorf("want the resource l1 of node_1 alone, got\n%v", out)
+	}
+
+	opts = client.ClientOptions{Platform: "gcp", OutputFormat: "json"}
+	out = clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, &csdspb_v3.ClientStatusResponse{}, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want = `{
+  "summary": {
+    "clients": 0,
+    "statuses": {},
+    "types": {},
+    "has_errors": false
+  },
+  "counts": {
+    "response": 0
+  },
+  "clients": [],
+  "matrix": {},
+  "resources": []
+}
+`
+	if out != want {
+		t.Errorf("want an empty document, got %v", out)
+	}
+}
+
+// TestJsonlOutputFormat tests printing one JSON object per line and client, with the time of the poll,
+// and appending a batch of lines per monitor cycle
+func TestJsonlOutputFormat(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1", "metadata": {"XDS_STREAM_TYPE": "ADS"}}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"}]},
+		{"node": {"id": "node_2"}}]}`)
+	var out bytes.Buffer
+	if err := printJsonl(&out, response.GetConfig(), nil, time.Date(2021, 1, 2, 3, 4, 5, 600000000, time.UTC), false, false); err != nil {
+		t.Fatalf("Print jsonl error: %v", err)
+	}
+	want := `{"poll_time":"2021-01-02T03:04:05.6Z","client_id":"node_1","xds_stream_type":"ADS","configs":[{"xds":"CDS","status":"SYNCED","type_url":"type.googleapis.com/envoy.config.cluster.v3.Cluster"}]}
+{"poll_time":"2021-01-02T03:04:05.6Z","client_id":"node_2","xds_stream_type":"","configs":[]}
+`
+	if out.String() != want {
+		t.Errorf("want\n%vout\n%v", want, out.String())
+	}
+
+	out.Reset()
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:        "gcp",
+			OutputFormat:    "jsonl",
+			ConfigFile:      "output.jsonl",
+			MonitorInterval: time.Second,
+		},
+		out: &out,
+	}
+	stream := &fakeStream{
+		responses: []*csdspb_v3.ClientStatusResponse{response, {}, response},
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.doRequest(context.Background(), stream); err != nil {
+			t.Errorf("Do request error: %v", err)
+		}
+	}
+	// the empty response adds no line, and the cycles aren't separated
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("want 4 lines, got\n%v", out.String())
+	}
+	var pollTimes []string
+	for _, line := range lines {
+		var status clientStatus
+		if err := json.Unmarshal([]byte(line), &status); err != nil {
+			t.Fatalf("want a JSON object per line, got %q: %v", line, err)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, status.PollTime); err != nil {
+			t.Errorf("want the poll time of %q, got %v", line, err)
+		}
+		pollTimes = append(pollTimes, status.PollTime)
+	}
+	if pollTimes[0] != pollTimes[1] || pollTimes[2] != pollTimes[3] {
+		t.Errorf("want the clients of a cycle to share their poll time, got %v", pollTimes)
+	}
+}
+
+// TestYamlOutputFormat tests printing the same document as the json output format as YAML
+func TestYamlOutputFormat(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "ERROR",
+			 "errorState": {"details": "line1\nline2\n"}}]}]}`)
+	opts := client.ClientOptions{Platform: "gcp", OutputFormat: "yaml"}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := `clients:
+- client_id: node_1
+  configs:
+  - status: ERROR
+    type_url: type.googleapis.com/envoy.config.cluster.v3.Cluster
+    xds: CDS
+  xds_stream_type: ""
+counts:
+  response: 1
+matrix:
+  node_1:
+    CDS: ERROR
+resources:
+- client_id: node_1
+  resources:
+  - name: c1
+    status: ERROR
+    type_url: type.googleapis.com/envoy.config.cluster.v3.Cluster
+    xds: CDS
+summary:
+  clients: 1
+  has_errors: true
+  statuses:
+    ERROR: 1
+  types:
+    CDS: 1
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	// the yaml and json output formats share the same document
+	opts.OutputFormat = "json"
+	jsonOut := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	yamlOut, err := yaml.YAMLToJSON([]byte(out))
+	if err != nil {
+		t.Fatalf("unable to parse the yaml output: %v", err)
+	}
+	var yamlDoc, jsonDoc interface{}
+	if err := json.Unmarshal(yamlOut, &yamlDoc); err != nil {
+		t.Fatalf("unable to parse the yaml output: %v", err)
+	}
+	if err := json.Unmarshal([]byte(jsonOut), &jsonDoc); err != nil {
+		t.Fatalf("unable to parse the json output: %v", err)
+	}
+	if !reflect.DeepEqual(yamlDoc, jsonDoc) {
+		t.Errorf("want the json document\n%vgot\n%v", jsonOut, out)
+	}
+
+	// the enabled filter stages are counted even without any client
+	opts.OutputFormat = "yaml"
+	opts.FilterMode = "exact"
+	opts.FilterPattern = "node_1"
+	out = clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, &csdspb_v3.ClientStatusResponse{}, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want = `clients: []
+counts:
+  node_id: 0
+  response: 0
+matrix: {}
+resources: []
+summary:
+  clients: 0
+  has_errors: false
+  statuses: {}
+  types: {}
+`
+	if out != want {
+		t.Errorf("want an empty document, got\n%v", out)
+	}
+}
+
+// TestOutputFileInMonitorMode tests appending the responses of each monitor cycle to -output_file
+func TestOutputFileInMonitorMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds-output")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := client.ClientOptions{ConfigFile: filepath.Join(dir, "output.txt"), MonitorInterval: time.Second}
+	if err := ioutil.WriteFile(opts.ConfigFile, []byte("previous run\n"), 0644); err != nil {
+		t.Fatalf("Write output file failure: %v", err)
+	}
+	w, closeOut, err := clientUtil.OpenOutput(opts)
+	if err != nil {
+		t.Fatalf("Open output file failure: %v", err)
+	}
+	start := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		clientUtil.PrintCycleSeparator(w, start.Add(time.Duration(i)*time.Minute))
+		if err := printOutResponse(w, &csdspb_v3.ClientStatusResponse{}, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	}
+	if err := closeOut(); err != nil {
+		t.Errorf("Close output file failure: %v", err)
+	}
+
+	output, err := ioutil.ReadFile(opts.ConfigFile)
+	if err != nil {
+		t.Fatalf("Read output file failure: %v", err)
+	}
+	want := `previous run
+=== 2021-01-02T03:04:05Z ===
+No xDS clients connected.
+=== 2021-01-02T03:05:05Z ===
+No xDS clients connected.
+`
+	if string(output) != want {
+		t.Errorf("want\n%vout\n%v", want, string(output))
+	}
+}
+
+// TestCsvOutputFormat tests printing one csv row per xDS resource of the filtered clients
+func TestCsvOutputFormat(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1,zone_a", "metadata": {"XDS_STREAM_TYPE": "ADS"}}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE", "clientStatus": "ACKED"}]},
+		{"node": {"id": "node_2"}},
+		{"node": {"id": "other_node"}}]}`)
+	opts := client.ClientOptions{Platform: "gcp", OutputFormat: "csv", FilterMode: "prefix", FilterPattern: "node_"}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := `client_id,xds_stream_type,xds,config_status,client_status,type_url
+"node_1,zone_a",ADS,CDS,SYNCED,,type.googleapis.com/envoy.config.cluster.v3.Cluster
+"node_1,zone_a",ADS,LDS,STALE,ACKED,type.googleapis.com/envoy.config.listener.v3.Listener
+node_2,,,,,
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
+
+// TestPrototextOutputFormat tests printing the filtered clients in the protobuf text format to -output_file
+func TestPrototextOutputFormat(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1", "metadata": {"b": "2", "a": "1"}}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED",
+				"xdsConfig": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1"}},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE"}]},
+		{"node": {"id": "other_node"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"}]}]}`)
+	dir, err := ioutil.TempDir("", "csds-prototext")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := client.ClientOptions{
+		Platform:      "gcp",
+		OutputFormat:  "prototext",
+		FilterMode:    "prefix",
+		FilterPattern: "node_",
+		XdsType:       "CDS",
+		ConfigFile:    filepath.Join(dir, "output.txtpb"),
+	}
+	w, closeOut, err := clientUtil.OpenOutput(opts)
+	if err != nil {
+		t.Fatalf("Open output file failure: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(w, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	if err := closeOut(); err != nil {
+		t.Errorf("Close output file failure: %v", err)
+	}
+	if out != "" {
+		t.Errorf("want nothing printed to stdout, got\n%v", out)
+	}
+	output, err := ioutil.ReadFile(opts.ConfigFile)
+	if err != nil {
+		t.Fatalf("Read output file failure: %v", err)
+	}
+	want := `config: {
+  node: {
+    id: "node_1"
+    metadata: {
+      fields: {
+        key: "a"
+        value: {
+          string_value: "1"
+        }
+      }
+      fields: {
+        key: "b"
+        value: {
+          string_value: "2"
+        }
+      }
+    }
+  }
+  generic_xds_configs: {
+    type_url: "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+    name: "c1"
+    xds_config: {
+      [type.googleapis.com/envoy.config.cluster.v3.Cluster]: {
+        name: "c1"
+      }
+    }
+    config_status: SYNCED
+  }
+}
+`
+	if string(output) != want {
+		t.Errorf("want\n%vout\n%v", want, string(output))
+	}
+	var parsed csdspb_v3.ClientStatusResponse
+	if err := prototext.Unmarshal(output, &parsed); err != nil {
+		t.Errorf("want the output to be parsed back, got %v", err)
+	}
+
+	out = clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, &csdspb_v3.ClientStatusResponse{}, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	if out != "" {
+		t.Errorf("want nothing printed without any client, got\n%v", out)
+	}
+	if _, err := New(client.ClientOptions{Platform: "gcp", OutputFormat: "prototext", Uri: "a:443,b:443"}); err == nil || !strings.Contains(err.Error(), "prototext") {
+		t.Errorf("want the prototext output format to be rejected with several uris, got %v", err)
+	}
+}
+
+// TestColor tests colorizing the config statuses without breaking the column widths
+func TestColor(t *testing.T) {
+	os.Unsetenv("NO_COLOR")
+	if !useColor("always", ioutil.Discard) || useColor("never", os.Stdout) || useColor("auto", ioutil.Discard) {
+		t.Errorf("Color modes are not honored")
+	}
+	os.Setenv("NO_COLOR", "1")
+	if useColor("always", ioutil.Discard) {
+		t.Errorf("NO_COLOR should override the always color mode")
+	}
+	os.Unsetenv("NO_COLOR")
+
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE"},
+			{"typeUrl": "type.googleapis.com/envoy.config.route.v3.RouteConfiguration", "name": "r1", "configStatus": "SYNCED", "clientStatus": "NACKED"}]}]}`)
+	out := clientUtil.CaptureOutput(func() {
+		printTable(os.Stdout, response.GetConfig(), true, false, false, nil, fixedTableWidths)
+	})
+	want := fmt.Sprintf(`Client ID                                          xDS stream type                Config Status                  Client Status   Last Updated
+node_1                                                                            CDS   %sSYNCED%s                   -               -
+                                                                                  LDS   %sSTALE%s                    -               -
+                                                                                  RDS   %sSYNCED%s                   NACKED          -
+`, colorGreen, colorReset, colorYellow, colorReset, colorRed, colorReset)
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
+
+// TestFailOn tests returning a StatusError when a client reports a config status of -fail_on
+func TestFailOn(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE"}]},
+		{"node": {"id": "other_node"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "ERROR"}]}]}`)
+
+	tests := []struct {
+		failOn        string
+		filterPattern string
+		want          []string
+	}{
+		{failOn: "error, stale", want: []string{"ERROR", "STALE"}},
+		{failOn: "ERROR,STALE", filterPattern: "node_", want: []string{"STALE"}},
+		{failOn: "NOT_SENT"},
+	}
+	for _, test := range tests {
+		c := ClientV3{
+			opts: client.ClientOptions{
+				Platform:      "gcp",
+				OutputFormat:  "compact",
+				FailOn:        test.failOn,
+				FilterMode:    "prefix",
+				FilterPattern: test.filterPattern,
+			},
+		}
+		stream := &fakeStream{responses: []*csdspb_v3.ClientStatusResponse{response}}
+		var err error
+		clientUtil.CaptureOutput(func() {
+			_, err = c.doRequest(context.Background(), stream)
+		})
+		var statusErr *client.StatusError
+		if !errors.As(err, &statusErr) {
+			if test.want != nil || err != nil {
+				t.Errorf("fail_on %s: want statuses %v, got error %v", test.failOn, test.want, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(statusErr.Statuses, test.want) {
+			t.Errorf("fail_on %s: want statuses %v, got %v", test.failOn, test.want, statusErr.Statuses)
+		}
+	}
+}
+
+// TestTolerateStale tests that -fail_on tolerates the STALE resources updated within -tolerate_stale_for
+func TestTolerateStale(t *testing.T) {
+	now := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "STALE", "lastUpdated": "2021-01-02T02:59:05Z"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE", "lastUpdated": "2021-01-02T03:03:05Z"},
+			{"typeUrl": "type.googleapis.com/envoy.config.route.v3.RouteConfiguration", "name": "r1", "configStatus": "STALE"},
+			{"typeUrl": "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment", "name": "e1", "configStatus": "ERROR", "lastUpdated": "2021-01-02T03:04:00Z"}]}]}`)
+
+	tests := []struct {
+		window time.Duration
+		want   []string
+	}{
+		// c1 has been stale for exactly the window, which isn't tolerated anymore
+		{window: 5 * time.Minute, want: []string{"c1", "r1", "e1"}},
+		{window: 5*time.Minute + time.Nanosecond, want: []string{"r1", "e1"}},
+		{window: time.Minute, want: []string{"c1", "l1", "r1", "e1"}},
+		{window: time.Minute + time.Nanosecond, want: []string{"c1", "r1", "e1"}},
+	}
+	for _, test := range tests {
+		configs := tolerateStale(response.GetConfig(), test.window, now)
+		var got []string
+		for _, genericXdsConfig := range configs[0].GetGenericXdsConfigs() {
+			got = append(got, genericXdsConfig.GetName())
+		}
+		// the tolerated resources are dropped, the others are kept in order
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("tolerate_stale_for %v: want %v, got %v", test.window, test.want, got)
+		}
+	}
+	if len(response.GetConfig()[0].GetGenericXdsConfigs()) != 4 {
+		t.Errorf("want the response left unchanged, got %v", response)
+	}
+
+	// the STALE resources updated within the window don't fail, the ones without a last updated time do
+	fresh := parseResponse(t, fmt.Sprintf(`{"config": [{"node": {"id": "node_1"}, "genericXdsConfigs": [
+		{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "STALE", "lastUpdated": %q}]}]}`,
+		time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)))
+	for _, test := range []struct {
+		response *csdspb_v3.ClientStatusResponse
+		window   time.Duration
+		wantErr  bool
+	}{
+		{response: fresh, window: time.Hour},
+		{response: fresh, wantErr: true},
+		{response: fresh, window: time.Second, wantErr: true},
+		{response: response, window: time.Hour, wantErr: true},
+	} {
+		c := ClientV3{opts: client.ClientOptions{Platform: "gcp", OutputFormat: "compact", FailOn: "STALE", TolerateStaleFor: test.window}}
+		stream := &fakeStream{responses: []*csdspb_v3.ClientStatusResponse{test.response}}
+		var err error
+		clientUtil.CaptureOutput(func() {
+			_, err = c.doRequest(context.Background(), stream)
+		})
+		var statusErr *client.StatusError
+		if errors.As(err, &statusErr) != test.wantErr {
+			t.Errorf("tolerate_stale_for %v: want a status error %v, got %v", test.window, test.wantErr, err)
+		}
+	}
+
+	for _, opts := range []client.ClientOptions{
+		{Platform: "gcp", TolerateStaleFor: time.Minute},
+		{Platform: "gcp", FailOn: "STALE", TolerateStaleFor: -time.Minute},
+	} {
+		if _, err := New(opts); err == nil || !strings.Contains(err.Error(), "tolerate_stale_for") {
+			t.Errorf("want tolerate_stale_for %v to be rejected with %+v, got %v", opts.TolerateStaleFor, opts, err)
+		}
+	}
+}
+
+// TestColumns tests printing the chosen columns of the table in order, each as wide as its widest cell
+func TestColumns(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1", "metadata": {"XDS_STREAM_TYPE": "ADS"}}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED", "clientStatus": "ACKED", "lastUpdated": "2021-01-02T03:04:05Z"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "ERROR", "clientStatus": "NACKED",
+				"errorState": {"versionInfo": "v2", "details": "invalid listener"}}]},
+		{"node": {"id": "a_much_longer_node_id"}}]}`)
+
+	tests := []struct {
+		columns string
+		want    string
+	}{
+		{
+			columns: "id,xds,status,last_updated",
+			want: `Client ID             xDS Config Status Last Updated
+a_much_longer_node_id N/A N/A           N/A
+node_1                CDS SYNCED        2021-01-02T03:04:05Z
+                      LDS ERROR         -
+                        failed version: v2
+                        details: invalid listener
+`,
+		},
+		{
+			columns: " Client_Status, type_url ,stream_type",
+			want: `Client Status Type URL                                              xDS stream type
+N/A           N/A
+ACKED         type.googleapis.com/envoy.config.cluster.v3.Cluster   ADS
+NACKED        type.googleapis.com/envoy.config.listener.v3.Listener
+                failed version: v2
+                details: invalid listener
+`,
+		},
+	}
+	for _, test := range tests {
+		opts := client.ClientOptions{
+			Platform:   "gcp",
+			Columns:    test.columns,
+			ShowErrors: true,
+			UTC:        true,
+		}
+		out := clientUtil.CaptureOutput(func() {
+			if err := printOutResponse(os.Stdout, response, opts); err != nil {
+				t.Errorf("Print out response error: %v", err)
+			}
+		})
+		if got := strings.SplitN(out, "Clients: ", 2)[0]; got != test.want {
+			t.Errorf("columns %q: want\n%vout\n%v", test.columns, test.want, got)
+		}
+	}
+
+	errTests := []struct {
+		opts client.ClientOptions
+		want string
+	}{
+		{opts: client.ClientOptions{Platform: "gcp", Columns: "id,name"}, want: "name column is not supported by columns, list of supported columns: id, stream_type, locality, xds, status, type_url, last_updated, client_status, version"},
+		{opts: client.ClientOptions{Platform: "gcp", Columns: "id,ID"}, want: "id column is listed more than once in columns"},
+		{opts: client.ClientOptions{Platform: "gcp", Columns: "id", OutputFormat: "json"}, want: "columns cannot be used with the json output format"},
+	}
+	for _, test := range errTests {
+		if _, err := New(test.opts); err == nil || err.Error() != test.want {
+			t.Errorf("columns %q: want error %q, got %v", test.opts.Columns, test.want, err)
+		}
+	}
+}
+
+// TestTableWidths tests sizing the columns of the table to their content, truncating the long cells
+func TestTableWidths(t *testing.T) {
+	longId := "projects/123456789012/networks/default/nodes/" + strings.Repeat("0123456789", 6)
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "`+longId+`", "metadata": {"XDS_STREAM_TYPE": "ADS"}}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED", "clientStatus": "ACKED"}]},
+		{"node": {"id": "node_1"}}]}`)
+
+	tests := []struct {
+		name string
+		opts client.ClientOptions
+		want string
+	}{
+		{
+			name: "truncated",
+			opts: client.ClientOptions{Platform: "gcp"},
+			want: fmt.Sprintf(`%-100s xDS stream type Config Status Client Status Last Updated
+%-100s                 N/A           
+%s... ADS             CDS   SYNCED  ACKED         -
+`, "Client ID", "node_1", longId[:97]),
+		},
+		{
+			name: "no_truncate",
+			opts: client.ClientOptions{Platform: "gcp", NoTruncate: true},
+			want: fmt.Sprintf(`%-105s xDS stream type Config Status Client Status Last Updated
+%-105s                 N/A           
+%s ADS             CDS   SYNCED  ACKED         -
+`, "Client ID", "node_1", longId),
+		},
+	}
+	for _, test := range tests {
+		out := clientUtil.CaptureOutput(func() {
+			if err := printOutResponse(os.Stdout, response, test.opts); err != nil {
+				t.Errorf("Print out response error: %v", err)
+			}
+		})
+		if got := strings.SplitN(out, "Clients: ", 2)[0]; got != test.want {
+			t.Errorf("%s: want\n%vout\n%v", test.name, test.want, got)
+		}
+	}
+}
+
+// TestFixedWidth tests that -fixed_width prints the table of the former releases byte for byte, without
+// the columns and the summary added since
+func TestFixedWidth(t *testing.T) {
+	longId := "projects/123456789012/networks/default/nodes/" + strings.Repeat("0123456789", 4)
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "`+longId+`", "metadata": {"XDS_STREAM_TYPE": "ADS"}}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED", "clientStatus": "ACKED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE"}]},
+		{"node": {"id": "node_1"}},
+		{"node": {"id": "node_2", "metadata": {"XDS_STREAM_TYPE": "SotW"}}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment", "name": "e1", "configStatus": "ERROR"}]}]}`)
+	// the output of the former releases for this response, trailing spaces included
+	want := `Client ID                                          xDS stream type                Config Status                  
+projects/123456789012/networks/default/nodes/0123456789012345678901234567890123456789 ADS                            CDS   SYNCED                   
+                                                                                  LDS   STALE                    
+node_1                                                                            N/A                            
+node_2                                             SotW                           EDS   ERROR                    
+`
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, client.ClientOptions{Platform: "gcp", Sort: "none", FixedWidth: true}); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	parts := strings.SplitN(out, "Detailed Config:\n", 2)
+	if len(parts) != 2 || parts[0] != want {
+		t.Errorf("want\n%qout\n%q", want, out)
+	}
+
+	for _, opts := range []client.ClientOptions{
+		{Platform: "gcp", FixedWidth: true, OutputFormat: "csv"},
+		{Platform: "gcp", FixedWidth: true, ShowVersion: true},
+		{Platform: "gcp", FixedWidth: true, Uri: "a=localhost:1,b=localhost:2"},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Errorf("want fixed_width to be rejected with %+v", opts)
+		}
+	}
+}
+
+// TestSummaryOnly tests printing only the summary of the clients matching the filter
+func TestSummaryOnly(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE"}]},
+		{"node": {"id": "node_2"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"}]},
+		{"node": {"id": "other_node"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "ERROR"}]}]}`)
+
+	tests := []struct {
+		filterPattern string
+		want          string
+	}{
+		{want: "Clients: 3  SYNCED: 2  STALE: 1  ERROR: 1\nCDS:3 LDS:1\n"},
+		{filterPattern: "node_", want: "Clients: 2  SYNCED: 2  STALE: 1\nCDS:2 LDS:1\n"},
+		{filterPattern: "none", want: "Clients: 0\n"},
+	}
+	for _, test := range tests {
+		opts := client.ClientOptions{
+			Platform:      "gcp",
+			SummaryOnly:   true,
+			FilterMode:    "prefix",
+			FilterPattern: test.filterPattern,
+		}
+		out := clientUtil.CaptureOutput(func() {
+			if err := printOutResponse(os.Stdout, response, opts); err != nil {
+				t.Errorf("Print out response error: %v", err)
+			}
+		})
+		if out != test.want {
+			t.Errorf("filter_pattern %q: want %q, got %q", test.filterPattern, test.want, out)
+		}
+	}
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", SummaryOnly: true, OutputFormat: "json"}); err == nil {
+		t.Errorf("want summary_only with the json output format to be rejected")
+	}
+}
+
+// TestExcludeContents tests that exclude_contents leaves the detailed config out, and warns that the request
+// can't ask the server to omit the contents
+func TestExcludeContents(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED",
+				"xdsConfig": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1"}}]}]}`)
+	opts := client.ClientOptions{Platform: "gcp", ExcludeContents: true}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	want := `Client ID xDS stream type Config Status Client Status Last Updated
+node_1                    CDS   SYNCED  -             -
+Clients: 1  SYNCED: 1
+CDS:1
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	opts = client.ClientOptions{Platform: "local", AuthnMode: "insecure", RequestYaml: "{node: {id: fake_client}}", ExcludeContents: true}
+	var c *ClientV3
+	var err error
+	out = clientUtil.CaptureOutput(func() {
+		c, err = New(opts)
+	})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	if !strings.Contains(out, "WARNING: exclude_contents is not sent to the server") || !strings.Contains(out, "v0.11.0 or later") {
+		t.Errorf("want a warning that exclude_contents is not sent, got\n%v", out)
+	}
+	var req *csdspb_v3.ClientStatusRequest
+	out = clientUtil.CaptureOutput(func() {
+		req = c.BuildRequest()
+		c.BuildRequest()
+	})
+	if out != "" {
+		t.Errorf("want the warning printed once by New, got\n%v", out)
+	}
+	opts.ExcludeContents = false
+	if c, err = New(opts); err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	if !proto.Equal(req, c.BuildRequest()) {
+		t.Errorf("want the request unchanged, got %v", req)
+	}
+
+	for _, opts := range []client.ClientOptions{
+		{Platform: "gcp", ExcludeContents: true, Visualization: true},
+		{Platform: "gcp", ExcludeContents: true, RouteTable: true},
+	} {
+		if _, err := New(opts); err == nil || !strings.Contains(err.Error(), "exclude_contents cannot be used") {
+			t.Errorf("want exclude_contents to be rejected with %+v, got %v", opts, err)
+		}
+	}
+}
+
+// TestDumpDir tests writing the decoded config of each resource to its own file with -dump_dir
+func TestDumpDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dump_dir")
+	if err != nil {
+		t.Fatalf("TempDir error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "projects/1/nodes/a"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED",
+				"xdsConfig": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1"}},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "../l1", "configStatus": "SYNCED",
+				"xdsConfig": {"@type": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "../l1"}},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l2", "configStatus": "NOT_SENT"}]},
+		{"node": {"id": "projects/1/nodes/a"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED",
+				"xdsConfig": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1"}}]}]}`)
+	opts := client.ClientOptions{Platform: "gcp", DumpDir: dir}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	if !strings.HasSuffix(out, fmt.Sprintf("3 files have been written to %s\n", dir)) || strings.Contains(out, "Detailed Config:") {
+		t.Errorf("want only the number of files written, got\n%v", out)
+	}
+
+	clientDir := sanitizePathSegment("projects/1/nodes/a")
+	if !strings.HasPrefix(clientDir, "projects_1_nodes_a-") {
+		t.Errorf("want the client id sanitized, got %s", clientDir)
+	}
+	var files []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Walk error: %v", err)
+	}
+	want := []string{
+		clientDir + "/CDS/c1-2.json",
+		clientDir + "/CDS/c1.json",
+		clientDir + "/LDS/" + sanitizePathSegment("../l1") + ".json",
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("want files %v, got %v", want, files)
+	}
+	if strings.Contains(want[2], "..") || strings.Contains(want[2], "/l1") {
+		t.Errorf("want the resource name sanitized, got %s", want[2])
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, clientDir, "CDS", "c1.json"))
+	if err != nil {
+		t.Fatalf("ReadFile error: %v", err)
+	}
+	if !strings.Contains(string(content), `"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster"`) {
+		t.Errorf("want the decoded config of the resource, got\n%s", content)
+	}
+
+	if got := sanitizePathSegment("a/b"); got == sanitizePathSegment("a_b") || sanitizePathSegment("a_b") != "a_b" {
+		t.Errorf("want a/b and a_b sanitized to different names, got %s", got)
+	}
+	if got := sanitizePathSegment(""); !strings.HasPrefix(got, "_-") {
+		t.Errorf("want an empty name sanitized to _, got %s", got)
+	}
+
+	for _, opts := range []client.ClientOptions{
+		{Platform: "gcp", DumpDir: dir, ExcludeContents: true},
+		{Platform: "gcp", DumpDir: dir, SummaryOnly: true},
+	} {
+		if _, err := New(opts); err == nil || !strings.Contains(err.Error(), "dump_dir cannot be used") {
+			t.Errorf("want dump_dir to be rejected with %+v, got %v", opts, err)
+		}
+	}
+}
+
+// TestTally tests the tally of the matched clients of a response, without printing it
+func TestTally(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE"}]},
+		{"node": {"id": "node_2"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED",
+				"errorState": {"details": "rejected update"}},
+			{"typeUrl": "type.googleapis.com/envoy.config.future.v3.Resource", "name": "f1", "configStatus": "SYNCED"}]},
+		{"node": {"id": "other_node"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "ERROR"}]},
+		{"genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "ERROR"}]}]}`)
+
+	tests := []struct {
+		name string
+		opts client.ClientOptions
+		want Result
+	}{
+		{
+			name: "all clients",
+			opts: client.ClientOptions{Platform: "gcp"},
+			want: Result{
+				Clients:   3,
+				Statuses:  map[csdspb_v3.ConfigStatus]int{csdspb_v3.ConfigStatus_SYNCED: 3, csdspb_v3.ConfigStatus_STALE: 1, csdspb_v3.ConfigStatus_ERROR: 1},
+				Types:     map[string]int{"CDS": 3, "LDS": 1, otherXds: 1},
+				HasErrors: true,
+			},
+		},
+		{
+			name: "error state",
+			opts: client.ClientOptions{Platform: "gcp", FilterMode: "exact", FilterPattern: "node_2"},
+			want: Result{
+				Clients:   1,
+				Statuses:  map[csdspb_v3.ConfigStatus]int{csdspb_v3.ConfigStatus_SYNCED: 2},
+				Types:     map[string]int{"CDS": 1, otherXds: 1},
+				HasErrors: true,
+			},
+		},
+		{
+			name: "healthy",
+			opts: client.ClientOptions{Platform: "gcp", FilterMode: "exact", FilterPattern: "node_1"},
+			want: Result{
+				Clients:  1,
+				Statuses: map[csdspb_v3.ConfigStatus]int{csdspb_v3.ConfigStatus_SYNCED: 1, csdspb_v3.ConfigStatus_STALE: 1},
+				Types:    map[string]int{"CDS": 1, "LDS": 1},
+			},
+		},
+		{
+			name: "xds type",
+			opts: client.ClientOptions{Platform: "gcp", XdsType: "LDS"},
+			want: Result{
+				Clients:  1,
+				Statuses: map[csdspb_v3.ConfigStatus]int{csdspb_v3.ConfigStatus_STALE: 1},
+				Types:    map[string]int{"LDS": 1},
+			},
+		},
+		{
+			name: "no client",
+			opts: client.ClientOptions{Platform: "gcp", FilterMode: "prefix", FilterPattern: "none"},
+			want: Result{Statuses: map[csdspb_v3.ConfigStatus]int{}, Types: map[string]int{}},
+		},
+	}
+	for _, test := range tests {
+		c := ClientV3{opts: test.opts}
+		out := clientUtil.CaptureOutput(func() {
+			got, err := c.Tally(response)
+			if err != nil {
+				t.Fatalf("%s: tally error: %v", test.name, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s: want %+v, got %+v", test.name, test.want, got)
+			}
+		})
+		if out != "" {
+			t.Errorf("%s: want nothing printed, got\n%v", test.name, out)
+		}
+	}
+
+	c := ClientV3{opts: client.ClientOptions{Platform: "gcp", StrictTypes: true}}
+	if _, err := c.Tally(response); err == nil || !strings.Contains(err.Error(), "envoy.config.future.v3.Resource") {
+		t.Errorf("want the unsupported xDS type to fail the tally with strict_types, got %v", err)
+	}
+	if got, want := tallyConfigs(response.GetConfig()).matchStatuses([]string{"STALE", "ERROR", "NOT_SENT"}), []string{"ERROR", "STALE"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want the matched statuses %v, got %v", want, got)
+	}
+}
+
+// TestXdsTypeFilter tests restricting the client status and the detailed config to some xDS types
+func TestXdsTypeFilter(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE"}]},
+		{"node": {"id": "node_2"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"}]}]}`)
+	opts := client.ClientOptions{
+		Platform: "gcp",
+		XdsType:  "lds, rds",
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	parts := strings.SplitN(out, "Detailed Config:\n", 2)
+	if len(parts) != 2 {
+		t.Fatalf("want the detailed config in the output, got\n%v", out)
+	}
+	want := `Client ID xDS stream type Config Status Client Status Last Updated
+node_1                    LDS   STALE   -             -
+Clients: 1  STALE: 1
+LDS:1
+`
+	if parts[0] != want {
+		t.Errorf("want\n%vout\n%v", want, parts[0])
+	}
+	if strings.Contains(parts[1], "Cluster") || !strings.Contains(parts[1], "Listener") {
+		t.Errorf("want only the LDS resources in the detailed config, got\n%v", parts[1])
+	}
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", XdsType: "LDS,FOO"}); err == nil || !strings.Contains(err.Error(), "FOO") {
+		t.Errorf("want the unknown xDS type FOO to be rejected, got %v", err)
+	}
+}
+
+// TestStatusFilter tests restricting the client status and the detailed config to some config statuses
+func TestStatusFilter(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE"}]},
+		{"node": {"id": "node_2"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"}]},
+		{"node": {"id": "other_node"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "ERROR"}]},
+		{"node": {"id": "node_3"}}]}`)
+
+	tests := []struct {
+		filterPattern string
+		want          string
+	}{
+		{want: "Clients: 2  STALE: 1  ERROR: 1\nCDS:1 LDS:1\n"},
+		// the filter on node ids and the one on config statuses both apply
+		{filterPattern: "node_", want: "Clients: 1  STALE: 1\nLDS:1\n"},
+	}
+	for _, test := range tests {
+		opts := client.ClientOptions{
+			Platform:      "gcp",
+			SummaryOnly:   true,
+			FilterMode:    "prefix",
+			FilterPattern: test.filterPattern,
+			StatusFilter:  "STALE, ERROR",
+		}
+		out := clientUtil.CaptureOutput(func() {
+			if err := printOutResponse(os.Stdout, response, opts); err != nil {
+				t.Errorf("Print out response error: %v", err)
+			}
+		})
+		if out != test.want {
+			t.Errorf("filter_pattern %q: want %q, got %q", test.filterPattern, test.want, out)
+		}
+	}
+
+	opts := client.ClientOptions{
+		Platform:     "gcp",
+		StatusFilter: "stale",
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	parts := strings.SplitN(out, "Detailed Config:\n", 2)
+	if len(parts) != 2 {
+		t.Fatalf("want the detailed config in the output, got\n%v", out)
+	}
+	want := `Client ID xDS stream type Config Status Client Status Last Updated
+node_1                    LDS   STALE   -             -
+Clients: 1  STALE: 1
+LDS:1
+`
+	if parts[0] != want {
+		t.Errorf("want\n%vout\n%v", want, parts[0])
+	}
+	if strings.Contains(parts[1], "Cluster") || !strings.Contains(parts[1], "Listener") {
+		t.Errorf("want only the STALE resources in the detailed config, got\n%v", parts[1])
+	}
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", StatusFilter: "STALE,BROKEN"}); err == nil || !strings.Contains(err.Error(), "BROKEN") {
+		t.Errorf("want the unknown config status BROKEN to be rejected, got %v", err)
+	}
+}
+
+// TestResourceNameFilter tests restricting the detailed config to the resources whose name matches -resource_name
+func TestResourceNameFilter(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "0.0.0.0_8080", "configStatus": "SYNCED",
+				"xdsConfig": {"@type": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "0.0.0.0_8080"}},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "0.0.0.0_9090", "configStatus": "SYNCED",
+				"xdsConfig": {"@type": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "0.0.0.0_9090"}},
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "outbound/backend", "configStatus": "SYNCED",
+				"xdsConfig": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "outbound/backend"}}]},
+		{"node": {"id": "node_2"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "inbound/frontend", "configStatus": "SYNCED",
+				"xdsConfig": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "inbound/frontend"}}]}]}`)
+
+	tests := []struct {
+		mode    string
+		pattern string
+		want    []string
+	}{
+		{mode: "exact", pattern: "0.0.0.0_8080", want: []string{"0.0.0.0_8080"}},
+		{mode: "prefix", pattern: "0.0.0.0_", want: []string{"0.0.0.0_8080", "0.0.0.0_9090"}},
+		{mode: "suffix", pattern: "end", want: []string{"outbound/backend", "inbound/frontend"}},
+		{mode: "regex", pattern: `_\d{4}$`, want: []string{"0.0.0.0_8080", "0.0.0.0_9090"}},
+		{mode: "glob", pattern: "*/frontend,0.0.0.0_90?0", want: []string{"0.0.0.0_9090", "inbound/frontend"}},
+		{mode: "exact", pattern: "missing"},
+	}
+	all := []string{"0.0.0.0_8080", "0.0.0.0_9090", "outbound/backend", "inbound/frontend"}
+	for _, test := range tests {
+		opts := client.ClientOptions{Platform: "gcp", ResourceName: test.pattern, ResourceNameMode: test.mode}
+		out := clientUtil.CaptureOutput(func() {
+			if err := printOutResponse(os.Stdout, response, opts); err != nil {
+				t.Errorf("Print out response error: %v", err)
+			}
+		})
+		parts := strings.SplitN(out, "Detailed Config:\n", 2)
+		// the client status is not filtered by resource name
+		if !strings.Contains(parts[0], "Clients: 2") {
+			t.Errorf("%s %q: want every client in the client status, got\n%v", test.mode, test.pattern, parts[0])
+		}
+		if len(test.want) == 0 {
+			if len(parts) != 1 {
+				t.Errorf("%s %q: want no detailed config, got\n%v", test.mode, test.pattern, parts[1])
+			}
+			continue
+		}
+		if len(parts) != 2 {
+			t.Fatalf("%s %q: want the detailed config in the output, got\n%v", test.mode, test.pattern, out)
+		}
+		for _, name := range all {
+			want := false
+			for _, w := range test.want {
+				want = want || w == name
+			}
+			if got := strings.Contains(parts[1], `"name": "`+name+`"`); got != want {
+				t.Errorf("%s %q: want %s in the detailed config %v, got\n%v", test.mode, test.pattern, name, want, parts[1])
+			}
+		}
+		// the clients without any matching resource are omitted
+		if got, want := strings.Contains(parts[1], `"node_2"`), strings.Contains(strings.Join(test.want, ","), "inbound"); got != want {
+			t.Errorf("%s %q: want node_2 in the detailed config %v, got\n%v", test.mode, test.pattern, want, parts[1])
+		}
+	}
+
+	for _, opts := range []client.ClientOptions{
+		{Platform: "gcp", ResourceName: "l1", ResourceNameMode: "foo"},
+		{Platform: "gcp", ResourceName: "l1[", ResourceNameMode: "regex"},
+	} {
+		if _, err := New(opts); err == nil || !strings.Contains(err.Error(), "resource_name") {
+			t.Errorf("want resource_name %q in %s mode to be rejected, got %v", opts.ResourceName, opts.ResourceNameMode, err)
+		}
+	}
+}
+
+// TestMultipleFilterPatterns tests matching node ids against any of several filter patterns
+func TestMultipleFilterPatterns(t *testing.T) {
+	filename, _ := filepath.Abs("./response_for_filter.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	var response csdspb_v3.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+
+	tests := []struct {
+		filterMode    string
+		filterPattern string
+		filterInvert  bool
+		want          []string
+	}{
+		{filterMode: "prefix", filterPattern: "test_node_1,node_", want: []string{"test_node_1", "node_3"}},
+		{filterMode: "suffix", filterPattern: "_2, ,_3", want: []string{"test_node_2", "test_node_3", "node_3"}},
+		{filterMode: "regex", filterPattern: `^test_node_[12]$,^node_\d{1,3}$`, want: []string{"test_node_1", "test_node_2", "node_3"}},
+		{filterMode: "prefix", filterPattern: "test_", filterInvert: true, want: []string{"node_3"}},
+	}
+	for _, test := range tests {
+		opts := client.ClientOptions{
+			FilterMode:    test.filterMode,
+			FilterPattern: test.filterPattern,
+			FilterInvert:  test.filterInvert,
+		}
+		configs, _, err := filterClientConfigs(response.GetConfig(), opts)
+		if err != nil {
+			t.Errorf("filter_pattern %q: unexpected error %v", test.filterPattern, err)
+			continue
+		}
+		var ids []string
+		for _, config := range configs {
+			ids = append(ids, config.GetNode().GetId())
+		}
+		if !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("filter_pattern %q: want %v, got %v", test.filterPattern, test.want, ids)
+		}
+	}
+
+	c := ClientV3{opts: client.ClientOptions{FilterMode: "regex", FilterPattern: "test.*,node_("}}
+	if err := c.validateFilterMode(); err == nil || !strings.Contains(err.Error(), `"node_("`) {
+		t.Errorf("want the invalid pattern node_( to be named, got %v", err)
+	}
+}
+
+// TestExactFilter tests matching only the clients whose id equals one of the patterns of the exact filter mode
+func TestExactFilter(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "frontend-1"}},
+		{"node": {"id": "frontend-10"}},
+		{"node": {"id": "my-frontend-1"}},
+		{"node": {"id": "Frontend-2"}}]}`)
+
+	tests := []struct {
+		filterPattern string
+		ignoreCase    bool
+		invert        bool
+		want          []string
+	}{
+		{filterPattern: "frontend-1", want: []string{"frontend-1"}},
+		// a substring, a prefix or a suffix of the id doesn't match
+		{filterPattern: "frontend", want: nil},
+		{filterPattern: "frontend-", want: nil},
+		{filterPattern: "end-1", want: nil},
+		{filterPattern: "frontend-2", want: nil},
+		{filterPattern: "frontend-2", ignoreCase: true, want: []string{"Frontend-2"}},
+		{filterPattern: "frontend-1,my-frontend-1", want: []string{"frontend-1", "my-frontend-1"}},
+		{filterPattern: "frontend-1", invert: true, want: []string{"frontend-10", "my-frontend-1", "Frontend-2"}},
+	}
+	for _, test := range tests {
+		opts := client.ClientOptions{
+			FilterMode:       "exact",
+			FilterPattern:    test.filterPattern,
+			FilterIgnoreCase: test.ignoreCase,
+			FilterInvert:     test.invert,
+		}
+		configs, _, err := filterClientConfigs(response.GetConfig(), opts)
+		if err != nil {
+			t.Errorf("filter_pattern %q: unexpected error %v", test.filterPattern, err)
+			continue
+		}
+		var ids []string
+		for _, config := range configs {
+			ids = append(ids, config.GetNode().GetId())
+		}
+		if !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("filter_pattern %q, ignore case %v, invert %v: want %v, got %v", test.filterPattern, test.ignoreCase, test.invert, test.want, ids)
+		}
+	}
+}
+
+// TestGlobFilter tests the single-segment and multi-segment wildcards of the glob filter mode
+func TestGlobFilter(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "proj/1/cluster/frontend/pod/xyz"}},
+		{"node": {"id": "proj/1/cluster/backend/pod/xyz"}},
+		{"node": {"id": "proj/2/cluster/frontend/pod/abc"}},
+		{"node": {"id": "proj/2/region/us/cluster/frontend/pod/xyz"}},
+		{"node": {"id": "Proj/3/cluster/frontend/pod/xyz"}}]}`)
+
+	tests := []struct {
+		filterPattern string
+		ignoreCase    bool
+		want          []string
+	}{
+		// "*" doesn't match across the segments of the id
+		{filterPattern: "proj/*/cluster/frontend/*", want: nil},
+		{filterPattern: "proj/*/cluster/frontend/pod/*", want: []string{"proj/1/cluster/frontend/pod/xyz", "proj/2/cluster/frontend/pod/abc"}},
+		{filterPattern: "proj/*/cluster/*/pod/xyz", want: []string{"proj/1/cluster/frontend/pod/xyz", "proj/1/cluster/backend/pod/xyz"}},
+		// "**" does
+		{filterPattern: "proj/**/frontend/**", want: []string{"proj/1/cluster/frontend/pod/xyz", "proj/2/cluster/frontend/pod/abc", "proj/2/region/us/cluster/frontend/pod/xyz"}},
+		{filterPattern: "proj/**/pod/xyz", want: []string{"proj/1/cluster/frontend/pod/xyz", "proj/1/cluster/backend/pod/xyz", "proj/2/region/us/cluster/frontend/pod/xyz"}},
+		// the pattern matches the whole id
+		{filterPattern: "proj/1", want: nil},
+		{filterPattern: "proj/?/cluster/[!b]*/pod/[a-c]*", want: []string{"proj/2/cluster/frontend/pod/abc"}},
+		{filterPattern: "proj/[3]/**,proj/2/cluster/**", ignoreCase: true, want: []string{"proj/2/cluster/frontend/pod/abc", "Proj/3/cluster/frontend/pod/xyz"}},
+		{filterPattern: `proj/1/cluster/backend/pod/\x\y\z`, want: []string{"proj/1/cluster/backend/pod/xyz"}},
+	}
+	for _, test := range tests {
+		opts := client.ClientOptions{
+			FilterMode:       "glob",
+			FilterPattern:    test.filterPattern,
+			FilterIgnoreCase: test.ignoreCase,
+		}
+		configs, _, err := filterClientConfigs(response.GetConfig(), opts)
+		if err != nil {
+			t.Errorf("filter_pattern %q: unexpected error %v", test.filterPattern, err)
+			continue
+		}
+		var ids []string
+		for _, config := range configs {
+			ids = append(ids, config.GetNode().GetId())
+		}
+		if !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("filter_pattern %q, ignore case %v: want %v, got %v", test.filterPattern, test.ignoreCase, test.want, ids)
+		}
+	}
+
+	for _, pattern := range []string{"proj/[a", "proj/[]", "proj/[!]", `proj\`, "proj/[z-a]"} {
+		c := ClientV3{opts: client.ClientOptions{FilterMode: "glob", FilterPattern: pattern}}
+		if err := c.validateFilterMode(); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("invalid filter pattern %q", pattern)) {
+			t.Errorf("want the invalid pattern %q to be named, got %v", pattern, err)
+		}
+	}
+}
+
+// TestFilterIgnoreCase tests matching mixed-case node ids ignoring case in each filter mode
+func TestFilterIgnoreCase(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "Gke-Node-1"}},
+		{"node": {"id": "gke-node-2"}},
+		{"node": {"id": "VM-NODE-3"}}]}`)
+
+	tests := []struct {
+		filterMode    string
+		filterPattern string
+		ignoreCase    bool
+		want          []string
+	}{
+		{filterMode: "prefix", filterPattern: "gke-", want: []string{"gke-node-2"}},
+		{filterMode: "prefix", filterPattern: "gke-", ignoreCase: true, want: []string{"Gke-Node-1", "gke-node-2"}},
+		{filterMode: "suffix", filterPattern: "Node-3", want: nil},
+		{filterMode: "suffix", filterPattern: "Node-3", ignoreCase: true, want: []string{"VM-NODE-3"}},
+		{filterMode: "regex", filterPattern: "^[a-z]+-node-[0-9]$", want: []string{"gke-node-2"}},
+		{filterMode: "regex", filterPattern: "^[a-z]+-node-[0-9]$", ignoreCase: true, want: []string{"Gke-Node-1", "gke-node-2", "VM-NODE-3"}},
+	}
+	for _, test := range tests {
+		opts := client.ClientOptions{
+			FilterMode:       test.filterMode,
+			FilterPattern:    test.filterPattern,
+			FilterIgnoreCase: test.ignoreCase,
+		}
+		configs, _, err := filterClientConfigs(response.GetConfig(), opts)
+		if err != nil {
+			t.Errorf("filter_mode %s: unexpected error %v", test.filterMode, err)
+			continue
+		}
+		var ids []string
+		for _, config := range configs {
+			ids = append(ids, config.GetNode().GetId())
+		}
+		if !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("filter_mode %s, filter_pattern %q, ignore case %v: want %v, got %v", test.filterMode, test.filterPattern, test.ignoreCase, test.want, ids)
+		}
+	}
+}
+
+// TestWaitMonitorInterval tests that the wait between monitor cycles is interrupted by a stop or a done context
+func TestWaitMonitorInterval(t *testing.T) {
+	stop := make(chan struct{})
+	if stopped, err := clientUtil.WaitMonitorInterval(context.Background(), stop, time.Millisecond); stopped || err != nil {
+		t.Errorf("want the interval to elapse, got stopped %v, error %v", stopped, err)
+	}
+
+	close(stop)
+	if stopped, err := clientUtil.WaitMonitorInterval(context.Background(), stop, time.Hour); !stopped || err != nil {
+		t.Errorf("want the wait to be stopped, got stopped %v, error %v", stopped, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := clientUtil.WaitMonitorInterval(ctx, nil, time.Hour); err != context.Canceled {
+		t.Errorf("want %v, got %v", context.Canceled, err)
+	}
+}
+
+// TestMonitorCountValidation tests that monitor_count is only accepted in monitor mode
+func TestMonitorCountValidation(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		count    int
+		wantErr  bool
+	}{
+		{count: 3, wantErr: true},
+		{interval: time.Second, count: -1, wantErr: true},
+	}
+	for _, test := range tests {
+		_, err := New(client.ClientOptions{Platform: "gcp", MonitorInterval: test.interval, MonitorCount: test.count})
+		if (err != nil) != test.wantErr {
+			t.Errorf("monitor_interval %v, monitor_count %d: want error %v, got %v", test.interval, test.count, test.wantErr, err)
+		}
+	}
+}
+
+// TestWatch tests starting each monitor cycle of -watch with a header, without clearing an output that isn't a terminal
+func TestWatch(t *testing.T) {
+	var out bytes.Buffer
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:        "gcp",
+			OutputFormat:    "compact",
+			MonitorInterval: 5 * time.Second,
+			Watch:           true,
+		},
+		out: &out,
+	}
+	response := `{"config": [{"node": {"id": "test_node_1"}, "genericXdsConfigs": [
+		{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "SYNCED"}]}]}`
+	stream := &fakeStream{
+		responses: []*csdspb_v3.ClientStatusResponse{parseResponse(t, response), parseResponse(t, response)},
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.doRequest(context.Background(), stream); err != nil {
+			t.Errorf("Do request error: %v", err)
+		}
+	}
+	if strings.Contains(out.String(), "\x1b[") {
+		t.Errorf("want no ANSI escape codes when the output isn't a terminal, got %q", out.String())
+	}
+	header := regexp.MustCompile(`(?m)^Every 5s: \d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z\n\ntest_node_1 `)
+	if got := len(header.FindAllString(out.String(), -1)); got != 2 {
+		t.Errorf("want 2 watch headers, each followed by the client status, got %d in\n%v", got, out.String())
+	}
+
+	tests := []struct {
+		opts client.ClientOptions
+		want string
+	}{
+		{opts: client.ClientOptions{Platform: "gcp", Watch: true}, want: "watch can only be used in monitor mode"},
+		{opts: client.ClientOptions{Platform: "gcp", Watch: true, MonitorInterval: time.Second, OutputFormat: "json"}, want: "watch cannot be used with the json output format"},
+	}
+	for _, test := range tests {
+		if _, err := New(test.opts); err == nil || err.Error() != test.want {
+			t.Errorf("want error %q, got %v", test.want, err)
+		}
+	}
+}
+
+// TestMonitorDiff tests printing the first response in full and the changes since the previous one afterwards
+func TestMonitorDiff(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:        "gcp",
+			OutputFormat:    "compact",
+			MonitorInterval: time.Second,
+			MonitorDiff:     true,
+		},
+	}
+	first := `{"config": [
+		{"node": {"id": "test_node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "versionInfo": "v1", "configStatus": "SYNCED"}]},
+		{"node": {"id": "test_node_2"}}]}`
+	second := `{"config": [
+		{"node": {"id": "test_node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "versionInfo": "v2", "configStatus": "STALE"}]},
+		{"node": {"id": "test_node_3"}}]}`
+	stream := &fakeStream{
+		responses: []*csdspb_v3.ClientStatusResponse{parseResponse(t, first), parseResponse(t, second), parseResponse(t, second)},
+	}
+	out := clientUtil.CaptureOutput(func() {
+		for i := 0; i < 3; i++ {
+			if _, err := c.doRequest(context.Background(), stream); err != nil {
+				t.Errorf("Do request error: %v", err)
+			}
+		}
+	})
+	wantBaseline := `Baseline:
+test_node_1                                        C:S L:- R:- S:- E:-
+test_node_2                                        C:- L:- R:- S:- E:-
+Clients: 2  SYNCED: 1
+CDS:1
+Detailed Config:
+`
+	wantChanges := `Changes since the previous request:
+~ test_node_1                                        CDS    SYNCED (v1) -> STALE (v2)
+- test_node_2                                        N/A
++ test_node_3                                        N/A
+No changes since the previous request.
+`
+	// the detailed config of the baseline is not compared
+	if !strings.HasPrefix(out, wantBaseline) || !strings.HasSuffix(out, wantChanges) {
+		t.Errorf("want\n%v<detailed config>\n%vout\n%v", wantBaseline, wantChanges, out)
+	}
+}
+
+// TestParseConfigStatusMixedTypes tests the VHDS and ECDS types, and the fallback to the type url of unsupported types
+func TestParseConfigStatusMixedTypes(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.route.v3.VirtualHost", "name": "v1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.core.v3.TypedExtensionConfig", "name": "e1", "configStatus": "STALE"},
+			{"typeUrl": "type.googleapis.com/envoy.config.future.v3.Resource", "name": "f1", "configStatus": "ERROR"}]}]}`)
+
+	configStatus := parseConfigStatus(response.GetConfig()[0].GetGenericXdsConfigs())
+	want := []string{"CDS   SYNCED", "VHDS   SYNCED", "ECDS   STALE", "envoy.config.future.v3.Resource   ERROR"}
+	if !reflect.DeepEqual(configStatus, want) {
+		t.Errorf("want %v, got %v", want, configStatus)
+	}
+
+	out := clientUtil.CaptureOutput(func() {
+		printSummary(os.Stdout, tallyConfigs(response.GetConfig()))
+	})
+	if wantSummary := "Clients: 1  SYNCED: 2  STALE: 1  ERROR: 1\nCDS:1 VHDS:1 ECDS:1 OTHER:1\n"; out != wantSummary {
+		t.Errorf("want summary %q, got %q", wantSummary, out)
+	}
+}
+
+// TestRuntimeResources tests that the runtime layers pushed by RTDS are listed as RTDS, and can be selected by xds_type
+func TestRuntimeResources(t *testing.T) {
+	filename, _ := filepath.Abs("./response_with_runtime.json")
+	responsejson, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	var response csdspb_v3.ClientStatusResponse
+	if err = protojson.Unmarshal(responsejson, &response); err != nil {
+		t.Errorf("Read From File Failure: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, &response, client.ClientOptions{Platform: "gcp"}); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	parts := strings.SplitN(out, "Detailed Config:\n", 2)
+	if len(parts) != 2 {
+		t.Fatalf("want the detailed config in the output, got\n%v", out)
+	}
+	want := `Client ID   xDS stream type   Config Status Client Status Last Updated
+test_nodeid test_stream_type1 LDS   SYNCED  -             -
+                              RTDS   STALE  -             -
+Clients: 1  SYNCED: 1  STALE: 1
+LDS:1 RTDS:1
+`
+	if parts[0] != want {
+		t.Errorf("want\n%vout\n%v", want, parts[0])
+	}
+	if !strings.Contains(parts[1], "envoy.reloadable_features.fake_feature") {
+		t.Errorf("want the runtime layer in the detailed config, got\n%v", parts[1])
+	}
+
+	opts := client.ClientOptions{Platform: "gcp", XdsType: "rtds"}
+	if _, err := New(opts); err != nil && strings.Contains(err.Error(), "xds_type") {
+		t.Errorf("want the RTDS xDS type to be accepted by xds_type, got %v", err)
+	}
+	out = clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, &response, opts); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	if !strings.Contains(out, "RTDS   STALE") || strings.Contains(out, "LDS   SYNCED") {
+		t.Errorf("want only the RTDS resources, got\n%v", out)
+	}
+}
+
+// TestStrictTypes tests that a resource of an unsupported xDS type fails the response only with strict_types
+func TestStrictTypes(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.future.v3.Resource", "name": "f1", "configStatus": "ERROR"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE"}]}]}`)
+
+	out := clientUtil.CaptureOutput(func() {
+		if err := printOutResponse(os.Stdout, response, client.ClientOptions{Platform: "gcp"}); err != nil {
+			t.Errorf("Print out response error: %v", err)
+		}
+	})
+	for _, want := range []string{"CDS   SYNCED", "envoy.config.future.v3.Resource   ERROR", "LDS   STALE"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q in the output, got\n%v", want, out)
+		}
+	}
+
+	for _, format := range []string{"text", "compact", "json"} {
+		var buf strings.Builder
+		err := printOutResponse(&buf, response, client.ClientOptions{Platform: "gcp", OutputFormat: format, StrictTypes: true})
+		want := "unsupported xDS type type.googleapis.com/envoy.config.future.v3.Resource of resource f1 of client node_1"
+		if err == nil || err.Error() != want {
+			t.Errorf("output_format %s: want error %q, got %v", format, want, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("output_format %s: want nothing printed, got\n%v", format, buf.String())
+		}
+	}
+}
+
+// TestLastUpdated tests the last_updated column of the table, in the local time zone or in UTC
+func TestLastUpdated(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED", "lastUpdated": "2021-06-01T12:30:00Z"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "name": "l1", "configStatus": "STALE"}]}]}`)
+	xdsConfig := response.GetConfig()[0].GetGenericXdsConfigs()
+	updated := time.Date(2021, 6, 1, 12, 30, 0, 0, time.UTC)
+
+	if got, want := parseLastUpdated(xdsConfig, false), []string{updated.Local().Format(time.RFC3339), "-"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("local time zone: want %v, got %v", want, got)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		printTable(os.Stdout, response.GetConfig(), false, true, false, nil, fixedTableWidths)
+	})
+	want := `Client ID                                          xDS stream type                Config Status                  Client Status   Last Updated
+node_1                                                                            CDS   SYNCED                   -               2021-06-01T12:30:00Z
+                                                                                  LDS   STALE                    -               -
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+}
+
+// TestClientStatus tests the client_status column of the table and field of the structured output formats
+func TestClientStatus(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "configStatus": "SYNCED", "clientStatus": "UNKNOWN"},
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c2", "configStatus": "SYNCED", "clientStatus": "REQUESTED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c3", "configStatus": "SYNCED", "clientStatus": "DOES_NOT_EXIST"},
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c4", "configStatus": "SYNCED", "clientStatus": "ACKED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c5", "configStatus": "SYNCED", "clientStatus": "NACKED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c6", "configStatus": "SYNCED"}]}]}`)
+
+	out := clientUtil.CaptureOutput(func() {
+		printTable(os.Stdout, response.GetConfig(), false, false, false, nil, fixedTableWidths)
+	})
+	want := `Client ID                                          xDS stream type                Config Status                  Client Status   Last Updated
+node_1                                                                            CDS   SYNCED                   -               -
+                                                                                  CDS   SYNCED                   REQUESTED       -
+                                                                                  CDS   SYNCED                   DOES_NOT_EXIST  -
+                                                                                  CDS   SYNCED                   ACKED           -
+                                                                                  CDS   SYNCED                   NACKED          -
+                                                                                  CDS   SYNCED                   -               -
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	var got []string
+	for _, config := range parseClientStatuses(response.GetConfig(), nil, false, false)[0].Configs {
+		got = append(got, config.ClientStatus)
+	}
+	if wantStatuses := []string{"", "REQUESTED", "DOES_NOT_EXIST", "ACKED", "NACKED", ""}; !reflect.DeepEqual(got, wantStatuses) {
+		t.Errorf("want client statuses %q, got %q", wantStatuses, got)
+	}
+}
+
+// TestShowErrors tests printing the error state of the resources in ERROR beneath their rows
+func TestShowErrors(t *testing.T) {
+	details := strings.Repeat("rejected ", 12) + "\nsecond line"
+	response := &csdspb_v3.ClientStatusResponse{Config: []*csdspb_v3.ClientConfig{{
+		Node: &envoy_config_core_v3.Node{Id: "node_1"},
+		GenericXdsConfigs: []*csdspb_v3.ClientConfig_GenericXdsConfig{
+			{TypeUrl: "type.googleapis.com/envoy.config.cluster.v3.Cluster", Name: "c1", ConfigStatus: csdspb_v3.ConfigStatus_ERROR,
+				ErrorState: &envoy_admin_v3.UpdateFailureState{VersionInfo: "v2", Details: details}},
+			{TypeUrl: "type.googleapis.com/envoy.config.listener.v3.Listener", Name: "l1", ConfigStatus: csdspb_v3.ConfigStatus_SYNCED},
+		},
+	}}}
+
+	out := clientUtil.CaptureOutput(func() {
+		printTable(os.Stdout, response.GetConfig(), false, false, true, nil, fixedTableWidths)
+	})
+	want := `Client ID                                          xDS stream type                Config Status                  Client Status   Last Updated
+node_1                                                                            CDS   ERROR                    -               -
+                                                                                    failed version: v2
+                                                                                    details: rejected rejected rejected rejected rejected rejected rejected rejected rejected
+                                                                                             rejected rejected rejected
+                                                                                             second line
+                                                                                  LDS   SYNCED                   -               -
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+
+	// the table is unchanged without show_errors
+	out = clientUtil.CaptureOutput(func() {
+		printTable(os.Stdout, response.GetConfig(), false, false, false, nil, fixedTableWidths)
+	})
+	if strings.Contains(out, "failed version") {
+		t.Errorf("want no error state without show_errors, got\n%v", out)
+	}
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", ShowErrors: true, OutputFormat: "json"}); err == nil {
+		t.Errorf("want show_errors with the json output format to be rejected")
+	}
+}
+
+// TestDumpRaw tests that dump_raw prints the whole response as received, instead of the client status
+func TestDumpRaw(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_1", "metadata": {"TRAFFICDIRECTOR_NETWORK_NAME": "default"}, "locality": {"zone": "us-central1-a"}},
+		 "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1", "versionInfo": "v1", "configStatus": "SYNCED",
+			 "xdsConfig": {"@type": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "c1"}}]}]}`)
+	var out strings.Builder
+	c := ClientV3{
+		opts: client.ClientOptions{Platform: "gcp", DumpRaw: true},
+		out:  &out,
+	}
+	stream := &fakeStream{responses: []*csdspb_v3.ClientStatusResponse{response}}
+	if _, err := c.doRequest(context.Background(), stream); err != nil {
+		t.Fatalf("Do request error: %v", err)
+	}
+
+	got := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal([]byte(out.String()), got); err != nil {
+		t.Fatalf("want the output to be the response as JSON, got %v\n%v", err, out.String())
+	}
+	if !proto.Equal(got, response) {
+		t.Errorf("want\n%v\ngot\n%v", response, got)
+	}
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", DumpRaw: true, OutputFormat: "json"}); err == nil {
+		t.Errorf("want dump_raw with the json output format to be rejected")
+	}
+}
+
+// TestLabelByMatcher tests that the clients of a request with two NodeMatchers are printed in a section per matcher
+func TestLabelByMatcher(t *testing.T) {
+	c := ClientV3{
+		opts: client.ClientOptions{
+			Platform:    "gcp",
+			SummaryOnly: true,
+			RequestYaml: `{"node_matchers": [
+				{"node_metadatas": [{"path": [{"key": "TRAFFICDIRECTOR_GCP_PROJECT_NUMBER"}], "value": {"string_match": {"exact": "123"}}},
+					{"path": [{"key": "TRAFFICDIRECTOR_MESH_SCOPE_NAME"}], "value": {"string_match": {"exact": "mesh_a"}}}]},
+				{"node_id": {"prefix": "sidecar~"}, "node_metadatas": [{"path": [{"key": "TRAFFICDIRECTOR_MESH_SCOPE_NAME"}], "value": {"string_match": {"exact": "mesh_b"}}}]}]}`,
+		},
+	}
+	if err := c.parseNodeMatcher(); err != nil {
+		t.Fatalf("Parse NodeMatcher Error: %v", err)
+	}
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "a1", "metadata": {"TRAFFICDIRECTOR_GCP_PROJECT_NUMBER": "123", "TRAFFICDIRECTOR_MESH_SCOPE_NAME": "mesh_a"}}},
+		{"node": {"id": "sidecar~b1", "metadata": {"TRAFFICDIRECTOR_MESH_SCOPE_NAME": "mesh_b"}}},
+		{"node": {"id": "sidecar~b2", "metadata": {"TRAFFICDIRECTOR_MESH_SCOPE_NAME": "mesh_b"}}},
+		{"node": {"id": "b3", "metadata": {"TRAFFICDIRECTOR_MESH_SCOPE_NAME": "mesh_b"}}}]}`)
+
+	for i, want := range [][]bool{{true, false, false, false}, {false, true, true, false}} {
+		for j, config := range response.GetConfig() {
+			if got := matchNode(c.nodeMatcher[i], config.GetNode()); got != want[j] {
+				t.Errorf("matcher #%d, client %s: want match %v, got %v", i+1, config.GetNode().GetId(), want[j], got)
+			}
+		}
+	}
+
+	var out strings.Builder
+	c.out = &out
+	if _, err := c.doRequest(context.Background(), &fakeStream{responses: []*csdspb_v3.ClientStatusResponse{response}}); err != nil {
+		t.Fatalf("Do request error: %v", err)
+	}
+	want := `Node matcher #1 (TRAFFICDIRECTOR_MESH_SCOPE_NAME: mesh_a):
+Clients: 1
+Node matcher #2 (TRAFFICDIRECTOR_MESH_SCOPE_NAME: mesh_b):
+Clients: 2
+Not matched by any node matcher:
+Clients: 1
+`
+	if out.String() != want {
+		t.Errorf("want\n%vout\n%v", want, out.String())
+	}
+}
+
+// TestMtlsMissingFiles tests that the mtls authn_mode names the missing file before dialing
+func TestMtlsMissingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds-mtls")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		cert, key, ca string
+		want          string
+	}{
+		{key: "key.pem", ca: "ca.pem", want: "missing client_cert file"},
+		{cert: filepath.Join(dir, "cert.pem"), key: "key.pem", ca: "ca.pem", want: "unable to read client_cert file"},
+	}
+	for _, test := range tests {
+		_, err := clientUtil.ConnWithMtls(context.Background(), "localhost:0", time.Second, test.cert, test.key, test.ca)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("want error %q, got %v", test.want, err)
+		}
+	}
+}
+
+// fakeCsdsServer is an in-process CSDS server that records the requests and replies with response
+type fakeCsdsServer struct {
+	csdspb_v3.UnimplementedClientStatusDiscoveryServiceServer
+	response *csdspb_v3.ClientStatusResponse
+	requests []*csdspb_v3.ClientStatusRequest
+}
+
+func (s *fakeCsdsServer) StreamClientStatus(stream csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.requests = append(s.requests, req)
+		if err := stream.Send(s.response); err != nil {
+			return err
+		}
+	}
+}
+
+// TestInsecureRoundTrip tests a request round-trip with the insecure authn_mode against an in-process server
+func TestInsecureRoundTrip(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	fake := &fakeCsdsServer{response: parseResponse(t, `{"config": [
+		{"node": {"id": "test_node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "SYNCED"}]}]}`)}
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(server, fake)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	c, err := New(client.ClientOptions{
+		Uri:          "bufnet",
+		Platform:     "local",
+		AuthnMode:    "insecure",
+		RequestYaml:  "{node: {id: fake_client}}",
+		OutputFormat: "compact",
+	})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	c.dialOptions = []grpc.DialOption{grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	})}
+
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	})
+	want := `test_node_1                                        C:S L:- R:- S:- E:-
+Clients: 1  SYNCED: 1
+`
+	if !strings.HasPrefix(out, want) {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+	if len(fake.requests) != 1 || fake.requests[0].GetNode().GetId() != "fake_client" {
+		t.Errorf("want one request from fake_client, got %v", fake.requests)
+	}
+}
+
+// TestCompress tests that the responses are compressed with -compress, and that their sizes are reported
+func TestCompress(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	fake := &fakeCsdsServer{response: parseResponse(t, `{"config": [
+		{"node": {"id": "test_node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "`+strings.Repeat("cluster", 200)+`", "configStatus": "SYNCED"}]}]}`)}
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(server, fake)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	c, err := New(client.ClientOptions{
+		Uri:          "bufnet",
+		Platform:     "local",
+		AuthnMode:    "insecure",
+		RequestYaml:  "{node: {id: fake_client}}",
+		OutputFormat: "compact",
+		Compress:     true,
+		Verbose:      true,
+	})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	var buf bytes.Buffer
+	c.compression.w = &buf
+	c.dialOptions = []grpc.DialOption{grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	})}
+
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	})
+	if !strings.Contains(out, "test_node_1   ") {
+		t.Errorf("want the client status of test_node_1, got\n%v", out)
+	}
+	var length, wireLength int
+	if _, err := fmt.Sscanf(buf.String(), "Compression: response of %d bytes received as %d bytes\n", &length, &wireLength); err != nil {
+		t.Fatalf("want the sizes of the response, got %q: %v", buf.String(), err)
+	}
+	if length != proto.Size(fake.response) || wireLength >= length {
+		t.Errorf("want the %d bytes of the response compressed on the wire, got %d bytes received as %d bytes", proto.Size(fake.response), length, wireLength)
+	}
+}
+
+// TestMaxRecvBytes tests receiving a response larger than the 4MB default of gRPC
+func TestMaxRecvBytes(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	fake := &fakeCsdsServer{response: parseResponse(t, `{"config": [
+		{"node": {"id": "test_node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "name": "`+strings.Repeat("c", 5<<20)+`", "configStatus": "SYNCED"}]}]}`)}
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(server, fake)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	tests := []struct {
+		maxRecvBytes int
+		wantErr      string
+	}{
+		{maxRecvBytes: 4 << 20, wantErr: "the response exceeds the 4194304 bytes of max_recv_bytes, raise it to receive the response"},
+		// the default
+		{maxRecvBytes: 0},
+	}
+	for _, test := range tests {
+		c, err := New(client.ClientOptions{
+			Uri:          "bufnet",
+			Platform:     "local",
+			AuthnMode:    "insecure",
+			RequestYaml:  "{node: {id: fake_client}}",
+			OutputFormat: "json",
+			MaxRecvBytes: test.maxRecvBytes,
+		})
+		if err != nil {
+			t.Fatalf("New client error: %v", err)
+		}
+		c.dialOptions = []grpc.DialOption{grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		})}
+
+		var runErr error
+		out := clientUtil.CaptureOutput(func() {
+			runErr = c.Run()
+		})
+		if test.wantErr != "" {
+			if runErr == nil || !strings.Contains(runErr.Error(), test.wantErr) {
+				t.Errorf("max_recv_bytes %d: want error %q, got %v", test.maxRecvBytes, test.wantErr, runErr)
+			}
+			continue
+		}
+		if runErr != nil || !strings.Contains(out, `"client_id": "test_node_1"`) {
+			t.Errorf("max_recv_bytes %d: want the client status of test_node_1, got error %v", test.maxRecvBytes, runErr)
+		}
+	}
+}
+
+// TestKeepalive tests a request round-trip with the keepalive parameters against a server enforcing them
+func TestKeepalive(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: 10 * time.Second, PermitWithoutStream: true}))
+	fake := &fakeCsdsServer{response: parseResponse(t, `{"config": [{"node": {"id": "test_node_1"}}]}`)}
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(server, fake)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	opts := client.ClientOptions{
+		Uri:               "bufnet",
+		Platform:          "local",
+		AuthnMode:         "insecure",
+		RequestYaml:       "{node: {id: fake_client}}",
+		OutputFormat:      "compact",
+		KeepaliveInterval: 10 * time.Second,
+		KeepaliveTimeout:  time.Second,
+	}
+	c, err := New(opts)
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	c.dialOptions = []grpc.DialOption{grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	})}
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	})
+	if !strings.HasPrefix(out, "test_node_1 ") {
+		t.Errorf("want the client status of test_node_1, got\n%v", out)
+	}
+
+	interval, timeout := opts, opts
+	interval.KeepaliveInterval = -time.Second
+	timeout.KeepaliveTimeout = -time.Second
+	for _, test := range []struct {
+		opts client.ClientOptions
+		want string
+	}{
+		{opts: interval, want: "keepalive_interval must not be negative"},
+		{opts: timeout, want: "keepalive_timeout must not be negative"},
+	} {
+		if _, err := New(test.opts); err == nil || err.Error() != test.want {
+			t.Errorf("want error %q, got %v", test.want, err)
+		}
+	}
+}
+
+// TestCheckConnectivity tests the outcomes of -check_connectivity against an in-process server
+func TestCheckConnectivity(t *testing.T) {
+	unclassified := errors.New("unclassified")
+	tests := []struct {
+		name      string
+		authnMode string
+		serverErr error
+		down      bool
+		want      string
+		wantErr   error
+	}{
+		{name: "ok", authnMode: "insecure", want: "OK: bufnet is reachable and authenticated the request, 1 clients connected\n"},
+		{name: "permission denied", authnMode: "insecure", serverErr: status.Error(codes.PermissionDenied, "caller not allowed"), wantErr: client.ErrAuthFailed},
+		{name: "unauthenticated", authnMode: "insecure", serverErr: status.Error(codes.Unauthenticated, "missing token"), wantErr: client.ErrAuthFailed},
+		{name: "missing credentials", authnMode: "mtls", wantErr: client.ErrAuthFailed},
+		{name: "unreachable", authnMode: "insecure", down: true, wantErr: client.ErrUnreachable},
+		{name: "unimplemented", authnMode: "insecure", serverErr: status.Error(codes.Unimplemented, "no csds"), wantErr: unclassified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener := bufconn.Listen(1024 * 1024)
+			server := grpc.NewServer()
+			fake := &flakyCsdsServer{err: tt.serverErr}
+			if tt.serverErr != nil {
+				fake.failures = 1
+			}
+			fake.response = parseResponse(t, `{"config": [{"node": {"id": "test_node_1"}}]}`)
+			csdspb_v3.RegisterClientStatusDiscoveryServiceServer(server, fake)
+			go server.Serve(listener)
+			defer server.Stop()
+
+			c, err := New(client.ClientOptions{
+				Uri:               "bufnet",
+				Platform:          "local",
+				AuthnMode:         tt.authnMode,
+				ClientCert:        filepath.Join(os.TempDir(), "csds-missing-cert.pem"),
+				ClientKey:         filepath.Join(os.TempDir(), "csds-missing-key.pem"),
+				RequestYaml:       "{node: {id: fake_client}}",
+				CheckConnectivity: true,
+				ConnectTimeout:    100 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatalf("New client error: %v", err)
+			}
+			c.dialOptions = []grpc.DialOption{grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				if tt.down {
+					return nil, errors.New("connection refused")
+				}
+				return listener.DialContext(ctx)
+			})}
+
+			var runErr error
+			out := clientUtil.CaptureOutput(func() {
+				runErr = c.Run()
+			})
+			switch {
+			case tt.wantErr == nil:
+				if runErr != nil || out != tt.want {
+					t.Errorf("want %q, got %q and error %v", tt.want, out, runErr)
+				}
+			case tt.wantErr == unclassified:
+				if runErr == nil || errors.Is(runErr, client.ErrAuthFailed) || errors.Is(runErr, client.ErrUnreachable) {
+					t.Errorf("want an unclassified error, got %v", runErr)
+				}
+			case !errors.Is(runErr, tt.wantErr):
+				t.Errorf("want error %v, got %v", tt.wantErr, runErr)
+			}
+			if tt.wantErr != nil && strings.Contains(out, "Client ID") {
+				t.Errorf("want nothing rendered, got\n%v", out)
+			}
+		})
+	}
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", RequestYaml: "{}", CheckConnectivity: true, MonitorInterval: time.Second}); err == nil {
+		t.Errorf("want error for check_connectivity in monitor mode")
+	}
+}
+
+// TestAdcMissingCredentials tests that the adc authn_mode tells how to set up the credentials if none are found
+func TestAdcMissingCredentials(t *testing.T) {
+	defer os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(os.TempDir(), "csds-missing-credentials.json"))
+
+	_, err := clientUtil.ConnToGCPWithAdc(context.Background(), "trafficdirector.googleapis.com:443", "", time.Second)
+	if err == nil || !strings.Contains(err.Error(), "gcloud auth application-default login") {
+		t.Errorf("want an error suggesting gcloud auth application-default login, got %v", err)
+	}
+}
+
+// TestConnWithAuthPlatforms tests which authn modes connect to the endpoints of which platforms, and that
+// only gcp endpoints get the x-goog-user-project header
+func TestConnWithAuthPlatforms(t *testing.T) {
+	defer os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(os.TempDir(), "csds-missing-credentials.json"))
+
+	var nms []*envoy_type_matcher_v3.NodeMatcher
+	requestYaml := `{"node_matchers": [{"node_metadatas": [{"path": [{"key": "TRAFFICDIRECTOR_GCP_PROJECT_NUMBER"}], "value": {"string_match": {"exact": "123456789"}}}]}]}`
+	if err := parseYaml("", requestYaml, &nms, &envoy_config_core_v3.Node{}, false); err != nil {
+		t.Fatalf("Parse yaml error: %v", err)
+	}
+
+	tests := []struct {
+		platform    string
+		authnMode   string
+		supported   bool
+		userProject bool
+	}{
+		{platform: "gcp", authnMode: "auto", supported: true, userProject: true},
+		{platform: "gcp", authnMode: "adc", supported: true, userProject: true},
+		{platform: "gcp", authnMode: "sa", supported: true, userProject: true},
+		{platform: "gcp", authnMode: "jwt", supported: true},
+		{platform: "gcp", authnMode: "mtls", supported: true},
+		{platform: "gcp", authnMode: "insecure", supported: true},
+		{platform: "aws", authnMode: "auto", supported: true},
+		{platform: "aws", authnMode: "adc"},
+		{platform: "aws", authnMode: "sa"},
+		{platform: "aws", authnMode: "jwt"},
+		{platform: "aws", authnMode: "mtls", supported: true},
+		{platform: "local", authnMode: "insecure", supported: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.platform+"/"+tt.authnMode, func(t *testing.T) {
+			if got := supportsPlatform(tt.authnMode, tt.platform); got != tt.supported {
+				t.Errorf("want supported %v, got %v", tt.supported, got)
+			}
+			_, err := New(client.ClientOptions{Platform: tt.platform, AuthnMode: tt.authnMode})
+			if rejected := err != nil && strings.Contains(err.Error(), "platform is not supported"); rejected == tt.supported {
+				t.Errorf("want the platform rejected by New %v, got %v", !tt.supported, err)
+			}
+
+			c := &ClientV3{
+				opts:        client.ClientOptions{Platform: tt.platform, AuthnMode: tt.authnMode, ConnectTimeout: 50 * time.Millisecond},
+				nodeMatcher: nms,
+			}
+			err = c.connWithAuth(context.Background(), endpoint{uri: "localhost:1", platform: tt.platform})
+			if err == nil {
+				c.clientConn.Close()
+			}
+			if rejected := err != nil && strings.Contains(err.Error(), "platform is not supported"); rejected == tt.supported {
+				t.Errorf("want the endpoint rejected %v, got %v", !tt.supported, err)
+			}
+			if got := len(c.metadata.Get("x-goog-user-project")) > 0; got != tt.userProject {
+				t.Errorf("want the x-goog-user-project header %v, got %v", tt.userProject, c.metadata)
+			}
+		})
+	}
+}
+
+// TestServiceAccountInvalidFile tests that the sa authn_mode names the key file it fails to parse, without its content
+func TestServiceAccountInvalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds-sa")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key.json")
+	if err := ioutil.WriteFile(path, []byte(`{"type": "service_account", "private_key": "secret_value"`), 0600); err != nil {
+		t.Fatalf("Write key file failure: %v", err)
+	}
+
+	_, err = clientUtil.ConnToGCPWithServiceAccount(context.Background(), path, "trafficdirector.googleapis.com:443", "", time.Second)
+	if err == nil || !strings.Contains(err.Error(), path) || strings.Contains(err.Error(), "secret_value") {
+		t.Errorf("want an error naming %s without the key, got %v", path, err)
+	}
+}
+
+// TestReadJwt tests reading the jwt key from the environment, stdin or a file, without the key in the errors
+func TestReadJwt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds-jwt")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	key := `{"type": "service_account", "private_key": "secret_value"}`
+	path := filepath.Join(dir, "key.json")
+	if err := ioutil.WriteFile(path, []byte(key), 0600); err != nil {
+		t.Fatalf("Write key file failure: %v", err)
+	}
+	defer os.Unsetenv("CSDS_TEST_JWT")
+	os.Setenv("CSDS_TEST_JWT", key)
+
+	stdin := os.Stdin
+	defer func() { os.Stdin = stdin }()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open key file failure: %v", err)
+	}
+	defer f.Close()
+	os.Stdin = f
+
+	for _, tt := range []struct {
+		path    string
+		env     string
+		wantErr string
+	}{
+		{path: path},
+		{path: "-"},
+		{env: "CSDS_TEST_JWT"},
+		{path: path, env: "CSDS_TEST_JWT", wantErr: "cannot both be set"},
+		{env: "CSDS_TEST_MISSING_JWT", wantErr: "jwt_env CSDS_TEST_MISSING_JWT is not set"},
+		{wantErr: "missing jwt file"},
+	} {
+		jwt, err := clientUtil.ReadJwt(tt.path, tt.env)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || strings.Contains(err.Error(), "secret_value") {
+				t.Errorf("path %q env %q: want an error %q without the key, got %v", tt.path, tt.env, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil || string(jwt) != key {
+			t.Errorf("path %q env %q: want the key, got %v", tt.path, tt.env, err)
+		}
+	}
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", AuthnMode: "auto", JwtEnv: "CSDS_TEST_JWT", RequestFile: "./test_request.yaml"}); err == nil || !strings.Contains(err.Error(), "jwt_env can only be used with the jwt authentication mode") {
+		t.Errorf("want an error for jwt_env without the jwt authentication mode, got %v", err)
+	}
+}
+
+// TestRootCAs tests that ca_cert replaces the system roots with its certificates, and that it must hold at least one
+func TestRootCAs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csds-ca")
+	if err != nil {
+		t.Fatalf("Create temp dir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Generate key failure: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "internal-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Create certificate failure: %v", err)
+	}
+	caPath := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Write ca_cert file failure: %v", err)
+	}
+	invalidPath := filepath.Join(dir, "invalid.pem")
+	if err := ioutil.WriteFile(invalidPath, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Write ca_cert file failure: %v", err)
+	}
+
+	pool, err := clientUtil.RootCAs(caPath)
+	if err != nil {
+		t.Fatalf("Load ca_cert failure: %v", err)
+	}
+	if subjects := pool.Subjects(); len(subjects) != 1 {
+		t.Errorf("want the only certificate of ca_cert in the pool, got %d", len(subjects))
+	}
+	if _, err := clientUtil.RootCAs(""); err != nil {
+		t.Errorf("want the system roots without ca_cert, got %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: filepath.Join(dir, "missing.pem"), want: "unable to read ca_cert file"},
+		{path: invalidPath, want: "no certificate found in ca_cert file " + invalidPath},
+	}
+	for _, test := range tests {
+		if _, err := clientUtil.RootCAs(test.path); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("want error %q, got %v", test.want, err)
+		}
+		// the GCP helpers fail on ca_cert before looking for credentials
+		_, err := clientUtil.ConnWithAuto(context.Background(), "trafficdirector.googleapis.com:443", test.path, time.Second)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("want error %q, got %v", test.want, err)
+		}
+	}
+}
+
+// TestConnectTimeout tests that a dial to an unresponsive server fails after connect_timeout
+func TestConnectTimeout(t *testing.T) {
+	// the dialer never connects, like a server behind a dropped route
+	blocked := grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	start := time.Now()
+	_, err := clientUtil.ConnInsecure(context.Background(), "unreachable:443", 50*time.Millisecond, blocked)
+	if err == nil || !strings.Contains(err.Error(), "dial to unreachable:443 timed out after 50ms") {
+		t.Errorf("want the dial to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("want the dial to fail after about 50ms, took %v", elapsed)
+	}
+}
+
+// startConnectProxy starts an HTTP CONNECT proxy that tunnels to the requested address, or refuses
+// with status if it's not 200. It returns the address of the proxy and the requested addresses.
+func startConnectProxy(t *testing.T, status int) (string, chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failure: %v", err)
+	}
+	targets := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				targets <- req.Host
+				if status != http.StatusOK {
+					fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+					return
+				}
+				backend, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					return
+				}
+				defer backend.Close()
+				fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go io.Copy(backend, conn)
+				io.Copy(conn, backend)
+			}()
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String(), targets
+}
+
+// TestProxy tests tunneling the connection through an HTTP CONNECT proxy, and telling the proxy failures apart
+func TestProxy(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failure: %v", err)
+	}
+	server := grpc.NewServer()
+	fake := &fakeCsdsServer{response: parseResponse(t, `{"config": [{"node": {"id": "test_node_1"}}]}`)}
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(server, fake)
+	go server.Serve(listener)
+	defer server.Stop()
+	opts := client.ClientOptions{
+		Uri:          listener.Addr().String(),
+		Platform:     "local",
+		AuthnMode:    "insecure",
+		RequestYaml:  "{node: {id: fake_client}}",
+		OutputFormat: "compact",
+	}
+
+	proxy, targets := startConnectProxy(t, http.StatusOK)
+	opts.Proxy = "http://" + proxy
+	c, err := New(opts)
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	})
+	if target := <-targets; target != listener.Addr().String() || len(fake.requests) != 1 {
+		t.Errorf("want one request tunneled to %s, got %d to %s", listener.Addr(), len(fake.requests), target)
+	}
+
+	refusing, _ := startConnectProxy(t, http.StatusForbidden)
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failure: %v", err)
+	}
+	closed.Close()
+	tests := []struct {
+		proxy string
+		want  string
+	}{
+		{proxy: "http://" + refusing, want: "proxy " + refusing + " refused to connect to " + listener.Addr().String() + ": 403 Forbidden"},
+		{proxy: "http://" + closed.Addr().String(), want: "unable to connect to proxy " + closed.Addr().String()},
+	}
+	for _, test := range tests {
+		opts.Proxy = test.proxy
+		opts.ConnectTimeout = time.Second
+		c, err := New(opts)
+		if err != nil {
+			t.Fatalf("New client error: %v", err)
+		}
+		err = c.Run()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("proxy %s: want error %q, got %v", test.proxy, test.want, err)
+		}
+	}
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", Proxy: "ftp://proxy:21"}); err == nil {
+		t.Errorf("want the ftp proxy scheme to be rejected")
+	}
+}
+
+// startFakeCsdsServer serves fake on a local port and returns its address
+func startFakeCsdsServer(t *testing.T, fake *fakeCsdsServer) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failure: %v", err)
+	}
+	server := grpc.NewServer()
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(server, fake)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+// TestMultipleEndpoints tests merging the responses of several uris, with one of them failing
+func TestMultipleEndpoints(t *testing.T) {
+	first := startFakeCsdsServer(t, &fakeCsdsServer{response: parseResponse(t, `{"config": [
+		{"node": {"id": "test_node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "SYNCED"}]}]}`)})
+	second := startFakeCsdsServer(t, &fakeCsdsServer{response: parseResponse(t, `{"config": [
+		{"node": {"id": "test_node_2"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "ERROR"}]}]}`)})
+	// a port nothing listens on anymore
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failure: %v", err)
+	}
+	failing := listener.Addr().String()
+	listener.Close()
+
+	opts := client.ClientOptions{
+		Uri:            strings.Join([]string{first, failing, second}, ","),
+		Platform:       "local",
+		AuthnMode:      "insecure",
+		RequestYaml:    "{node: {id: fake_client}}",
+		OutputFormat:   "compact",
+		ConnectTimeout: 500 * time.Millisecond,
+	}
+	c, err := New(opts)
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	var runErr error
+	out := clientUtil.CaptureOutput(func() {
+		runErr = c.Run()
+	})
+	if runErr == nil || runErr.Error() != "1 of 3 endpoints failed" {
+		t.Errorf("want error 1 of 3 endpoints failed, got %v", runErr)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("%-40s %-50s C:S L:- R:- S:- E:-\n", first, "test_node_1"),
+		fmt.Sprintf("%-40s %-50s C:E L:- R:- S:- E:-\n", second, "test_node_2"),
+		fmt.Sprintf("%-40s ERROR: local endpoint %s: ", failing, failing),
+		"Clients: 2  SYNCED: 1  ERROR: 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q in\n%v", want, out)
+		}
+	}
+
+	// the structured formats tell the clients apart by their endpoint field
+	opts.Uri = strings.Join([]string{first, second}, ",")
+	opts.OutputFormat = "json"
+	if c, err = New(opts); err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	out = clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	})
+	for _, want := range []string{
+		fmt.Sprintf(`"endpoint": %q,
+      "client_id": "test_node_1"`, first),
+		fmt.Sprintf(`"endpoint": %q,
+      "client_id": "test_node_2"`, second),
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q in\n%v", want, out)
+		}
+	}
+
+	opts.OutputFormat = "matrix"
+	if _, err := New(opts); err == nil {
+		t.Errorf("want error for the matrix output format with several uris")
+	}
+}
+
+// hangingCsdsServer is a csds server that receives the requests but never responds
+type hangingCsdsServer struct {
+	csdspb_v3.UnimplementedClientStatusDiscoveryServiceServer
+}
+
+func (s *hangingCsdsServer) StreamClientStatus(stream csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusServer) error {
+	<-stream.Context().Done()
+	return stream.Context().Err()
+}
+
+// TestEndpointTimeout tests that an endpoint not responding within its deadline is shown as timed out,
+// without holding the output of the others
+func TestEndpointTimeout(t *testing.T) {
+	fake := &fakeCsdsServer{response: parseResponse(t, `{"config": [
+		{"node": {"id": "test_node_1"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "SYNCED"}]}]}`)}
+	healthy := startFakeCsdsServer(t, fake)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failure: %v", err)
+	}
+	server := grpc.NewServer()
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(server, &hangingCsdsServer{})
+	go server.Serve(listener)
+	defer server.Stop()
+	hanging := listener.Addr().String()
+
+	opts := client.ClientOptions{
+		Uri:             strings.Join([]string{healthy, hanging}, ","),
+		Platform:        "local",
+		AuthnMode:       "insecure",
+		RequestYaml:     "{node: {id: fake_client}}",
+		OutputFormat:    "compact",
+		ConnectTimeout:  200 * time.Millisecond,
+		RequestTimeout:  200 * time.Millisecond,
+		MonitorInterval: 10 * time.Millisecond,
+		MonitorCount:    2,
+	}
+	c, err := New(opts)
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	start := time.Now()
+	var runErr error
+	out := clientUtil.CaptureOutput(func() {
+		runErr = c.Run()
+	})
+	if runErr == nil || runErr.Error() != "1 of 2 endpoints failed, 1 timed out" {
+		t.Errorf("want error 1 of 2 endpoints failed, 1 timed out, got %v", runErr)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("want each cycle bound to the deadline of the endpoints, took %v", elapsed)
+	}
+	row := fmt.Sprintf("%-40s %-50s C:S L:- R:- S:- E:-\n", healthy, "test_node_1")
+	timedOut := fmt.Sprintf("%-40s TIMEOUT: no response within 400ms\n", hanging)
+	if strings.Count(out, row) != 2 || strings.Count(out, timedOut) != 2 {
+		t.Errorf("want the client of %s and the timeout of %s in each cycle, got\n%v", healthy, hanging, out)
+	}
+	// the stream of the endpoint that responded is kept for the next cycle
+	if len(fake.requests) != 2 {
+		t.Errorf("want 2 requests to %s, got %d", healthy, len(fake.requests))
+	}
+
+	opts.RequestTimeout = -time.Second
+	if _, err := New(opts); err == nil {
+		t.Errorf("want error for a negative request_timeout")
+	}
+}
+
+// flakyCsdsServer is a fakeCsdsServer that fails its first failures streams with err
+type flakyCsdsServer struct {
+	fakeCsdsServer
+	failures int
+	err      error
+	streams  int
+}
+
+func (s *flakyCsdsServer) StreamClientStatus(stream csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusServer) error {
+	s.streams++
+	if s.streams <= s.failures {
+		return s.err
+	}
+	return s.fakeCsdsServer.StreamClientStatus(stream)
+}
+
+// TestRetry tests retrying the transient errors of a request with backoff, up to max_retries
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		failures   int
+		maxRetries int
+		quiet      bool
+		wantErr    codes.Code
+		wantStream int
+	}{
+		{name: "unavailable", err: status.Error(codes.Unavailable, "restarting"), failures: 2, maxRetries: 3, wantErr: codes.OK, wantStream: 3},
+		{name: "deadline exceeded", err: status.Error(codes.DeadlineExceeded, "timeout"), failures: 1, maxRetries: 1, wantErr: codes.OK, wantStream: 2},
+		{name: "security policy", err: status.Error(codes.PermissionDenied, "closed by RpcSecurityPolicy"), failures: 1, maxRetries: 1, wantErr: codes.OK, wantStream: 2},
+		{name: "retries exhausted", err: status.Error(codes.Unavailable, "restarting"), failures: 3, maxRetries: 2, wantErr: codes.Unavailable, wantStream: 3},
+		{name: "disabled", err: status.Error(codes.Unavailable, "restarting"), failures: 1, maxRetries: 0, wantErr: codes.Unavailable, wantStream: 1},
+		{name: "not retryable", err: status.Error(codes.PermissionDenied, "denied"), failures: 1, maxRetries: 3, wantErr: codes.PermissionDenied, wantStream: 1},
+		{name: "quiet", err: status.Error(codes.Unavailable, "restarting"), failures: 1, maxRetries: 1, quiet: true, wantErr: codes.OK, wantStream: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener := bufconn.Listen(1024 * 1024)
+			server := grpc.NewServer()
+			fake := &flakyCsdsServer{failures: tt.failures, err: tt.err}
+			fake.response = parseResponse(t, `{"config": [{"node": {"id": "test_node_1"}}]}`)
+			csdspb_v3.RegisterClientStatusDiscoveryServiceServer(server, fake)
+			go server.Serve(listener)
+			defer server.Stop()
+
+			c, err := New(client.ClientOptions{
+				Uri:          "bufnet",
+				Platform:     "local",
+				AuthnMode:    "insecure",
+				RequestYaml:  "{node: {id: fake_client}}",
+				OutputFormat: "compact",
+				MaxRetries:   tt.maxRetries,
+				Quiet:        tt.quiet,
+			})
+			if err != nil {
+				t.Fatalf("New client error: %v", err)
+			}
+			c.dialOptions = []grpc.DialOption{grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			})}
+			c.backoff = clientUtil.Backoff{Base: time.Millisecond, Max: 4 * time.Millisecond, Multiplier: 2}
+
+			var runErr error
+			out := clientUtil.CaptureOutput(func() {
+				runErr = c.Run()
+			})
+			if status.Code(runErr) != tt.wantErr {
+				t.Errorf("want error code %v, got %v", tt.wantErr, runErr)
+			}
+			if fake.streams != tt.wantStream {
+				t.Errorf("want %d streams, got %d", tt.wantStream, fake.streams)
+			}
+			// the retries are noted unless -quiet is set
+			if retried := strings.Contains(out, "retrying the request"); retried != (tt.wantStream > 1 && !tt.quiet) {
+				t.Errorf("want the retries noted %v, got\n%v", !retried, out)
+			}
+		})
+	}
+}
+
+// TestRequestRetries tests that a transient failure of a request is retried on a new stream of the same
+// connection with request_retries, without the reopen of max_retries
+func TestRequestRetries(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	fake := &flakyCsdsServer{failures: 1, err: status.Error(codes.Unavailable, "overloaded")}
+	fake.response = parseResponse(t, `{"config": [{"node": {"id": "test_node_1"}}]}`)
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(server, fake)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	opts := client.ClientOptions{
+		Uri:            "bufnet",
+		Platform:       "local",
+		AuthnMode:      "insecure",
+		RequestYaml:    "{node: {id: fake_client}}",
+		OutputFormat:   "compact",
+		RequestRetries: 1,
+	}
+	c, err := New(opts)
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	c.dialOptions = []grpc.DialOption{grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	})}
+	c.backoff = clientUtil.Backoff{Base: time.Millisecond, Max: 4 * time.Millisecond, Multiplier: 2}
+
+	var runErr error
+	out := clientUtil.CaptureOutput(func() {
+		runErr = c.Run()
+	})
+	if runErr != nil {
+		t.Fatalf("Run error: %v", runErr)
+	}
+	if !strings.Contains(out, "retrying the request on a new stream (1/1) after error: rpc error: code = Unavailable desc = overloaded") {
+		t.Errorf("want a note of the retry, got\n%v", out)
+	}
+	if !strings.Contains(out, "test_node_1 ") {
+		t.Errorf("want the client status of test_node_1, got\n%v", out)
+	}
+	if fake.streams != 2 || c.dials != 1 {
+		t.Errorf("want 2 streams on a single connection, got %d streams and %d connections", fake.streams, c.dials)
+	}
+
+	opts.RequestRetries = -1
+	if _, err := New(opts); err == nil || err.Error() != "request_retries must not be negative" {
+		t.Errorf("want error for a negative request_retries, got %v", err)
+	}
+}
+
+// TestReconnect tests that the monitor mode redials a control plane that restarted on a new listener, instead
+// of retrying on the failed connection until gRPC's own backoff elapses
+func TestReconnect(t *testing.T) {
+	first, second := bufconn.Listen(1024*1024), bufconn.Listen(1024*1024)
+	var mu sync.Mutex
+	listener := first
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if listener == nil {
+			return nil, errors.New("control plane is down")
+		}
+		return listener.DialContext(ctx)
+	}
+	response := parseResponse(t, `{"config": [{"node": {"id": "test_node_1"}}]}`)
+	firstServer := grpc.NewServer()
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(firstServer, &fakeCsdsServer{response: response})
+	go firstServer.Serve(first)
+	secondServer := grpc.NewServer()
+	restarted := &fakeCsdsServer{response: response}
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(secondServer, restarted)
+	defer secondServer.Stop()
+
+	c, err := New(client.ClientOptions{
+		Uri:             "bufnet",
+		Platform:        "local",
+		AuthnMode:       "insecure",
+		RequestYaml:     "{node: {id: fake_client}}",
+		OutputFormat:    "compact",
+		MonitorInterval: 200 * time.Millisecond,
+		MonitorCount:    2,
+		MaxRetries:      10,
+		Verbose:         true,
+	})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	c.dialOptions = []grpc.DialOption{grpc.WithContextDialer(dialer)}
+	c.backoff = clientUtil.Backoff{Base: 20 * time.Millisecond, Max: 60 * time.Millisecond, Multiplier: 2}
+
+	// the control plane restarts during the first monitor interval, and is down for a while
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		listener = nil
+		mu.Unlock()
+		firstServer.Stop()
+		time.Sleep(200 * time.Millisecond)
+		mu.Lock()
+		listener = second
+		mu.Unlock()
+		secondServer.Serve(second)
+	}()
+
+	var runErr error
+	out := clientUtil.CaptureOutput(func() {
+		runErr = c.Run()
+	})
+	if runErr != nil {
+		t.Fatalf("Run error: %v", runErr)
+	}
+	if !strings.Contains(out, "reconnecting to bufnet after the connection failed") || !strings.Contains(out, "Redialing bufnet: connection 1 is ") {
+		t.Errorf("want the connection to be redialed, got\n%v", out)
+	}
+	if c.dials != 2 || c.clientConn != nil {
+		t.Errorf("want 2 connections dialed and the last one closed, got %d dialed and %v", c.dials, c.clientConn)
+	}
+	if strings.Count(out, "test_node_1 ") != 2 {
+		t.Errorf("want the client status of both cycles, got\n%v", out)
+	}
+	if len(restarted.requests) != 1 {
+		t.Errorf("want the second request to reach the restarted server, got %d requests", len(restarted.requests))
+	}
+}
+
+// TestConnectionReuse tests that monitor mode sends the requests of all its cycles on a single connection,
+// which is closed once the run is done
+func TestConnectionReuse(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	fake := &fakeCsdsServer{response: parseResponse(t, `{"config": [{"node": {"id": "test_node_1"}}]}`)}
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(server, fake)
+	go server.Serve(listener)
+	defer server.Stop()
+	var mu sync.Mutex
+	var transports int
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		mu.Lock()
+		transports++
+		mu.Unlock()
+		return listener.DialContext(ctx)
+	}
+
+	c, err := New(client.ClientOptions{
+		Uri:             "bufnet",
+		Platform:        "local",
+		AuthnMode:       "insecure",
+		RequestYaml:     "{node: {id: fake_client}}",
+		OutputFormat:    "compact",
+		MonitorInterval: 10 * time.Millisecond,
+		MonitorCount:    5,
+		Verbose:         true,
+	})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	c.dialOptions = []grpc.DialOption{grpc.WithContextDialer(dialer)}
+	var runErr error
+	out := clientUtil.CaptureOutput(func() {
+		runErr = c.Run()
+	})
+	if runErr != nil {
+		t.Fatalf("Run error: %v", runErr)
+	}
+	if len(fake.requests) != 5 {
+		t.Errorf("want 5 requests, got %d", len(fake.requests))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if c.dials != 1 || transports != 1 {
+		t.Errorf("want a single connection across the cycles, got %d dialed and %d transports", c.dials, transports)
+	}
+	if c.clientConn != nil {
+		t.Errorf("want the connection to be closed after the run")
+	}
+	if strings.Contains(out, "Redialing") {
+		t.Errorf("want no redial, got\n%v", out)
+	}
+}
+
+// TestConnectClose tests that the calls of Fetch between Connect and Close share a single connection, and
+// that Close can be called several times, and after a failed Connect
+func TestConnectClose(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	fake := &fakeCsdsServer{response: parseResponse(t, `{"config": [{"node": {"id": "test_node_1"}}]}`)}
+	csdspb_v3.RegisterClientStatusDiscoveryServiceServer(server, fake)
+	go server.Serve(listener)
+	defer server.Stop()
+	var mu sync.Mutex
+	var transports int
+	down := false
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			return nil, errors.New("control plane is down")
+		}
+		transports++
+		return listener.DialContext(ctx)
+	}
+
+	c, err := New(client.ClientOptions{
+		Uri:            "bufnet",
+		Platform:       "local",
+		AuthnMode:      "insecure",
+		RequestYaml:    "{node: {id: fake_client}}",
+		ConnectTimeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	c.dialOptions = []grpc.DialOption{grpc.WithContextDialer(dialer)}
+	if err := c.Close(); err != nil {
+		t.Errorf("want no error closing a client never connected, got %v", err)
+	}
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		resp, err := c.Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("Fetch error: %v", err)
+		}
+		if len(resp.GetConfig()) != 1 {
+			t.Errorf("want the client of the response, got %v", resp)
+		}
+	}
+	mu.Lock()
+	if len(fake.requests) != 3 || c.dials != 1 || transports != 1 {
+		t.Errorf("want 3 requests on a single connection, got %d requests, %d dialed and %d transports", len(fake.requests), c.dials, transports)
+	}
+	down = true
+	mu.Unlock()
+	for i := 0; i < 2; i++ {
+		if err := c.Close(); err != nil {
+			t.Errorf("Close error: %v", err)
+		}
+	}
+	if c.clientConn != nil {
+		t.Errorf("want the connection to be closed")
+	}
+
+	var connectErr error
+	clientUtil.CaptureOutput(func() {
+		connectErr = c.Connect(context.Background())
+	})
+	if connectErr == nil {
+		t.Fatalf("want error connecting to a control plane that is down")
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("want no error closing after a failed Connect, got %v", err)
+	}
+
+	c.opts.Uri = "bufnet,bufnet2"
+	if err := c.Connect(context.Background()); err == nil {
+		t.Errorf("want error connecting to several uris")
+	}
+}
+
+// TestBackoffDelay tests that the delay of each retry is in the upper half of the capped exponential delay
+func TestBackoffDelay(t *testing.T) {
+	backoff := clientUtil.Backoff{Base: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if delay := backoff.Delay(attempt); delay < want/2 || delay > want {
+				t.Errorf("attempt %d: want a delay in [%v, %v], got %v", attempt, want/2, want, delay)
+			}
+		}
+	}
+}
+
+// TestIsRetryable tests the retry decision of synthetic errors
+func TestIsRetryable(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{name: "unavailable", ctx: context.Background(), err: status.Error(codes.Unavailable, "connection refused"), want: true},
+		{name: "deadline exceeded", ctx: context.Background(), err: status.Error(codes.DeadlineExceeded, "timeout"), want: true},
+		{name: "security policy", ctx: context.Background(), err: status.Error(codes.PermissionDenied, "stream closed by RpcSecurityPolicy"), want: true},
+		{name: "permission denied", ctx: context.Background(), err: status.Error(codes.PermissionDenied, "denied"), want: false},
+		{name: "invalid argument", ctx: context.Background(), err: status.Error(codes.InvalidArgument, "bad node matcher"), want: false},
+		{name: "not a status", ctx: context.Background(), err: errors.New("closed by RpcSecurityPolicy"), want: false},
+		{name: "canceled by the caller", ctx: canceled, err: status.Error(codes.Unavailable, "connection refused"), want: false},
+		{name: "no error", ctx: context.Background(), err: nil, want: false},
+	}
+	for _, tt := range tests {
+		if got := clientUtil.IsRetryable(tt.ctx, tt.err); got != tt.want {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+// TestSortClients tests the orders of -sort, with ties ordered by client id
+func TestSortClients(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_c", "metadata": {"XDS_STREAM_TYPE": "ADS"}}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "SYNCED"}]},
+		{"node": {"id": "node_a", "metadata": {"XDS_STREAM_TYPE": "SotW"}}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "STALE"}]},
+		{"node": {"id": "node_d", "metadata": {"XDS_STREAM_TYPE": "ADS"}}},
+		{"node": {"id": "node_b", "metadata": {"XDS_STREAM_TYPE": "ADS"}}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "configStatus": "ERROR"}]}]}`)
+	tests := []struct {
+		mode string
+		want []string
+	}{
+		{mode: "", want: []string{"node_a", "node_b", "node_c", "node_d"}},
+		{mode: "id", want: []string{"node_a", "node_b", "node_c", "node_d"}},
+		{mode: "status", want: []string{"node_b", "node_a", "node_c", "node_d"}},
+		{mode: "type", want: []string{"node_b", "node_c", "node_d", "node_a"}},
+		{mode: "none", want: []string{"node_c", "node_a", "node_d", "node_b"}},
+	}
+	for _, tt := range tests {
+		var ids []string
+		for _, config := range sortClientConfigs(response.GetConfig(), tt.mode) {
+			ids = append(ids, config.GetNode().GetId())
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("sort %q: want %v, got %v", tt.mode, tt.want, ids)
+		}
+	}
+	if response.GetConfig()[0].GetNode().GetId() != "node_c" {
+		t.Errorf("want the response left in the server order")
+	}
+
+	// the structured output formats are sorted too
+	var buf strings.Builder
+	if err := printOutResponse(&buf, response, client.ClientOptions{Platform: "gcp", OutputFormat: "csv", Sort: "status"}); err != nil {
+		t.Fatalf("Print out response error: %v", err)
+	}
+	if !strings.HasPrefix(strings.Split(buf.String(), "\n")[1], "node_b,") {
+		t.Errorf("want node_b first in\n%v", buf.String())
+	}
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", RequestYaml: "{}", Sort: "name"}); err == nil || !strings.Contains(err.Error(), "name sort mode is not supported") {
+		t.Errorf("want an unsupported sort mode error, got %v", err)
+	}
+}
+
+// TestLimitOffset tests paging through the sorted clients, with the summary counting all of them
+func TestLimitOffset(t *testing.T) {
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_c"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "SYNCED"}]},
+		{"node": {"id": "node_a"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "STALE"}]},
+		{"node": {"id": "node_d"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "SYNCED"}]},
+		{"node": {"id": "node_b"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "ERROR"}]}]}`)
+	tests := []struct {
+		name   string
+		offset int
+		limit  int
+		want   string
+	}{
+		{name: "page", offset: 1, limit: 2, want: `node_b                                             C:E L:- R:- S:- E:-
+node_c                                             C:S L:- R:- S:- E:-
+Showing 2 of 4 clients from offset 1
+Clients: 4  SYNCED: 2  STALE: 1  ERROR: 1
+`},
+		{name: "no limit", offset: 3, limit: 0, want: `node_d                                             C:S L:- R:- S:- E:-
+Showing 1 of 4 clients from offset 3
+Clients: 4  SYNCED: 2  STALE: 1  ERROR: 1
+`},
+		{name: "negative limit", offset: 0, limit: -1, want: `node_a                                             C:T L:- R:- S:- E:-
+node_b                                             C:E L:- R:- S:- E:-
+node_c                                             C:S L:- R:- S:- E:-
+node_d                                             C:S L:- R:- S:- E:-
+Clients: 4  SYNCED: 2  STALE: 1  ERROR: 1
+`},
+		{name: "past the end", offset: 4, limit: 2, want: `Showing 0 of 4 clients from offset 4
+Clients: 4  SYNCED: 2  STALE: 1  ERROR: 1
+`},
+	}
+	for _, tt := range tests {
+		var buf strings.Builder
+		opts := client.ClientOptions{Platform: "gcp", OutputFormat: "compact", Offset: tt.offset, Limit: tt.limit}
+		if err := printOutResponse(&buf, response, opts); err != nil {
+			t.Fatalf("%s: print out response error: %v", tt.name, err)
+		}
+		if !strings.HasPrefix(buf.String(), tt.want) {
+			t.Errorf("%s: want\n%vout\n%v", tt.name, tt.want, buf.String())
+		}
+	}
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", RequestYaml: "{}", Offset: -1}); err == nil || err.Error() != "offset must not be negative" {
+		t.Errorf("want a negative offset error, got %v", err)
+	}
+}
+
+// TestInputFile tests rendering a saved response offline, as printed by -dump_raw or in the text format
+func TestInputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "input_file")
+	if err != nil {
+		t.Fatalf("TempDir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_b"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "ERROR"}]},
+		{"node": {"id": "node_a"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "SYNCED"}]}]}`)
+	var raw strings.Builder
+	if err := printRawResponse(&raw, response, client.ClientOptions{}); err != nil {
+		t.Fatalf("printRawResponse error: %v", err)
+	}
+	jsonFile := filepath.Join(dir, "response.json")
+	textFile := filepath.Join(dir, "response.txt")
+	if err := ioutil.WriteFile(jsonFile, []byte(raw.String()), 0644); err != nil {
+		t.Fatalf("WriteFile failure: %v", err)
+	}
+	text := `config {
+  node { id: "node_b" }
+  generic_xds_configs { type_url: "type.googleapis.com/envoy.config.cluster.v3.Cluster" config_status: ERROR }
+}
+config {
+  node { id: "node_a" }
+  generic_xds_configs { type_url: "type.googleapis.com/envoy.config.cluster.v3.Cluster" config_status: SYNCED }
+}
+`
+	if err := ioutil.WriteFile(textFile, []byte(text), 0644); err != nil {
+		t.Fatalf("WriteFile failure: %v", err)
+	}
+
+	want := `node_a                                             C:S L:- R:- S:- E:-
+Clients: 1  SYNCED: 1
+`
+	for _, path := range []string{jsonFile, textFile} {
+		// no request yaml is needed, nor any platform field, as nothing is sent
+		c, err := New(client.ClientOptions{Platform: "gcp", InputFile: path, OutputFormat: "compact", FilterMode: "prefix", FilterPattern: "node_a"})
+		if err != nil {
+			t.Fatalf("New client error: %v", err)
+		}
+		out := clientUtil.CaptureOutput(func() {
+			if err := c.Run(); err != nil {
+				t.Errorf("Run error: %v", err)
+			}
+		})
+		if !strings.HasPrefix(out, want) {
+			t.Errorf("%s: want\n%vout\n%v", path, want, out)
+		}
+	}
+
+	// the checks of the response apply as well
+	c, err := New(client.ClientOptions{Platform: "gcp", InputFile: jsonFile, OutputFormat: "compact", FailOn: "ERROR"})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	var runErr error
+	clientUtil.CaptureOutput(func() {
+		runErr = c.Run()
+	})
+	var statusErr *client.StatusError
+	if !errors.As(runErr, &statusErr) {
+		t.Errorf("want a StatusError, got %v", runErr)
+	}
+
+	for _, tt := range []struct {
+		opts client.ClientOptions
+		want string
+	}{
+		{opts: client.ClientOptions{Platform: "gcp", InputFile: jsonFile, Uri: "localhost:18000"}, want: "input_file cannot be used with service_uri"},
+		{opts: client.ClientOptions{Platform: "gcp", InputFile: jsonFile, MonitorInterval: time.Second}, want: "input_file cannot be used in monitor mode"},
+	} {
+		if _, err := New(tt.opts); err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("want error %q, got %v", tt.want, err)
+		}
+	}
+	if _, err := loadResponse(filepath.Join(dir, "missing.json")); err == nil || !strings.HasPrefix(err.Error(), "unable to read input_file") {
+		t.Errorf("want a read error, got %v", err)
+	}
+}
+
+// TestDryRun tests printing the request built from the request yaml without connecting to the server
+func TestDryRun(t *testing.T) {
+	c, err := New(client.ClientOptions{
+		Uri:         "localhost:1",
+		Platform:    "gcp",
+		AuthnMode:   "insecure",
+		RequestFile: "./test_request.yaml",
+		RequestYaml: "{\"node\": {\"id\": \"fake_node_id_from_cli\"}}",
+		DryRun:      true,
+	})
+	if err != nil {
+		t.Fatalf("New client error: %v", err)
+	}
+	out := clientUtil.CaptureOutput(func() {
+		if err := c.Run(); err != nil {
+			t.Errorf("Run error: %v", err)
+		}
+	})
+	want := `{
+  "nodeMatchers": [
+    {
+      "nodeId": {
+        "exact": "fake_node_id"
+      },
+      "nodeMetadatas": [
+        {
+          "path": [
+            {
+              "key": "TRAFFICDIRECTOR_GCP_PROJECT_NUMBER"
+            }
+          ],
+          "value": {
+            "stringMatch": {
+              "exact": "fake_project_number"
+            }
+          }
+        },
+        {
+          "path": [
+            {
+              "key": "TRAFFICDIRECTOR_NETWORK_NAME"
+            }
+          ],
+          "value": {
+            "stringMatch": {
+              "exact": "fake_network_name"
+            }
+          }
+        }
+      ]
+    }
+  ],
+  "node": {
+    "id": "fake_node_id_from_cli"
+  }
+}
+`
+	if out != want {
+		t.Errorf("want\n%vout\n%v", want, out)
+	}
+	if c.clientConn != nil {
+		t.Errorf("want no connection in dry run")
+	}
+
+	// the request is still validated
+	if _, err := New(client.ClientOptions{Platform: "gcp", RequestYaml: "{\"node\": {\"id\": \"fake_node_id\"}}", DryRun: true}); err == nil || !strings.Contains(err.Error(), "missing field TRAFFICDIRECTOR_GCP_PROJECT_NUMBER") {
+		t.Errorf("want an error for the missing project number, got %v", err)
+	}
+}
+
+// TestQuiet tests printing nothing but the error of -fail_on, with the same checks as without -quiet
+func TestQuiet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quiet")
+	if err != nil {
+		t.Fatalf("TempDir failure: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	responses := map[string]string{
+		"error.json": `{"config": [
+			{"node": {"id": "node_a"}, "genericXdsConfigs": [
+				{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "ERROR"}]}]}`,
+		"synced.json": `{"config": [
+			{"node": {"id": "node_a"}, "genericXdsConfigs": [
+				{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "SYNCED"}]}]}`,
+		"empty.json": `{}`,
+	}
+	for name, response := range responses {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(response), 0644); err != nil {
+			t.Fatalf("WriteFile failure: %v", err)
+		}
+	}
+
+	tests := []struct {
+		file         string
+		outputFormat string
+		wantStatus   bool
+	}{
+		{file: "error.json", wantStatus: true},
+		{file: "synced.json"},
+		{file: "synced.json", outputFormat: "json"},
+		{file: "empty.json"},
+	}
+	for _, tt := range tests {
+		c, err := New(client.ClientOptions{
+			Platform:     "gcp",
+			InputFile:    filepath.Join(dir, tt.file),
+			OutputFormat: tt.outputFormat,
+			FilterMode:   "prefix",
+			FailOn:       "ERROR",
+			Quiet:        true,
+		})
+		if err != nil {
+			t.Fatalf("New client error: %v", err)
+		}
+		var runErr error
+		out := clientUtil.CaptureOutput(func() {
+			runErr = c.Run()
+		})
+		if out != "" {
+			t.Errorf("%s: want no output, got\n%v", tt.file, out)
+		}
+		var statusErr *client.StatusError
+		if errors.As(runErr, &statusErr) != tt.wantStatus {
+			t.Errorf("%s: want a StatusError %v, got %v", tt.file, tt.wantStatus, runErr)
+		}
+	}
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", InputFile: "response.json", Quiet: true, ConfigFile: "out.txt"}); err == nil {
+		t.Errorf("want an error for quiet with output_file")
+	}
+}
+
+// TestMonitorMetrics tests the metrics served on -metrics_addr
+func TestMonitorMetrics(t *testing.T) {
+	m := newMonitorMetrics()
+	response := parseResponse(t, `{"config": [
+		{"node": {"id": "node_a"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "SYNCED"},
+			{"typeUrl": "type.googleapis.com/envoy.config.listener.v3.Listener", "configStatus": "ERROR"}]},
+		{"node": {"id": "node_b"}, "genericXdsConfigs": [
+			{"typeUrl": "type.googleapis.com/envoy.config.cluster.v3.Cluster", "configStatus": "SYNCED"}]}]}`)
+	m.record(response.GetConfig())
+	// the last response replaces the counts of the previous one
+	m.record(response.GetConfig()[1:])
+	m.observeLatency(20 * time.Millisecond)
+	m.retried()
+	m.retried()
+
+	server := httptest.NewServer(m.handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get metrics error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Read metrics error: %v", err)
+	}
+	for _, want := range []string{
+		"csds_client_clients 1\n",
+		`csds_client_resources{status="SYNCED"} 1` + "\n",
+		`csds_client_resources{status="ERROR"} 0` + "\n",
+		"csds_client_request_duration_seconds_count 1\n",
+		"csds_client_retries_total 2\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("want %q in\n%s", want, body)
+		}
+	}
+
+	// a nil metrics, without -metrics_addr, records nothing
+	var disabled *monitorMetrics
+	disabled.record(response.GetConfig())
+	disabled.observeLatency(time.Second)
+	disabled.retried()
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", RequestYaml: "{}", MetricsAddr: ":9090"}); err == nil || err.Error() != "metrics_addr can only be used in monitor mode" {
+		t.Errorf("want a monitor mode error, got %v", err)
+	}
+}
+
+// TestRequestTiming tests the dial and round trip times printed by -timing
+func TestRequestTiming(t *testing.T) {
+	var buf strings.Builder
+	timing := &requestTiming{label: "localhost:18000", w: &buf}
+	timing.dialed(5 * time.Millisecond)
+	for _, d := range []time.Duration{10, 30, 20} {
+		timing.observe(d * time.Millisecond)
+	}
+	want := `Timing of localhost:18000: dial 5ms
+Timing of localhost:18000: request 10ms
+Timing of localhost:18000: request 30ms (min 10ms, avg 20ms, max 30ms over 2 requests)
+Timing of localhost:18000: request 20ms (min 10ms, avg 20ms, max 30ms over 3 requests)
+`
+	if buf.String() != want {
+		t.Errorf("want\n%vout\n%v", want, buf.String())
+	}
+
+	// fetch times the round trip of each request
+	buf.Reset()
+	c := &ClientV3{opts: client.ClientOptions{Platform: "gcp"}, timing: &requestTiming{w: &buf}}
+	stream := &fakeStream{responses: []*csdspb_v3.ClientStatusResponse{{}}}
+	if _, err := c.fetch(context.Background(), stream); err != nil {
+		t.Fatalf("fetch error: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "Timing: request ") || strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("want a single request timing, got %q", buf.String())
+	}
+}
+
+// TestResolveAny tests decoding the google.protobuf.Any configs of the detailed config, and printing the
+// unknown ones in base64 with their type url
+func TestResolveAny(t *testing.T) {
+	typedStruct, err := anypb.New(&xds_type_v3.TypedStruct{
+		TypeUrl: "type.googleapis.com/example.CustomFilter",
+		Value:   &structpb.Struct{Fields: map[string]*structpb.Value{"key": structpb.NewStringValue("custom_value")}},
+	})
+	if err != nil {
+		t.Fatalf("Marshal any error: %v", err)
+	}
+	listener, err := anypb.New(&envoy_config_listener_v3.Listener{
+		Name: "listener_1",
+		ListenerFilters: []*envoy_config_listener_v3.ListenerFilter{
+			{Name: "typed", ConfigType: &envoy_config_listener_v3.ListenerFilter_TypedConfig{TypedConfig: typedStruct}},
+			{Name: "unknown", ConfigType: &envoy_config_listener_v3.ListenerFilter_TypedConfig{TypedConfig: &anypb.Any{
+				TypeUrl: "type.googleapis.com/example.UnknownFilter",
+				Value:   []byte("opaque"),
+			}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Marshal any error: %v", err)
+	}
+	response := &csdspb_v3.ClientStatusResponse{Config: []*csdspb_v3.ClientConfig{{
+		Node: &envoy_config_core_v3.Node{Id: "node_1"},
+		GenericXdsConfigs: []*csdspb_v3.ClientConfig_GenericXdsConfig{{
+			TypeUrl:   "type.googleapis.com/envoy.config.listener.v3.Listener",
+			Name:      "listener_1",
+			XdsConfig: listener,
+		}},
+	}}}
+	original := proto.Clone(response)
+
+	tests := []struct {
+		resolveAny bool
+		want       []string
+		notWant    []string
+	}{
+		{
+			resolveAny: true,
+			want: []string{
+				`"listenerFilters"`,
+				`"@type": "type.googleapis.com/xds.type.v3.TypedStruct"`,
+				`"typeUrl": "type.googleapis.com/example.CustomFilter"`,
+				`"key": "custom_value"`,
+				`"@type": "type.googleapis.com/example.UnknownFilter"`,
+				`"value": "b3BhcXVl"`,
+			},
+		},
+		{
+			want:    []string{`"@type": "type.googleapis.com/envoy.config.listener.v3.Listener",`},
+			notWant: []string{"listenerFilters", "custom_value"},
+		},
+	}
+	for _, test := range tests {
+		out, err := clientUtil.MarshalDetailedConfig(response, 1, test.resolveAny)
+		if err != nil {
+			t.Fatalf("resolve_any %v: MarshalDetailedConfig error: %v", test.resolveAny, err)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(string(out), want) {
+				t.Errorf("resolve_any %v: want %s in\n%s", test.resolveAny, want, out)
+			}
+		}
+		for _, notWant := range test.notWant {
+			if strings.Contains(string(out), notWant) {
+				t.Errorf("resolve_any %v: want no %s in\n%s", test.resolveAny, notWant, out)
+			}
+		}
+		if !proto.Equal(response, original) {
+			t.Errorf("resolve_any %v: want the response to be left unchanged", test.resolveAny)
+		}
+	}
+
+	if _, err := New(client.ClientOptions{Platform: "gcp", RawAny: true, Visualization: true}); err == nil {
+		t.Errorf("want visualization with resolve_any disabled to be rejected")
+	}
+}
+
+// TestRedact tests that the bytes of the secrets never appear in the detailed config and the raw response
+// with -redact
+func TestRedact(t *testing.T) {
+	secrets := []string{"private-key-bytes", "inline-secret", "hunter2"}
+	secret, err := anypb.New(&envoy_extensions_transport_sockets_tls_v3.Secret{
+		Name: "server_cert",
+		Type: &envoy_extensions_transport_sockets_tls_v3.Secret_TlsCertificate{TlsCertificate: &envoy_extensions_transport_sockets_tls_v3.TlsCertificate{
+			CertificateChain: &envoy_config_core_v3.DataSource{Specifier: &envoy_config_core_v3.DataSource_Filename{Filename: "/etc/certs/chain.pem"}},
+			PrivateKey:       &envoy_config_core_v3.DataSource{Specifier: &envoy_config_core_v3.DataSource_InlineBytes{InlineBytes: []byte(secrets[0])}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Marshal any error: %v", err)
+	}
+	generic, err := anypb.New(&envoy_extensions_transport_sockets_tls_v3.Secret{
+		Name: "token",
+		Type: &envoy_extensions_transport_sockets_tls_v3.Secret_GenericSecret{GenericSecret: &envoy_extensions_transport_sockets_tls_v3.GenericSecret{
+			Secret: &envoy_config_core_v3.DataSource{Specifier: &envoy_config_core_v3.DataSource_InlineString{InlineString: secrets[1]}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Marshal any error: %v", err)
+	}
+	typedStruct, err := anypb.New(&xds_type_v3.TypedStruct{
+		TypeUrl: "type.googleapis.com/example.AuthFilter",
+		Value: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"user":     structpb.NewStringValue("admin"),
+			"password": structpb.NewStringValue(secrets[2]),
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Marshal any error: %v", err)
+	}
+	listener, err := anypb.New(&envoy_config_listener_v3.Listener{
+		Name: "listener_1",
+		ListenerFilters: []*envoy_config_listener_v3.ListenerFilter{
+			{Name: "auth", ConfigType: &envoy_config_listener_v3.ListenerFilter_TypedConfig{TypedConfig: typedStruct}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Marshal any error: %v", err)
+	}
+	var xdsConfigs []*csdspb_v3.ClientConfig_GenericXdsConfig
+	for _, config := range []*anypb.Any{secret, generic, listener} {
+		xdsConfigs = append(xdsConfigs, &csdspb_v3.ClientConfig_GenericXdsConfig{TypeUrl: config.GetTypeUrl(), XdsConfig: config})
+	}
+	response := &csdspb_v3.ClientStatusResponse{Config: []*csdspb_v3.ClientConfig{{
+		Node:              &envoy_config_core_v3.Node{Id: "node_1"},
+		GenericXdsConfigs: xdsConfigs,
+	}}}
+	original := proto.Clone(response)
+
+	for _, redact := range []bool{false, true} {
+		opts := client.ClientOptions{Platform: "gcp", Redact: redact}
+		var raw strings.Builder
+		if err := printRawResponse(&raw, response, opts); err != nil {
+			t.Fatalf("Print raw response error: %v", err)
+		}
+		out := clientUtil.CaptureOutput(func() {
+			if err := printOutResponse(os.Stdout, response, opts); err != nil {
+				t.Errorf("Print out response error: %v", err)
+			}
+		})
+		for name, output := range map[string]string{"detailed config": out, "raw response": raw.String()} {
+			for _, secret := range secrets {
+				leaked := strings.Contains(output, secret) || strings.Contains(output, base64.StdEncoding.EncodeToString([]byte(secret)))
+				if leaked == redact {
+					t.Errorf("redact %v: want the secret %s in the %s %v, got\n%s", redact, secret, name, !redact, output)
+				}
+			}
+			// the fields that aren't sensitive are kept
+			if redact && (!strings.Contains(output, clientUtil.RedactedValue) || !strings.Contains(output, "/etc/certs/chain.pem") || !strings.Contains(output, "admin")) {
+				t.Errorf("redact %v: want only the sensitive fields of the %s to be redacted, got\n%s", redact, name, output)
+			}
+		}
+	}
+	if !proto.Equal(response, original) {
+		t.Errorf("want the response to be left unchanged")
+	}
+}
+
+// TestMarshalDetailedConfig tests that the detailed config marshaled concurrently is the one marshaled at once,
+// in the same order, for a response with many clients and resources
+func TestMarshalDetailedConfig(t *testing.T) {
+	response := &csdspb_v3.ClientStatusResponse{}
+	for i := 0; i < 200; i++ {
+		config := &csdspb_v3.ClientConfig{Node: &envoy_config_core_v3.Node{Id: fmt.Sprintf("node_%03d", i)}}
+		for j := 0; j < 10; j++ {
+			config.GenericXdsConfigs = append(config.GenericXdsConfigs, &csdspb_v3.ClientConfig_GenericXdsConfig{
+				TypeUrl:      "type.googleapis.com/envoy.config.cluster.v3.Cluster",
+				Name:         fmt.Sprintf("cluster_%03d_%02d", i, j),
+				VersionInfo:  fmt.Sprintf("v%d", j),
+				ConfigStatus: csdspb_v3.ConfigStatus(j % 5),
+			})
+		}
+		response.Config = append(response.Config, config)
+	}
+	withAnyJson, err := ioutil.ReadFile("./response_with_nodeid_test.json")
+	if err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+	withAny := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal(withAnyJson, withAny); err != nil {
+		t.Fatalf("Read From File Failure: %v", err)
+	}
+
+	for _, resp := range []*csdspb_v3.ClientStatusResponse{response, withAny, {}} {
+		compact, err := protojson.MarshalOptions{Resolver: &clientUtil.TypeResolver{}}.Marshal(resp)
+		if err != nil {
+			t.Fatalf("Marshal error: %v", err)
+		}
+		var want bytes.Buffer
+		if err := json.Indent(&want, compact, "", "  "); err != nil {
+			t.Fatalf("Indent error: %v", err)
+		}
+		for _, concurrency := range []int{1, 16, 0} {
+			got, err := clientUtil.MarshalDetailedConfig(resp, concurrency, true)
+			if err != nil {
+				t.Fatalf("MarshalDetailedConfig error: %v", err)
+			}
+			if string(got) != want.String() {
+				t.Errorf("concurrency %d: want\n%.500s\ngot\n%.500s", concurrency, want.String(), got)
+			}
+		}
+	}
+}
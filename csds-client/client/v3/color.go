@@ -0,0 +1,73 @@
+package client
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	clientutil "envoy-tools/csds-client/client/util"
+
+	envoy_admin_v3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// ANSI escape codes of the config status colors
+const (
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorRed    = "\x1b[31m"
+	colorReset  = "\x1b[0m"
+)
+
+// useColor decides if the config statuses rendered to w are colorized according to -color. A non-empty
+// NO_COLOR environment variable disables colors, even with "always". With "auto", colors are only used
+// if w is a terminal, so that piped or saved output stays clean.
+func useColor(mode string, w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	return clientutil.IsTerminal(w)
+}
+
+// configStatusColor returns the color of a config status: green for SYNCED, yellow for STALE and NOT_SENT
+// and red for ERROR. Other statuses are not colorized.
+func configStatusColor(status csdspb_v3.ConfigStatus) string {
+	switch status {
+	case csdspb_v3.ConfigStatus_SYNCED:
+		return colorGreen
+	case csdspb_v3.ConfigStatus_STALE, csdspb_v3.ConfigStatus_NOT_SENT:
+		return colorYellow
+	case csdspb_v3.ConfigStatus_ERROR:
+		return colorRed
+	}
+	return ""
+}
+
+// resourceColor returns the color of the status of a resource. Resources the client NACKed, or requested
+// without acknowledging them yet, are red whatever their config status.
+func resourceColor(genericXdsConfig *csdspb_v3.ClientConfig_GenericXdsConfig) string {
+	switch genericXdsConfig.GetClientStatus() {
+	case envoy_admin_v3.ClientResourceStatus_NACKED, envoy_admin_v3.ClientResourceStatus_REQUESTED:
+		return colorRed
+	}
+	return configStatusColor(genericXdsConfig.GetConfigStatus())
+}
+
+// colorizeCell pads cell to width and colorizes its suffix status with color. The escape codes are added
+// after padding, so that they don't count toward the width of the column.
+func colorizeCell(cell string, status string, width int, color string) string {
+	padding := ""
+	if len(cell) < width {
+		padding = strings.Repeat(" ", width-len(cell))
+	}
+	if color == "" || !strings.HasSuffix(cell, status) {
+		return cell + padding
+	}
+	return cell[:len(cell)-len(status)] + color + status + colorReset + padding
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// tableColumn is a column of the table printed by -columns
+type tableColumn struct {
+	// name is the name of the column in -columns
+	name   string
+	header string
+	// client is set for the columns of the client, which are only filled on the first row of each client
+	client bool
+	// cell returns the cell of the column for a resource of config, or for config itself if it has no
+	// resource, in which case resource is nil and the columns of the resources are N/A
+	cell func(config *csdspb_v3.ClientConfig, resource *csdspb_v3.ClientConfig_GenericXdsConfig, utc bool) string
+}
+
+// tableColumns are the columns supported by -columns
+var tableColumns = []tableColumn{
+	{name: "id", header: "Client ID", client: true, cell: func(config *csdspb_v3.ClientConfig, _ *csdspb_v3.ClientConfig_GenericXdsConfig, _ bool) string {
+		id, _ := parseNode(config)
+		return id
+	}},
+	{name: "stream_type", header: "xDS stream type", client: true, cell: func(config *csdspb_v3.ClientConfig, _ *csdspb_v3.ClientConfig_GenericXdsConfig, _ bool) string {
+		_, xdsType := parseNode(config)
+		return xdsType
+	}},
+	{name: "locality", header: "Locality", client: true, cell: func(config *csdspb_v3.ClientConfig, _ *csdspb_v3.ClientConfig_GenericXdsConfig, _ bool) string {
+		return formatLocality(config.GetNode())
+	}},
+	{name: "xds", header: "xDS", cell: func(_ *csdspb_v3.ClientConfig, resource *csdspb_v3.ClientConfig_GenericXdsConfig, _ bool) string {
+		if resource == nil {
+			return "N/A"
+		}
+		return xdsDisplayName(resource.GetTypeUrl())
+	}},
+	{name: "status", header: "Config Status", cell: func(_ *csdspb_v3.ClientConfig, resource *csdspb_v3.ClientConfig_GenericXdsConfig, _ bool) string {
+		if resource == nil {
+			return "N/A"
+		}
+		return resource.GetConfigStatus().String()
+	}},
+	{name: "type_url", header: "Type URL", cell: func(_ *csdspb_v3.ClientConfig, resource *csdspb_v3.ClientConfig_GenericXdsConfig, _ bool) string {
+		if resource == nil {
+			return "N/A"
+		}
+		return resource.GetTypeUrl()
+	}},
+	{name: "last_updated", header: "Last Updated", cell: func(_ *csdspb_v3.ClientConfig, resource *csdspb_v3.ClientConfig_GenericXdsConfig, utc bool) string {
+		if resource == nil {
+			return "N/A"
+		}
+		return parseLastUpdated([]*csdspb_v3.ClientConfig_GenericXdsConfig{resource}, utc)[0]
+	}},
+	{name: "client_status", header: "Client Status", cell: func(_ *csdspb_v3.ClientConfig, resource *csdspb_v3.ClientConfig_GenericXdsConfig, _ bool) string {
+		if resource == nil {
+			return "N/A"
+		}
+		return formatClientStatus(resource)
+	}},
+	{name: "version", header: "Version", cell: func(_ *csdspb_v3.ClientConfig, resource *csdspb_v3.ClientConfig_GenericXdsConfig, _ bool) string {
+		if resource == nil {
+			return "N/A"
+		}
+		return formatVersion(resource)
+	}},
+}
+
+// columnNames lists the names of tableColumns for the error messages
+func columnNames() string {
+	names := make([]string, 0, len(tableColumns))
+	for _, column := range tableColumns {
+		names = append(names, column.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// parseColumns returns the columns of the comma-separated list of -columns, in order
+func parseColumns(list string) ([]tableColumn, error) {
+	var columns []tableColumn
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("%s column is listed more than once in columns", name)
+		}
+		seen[name] = true
+		var found bool
+		for _, column := range tableColumns {
+			if column.name == name {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s column is not supported by columns, list of supported columns: %s", name, columnNames())
+		}
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("columns must list at least one column, list of supported columns: %s", columnNames())
+	}
+	return columns, nil
+}
+
+// columnRow is a row of the table of -columns: the resource of the client it's printed for, nil for a
+// client without resources, and its cells
+type columnRow struct {
+	config   *csdspb_v3.ClientConfig
+	resource *csdspb_v3.ClientConfig_GenericXdsConfig
+	// first is set on the first row of the client
+	first bool
+	cells []string
+}
+
+// printColumns prints the config status of each client as a table of columns, led by the Endpoint column
+// of view if any. Each column is as wide as its widest cell, so the rows of a table printed in several
+// parts wouldn't line up. The errors of -show_errors and the version skew warnings are indented by the
+// first column.
+func printColumns(w io.Writer, configs []*csdspb_v3.ClientConfig, columns []tableColumn, color bool, utc bool, showErrors bool, view *endpointView) {
+	widths := make([]int, len(columns))
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.header
+		widths[i] = len(column.header)
+	}
+	var rows []columnRow
+	addRow := func(config *csdspb_v3.ClientConfig, resource *csdspb_v3.ClientConfig_GenericXdsConfig, first bool) {
+		row := columnRow{config: config, resource: resource, first: first, cells: make([]string, len(columns))}
+		for i, column := range columns {
+			if column.client && !first {
+				continue
+			}
+			row.cells[i] = column.cell(config, resource, utc)
+			if len(row.cells[i]) > widths[i] {
+				widths[i] = len(row.cells[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+	for _, config := range configs {
+		if config.GetGenericXdsConfigs() == nil {
+			if config.GetNode() != nil {
+				addRow(config, nil, true)
+			}
+			continue
+		}
+		for i, resource := range config.GetGenericXdsConfigs() {
+			addRow(config, resource, i == 0)
+		}
+	}
+
+	printColumnRow(w, view.header(), header, widths, nil, "")
+	indent := view.blank() + strings.Repeat(" ", widths[0])
+	for i, row := range rows {
+		prefix := view.blank()
+		if row.first {
+			prefix = view.cell(row.config)
+		}
+		var statusColor string
+		if color && row.resource != nil {
+			statusColor = resourceColor(row.resource)
+		}
+		printColumnRow(w, prefix, row.cells, widths, columns, statusColor)
+		if showErrors && row.resource.GetConfigStatus() == csdspb_v3.ConfigStatus_ERROR {
+			printErrorLines(w, row.resource.GetErrorState(), indent)
+		}
+
+		// the version skew warnings of a client follow its last resource
+		last := i == len(rows)-1 || rows[i+1].config != row.config
+		if !last || row.resource == nil {
+			continue
+		}
+		skew := parseVersionSkew(row.config.GetGenericXdsConfigs())
+		skewedXds := make([]string, 0, len(skew))
+		for xds := range skew {
+			skewedXds = append(skewedXds, xds)
+		}
+		sort.Strings(skewedXds)
+		for _, xds := range skewedXds {
+			fmt.Fprintf(w, "%s WARNING: %s version skew: %s\n", indent, xds, strings.Join(skew[xds], ", "))
+		}
+	}
+}
+
+// printColumnRow prints the cells of a row of the table of -columns padded to widths, without trailing
+// spaces. The status column is colorized with statusColor.
+func printColumnRow(w io.Writer, prefix string, cells []string, widths []int, columns []tableColumn, statusColor string) {
+	last := len(cells) - 1
+	for last > 0 && cells[last] == "" {
+		last--
+	}
+	var line strings.Builder
+	line.WriteString(prefix)
+	for i := 0; i <= last; i++ {
+		if i != 0 {
+			line.WriteString(" ")
+		}
+		width := widths[i]
+		if i == last {
+			width = 0
+		}
+		if columns != nil && columns[i].name == "status" && statusColor != "" {
+			line.WriteString(colorizeCell(cells[i], cells[i], width, statusColor))
+		} else {
+			line.WriteString(fmt.Sprintf("%-*s", width, cells[i]))
+		}
+	}
+	fmt.Fprintln(w, line.String())
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/stats"
+)
+
+// compressionStats is only used by -compress with -verbose to report the size of each response once
+// uncompressed next to its size on the wire. It's the stats handler of the connection.
+type compressionStats struct {
+	// label tells apart the endpoints of several uris, "" for a single one
+	label string
+	// w is where the sizes are printed, stderr so that they don't mix with the output
+	w io.Writer
+}
+
+// newCompressionStats creates the compression stats of the responses of the endpoint named by label
+func newCompressionStats(label string) *compressionStats {
+	return &compressionStats{label: label, w: os.Stderr}
+}
+
+// compressDialOptions returns the dial options of -compress: the requests are sent compressed with gzip,
+// which tells the server it can compress the responses too. stats reports the sizes of the responses
+// if it isn't nil.
+func compressDialOptions(compress bool, stats *compressionStats) []grpc.DialOption {
+	if !compress {
+		return nil
+	}
+	opts := []grpc.DialOption{grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name))}
+	if stats != nil {
+		opts = append(opts, grpc.WithStatsHandler(stats))
+	}
+	return opts
+}
+
+// TagRPC implements stats.Handler
+func (s *compressionStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+// HandleRPC prints the uncompressed and the wire size of each received response
+func (s *compressionStats) HandleRPC(_ context.Context, rs stats.RPCStats) {
+	in, ok := rs.(*stats.InPayload)
+	if !ok || !in.IsClient() {
+		return
+	}
+	prefix := "Compression:"
+	if s.label != "" {
+		prefix = fmt.Sprintf("Compression of %s:", s.label)
+	}
+	fmt.Fprintf(s.w, "%s response of %d bytes received as %d bytes\n", prefix, in.Length, in.WireLength)
+}
+
+// TagConn implements stats.Handler
+func (s *compressionStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+// HandleConn implements stats.Handler
+func (s *compressionStats) HandleConn(context.Context, stats.ConnStats) {}
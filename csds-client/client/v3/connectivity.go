@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"envoy-tools/csds-client/client"
+	clientutil "envoy-tools/csds-client/client/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkConnectivity is the -check_connectivity mode: it connects to ep with the authn mode, opens the stream
+// and sends a single request, and reports whether a response was received without rendering it. The whole
+// check is bound to -connect_timeout. The failures are classified as client.ErrAuthFailed or
+// client.ErrUnreachable, other failures of the request are returned as is.
+func (c *ClientV3) checkConnectivity(ctx context.Context, ep endpoint) error {
+	ctx, cancel := context.WithTimeout(ctx, clientutil.ConnectTimeout(c.opts))
+	defer cancel()
+	uri := clientutil.SanitizeUri(ep.uri)
+
+	defer c.Close()
+	streamClientStatus, err := c.connect(ctx, ep)
+	if err != nil {
+		return classifyConnectivityError(ctx, uri, err)
+	}
+
+	if err := sendRequest(streamClientStatus, c.BuildRequest()); err != nil {
+		return classifyConnectivityError(ctx, uri, err)
+	}
+	resp, err := streamClientStatus.Recv()
+	if err == io.EOF {
+		return fmt.Errorf("%s closed the stream without a response", uri)
+	}
+	if err != nil {
+		return classifyConnectivityError(ctx, uri, err)
+	}
+	streamClientStatus.CloseSend()
+	if !c.opts.Quiet {
+		fmt.Fprintf(c.output(), "OK: %s is reachable and authenticated the request, %d clients connected\n", uri, len(resp.GetConfig()))
+	}
+	return nil
+}
+
+// classifyConnectivityError returns err of the -check_connectivity of uri wrapped into client.ErrAuthFailed
+// or client.ErrUnreachable, or err as is if it's neither, e.g. a server that doesn't implement CSDS
+func classifyConnectivityError(ctx context.Context, uri string, err error) error {
+	var dialErr *clientutil.DialError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("%w: no response from %s within connect_timeout: %v", client.ErrUnreachable, uri, err)
+	case errors.As(err, &dialErr):
+		// gRPC reports the rejected certificates of a TLS handshake as a failure to connect
+		if strings.Contains(err.Error(), "authentication handshake failed") {
+			return fmt.Errorf("%w: the TLS handshake with %s failed: %v", client.ErrAuthFailed, uri, err)
+		}
+		return fmt.Errorf("%w: unable to connect to %s: %v", client.ErrUnreachable, uri, err)
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		// connect only fails without a gRPC status before dialing, when loading the credentials
+		return fmt.Errorf("%w: unable to load the credentials for %s: %v", client.ErrAuthFailed, uri, err)
+	}
+	switch st.Code() {
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return fmt.Errorf("%w: %s rejected the credentials: %v", client.ErrAuthFailed, uri, err)
+	case codes.Unavailable, codes.DeadlineExceeded:
+		// gRPC reports the failure to get a token of the per-RPC credentials as unavailable
+		if strings.Contains(st.Message(), "per-RPC creds failed") {
+			return fmt.Errorf("%w: unable to get a token for %s: %v", client.ErrAuthFailed, uri, err)
+		}
+		return fmt.Errorf("%w: %s did not respond: %v", client.ErrUnreachable, uri, err)
+	}
+	return err
+}
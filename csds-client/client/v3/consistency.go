@@ -0,0 +1,196 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// versionOutlier is a client whose version_info of an xDS type differs from the most common one
+type versionOutlier struct {
+	id       string
+	xds      string
+	version  string
+	expected string
+}
+
+// parseClientVersions returns the version of each xDS type of a client. If the resources of a type
+// report differing versions, they are joined by "," in sorted order.
+func parseClientVersions(xdsConfig []*csdspb_v3.ClientConfig_GenericXdsConfig) map[string]string {
+	sets := make(map[string]map[string]bool)
+	for _, genericXdsConfig := range xdsConfig {
+		xds, err := xdsShortName(genericXdsConfig.GetTypeUrl())
+		if err != nil {
+			continue
+		}
+		if sets[xds] == nil {
+			sets[xds] = make(map[string]bool)
+		}
+		sets[xds][genericXdsConfig.GetVersionInfo()] = true
+	}
+
+	versions := make(map[string]string)
+	for xds, set := range sets {
+		var distinct []string
+		for version := range set {
+			distinct = append(distinct, version)
+		}
+		sort.Strings(distinct)
+		versions[xds] = strings.Join(distinct, ",")
+	}
+	return versions
+}
+
+// findVersionOutliers compares the versions of each xDS type across the clients reporting the type, and
+// returns the clients that diverge from the most common version sorted by id and xDS type.
+// Ties between the most common versions are broken in favor of the greatest version.
+func findVersionOutliers(configs []*csdspb_v3.ClientConfig) []versionOutlier {
+	clientVersions := make(map[string]map[string]string)
+	counts := make(map[string]map[string]int)
+	for _, config := range configs {
+		if config.GetNode() == nil {
+			continue
+		}
+		id, _ := parseNode(config)
+		clientVersions[id] = parseClientVersions(config.GetGenericXdsConfigs())
+		for xds, version := range clientVersions[id] {
+			if counts[xds] == nil {
+				counts[xds] = make(map[string]int)
+			}
+			counts[xds][version]++
+		}
+	}
+
+	expected := make(map[string]string)
+	for xds, versionCounts := range counts {
+		var best string
+		for version, count := range versionCounts {
+			if count > versionCounts[best] || (count == versionCounts[best] && version > best) {
+				best = version
+			}
+		}
+		expected[xds] = best
+	}
+
+	var outliers []versionOutlier
+	for id, versions := range clientVersions {
+		for xds, version := range versions {
+			if version != expected[xds] {
+				outliers = append(outliers, versionOutlier{id: id, xds: xds, version: version, expected: expected[xds]})
+			}
+		}
+	}
+	sort.Slice(outliers, func(i, j int) bool {
+		if outliers[i].id != outliers[j].id {
+			return outliers[i].id < outliers[j].id
+		}
+		return outliers[i].xds < outliers[j].xds
+	})
+	return outliers
+}
+
+// printVersionOutliers prints whether the matched clients are consistent and the diverging clients, if any
+func printVersionOutliers(w io.Writer, configs []*csdspb_v3.ClientConfig, outliers []versionOutlier) {
+	var clients int
+	for _, config := range configs {
+		if config.GetNode() != nil {
+			clients++
+		}
+	}
+	if len(outliers) == 0 {
+		fmt.Fprintf(w, "Config versions are consistent across %d clients.\n", clients)
+		return
+	}
+	fmt.Fprintf(w, "Config versions are inconsistent across %d clients:\n", clients)
+	fmt.Fprintf(w, "%-50s %-6s %-30s %s\n", "Client ID", "xDS", "Version", "Expected")
+	for _, outlier := range outliers {
+		fmt.Fprintf(w, "%-50s %-6s %-30s %s\n", outlier.id, outlier.xds, outlier.version, outlier.expected)
+	}
+}
+
+// versionCount is the number of clients reporting a version of an xDS type
+type versionCount struct {
+	version string
+	clients int
+}
+
+// parseVersionCounts returns the versions of each xDS type across the clients of configs, ordered from the
+// most common one, with ties between versions broken in favor of the greatest one like findVersionOutliers.
+// A client whose resources of a type report differing versions is counted under each of them.
+func parseVersionCounts(configs []*csdspb_v3.ClientConfig) map[string][]versionCount {
+	clients := make(map[string]map[string]int)
+	for _, config := range configs {
+		if config.GetNode() == nil {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			xds, err := xdsShortName(genericXdsConfig.GetTypeUrl())
+			if err != nil {
+				continue
+			}
+			version := formatVersion(genericXdsConfig)
+			if seen[xds+"/"+version] {
+				continue
+			}
+			seen[xds+"/"+version] = true
+			if clients[xds] == nil {
+				clients[xds] = make(map[string]int)
+			}
+			clients[xds][version]++
+		}
+	}
+
+	counts := make(map[string][]versionCount)
+	for xds, versionClients := range clients {
+		for version, count := range versionClients {
+			counts[xds] = append(counts[xds], versionCount{version: version, clients: count})
+		}
+		sort.Slice(counts[xds], func(i, j int) bool {
+			if counts[xds][i].clients != counts[xds][j].clients {
+				return counts[xds][i].clients > counts[xds][j].clients
+			}
+			return counts[xds][i].version > counts[xds][j].version
+		})
+	}
+	return counts
+}
+
+// printVersionSkew prints the number of distinct versions of each xDS type in flight across the matched
+// clients, and the number of clients of each version. The types with more than one version are flagged,
+// as they often indicate an in-progress or stuck rollout.
+func printVersionSkew(w io.Writer, configs []*csdspb_v3.ClientConfig) {
+	var clients int
+	for _, config := range configs {
+		if config.GetNode() != nil {
+			clients++
+		}
+	}
+	counts := parseVersionCounts(configs)
+	fmt.Fprintf(w, "Config versions across %d clients:\n", clients)
+	fmt.Fprintf(w, "%-6s %-8s %-4s %s\n", "xDS", "Versions", "Skew", "Clients per version")
+	var skewed []string
+	for _, xds := range knownXds {
+		if len(counts[xds]) == 0 {
+			continue
+		}
+		skew := "no"
+		if len(counts[xds]) > 1 {
+			skew = "yes"
+			skewed = append(skewed, xds)
+		}
+		perVersion := make([]string, 0, len(counts[xds]))
+		for _, count := range counts[xds] {
+			perVersion = append(perVersion, fmt.Sprintf("%s (%d)", count.version, count.clients))
+		}
+		fmt.Fprintf(w, "%-6s %-8d %-4s %s\n", xds, len(counts[xds]), skew, strings.Join(perVersion, ", "))
+	}
+	if len(skewed) == 0 {
+		fmt.Fprintf(w, "No version skew.\n")
+		return
+	}
+	fmt.Fprintf(w, "WARNING: version skew in %d of %d xDS types: %s\n", len(skewed), len(counts), strings.Join(skewed, ", "))
+}
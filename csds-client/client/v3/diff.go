@@ -0,0 +1,121 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// snapshotKey identifies the state of one xDS type of a client in a snapshot
+type snapshotKey struct {
+	id  string
+	xds string
+}
+
+// snapshotValue is the normalized state of one xDS type of a client
+type snapshotValue struct {
+	status   string
+	versions string
+}
+
+func (v snapshotValue) String() string {
+	if v.versions == "" {
+		return v.status
+	}
+	return v.status + " (" + v.versions + ")"
+}
+
+// snapshot is a normalized view of the client configs of a response that can be compared across responses
+type snapshot map[snapshotKey]snapshotValue
+
+// newSnapshot normalizes client configs to the worst config status and the distinct versions per client and xDS type.
+// Clients without any xds config are kept with the "N/A" xDS type so that they can be diffed as well.
+func newSnapshot(configs []*csdspb_v3.ClientConfig) snapshot {
+	s := make(snapshot)
+	for _, config := range configs {
+		if config.GetNode() == nil {
+			continue
+		}
+		id, _ := parseNode(config)
+		if len(config.GetGenericXdsConfigs()) == 0 {
+			s[snapshotKey{id: id, xds: "N/A"}] = snapshotValue{}
+			continue
+		}
+
+		versions := make(map[string]map[string]bool)
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			xds, err := xdsShortName(genericXdsConfig.GetTypeUrl())
+			if err != nil {
+				continue
+			}
+			if versions[xds] == nil {
+				versions[xds] = make(map[string]bool)
+			}
+			if genericXdsConfig.GetVersionInfo() != "" {
+				versions[xds][genericXdsConfig.GetVersionInfo()] = true
+			}
+		}
+		for xds, status := range parseWorstStatus(config.GetGenericXdsConfigs()) {
+			var distinct []string
+			for version := range versions[xds] {
+				distinct = append(distinct, version)
+			}
+			sort.Strings(distinct)
+			s[snapshotKey{id: id, xds: xds}] = snapshotValue{status: status.String(), versions: strings.Join(distinct, ",")}
+		}
+	}
+	return s
+}
+
+// snapshotChange is a difference between two snapshots.
+// kind is "+" for appeared, "-" for disappeared and "~" for changed entries.
+type snapshotChange struct {
+	kind   string
+	key    snapshotKey
+	before snapshotValue
+	after  snapshotValue
+}
+
+// diffSnapshots returns the changes from before to after, sorted by node id and xDS type
+func diffSnapshots(before, after snapshot) []snapshotChange {
+	var changes []snapshotChange
+	for key, value := range after {
+		if old, ok := before[key]; !ok {
+			changes = append(changes, snapshotChange{kind: "+", key: key, after: value})
+		} else if old != value {
+			changes = append(changes, snapshotChange{kind: "~", key: key, before: old, after: value})
+		}
+	}
+	for key, value := range before {
+		if _, ok := after[key]; !ok {
+			changes = append(changes, snapshotChange{kind: "-", key: key, before: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].key.id != changes[j].key.id {
+			return changes[i].key.id < changes[j].key.id
+		}
+		return changes[i].key.xds < changes[j].key.xds
+	})
+	return changes
+}
+
+// printDiff prints the changes between two snapshots
+func printDiff(w io.Writer, changes []snapshotChange) {
+	for _, change := range changes {
+		var state string
+		switch change.kind {
+		case "+":
+			state = change.after.String()
+		case "-":
+			state = change.before.String()
+		default:
+			state = change.before.String() + " -> " + change.after.String()
+		}
+		line := fmt.Sprintf("%s %-50s %-6s %s", change.kind, change.key.id, change.key.xds, state)
+		fmt.Fprintln(w, strings.TrimRight(line, " "))
+	}
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"envoy-tools/csds-client/client"
+	clientutil "envoy-tools/csds-client/client/util"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// unsafePathChars are the characters replaced by "_" in the path segments of -dump_dir
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// maxPathSegment is the length a path segment of -dump_dir is cut at, below the 255 bytes file systems allow
+const maxPathSegment = 200
+
+// sanitizePathSegment returns s as a single file name safe on any platform. The characters other than
+// letters, digits, ".", "_" and "-" are replaced by "_", a leading "." by "_" so that the name is neither
+// hidden nor a parent directory, and an empty name becomes "_". A name that had to be changed gets
+// the fnv hash of s appended, so that e.g. "a/b" and "a_b" don't end up in the same file.
+func sanitizePathSegment(s string) string {
+	sanitized := unsafePathChars.ReplaceAllString(s, "_")
+	if strings.HasPrefix(sanitized, ".") {
+		sanitized = "_" + sanitized[1:]
+	}
+	if sanitized == "" {
+		sanitized = "_"
+	}
+	if len(sanitized) > maxPathSegment {
+		sanitized = sanitized[:maxPathSegment]
+	}
+	if sanitized == s {
+		return s
+	}
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return fmt.Sprintf("%s-%08x", sanitized, h.Sum32())
+}
+
+// writeDumpDir writes the decoded config of each resource of response to its own file under dir, at
+// <dir>/<client_id>/<xDS>/<resource_name>.json, and returns the number of files written. Resources without
+// a config aren't written. The files of the resources landing on the same path, e.g. the same client
+// reported by several uris, get a -2, -3... suffix in the order of the response, so that the same
+// response always writes the same files. Existing files are overwritten.
+func writeDumpDir(dir string, response *csdspb_v3.ClientStatusResponse, opts client.ClientOptions) (int, error) {
+	if opts.Redact {
+		response = clientutil.Redact(response).(*csdspb_v3.ClientStatusResponse)
+	}
+	written := make(map[string]bool)
+	for _, config := range response.GetConfig() {
+		id, _ := parseNode(config)
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			if genericXdsConfig.GetXdsConfig() == nil {
+				continue
+			}
+			resourceDir := filepath.Join(dir, sanitizePathSegment(id), sanitizePathSegment(xdsDisplayName(genericXdsConfig.GetTypeUrl())))
+			name := sanitizePathSegment(genericXdsConfig.GetName())
+			path := filepath.Join(resourceDir, name+".json")
+			for i := 2; written[path]; i++ {
+				path = filepath.Join(resourceDir, name+"-"+strconv.Itoa(i)+".json")
+			}
+
+			out, err := clientutil.MarshalDetailedConfig(genericXdsConfig, 1, !opts.RawAny)
+			if err != nil {
+				return len(written), fmt.Errorf("unable to marshal %s of %s: %v", genericXdsConfig.GetName(), id, err)
+			}
+			if err := os.MkdirAll(resourceDir, 0755); err != nil {
+				return len(written), err
+			}
+			if err := ioutil.WriteFile(path, append(out, '\n'), 0644); err != nil {
+				return len(written), err
+			}
+			written[path] = true
+		}
+	}
+	return len(written), nil
+}
@@ -0,0 +1,328 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	clientutil "envoy-tools/csds-client/client/util"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxEndpointWorkers is the number of endpoints of -service_uri that are connected to and requested at once
+const maxEndpointWorkers = 4
+
+// endpointWidth is the width of the Endpoint column of the merged outputs
+const endpointWidth = 40
+
+// splitUris splits the comma-separated endpoints of -service_uri, ignoring empty ones
+func splitUris(uri string) []string {
+	var uris []string
+	for _, u := range strings.Split(uri, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			uris = append(uris, u)
+		}
+	}
+	return uris
+}
+
+// endpointFailure is the error of an endpoint of -service_uri that could not be requested
+type endpointFailure struct {
+	uri string
+	err error
+	// timedOut tells that the endpoint didn't respond within its deadline, rather than failing
+	timedOut bool
+}
+
+// state returns how the failure is marked in the outputs, TIMEOUT or ERROR
+func (f endpointFailure) state() string {
+	if f.timedOut {
+		return "TIMEOUT"
+	}
+	return "ERROR"
+}
+
+// endpointView is the Endpoint column of the output merging the responses of several endpoints: the endpoint
+// each client was received from, and the endpoints that failed. A nil view prints no column. The clients are
+// keyed by their node, which is kept by the copies of the client configs made when filtering xDS types.
+type endpointView struct {
+	endpoints map[*envoy_config_core_v3.Node]string
+	failures  []endpointFailure
+}
+
+// cell returns the Endpoint cell of the row of config, followed by a space
+func (v *endpointView) cell(config *csdspb_v3.ClientConfig) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%-*s ", endpointWidth, v.endpoints[config.GetNode()])
+}
+
+// blank returns the empty Endpoint cell of the continuation rows of a client
+func (v *endpointView) blank() string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%-*s ", endpointWidth, "")
+}
+
+// header returns the header of the Endpoint column
+func (v *endpointView) header() string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%-*s ", endpointWidth, "Endpoint")
+}
+
+// endpoint returns the endpoint config was received from, or "" without a view
+func (v *endpointView) endpoint(config *csdspb_v3.ClientConfig) string {
+	if v == nil {
+		return ""
+	}
+	return v.endpoints[config.GetNode()]
+}
+
+// printFailures prints a row per failed endpoint with its error in place of its clients
+func (v *endpointView) printFailures(w io.Writer) {
+	if v == nil {
+		return
+	}
+	for _, failure := range v.failures {
+		fmt.Fprintf(w, "%-*s %s: %v\n", endpointWidth, failure.uri, failure.state(), failure.err)
+	}
+}
+
+// timeouts returns ", N timed out" if N of the failed endpoints timed out, "" otherwise
+func (v *endpointView) timeouts() string {
+	var n int
+	for _, failure := range v.failures {
+		if failure.timedOut {
+			n++
+		}
+	}
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %d timed out", n)
+}
+
+// endpointClient is the connection to an endpoint of -service_uri. Each endpoint has its own copy of
+// the client, so that the endpoints can be connected to concurrently.
+type endpointClient struct {
+	uri    string
+	client *ClientV3
+	stream csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient
+	// cancelStream cancels the context of stream, which outlives a request in monitor mode
+	cancelStream context.CancelFunc
+	resp         *csdspb_v3.ClientStatusResponse
+	err          error
+	timedOut     bool
+}
+
+// forEachEndpoint calls f for each of clients, running at most maxEndpointWorkers calls at once. The
+// endpoints fail independently, so that the others still succeed, and f keeps the error of its endpoint.
+func forEachEndpoint(clients []*endpointClient, f func(*endpointClient)) {
+	var g errgroup.Group
+	g.SetLimit(maxEndpointWorkers)
+	for _, ec := range clients {
+		ec := ec
+		g.Go(func() error {
+			f(ec)
+			return nil
+		})
+	}
+	g.Wait()
+}
+
+// timeout is the deadline of a request of ec: -connect_timeout to connect to the endpoint if it isn't yet,
+// plus -request_timeout to receive the response
+func (ec *endpointClient) timeout() time.Duration {
+	return clientutil.ConnectTimeout(ec.client.opts) + clientutil.RequestTimeout(ec.client.opts)
+}
+
+// request sends the request of a cycle to the endpoint of ec, connecting to it first if it isn't yet,
+// e.g. because the previous cycle failed. A failed endpoint is disconnected to be retried next cycle.
+// The request has its own deadline, so that a slow endpoint doesn't hold the output of the others: the
+// stream is canceled when it passes, and the endpoint is marked as timed out.
+func (ec *endpointClient) request(ctx context.Context) {
+	ec.resp, ec.err, ec.timedOut = nil, nil, false
+	// the stream isn't bound to requestCtx, so that it's kept for the next cycles if the request succeeds
+	streamCtx := ctx
+	if ec.stream == nil {
+		streamCtx, ec.cancelStream = context.WithCancel(ctx)
+	}
+	timeout := ec.timeout()
+	requestCtx, cancel := context.WithTimeout(ctx, timeout)
+	cancelStream := ec.cancelStream
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		<-requestCtx.Done()
+		if requestCtx.Err() == context.DeadlineExceeded {
+			cancelStream()
+		}
+	}()
+	ec.send(streamCtx, requestCtx)
+	cancel()
+	<-watched
+	if requestCtx.Err() == context.DeadlineExceeded {
+		// a response received as the deadline passed is dropped too, as its stream is canceled
+		ec.resp, ec.timedOut = nil, true
+		ec.err = fmt.Errorf("no response within %s", timeout)
+	}
+	if ec.err != nil {
+		ec.close()
+	}
+}
+
+// send connects to the endpoint of ec with streamCtx if it isn't yet, and fetches the response of the request
+func (ec *endpointClient) send(streamCtx, ctx context.Context) {
+	if ec.stream == nil {
+		ep := parseEndpoint(ec.uri, ec.client.opts.Platform)
+		if ec.stream, ec.err = ec.client.connect(streamCtx, ep); ec.err != nil {
+			ec.stream = nil
+			return
+		}
+	}
+	ec.resp, ec.err = ec.client.fetch(ctx, ec.stream)
+}
+
+// close closes the stream and the connection to the endpoint of ec, if it's connected
+func (ec *endpointClient) close() {
+	if ec.cancelStream != nil {
+		ec.cancelStream()
+		ec.cancelStream = nil
+	}
+	if ec.stream == nil {
+		return
+	}
+	ec.stream.CloseSend()
+	ec.client.Close()
+	ec.stream = nil
+}
+
+// mergeEndpointResponses merges the responses of clients into a single response, and the view of the endpoint
+// of each client config and of the endpoints that failed
+func mergeEndpointResponses(clients []*endpointClient) (*csdspb_v3.ClientStatusResponse, *endpointView) {
+	merged := &csdspb_v3.ClientStatusResponse{}
+	view := &endpointView{endpoints: make(map[*envoy_config_core_v3.Node]string)}
+	for _, ec := range clients {
+		if ec.err != nil {
+			view.failures = append(view.failures, endpointFailure{uri: clientutil.SanitizeUri(ec.uri), err: ec.err, timedOut: ec.timedOut})
+			continue
+		}
+		for _, config := range ec.resp.GetConfig() {
+			merged.Config = append(merged.Config, config)
+			view.endpoints[config.GetNode()] = clientutil.SanitizeUri(ec.uri)
+		}
+	}
+	return merged, view
+}
+
+// runEndpoints runs the client against each of uris, sending the same request to all of them concurrently
+// and printing their responses merged in a single output with an Endpoint column. The endpoints that fail
+// are shown with their error instead of aborting the others, and Run fails once the output is printed.
+// In monitor mode, every endpoint is requested each cycle.
+func (c *ClientV3) runEndpoints(ctx context.Context, uris []string) (err error) {
+	sanitized := make([]string, len(uris))
+	for i, uri := range uris {
+		sanitized[i] = clientutil.SanitizeUri(uri)
+	}
+	ctx, span := clientutil.StartSpan(ctx, "Run",
+		clientutil.PlatformKey.String(c.opts.Platform), clientutil.UriKey.String(strings.Join(sanitized, ",")))
+	defer func() { clientutil.EndSpan(span, err) }()
+
+	if c.opts.MetricsAddr != "" {
+		stopMetrics, err := c.startMetrics()
+		if err != nil {
+			return err
+		}
+		defer stopMetrics()
+	}
+
+	clients := make([]*endpointClient, len(uris))
+	for i, uri := range uris {
+		copied := &ClientV3{opts: c.opts, nodeMatcher: c.nodeMatcher, node: c.node, dialOptions: c.dialOptions, metrics: c.metrics, jwt: c.jwt, request: c.request}
+		if c.opts.Timing {
+			copied.timing = newRequestTiming(clientutil.SanitizeUri(uri))
+		}
+		if c.compression != nil {
+			copied.compression = newCompressionStats(clientutil.SanitizeUri(uri))
+		}
+		clients[i] = &endpointClient{uri: uri, client: copied}
+	}
+	defer forEachEndpoint(clients, func(ec *endpointClient) { ec.close() })
+
+	out, closeOut, err := clientutil.OpenOutput(c.opts)
+	if err != nil {
+		return err
+	}
+	c.out = out
+	defer func() {
+		if closeErr := closeOut(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if c.opts.SqliteOut != "" {
+		if c.sqlite, err = newSqliteExporter(c.opts.SqliteOut); err != nil {
+			return err
+		}
+		defer c.sqlite.Close()
+	}
+
+	var stop <-chan struct{}
+	if c.opts.MonitorInterval != 0 {
+		var stopNotify func()
+		stop, stopNotify = clientutil.NotifyMonitorStop()
+		defer stopNotify()
+	}
+
+	var cycles int
+	for {
+		forEachEndpoint(clients, func(ec *endpointClient) { ec.request(ctx) })
+		merged, view := mergeEndpointResponses(clients)
+
+		w := c.output()
+		c.printCycleHeader(w)
+		if clientutil.IsStructuredOutput(c.opts) {
+			// the failures can't be rows of a structured document
+			for _, failure := range view.failures {
+				if failure.timedOut {
+					fmt.Fprintf(os.Stderr, "endpoint %s timed out: %v\n", failure.uri, failure.err)
+				} else {
+					fmt.Fprintf(os.Stderr, "endpoint %s failed: %v\n", failure.uri, failure.err)
+				}
+			}
+		}
+		if err := printOutMergedResponse(w, merged, c.opts, view, nil); err != nil {
+			return err
+		}
+		if err := c.checkResponse(w, merged); err != nil {
+			return err
+		}
+
+		cycles++
+		if c.opts.MonitorInterval != 0 && (c.opts.MonitorCount == 0 || cycles < c.opts.MonitorCount) {
+			stopped, err := clientutil.WaitMonitorInterval(ctx, stop, c.opts.MonitorInterval)
+			if err != nil {
+				return err
+			}
+			if !stopped {
+				continue
+			}
+			fmt.Fprintln(os.Stderr, "monitor stopped")
+			return nil
+		}
+		if len(view.failures) != 0 {
+			return fmt.Errorf("%d of %d endpoints failed%s", len(view.failures), len(clients), view.timeouts())
+		}
+		return nil
+	}
+}
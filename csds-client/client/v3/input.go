@@ -0,0 +1,114 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	clientutil "envoy-tools/csds-client/client/util"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+)
+
+// validateInputFile checks the options of an offline run of -input_file. The request is never sent,
+// so the request yaml is optional and only used to label the clients of each NodeMatcher.
+func (c *ClientV3) validateInputFile() error {
+	switch {
+	case c.opts.Uri != "":
+		return errors.New("input_file cannot be used with service_uri, the response is read from the file instead")
+	case c.opts.InputFile == "-" && c.opts.RequestFile == "-":
+		return errors.New("input_file and request_file cannot both be read from stdin")
+	case c.opts.MonitorInterval != 0 || c.opts.SelfDiff != 0:
+		return errors.New("input_file cannot be used in monitor mode or with self_diff")
+	case c.opts.DrainStream || c.opts.Transform != "":
+		return errors.New("input_file cannot be used with drain_stream or transform")
+	}
+
+	if c.opts.RequestFile != "" || c.opts.RequestYaml != "" {
+		var nodematchers []*envoy_type_matcher_v3.NodeMatcher
+		node := &envoy_config_core_v3.Node{}
+		if err := parseYaml(c.opts.RequestFile, c.opts.RequestYaml, &nodematchers, node, c.opts.LenientYaml); err != nil {
+			return err
+		}
+		c.nodeMatcher = nodematchers
+		c.node = node
+	}
+	return c.validateFilterMode()
+}
+
+// loadResponse reads the ClientStatusResponse saved at path, or from stdin if path is "-". It can be
+// in JSON, as printed by -dump_raw, or in the protobuf text format.
+func loadResponse(path string) (*csdspb_v3.ClientStatusResponse, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read input_file %s: %v", path, err)
+	}
+
+	resp := &csdspb_v3.ClientStatusResponse{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) != 0 && trimmed[0] == '{' {
+		err = protojson.Unmarshal(data, resp)
+	} else {
+		err = prototext.Unmarshal(data, resp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("input_file %s is not a valid ClientStatusResponse: %v", path, err)
+	}
+	return resp, nil
+}
+
+// runInputFile renders the response saved in -input_file as if it was received from the server,
+// applying the same filters, output format and checks as a request to it
+func (c *ClientV3) runInputFile(ctx context.Context) (err error) {
+	_, span := clientutil.StartSpan(ctx, "Run")
+	defer func() { clientutil.EndSpan(span, err) }()
+
+	resp, err := loadResponse(c.opts.InputFile)
+	if err != nil {
+		return err
+	}
+
+	out, closeOut, err := clientutil.OpenOutput(c.opts)
+	if err != nil {
+		return err
+	}
+	c.out = out
+	defer func() {
+		if closeErr := closeOut(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if c.opts.SqliteOut != "" {
+		if c.sqlite, err = newSqliteExporter(c.opts.SqliteOut); err != nil {
+			return err
+		}
+		defer c.sqlite.Close()
+	}
+
+	w := c.output()
+	if c.opts.DumpRaw {
+		if err := printRawResponse(w, resp, c.opts); err != nil {
+			return err
+		}
+	} else if c.labelByMatcher() {
+		if err := printOutResponseByMatcher(w, resp, c.opts, c.nodeMatcher); err != nil {
+			return err
+		}
+	} else if err := printOutResponse(w, resp, c.opts); err != nil {
+		return err
+	}
+	return c.checkResponse(w, resp)
+}
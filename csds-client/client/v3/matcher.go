@@ -0,0 +1,134 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"envoy-tools/csds-client/client"
+
+	envoy_config_core_v3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// matchNode reports whether node is selected by matcher: its id must match the node_id of matcher, if set,
+// and its metadata all the node_metadatas of matcher. The response doesn't tell which NodeMatcher selected a
+// client, so this is how the clients of a request with several NodeMatchers are correlated back to them.
+func matchNode(matcher *envoy_type_matcher_v3.NodeMatcher, node *envoy_config_core_v3.Node) bool {
+	if matcher.GetNodeId() != nil && !matchString(matcher.GetNodeId(), node.GetId()) {
+		return false
+	}
+	for _, structMatcher := range matcher.GetNodeMetadatas() {
+		value := lookupMetadata(node.GetMetadata(), structMatcher.GetPath())
+		if !matchValue(structMatcher.GetValue(), value) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupMetadata returns the value of metadata at path, or nil if there's none
+func lookupMetadata(metadata *structpb.Struct, path []*envoy_type_matcher_v3.StructMatcher_PathSegment) *structpb.Value {
+	var value *structpb.Value
+	for _, segment := range path {
+		if metadata == nil {
+			return nil
+		}
+		value = metadata.GetFields()[segment.GetKey()]
+		metadata = value.GetStructValue()
+	}
+	return value
+}
+
+// matchValue reports whether value is matched by matcher. Only the string, bool, null and present
+// matchers are supported, and the other ones never match.
+func matchValue(matcher *envoy_type_matcher_v3.ValueMatcher, value *structpb.Value) bool {
+	switch pattern := matcher.GetMatchPattern().(type) {
+	case *envoy_type_matcher_v3.ValueMatcher_StringMatch:
+		stringValue, ok := value.GetKind().(*structpb.Value_StringValue)
+		return ok && matchString(pattern.StringMatch, stringValue.StringValue)
+	case *envoy_type_matcher_v3.ValueMatcher_BoolMatch:
+		boolValue, ok := value.GetKind().(*structpb.Value_BoolValue)
+		return ok && boolValue.BoolValue == pattern.BoolMatch
+	case *envoy_type_matcher_v3.ValueMatcher_NullMatch_:
+		_, ok := value.GetKind().(*structpb.Value_NullValue)
+		return ok
+	case *envoy_type_matcher_v3.ValueMatcher_PresentMatch:
+		return (value != nil) == pattern.PresentMatch
+	}
+	return false
+}
+
+// matchString reports whether s is matched by matcher
+func matchString(matcher *envoy_type_matcher_v3.StringMatcher, s string) bool {
+	if matcher.GetIgnoreCase() {
+		s = strings.ToLower(s)
+	}
+	fold := func(pattern string) string {
+		if matcher.GetIgnoreCase() {
+			return strings.ToLower(pattern)
+		}
+		return pattern
+	}
+	switch pattern := matcher.GetMatchPattern().(type) {
+	case *envoy_type_matcher_v3.StringMatcher_Exact:
+		return s == fold(pattern.Exact)
+	case *envoy_type_matcher_v3.StringMatcher_Prefix:
+		return strings.HasPrefix(s, fold(pattern.Prefix))
+	case *envoy_type_matcher_v3.StringMatcher_Suffix:
+		return strings.HasSuffix(s, fold(pattern.Suffix))
+	case *envoy_type_matcher_v3.StringMatcher_Contains:
+		return strings.Contains(s, fold(pattern.Contains))
+	case *envoy_type_matcher_v3.StringMatcher_SafeRegex:
+		re, err := regexp.Compile("^(?:" + pattern.SafeRegex.GetRegex() + ")$")
+		return err == nil && re.MatchString(s)
+	}
+	return false
+}
+
+// matcherLabel names the i-th NodeMatcher of a request by its mesh scope or network name, if it has one
+func matcherLabel(i int, matcher *envoy_type_matcher_v3.NodeMatcher) string {
+	label := fmt.Sprintf("Node matcher #%d", i+1)
+	for _, key := range []string{gcpMeshScopeKey, gcpNetworkNameKey} {
+		if value := getValueByKeyFromNodeMatcher([]*envoy_type_matcher_v3.NodeMatcher{matcher}, key); value != "" {
+			return fmt.Sprintf("%s (%s: %s)", label, key, value)
+		}
+	}
+	return label
+}
+
+// printOutResponseByMatcher prints response in one section per NodeMatcher of the request, each labeled by
+// matcherLabel and holding the clients that matcher selects, as if it was requested on its own. A client
+// selected by several matchers is printed in each of their sections, and clients none of them selects,
+// e.g. because of an unsupported value matcher, are printed last.
+func printOutResponseByMatcher(w io.Writer, response *csdspb_v3.ClientStatusResponse, opts client.ClientOptions, matchers []*envoy_type_matcher_v3.NodeMatcher) error {
+	matched := make(map[*csdspb_v3.ClientConfig]bool)
+	for i, matcher := range matchers {
+		section := &csdspb_v3.ClientStatusResponse{}
+		for _, config := range response.GetConfig() {
+			if matchNode(matcher, config.GetNode()) {
+				section.Config = append(section.Config, config)
+				matched[config] = true
+			}
+		}
+		fmt.Fprintf(w, "%s:\n", matcherLabel(i, matcher))
+		if err := printOutResponse(w, section, opts); err != nil {
+			return err
+		}
+	}
+
+	unmatched := &csdspb_v3.ClientStatusResponse{}
+	for _, config := range response.GetConfig() {
+		if !matched[config] {
+			unmatched.Config = append(unmatched.Config, config)
+		}
+	}
+	if len(unmatched.GetConfig()) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "Not matched by any node matcher:")
+	return printOutResponse(w, unmatched, opts)
+}
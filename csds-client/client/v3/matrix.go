@@ -0,0 +1,117 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// matrixIdWidth is the maximum width of the client id column of the matrix output format,
+// longer ids are truncated so that the status columns stay aligned
+const matrixIdWidth = 50
+
+// statusMatrix is the worst config status of each xDS type of each client, keyed by client id and xDS type
+type statusMatrix struct {
+	ids   []string
+	types []string
+	cells map[string]map[string]csdspb_v3.ConfigStatus
+}
+
+// parseStatusMatrix builds the status matrix of configs. Rows keep the order of the clients in the response,
+// and only the xDS types reported by at least one client become columns, in the order of knownXds followed
+// by OTHER, the column of the types without a short name like the summary.
+func parseStatusMatrix(configs []*csdspb_v3.ClientConfig) statusMatrix {
+	matrix := statusMatrix{cells: make(map[string]map[string]csdspb_v3.ConfigStatus)}
+	present := make(map[string]bool)
+	for _, config := range configs {
+		if config.GetNode() == nil {
+			continue
+		}
+		id, _ := parseNode(config)
+		if _, ok := matrix.cells[id]; !ok {
+			matrix.ids = append(matrix.ids, id)
+		}
+		matrix.cells[id] = parseWorstStatus(config.GetGenericXdsConfigs())
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			if _, err := xdsShortName(genericXdsConfig.GetTypeUrl()); err != nil {
+				status := genericXdsConfig.GetConfigStatus()
+				if current, ok := matrix.cells[id][otherXds]; !ok || compactStatus[status].severity > compactStatus[current].severity {
+					matrix.cells[id][otherXds] = status
+				}
+			}
+		}
+		for xds := range matrix.cells[id] {
+			present[xds] = true
+		}
+	}
+	for _, xds := range append(append([]string{}, knownXds...), otherXds) {
+		if present[xds] {
+			matrix.types = append(matrix.types, xds)
+		}
+	}
+	return matrix
+}
+
+// parseMatrixCells returns the worst config status of each xDS type of each client of configs by name, e.g.
+// "SYNCED", the structured form of the matrix output format. Types a client has no resource of are left out.
+func parseMatrixCells(configs []*csdspb_v3.ClientConfig) map[string]map[string]string {
+	matrix := parseStatusMatrix(configs)
+	cells := make(map[string]map[string]string, len(matrix.ids))
+	for _, id := range matrix.ids {
+		cells[id] = make(map[string]string, len(matrix.cells[id]))
+		for xds, status := range matrix.cells[id] {
+			cells[id][xds] = status.String()
+		}
+	}
+	return cells
+}
+
+// truncateId shortens id to width, marking the truncation with "..."
+func truncateId(id string, width int) string {
+	if len(id) <= width {
+		return id
+	}
+	return id[:width-3] + "..."
+}
+
+// printMatrix prints one row per client and one column per xDS type with the worst config status of the
+// type, e.g. "SYNCED". Types a client has no resource of are shown as "-".
+func printMatrix(w io.Writer, configs []*csdspb_v3.ClientConfig, color bool) {
+	matrix := parseStatusMatrix(configs)
+	if len(matrix.ids) == 0 {
+		return
+	}
+
+	idWidth := len("Client ID")
+	for _, id := range matrix.ids {
+		if len(id) > idWidth {
+			idWidth = len(id)
+		}
+	}
+	if idWidth > matrixIdWidth {
+		idWidth = matrixIdWidth
+	}
+
+	header := []string{fmt.Sprintf("%-*s", idWidth, "Client ID")}
+	for _, xds := range matrix.types {
+		header = append(header, fmt.Sprintf("%-10s", xds))
+	}
+	fmt.Fprintln(w, strings.TrimRight(strings.Join(header, " "), " "))
+	for _, id := range matrix.ids {
+		row := []string{fmt.Sprintf("%-*s", idWidth, truncateId(id, idWidth))}
+		for _, xds := range matrix.types {
+			cell := "-"
+			var statusColor string
+			if status, ok := matrix.cells[id][xds]; ok {
+				cell = status.String()
+				if color {
+					statusColor = configStatusColor(status)
+				}
+			}
+			row = append(row, colorizeCell(cell, cell, 10, statusColor))
+		}
+		fmt.Fprintln(w, strings.TrimRight(strings.Join(row, " "), " "))
+	}
+}
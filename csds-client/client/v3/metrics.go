@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// monitorMetrics are the metrics of the monitor mode served on -metrics_addr, updated on each cycle
+type monitorMetrics struct {
+	registry  *prometheus.Registry
+	clients   prometheus.Gauge
+	resources *prometheus.GaugeVec
+	latency   prometheus.Histogram
+	retries   prometheus.Counter
+}
+
+// newMonitorMetrics creates the metrics of the monitor mode in a registry of their own
+func newMonitorMetrics() *monitorMetrics {
+	m := &monitorMetrics{
+		registry: prometheus.NewRegistry(),
+		clients: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "csds_client_clients",
+			Help: "Number of xDS clients matched by the last response, after filtering.",
+		}),
+		resources: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "csds_client_resources",
+			Help: "Number of xDS resources of the matched clients per config status in the last response.",
+		}, []string{"status"}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "csds_client_request_duration_seconds",
+			Help:    "Duration of the csds requests, from sending the request to receiving the response.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "csds_client_retries_total",
+			Help: "Number of csds requests retried after a transient error.",
+		}),
+	}
+	m.registry.MustRegister(m.clients, m.resources, m.latency, m.retries)
+	return m
+}
+
+// handler returns the handler serving the metrics in the Prometheus exposition format
+func (m *monitorMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// serve serves the metrics on /metrics of addr until the returned function is called. The listener
+// is opened before serve returns, so that an address in use fails the run right away.
+func (m *monitorMetrics) serve(addr string) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen on metrics_addr %s: %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.handler())
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintf(os.Stderr, "metrics server failed: %v\n", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}, nil
+}
+
+// record updates the gauges with the clients of configs and their resources, the statuses no resource
+// reports anymore being reset to 0
+func (m *monitorMetrics) record(configs []*csdspb_v3.ClientConfig) {
+	if m == nil {
+		return
+	}
+	r := tallyConfigs(configs)
+	m.clients.Set(float64(r.Clients))
+	for status := range csdspb_v3.ConfigStatus_name {
+		m.resources.WithLabelValues(csdspb_v3.ConfigStatus(status).String()).Set(float64(r.Statuses[csdspb_v3.ConfigStatus(status)]))
+	}
+}
+
+// observeLatency records the duration of a request
+func (m *monitorMetrics) observeLatency(latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.latency.Observe(latency.Seconds())
+}
+
+// retried counts a retry of a request
+func (m *monitorMetrics) retried() {
+	if m == nil {
+		return
+	}
+	m.retries.Inc()
+}
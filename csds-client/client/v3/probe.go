@@ -0,0 +1,296 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	envoy_type_matcher_v3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+)
+
+// probeRequest is the request evaluated against the route configurations of a client by -probe_path
+type probeRequest struct {
+	host    string
+	path    string
+	query   url.Values
+	headers map[string]string
+}
+
+// parseProbeRequest parses -probe_path "<host>/<path>" and the repeatable -probe_header "name=value"
+// options to a probe request. The method is matched as the ":method" pseudo-header like in Envoy.
+func parseProbeRequest(probePath string, probeHeaders []string, method string) (probeRequest, error) {
+	host, path := probePath, "/"
+	if i := strings.Index(probePath, "/"); i >= 0 {
+		host, path = probePath[:i], probePath[i:]
+	}
+	if host == "" {
+		return probeRequest{}, fmt.Errorf("missing host in probe_path %q, expected <host>/<path>", probePath)
+	}
+
+	req := probeRequest{host: host, path: path, headers: make(map[string]string)}
+	if i := strings.Index(path, "?"); i >= 0 {
+		query, err := url.ParseQuery(path[i+1:])
+		if err != nil {
+			return probeRequest{}, fmt.Errorf("invalid query in probe_path %q: %v", probePath, err)
+		}
+		req.path, req.query = path[:i], query
+	}
+	for _, header := range probeHeaders {
+		i := strings.Index(header, "=")
+		if i <= 0 {
+			return probeRequest{}, fmt.Errorf("invalid probe_header %q, expected name=value", header)
+		}
+		req.headers[strings.ToLower(header[:i])] = header[i+1:]
+	}
+	req.headers[":authority"] = host
+	req.headers[":path"] = path
+	if method != "" {
+		req.headers[":method"] = method
+	}
+	return req, nil
+}
+
+// matchDomain reports whether host matches a virtual host domain, and how specific the match is:
+// 3 for exact, 2 for suffix wildcard, 1 for prefix wildcard and 0 for "*"
+func matchDomain(domain string, host string) (bool, int) {
+	domain, host = strings.ToLower(domain), strings.ToLower(host)
+	switch {
+	case domain == "*":
+		return true, 0
+	case strings.HasPrefix(domain, "*"):
+		return strings.HasSuffix(host, domain[1:]) && len(host) > len(domain)-1, 2
+	case strings.HasSuffix(domain, "*"):
+		return strings.HasPrefix(host, domain[:len(domain)-1]) && len(host) > len(domain)-1, 1
+	default:
+		return domain == host, 3
+	}
+}
+
+// selectVirtualHost selects the virtual host for host in the order Envoy does: exact domains first,
+// then the longest suffix wildcard, then the longest prefix wildcard and finally "*".
+// Like Envoy by default, a port in host is part of the matched host.
+func selectVirtualHost(routeConfig *envoy_config_route_v3.RouteConfiguration, host string) *envoy_config_route_v3.VirtualHost {
+	var selected *envoy_config_route_v3.VirtualHost
+	bestRank, bestLen := -1, -1
+	for _, virtualHost := range routeConfig.GetVirtualHosts() {
+		for _, domain := range virtualHost.GetDomains() {
+			matched, rank := matchDomain(domain, host)
+			if !matched {
+				continue
+			}
+			if rank > bestRank || (rank == bestRank && len(domain) > bestLen) {
+				selected, bestRank, bestLen = virtualHost, rank, len(domain)
+			}
+		}
+	}
+	return selected
+}
+
+// matchFullRegex reports whether the whole value matches regex, like RE2 full matches in Envoy
+func matchFullRegex(regex string, value string) bool {
+	matched, err := regexp.MatchString("^(?:"+regex+")$", value)
+	return err == nil && matched
+}
+
+// matchStringMatcher evaluates a StringMatcher against value
+func matchStringMatcher(matcher *envoy_type_matcher_v3.StringMatcher, value string) bool {
+	if matcher.GetIgnoreCase() {
+		value = strings.ToLower(value)
+	}
+	normalize := func(pattern string) string {
+		if matcher.GetIgnoreCase() {
+			return strings.ToLower(pattern)
+		}
+		return pattern
+	}
+	switch matcher.GetMatchPattern().(type) {
+	case *envoy_type_matcher_v3.StringMatcher_Exact:
+		return value == normalize(matcher.GetExact())
+	case *envoy_type_matcher_v3.StringMatcher_Prefix:
+		return strings.HasPrefix(value, normalize(matcher.GetPrefix()))
+	case *envoy_type_matcher_v3.StringMatcher_Suffix:
+		return strings.HasSuffix(value, normalize(matcher.GetSuffix()))
+	case *envoy_type_matcher_v3.StringMatcher_Contains:
+		return strings.Contains(value, normalize(matcher.GetContains()))
+	case *envoy_type_matcher_v3.StringMatcher_SafeRegex:
+		return matchFullRegex(matcher.GetSafeRegex().GetRegex(), value)
+	}
+	return false
+}
+
+// matchHeader evaluates a header matcher of a route against the request headers
+func matchHeader(header *envoy_config_route_v3.HeaderMatcher, headers map[string]string) bool {
+	value, present := headers[strings.ToLower(header.GetName())]
+	var matched bool
+	switch header.GetHeaderMatchSpecifier().(type) {
+	case *envoy_config_route_v3.HeaderMatcher_ExactMatch:
+		matched = present && value == header.GetExactMatch()
+	case *envoy_config_route_v3.HeaderMatcher_PrefixMatch:
+		matched = present && strings.HasPrefix(value, header.GetPrefixMatch())
+	case *envoy_config_route_v3.HeaderMatcher_SuffixMatch:
+		matched = present && strings.HasSuffix(value, header.GetSuffixMatch())
+	case *envoy_config_route_v3.HeaderMatcher_ContainsMatch:
+		matched = present && strings.Contains(value, header.GetContainsMatch())
+	case *envoy_config_route_v3.HeaderMatcher_SafeRegexMatch:
+		matched = present && matchFullRegex(header.GetSafeRegexMatch().GetRegex(), value)
+	case *envoy_config_route_v3.HeaderMatcher_StringMatch:
+		matched = present && matchStringMatcher(header.GetStringMatch(), value)
+	case *envoy_config_route_v3.HeaderMatcher_RangeMatch:
+		n, err := strconv.ParseInt(value, 10, 64)
+		matched = present && err == nil && n >= header.GetRangeMatch().GetStart() && n < header.GetRangeMatch().GetEnd()
+	case *envoy_config_route_v3.HeaderMatcher_PresentMatch:
+		matched = present == header.GetPresentMatch()
+	default:
+		// no match specifier only requires the header to be present
+		matched = present
+	}
+	if header.GetInvertMatch() {
+		return !matched
+	}
+	return matched
+}
+
+// matchRoute evaluates the path, header and query parameter conditions of a route against the request
+func matchRoute(match *envoy_config_route_v3.RouteMatch, req probeRequest) bool {
+	path := req.path
+	caseSensitive := match.GetCaseSensitive() == nil || match.GetCaseSensitive().GetValue()
+	normalize := func(s string) string {
+		if caseSensitive {
+			return s
+		}
+		return strings.ToLower(s)
+	}
+
+	var matched bool
+	switch match.GetPathSpecifier().(type) {
+	case *envoy_config_route_v3.RouteMatch_Prefix:
+		matched = strings.HasPrefix(normalize(path), normalize(match.GetPrefix()))
+	case *envoy_config_route_v3.RouteMatch_Path:
+		matched = normalize(path) == normalize(match.GetPath())
+	case *envoy_config_route_v3.RouteMatch_SafeRegex:
+		matched = matchFullRegex(match.GetSafeRegex().GetRegex(), path)
+	case *envoy_config_route_v3.RouteMatch_PathSeparatedPrefix:
+		prefix := normalize(match.GetPathSeparatedPrefix())
+		rest := strings.TrimPrefix(normalize(path), prefix)
+		matched = strings.HasPrefix(normalize(path), prefix) && (rest == "" || rest[0] == '/')
+	default:
+		// connect matchers and path templates can't be evaluated for a plain path
+		return false
+	}
+	if !matched {
+		return false
+	}
+
+	for _, header := range match.GetHeaders() {
+		if !matchHeader(header, req.headers) {
+			return false
+		}
+	}
+	for _, query := range match.GetQueryParameters() {
+		values, present := req.query[query.GetName()]
+		switch {
+		case query.GetPresentMatch():
+			if !present {
+				return false
+			}
+		case query.GetStringMatch() != nil:
+			if !present || !matchStringMatcher(query.GetStringMatch(), values[0]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// formatRouteRewrites formats the path and host rewrites a route action applies to the request
+func formatRouteRewrites(route *envoy_config_route_v3.Route, req probeRequest) []string {
+	action := route.GetRoute()
+	if action == nil {
+		return nil
+	}
+
+	var rewrites []string
+	switch {
+	case action.GetPrefixRewrite() != "":
+		var matchedPrefix string
+		switch {
+		case route.GetMatch().GetPrefix() != "":
+			matchedPrefix = route.GetMatch().GetPrefix()
+		case route.GetMatch().GetPath() != "":
+			matchedPrefix = route.GetMatch().GetPath()
+		case route.GetMatch().GetPathSeparatedPrefix() != "":
+			matchedPrefix = route.GetMatch().GetPathSeparatedPrefix()
+		}
+		if len(matchedPrefix) <= len(req.path) {
+			rewrites = append(rewrites, "path "+req.path+" -> "+action.GetPrefixRewrite()+req.path[len(matchedPrefix):])
+		}
+	case action.GetRegexRewrite() != nil:
+		if re, err := regexp.Compile(action.GetRegexRewrite().GetPattern().GetRegex()); err == nil {
+			substitution := regexp.MustCompile(`\\(\d)`).ReplaceAllString(action.GetRegexRewrite().GetSubstitution(), "$${$1}")
+			rewrites = append(rewrites, "path "+req.path+" -> "+re.ReplaceAllString(req.path, substitution))
+		}
+	}
+	switch {
+	case action.GetHostRewriteLiteral() != "":
+		rewrites = append(rewrites, "host "+req.host+" -> "+action.GetHostRewriteLiteral())
+	case action.GetHostRewriteHeader() != "":
+		rewrites = append(rewrites, "host "+req.host+" -> value of header "+action.GetHostRewriteHeader())
+	case action.GetAutoHostRewrite().GetValue():
+		rewrites = append(rewrites, "host "+req.host+" -> host of the upstream endpoint")
+	}
+	return rewrites
+}
+
+// printProbe prints the virtual host, route, target and rewrites each route configuration of the only
+// matched client selects for -probe_path
+func printProbe(w io.Writer, configs []*csdspb_v3.ClientConfig, req probeRequest) error {
+	config, err := selectClient(configs, "probe_path")
+	if err != nil {
+		return err
+	}
+	routeConfigs, err := parseRouteConfigs(config)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Probe of %s%s for %s:\n", req.host, req.path, config.GetNode().GetId())
+	if len(routeConfigs) == 0 {
+		fmt.Fprintf(w, "No route configurations found.\n")
+	}
+	for _, routeConfig := range routeConfigs {
+		fmt.Fprintf(w, "%-15s %s\n", "Route Config:", routeConfig.GetName())
+		virtualHost := selectVirtualHost(routeConfig, req.host)
+		if virtualHost == nil {
+			fmt.Fprintf(w, "%-15s no virtual host matches %s\n", "Virtual Host:", req.host)
+			continue
+		}
+		fmt.Fprintf(w, "%-15s %s\n", "Virtual Host:", virtualHost.GetName())
+
+		var selected *envoy_config_route_v3.Route
+		for _, route := range virtualHost.GetRoutes() {
+			if matchRoute(route.GetMatch(), req) {
+				selected = route
+				break
+			}
+		}
+		if selected == nil {
+			fmt.Fprintf(w, "%-15s no route matches %s\n", "Route:", req.path)
+			continue
+		}
+		route := formatRouteMatch(selected.GetMatch())
+		if selected.GetName() != "" {
+			route = selected.GetName() + " (" + route + ")"
+		}
+		fmt.Fprintf(w, "%-15s %s\n", "Route:", route)
+		fmt.Fprintf(w, "%-15s %s\n", "Target:", formatRouteTarget(selected))
+		for _, rewrite := range formatRouteRewrites(selected, req) {
+			fmt.Fprintf(w, "%-15s %s\n", "Rewrite:", rewrite)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	envoy_config_route_v3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// routeTableRow is a single route of the flattened route table of a client
+type routeTableRow struct {
+	routeConfig string
+	virtualHost string
+	domains     string
+	match       string
+	target      string
+}
+
+// selectClient returns the only client config with a node, as required by the views of a single client
+func selectClient(configs []*csdspb_v3.ClientConfig, view string) (*csdspb_v3.ClientConfig, error) {
+	var selected []*csdspb_v3.ClientConfig
+	for _, config := range configs {
+		if config.GetNode() != nil {
+			selected = append(selected, config)
+		}
+	}
+	if len(selected) != 1 {
+		return nil, fmt.Errorf("%s requires exactly one matched client, got %d; select one with -filter_mode and -filter_pattern", view, len(selected))
+	}
+	return selected[0], nil
+}
+
+// parseRouteConfigs decodes the route configurations of a client, from its RDS resources
+// and from the SRDS resources that carry their route configuration inline
+func parseRouteConfigs(config *csdspb_v3.ClientConfig) ([]*envoy_config_route_v3.RouteConfiguration, error) {
+	var routeConfigs []*envoy_config_route_v3.RouteConfiguration
+	for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+		if genericXdsConfig.GetXdsConfig() == nil {
+			continue
+		}
+		switch xds, _ := xdsShortName(genericXdsConfig.GetTypeUrl()); xds {
+		case "RDS":
+			routeConfig := &envoy_config_route_v3.RouteConfiguration{}
+			if err := genericXdsConfig.GetXdsConfig().UnmarshalTo(routeConfig); err != nil {
+				return nil, fmt.Errorf("unable to parse route configuration %s: %v", genericXdsConfig.GetName(), err)
+			}
+			routeConfigs = append(routeConfigs, routeConfig)
+		case "SRDS":
+			scopedRouteConfig := &envoy_config_route_v3.ScopedRouteConfiguration{}
+			if err := genericXdsConfig.GetXdsConfig().UnmarshalTo(scopedRouteConfig); err != nil {
+				return nil, fmt.Errorf("unable to parse scoped route configuration %s: %v", genericXdsConfig.GetName(), err)
+			}
+			// route configurations referenced by name are delivered through RDS
+			if scopedRouteConfig.GetRouteConfiguration() != nil {
+				routeConfigs = append(routeConfigs, scopedRouteConfig.GetRouteConfiguration())
+			}
+		}
+	}
+	sort.SliceStable(routeConfigs, func(i, j int) bool {
+		return routeConfigs[i].GetName() < routeConfigs[j].GetName()
+	})
+	return routeConfigs, nil
+}
+
+// formatRouteMatch formats the path, header and query parameter conditions of a route match
+func formatRouteMatch(match *envoy_config_route_v3.RouteMatch) string {
+	var path string
+	switch {
+	case match.GetSafeRegex() != nil:
+		path = "regex:" + match.GetSafeRegex().GetRegex()
+	case match.GetConnectMatcher() != nil:
+		path = "connect"
+	case match.GetPath() != "":
+		path = "path:" + match.GetPath()
+	case match.GetPathSeparatedPrefix() != "":
+		path = "path_separated_prefix:" + match.GetPathSeparatedPrefix()
+	case match.GetPathTemplate() != "":
+		path = "path_template:" + match.GetPathTemplate()
+	default:
+		path = "prefix:" + match.GetPrefix()
+	}
+
+	conditions := []string{path}
+	for _, header := range match.GetHeaders() {
+		conditions = append(conditions, "header:"+formatHeaderMatcher(header))
+	}
+	for _, query := range match.GetQueryParameters() {
+		conditions = append(conditions, "query:"+query.GetName())
+	}
+	return strings.Join(conditions, " ")
+}
+
+// formatHeaderMatcher formats a header matcher as name, operator and value
+func formatHeaderMatcher(header *envoy_config_route_v3.HeaderMatcher) string {
+	var condition string
+	switch {
+	case header.GetExactMatch() != "":
+		condition = "=" + header.GetExactMatch()
+	case header.GetPrefixMatch() != "":
+		condition = "^=" + header.GetPrefixMatch()
+	case header.GetSuffixMatch() != "":
+		condition = "$=" + header.GetSuffixMatch()
+	case header.GetContainsMatch() != "":
+		condition = "*=" + header.GetContainsMatch()
+	case header.GetSafeRegexMatch() != nil:
+		condition = "~=" + header.GetSafeRegexMatch().GetRegex()
+	case header.GetStringMatch() != nil:
+		condition = "~" + header.GetStringMatch().String()
+	case header.GetRangeMatch() != nil:
+		condition = fmt.Sprintf(" in [%d,%d)", header.GetRangeMatch().GetStart(), header.GetRangeMatch().GetEnd())
+	case header.GetPresentMatch():
+		condition = " present"
+	}
+	if header.GetInvertMatch() {
+		return "!" + header.GetName() + condition
+	}
+	return header.GetName() + condition
+}
+
+// formatRouteTarget formats where a route sends the matched requests: a cluster, weighted clusters,
+// a redirect or a direct response
+func formatRouteTarget(route *envoy_config_route_v3.Route) string {
+	switch {
+	case route.GetRoute() != nil:
+		action := route.GetRoute()
+		switch {
+		case action.GetWeightedClusters() != nil:
+			var clusters []string
+			for _, cluster := range action.GetWeightedClusters().GetClusters() {
+				clusters = append(clusters, cluster.GetName()+":"+strconv.Itoa(int(cluster.GetWeight().GetValue())))
+			}
+			return "weighted:" + strings.Join(clusters, ",")
+		case action.GetClusterHeader() != "":
+			return "cluster_header:" + action.GetClusterHeader()
+		case action.GetClusterSpecifierPlugin() != "":
+			return "cluster_specifier_plugin:" + action.GetClusterSpecifierPlugin()
+		default:
+			return action.GetCluster()
+		}
+	case route.GetRedirect() != nil:
+		redirect := route.GetRedirect()
+		target := redirect.GetHostRedirect()
+		if redirect.GetPortRedirect() != 0 {
+			target += ":" + strconv.Itoa(int(redirect.GetPortRedirect()))
+		}
+		switch {
+		case redirect.GetPathRedirect() != "":
+			target += redirect.GetPathRedirect()
+		case redirect.GetPrefixRewrite() != "":
+			target += redirect.GetPrefixRewrite() + "*"
+		}
+		if redirect.GetHttpsRedirect() {
+			target = "https://" + target
+		} else if redirect.GetSchemeRedirect() != "" {
+			target = redirect.GetSchemeRedirect() + "://" + target
+		}
+		return "redirect:" + target
+	case route.GetDirectResponse() != nil:
+		return "direct_response:" + strconv.Itoa(int(route.GetDirectResponse().GetStatus()))
+	case route.GetNonForwardingAction() != nil:
+		return "non_forwarding"
+	default:
+		return "N/A"
+	}
+}
+
+// parseRouteTable flattens the route configurations of a client to one row per route
+func parseRouteTable(config *csdspb_v3.ClientConfig) ([]routeTableRow, error) {
+	routeConfigs, err := parseRouteConfigs(config)
+	if err != nil {
+		return nil, err
+	}
+	var rows []routeTableRow
+	for _, routeConfig := range routeConfigs {
+		for _, virtualHost := range routeConfig.GetVirtualHosts() {
+			for _, route := range virtualHost.GetRoutes() {
+				rows = append(rows, routeTableRow{
+					routeConfig: routeConfig.GetName(),
+					virtualHost: virtualHost.GetName(),
+					domains:     strings.Join(virtualHost.GetDomains(), ","),
+					match:       formatRouteMatch(route.GetMatch()),
+					target:      formatRouteTarget(route),
+				})
+			}
+		}
+	}
+	return rows, nil
+}
+
+// printRouteTable prints the effective route table of the only matched client
+func printRouteTable(w io.Writer, configs []*csdspb_v3.ClientConfig) error {
+	config, err := selectClient(configs, "route_table")
+	if err != nil {
+		return err
+	}
+	rows, err := parseRouteTable(config)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Route table of %s:\n", config.GetNode().GetId())
+	fmt.Fprintf(w, "%-30s %-30s %-30s %-40s %s\n", "Route Config", "Virtual Host", "Domains", "Match", "Target")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%-30s %-30s %-30s %-40s %s\n", row.routeConfig, row.virtualHost, row.domains, row.match, row.target)
+	}
+	if len(rows) == 0 {
+		fmt.Fprintf(w, "No routes found.\n")
+	}
+	return nil
+}
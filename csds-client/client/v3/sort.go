@@ -0,0 +1,61 @@
+package client
+
+import (
+	"sort"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// sortModes are the orders of the clients supported by -sort
+var sortModes = []string{"id", "status", "type", "none"}
+
+// isSortMode returns whether mode is supported by -sort, "" being the default id order
+func isSortMode(mode string) bool {
+	if mode == "" {
+		return true
+	}
+	for _, known := range sortModes {
+		if mode == known {
+			return true
+		}
+	}
+	return false
+}
+
+// worstSeverity returns the severity of the most severe config status of the resources of a client,
+// or -1 if it has none, so that the clients without any resource are sorted after the synced ones
+func worstSeverity(config *csdspb_v3.ClientConfig) int {
+	worst := -1
+	for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+		if severity := compactStatus[genericXdsConfig.GetConfigStatus()].severity; severity > worst {
+			worst = severity
+		}
+	}
+	return worst
+}
+
+// sortClientConfigs returns a sorted copy of configs in the order of -sort: by client id, by the most
+// severe config status first, so that the unhealthy clients are grouped at the top, or by xDS stream type.
+// Ties are ordered by client id, so that the output is stable across runs. "none" keeps the server order.
+func sortClientConfigs(configs []*csdspb_v3.ClientConfig, mode string) []*csdspb_v3.ClientConfig {
+	if mode == "none" {
+		return configs
+	}
+	sorted := append([]*csdspb_v3.ClientConfig{}, configs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		switch mode {
+		case "status":
+			if si, sj := worstSeverity(sorted[i]), worstSeverity(sorted[j]); si != sj {
+				return si > sj
+			}
+		case "type":
+			_, ti := parseNode(sorted[i])
+			_, tj := parseNode(sorted[j])
+			if ti != tj {
+				return ti < tj
+			}
+		}
+		return sorted[i].GetNode().GetId() < sorted[j].GetNode().GetId()
+	})
+	return sorted
+}
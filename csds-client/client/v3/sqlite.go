@@ -0,0 +1,145 @@
+package client
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	// pure-Go SQLite driver, registered as "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the tables of -sqlite_out. Every row references the capture, i.e. the
+// response of one cycle, it was recorded in, so that captures of several runs can be queried together.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS captures (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	captured_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS clients (
+	capture_id INTEGER NOT NULL REFERENCES captures(id),
+	node_id TEXT NOT NULL,
+	metadata TEXT
+);
+CREATE TABLE IF NOT EXISTS configs (
+	capture_id INTEGER NOT NULL REFERENCES captures(id),
+	node_id TEXT NOT NULL,
+	xds TEXT,
+	type_url TEXT NOT NULL,
+	name TEXT,
+	version_info TEXT,
+	config_status TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS statuses (
+	capture_id INTEGER NOT NULL REFERENCES captures(id),
+	node_id TEXT NOT NULL,
+	xds TEXT NOT NULL,
+	config_status TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS captures_captured_at ON captures(captured_at);
+CREATE INDEX IF NOT EXISTS configs_node_id_name ON configs(node_id, name);
+CREATE INDEX IF NOT EXISTS statuses_node_id_xds ON statuses(node_id, xds);
+`
+
+// sqliteTimeFormat is the format of captured_at, which can be used by the SQLite date and time functions
+const sqliteTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// sqliteExporter records the matched clients of each response to the SQLite database of -sqlite_out
+type sqliteExporter struct {
+	db *sql.DB
+}
+
+// newSqliteExporter opens or creates the database at path and creates the missing tables
+func newSqliteExporter(path string) (*sqliteExporter, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open sqlite_out %s: %v", path, err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create the tables of sqlite_out %s: %v", path, err)
+	}
+	return &sqliteExporter{db: db}, nil
+}
+
+// writeCapture inserts the clients, configs and worst status per xDS type of configs as a single
+// capture stamped with now. All the rows of a capture are inserted in one transaction.
+func (e *sqliteExporter) writeCapture(configs []*csdspb_v3.ClientConfig, now time.Time) (err error) {
+	tx, err := e.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.Exec("INSERT INTO captures (captured_at) VALUES (?)", now.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return err
+	}
+	captureId, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	insertClient, err := tx.Prepare("INSERT INTO clients (capture_id, node_id, metadata) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insertClient.Close()
+	insertConfig, err := tx.Prepare("INSERT INTO configs (capture_id, node_id, xds, type_url, name, version_info, config_status) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insertConfig.Close()
+	insertStatus, err := tx.Prepare("INSERT INTO statuses (capture_id, node_id, xds, config_status) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insertStatus.Close()
+
+	for _, config := range configs {
+		if config.GetNode() == nil {
+			continue
+		}
+		id := config.GetNode().GetId()
+		var nodeMetadata sql.NullString
+		if config.GetNode().GetMetadata() != nil {
+			js, err := protojson.Marshal(config.GetNode().GetMetadata())
+			if err != nil {
+				return err
+			}
+			nodeMetadata = sql.NullString{String: string(js), Valid: true}
+		}
+		if _, err := insertClient.Exec(captureId, id, nodeMetadata); err != nil {
+			return err
+		}
+
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			var xds sql.NullString
+			if name, err := xdsShortName(genericXdsConfig.GetTypeUrl()); err == nil {
+				xds = sql.NullString{String: name, Valid: true}
+			}
+			if _, err := insertConfig.Exec(captureId, id, xds, genericXdsConfig.GetTypeUrl(), genericXdsConfig.GetName(),
+				genericXdsConfig.GetVersionInfo(), genericXdsConfig.GetConfigStatus().String()); err != nil {
+				return err
+			}
+		}
+		for xds, status := range parseWorstStatus(config.GetGenericXdsConfigs()) {
+			if _, err := insertStatus.Exec(captureId, id, xds, status.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Close closes the database
+func (e *sqliteExporter) Close() error {
+	return e.db.Close()
+}
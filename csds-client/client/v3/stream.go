@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"time"
+
+	"envoy-tools/csds-client/client"
+	clientutil "envoy-tools/csds-client/client/util"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// streamable reports whether the responses to a request are rendered one at a time by -stream. The other
+// outputs need all the clients at once, e.g. to page them, to diff them with the previous cycle, to
+// section them by NodeMatcher or to size the columns of -columns, and are rendered once the stream is
+// drained like without -stream. The json output format is a single document of the summary and the clients.
+func (c *ClientV3) streamable() bool {
+	if !c.opts.Stream {
+		return false
+	}
+	switch c.opts.OutputFormat {
+	case "", "text", "jsonl", "csv":
+	default:
+		return false
+	}
+	return !c.opts.SummaryOnly && !c.opts.VersionSkew && !c.opts.DumpRaw && !c.opts.MonitorDiff && !c.opts.RouteTable && c.opts.ProbePath == "" &&
+		c.opts.Transform == "" && c.opts.Limit <= 0 && c.opts.Offset == 0 && c.opts.Columns == "" && !c.labelByMatcher()
+}
+
+// streamRequest sends the request and renders the clients of each response as soon as it's received,
+// until the stream is drained like -drain_stream does. The responses are merged once drained for the
+// summary, the detailed config and the checks of checkResponse.
+func (c *ClientV3) streamRequest(ctx context.Context, streamClientStatus csdspb_v3.ClientStatusDiscoveryService_StreamClientStatusClient) error {
+	req := c.BuildRequest()
+	// the round trip lasts until the stream is drained, like with -drain_stream
+	start := time.Now()
+	_, sendSpan := clientutil.StartSpan(ctx, "send")
+	err := sendRequest(streamClientStatus, req)
+	clientutil.EndSpan(sendSpan, err)
+	if err != nil {
+		return err
+	}
+
+	w := c.output()
+	c.printCycleHeader(w)
+	renderer := newStreamRenderer(w, c.opts)
+
+	_, recvSpan := clientutil.StartSpan(ctx, "receive")
+	var resps []*csdspb_v3.ClientStatusResponse
+	err = c.drain(streamClientStatus, func(resp *csdspb_v3.ClientStatusResponse) error {
+		if len(resps) == 0 {
+			// the headers of the stream are received at the latest with the first response
+			printServerIdentity(c.identityOutput(w), parseServerIdentity(streamClientStatus))
+		}
+		resps = append(resps, resp)
+		return renderer.render(resp)
+	})
+	if err != nil && err != io.EOF {
+		err = clientutil.RecvError(err, c.opts)
+		clientutil.EndSpan(recvSpan, err)
+		return err
+	}
+	if len(resps) == 0 {
+		printServerIdentity(c.identityOutput(w), parseServerIdentity(streamClientStatus))
+	}
+	resp := mergeResponses(resps)
+	recvSpan.SetAttributes(clientutil.ClientCountKey.Int(len(resp.GetConfig())))
+	clientutil.EndSpan(recvSpan, nil)
+	roundTrip := time.Since(start)
+	c.metrics.observeLatency(roundTrip)
+	c.timing.observe(roundTrip)
+
+	if err := renderer.finish(resp); err != nil {
+		return err
+	}
+	return c.checkResponse(w, resp)
+}
+
+// streamRenderer renders the clients of the responses to a request one response at a time for -stream.
+// The table and the csv have fixed columns, and the jsonl lines stand on their own, so that the output is
+// the same as the one of the merged response as long as no client is reported twice.
+type streamRenderer struct {
+	w     io.Writer
+	opts  client.ClientOptions
+	color bool
+	// csv writes the rows of the csv output format, flushed after each response
+	csv *csv.Writer
+	// started is set once a response with clients is rendered, and the table or csv header printed
+	started bool
+	// pollTime is the poll time of the lines of the jsonl output format, the same for all the responses
+	pollTime time.Time
+}
+
+// newStreamRenderer creates the renderer of the responses printed to w
+func newStreamRenderer(w io.Writer, opts client.ClientOptions) *streamRenderer {
+	return &streamRenderer{
+		w:        w,
+		opts:     opts,
+		color:    useColor(opts.Color, w),
+		csv:      csv.NewWriter(w),
+		pollTime: time.Now(),
+	}
+}
+
+// render prints the matched clients of resp, sorted by -sort among the clients of resp
+func (r *streamRenderer) render(resp *csdspb_v3.ClientStatusResponse) error {
+	if len(resp.GetConfig()) == 0 {
+		return nil
+	}
+	configs, _, err := filterClientConfigs(resp.GetConfig(), r.opts)
+	if err != nil {
+		return err
+	}
+	if r.opts.StrictTypes {
+		if err := checkXdsTypes(configs); err != nil {
+			return err
+		}
+	}
+	configs = sortClientConfigs(configs, r.opts.Sort)
+
+	switch r.opts.OutputFormat {
+	case "jsonl":
+		if err := printJsonl(r.w, configs, nil, r.pollTime, r.opts.ShowVersion, r.opts.ShowLocality); err != nil {
+			return err
+		}
+	case "csv":
+		if !r.started {
+			if err := writeCsvHeader(r.csv, nil, r.opts.ShowVersion, r.opts.ShowLocality); err != nil {
+				return err
+			}
+		}
+		if err := writeCsvRows(r.csv, configs, nil, r.opts.ShowVersion, r.opts.ShowLocality); err != nil {
+			return err
+		}
+		r.csv.Flush()
+		if err := r.csv.Error(); err != nil {
+			return err
+		}
+	default:
+		if !r.started {
+			printTableHeader(r.w, nil, streamTableWidths(r.opts.ShowVersion, r.opts.ShowLocality))
+		}
+		printTableRows(r.w, configs, r.color, r.opts.UTC, r.opts.ShowErrors, nil, streamTableWidths(r.opts.ShowVersion, r.opts.ShowLocality))
+	}
+	r.started = true
+	return nil
+}
+
+// finish ends the output once the stream is drained, with the summary and the detailed config of resp,
+// the merged responses. If none of the responses had clients, resp is printed like without -stream.
+func (r *streamRenderer) finish(resp *csdspb_v3.ClientStatusResponse) error {
+	if !r.started {
+		return printOutMergedResponse(r.w, resp, r.opts, nil, nil)
+	}
+	configs, counts, err := filterClientConfigs(resp.GetConfig(), r.opts)
+	if err != nil {
+		return err
+	}
+	if r.opts.Verbose {
+		printFilterCounts(counts)
+	}
+
+	switch r.opts.OutputFormat {
+	case "csv", "jsonl":
+	default:
+		printSummary(r.w, tallyConfigs(configs))
+	}
+	return printDetailedConfig(r.w, resp, configs, r.opts)
+}
@@ -0,0 +1,335 @@
+package client
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"envoy-tools/csds-client/client"
+	clientutil "envoy-tools/csds-client/client/util"
+
+	envoy_admin_v3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"github.com/ghodss/yaml"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// clientStatus is the status of a client in the structured output formats. The yaml output format is
+// encoded from the json tags as well, so that both formats have the same structure.
+type clientStatus struct {
+	// PollTime is the time of the request the client was received for, only set by the jsonl output format
+	PollTime      string      `json:"poll_time,omitempty"`
+	Endpoint      string      `json:"endpoint,omitempty"`
+	ClientId      string      `json:"client_id"`
+	XdsStreamType string      `json:"xds_stream_type"`
+	Configs       []xdsStatus `json:"configs"`
+	// Locality is the locality of the node, only set with -show_locality
+	Locality *nodeLocality `json:"locality,omitempty"`
+}
+
+// nodeLocality is the locality of a client in the structured output formats, its parts being empty if unset
+type nodeLocality struct {
+	Region  string `json:"region"`
+	Zone    string `json:"zone"`
+	SubZone string `json:"sub_zone"`
+}
+
+// xdsStatus is the status of an xDS resource of a client in the structured output formats
+type xdsStatus struct {
+	Xds          string `json:"xds"`
+	Status       string `json:"status"`
+	ClientStatus string `json:"client_status,omitempty"`
+	TypeUrl      string `json:"type_url"`
+	// Version is the version_info of the resource, only set with -show_version
+	Version string `json:"version,omitempty"`
+}
+
+// parseClientStatuses converts configs to the intermediate form shared by the structured output formats.
+// Resources of xDS types without a short name are kept with an empty xds, and resources without a
+// client_status with an empty client_status. The endpoint is only set for the response merged from several endpoints,
+// the version of the resources only if showVersion is set, and the locality of the clients only if showLocality is set.
+func parseClientStatuses(configs []*csdspb_v3.ClientConfig, view *endpointView, showVersion bool, showLocality bool) []clientStatus {
+	statuses := make([]clientStatus, 0, len(configs))
+	for _, config := range configs {
+		if config.GetNode() == nil {
+			continue
+		}
+		id, xdsType := parseNode(config)
+		status := clientStatus{Endpoint: view.endpoint(config), ClientId: id, XdsStreamType: xdsType, Configs: make([]xdsStatus, 0, len(config.GetGenericXdsConfigs()))}
+		if showLocality {
+			locality := config.GetNode().GetLocality()
+			status.Locality = &nodeLocality{Region: locality.GetRegion(), Zone: locality.GetZone(), SubZone: locality.GetSubZone()}
+		}
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			xds, _ := xdsShortName(genericXdsConfig.GetTypeUrl())
+			var clientStatus string
+			if genericXdsConfig.GetClientStatus() != envoy_admin_v3.ClientResourceStatus_UNKNOWN {
+				clientStatus = genericXdsConfig.GetClientStatus().String()
+			}
+			resource := xdsStatus{
+				Xds:          xds,
+				Status:       genericXdsConfig.GetConfigStatus().String(),
+				ClientStatus: clientStatus,
+				TypeUrl:      genericXdsConfig.GetTypeUrl(),
+			}
+			if showVersion {
+				resource.Version = genericXdsConfig.GetVersionInfo()
+			}
+			status.Configs = append(status.Configs, resource)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// jsonDocument is the single document of the json and yaml output formats, so that consumers get the summary,
+// the status and the detailed config of the same snapshot in one parse. The sections are built from the same
+// filtered clients: the summary counts all the matched clients, and the other sections the page of them.
+type jsonDocument struct {
+	Summary jsonSummary `json:"summary"`
+	// Identity is the value of each header identifying the control plane, e.g. server, left out if it sent
+	// none or the response was merged from several endpoints
+	Identity map[string]string `json:"identity,omitempty"`
+	// Counts is the number of clients in the response and left after each enabled filter stage, keyed by stage
+	Counts  map[string]int `json:"counts"`
+	Clients []clientStatus `json:"clients"`
+	// Matrix is the worst config status of each xDS type of each client, keyed by client id and xDS type like
+	// the matrix output format
+	Matrix    map[string]map[string]string `json:"matrix"`
+	Resources []clientResources            `json:"resources"`
+}
+
+// jsonSummary is the summary line of the table in the json output format
+type jsonSummary struct {
+	Clients int `json:"clients"`
+	// Statuses is the number of resources of each config status, e.g. SYNCED
+	Statuses map[string]int `json:"statuses"`
+	// Types is the number of resources of each xDS type, e.g. CDS
+	Types     map[string]int `json:"types"`
+	HasErrors bool           `json:"has_errors"`
+}
+
+// clientResources is the detailed config of a client in the json output format
+type clientResources struct {
+	Endpoint  string           `json:"endpoint,omitempty"`
+	ClientId  string           `json:"client_id"`
+	Resources []resourceConfig `json:"resources"`
+}
+
+// resourceConfig is an xDS resource of a client with its decoded config, left out if the server sent none
+type resourceConfig struct {
+	Name string `json:"name"`
+	xdsStatus
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// parseJsonDocument returns the document of the summary of all of configs, the identity of the server, the
+// counts of their filter stages, and the status, the status matrix and the resources of each client of page
+func parseJsonDocument(configs []*csdspb_v3.ClientConfig, counts []filterCount, page []*csdspb_v3.ClientConfig, view *endpointView, identity metadata.MD, opts client.ClientOptions) (jsonDocument, error) {
+	resources, err := parseClientResources(page, view, opts)
+	if err != nil {
+		return jsonDocument{}, err
+	}
+	r := tallyConfigs(configs)
+	summary := jsonSummary{Clients: r.Clients, Statuses: make(map[string]int), Types: r.Types, HasErrors: r.HasErrors}
+	for status, count := range r.Statuses {
+		summary.Statuses[status.String()] = count
+	}
+	stages := make(map[string]int, len(counts))
+	for _, count := range counts {
+		stages[count.stage] = count.count
+	}
+	return jsonDocument{Summary: summary, Identity: identityValues(identity), Counts: stages, Clients: parseClientStatuses(page, view, opts.ShowVersion, opts.ShowLocality), Matrix: parseMatrixCells(page), Resources: resources}, nil
+}
+
+// printJson prints configs as a single JSON document of the summary of all of them, the identity of the
+// server, the counts of their filter stages, and the status and the resources of each client of page
+func printJson(w io.Writer, configs []*csdspb_v3.ClientConfig, counts []filterCount, page []*csdspb_v3.ClientConfig, view *endpointView, identity metadata.MD, opts client.ClientOptions) error {
+	doc, err := parseJsonDocument(configs, counts, page, view, identity, opts)
+	if err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(out))
+	return nil
+}
+
+// parseClientResources returns the resources of each client of configs with their version and decoded
+// config, restricted to -resource_name like the detailed config. Clients without any resource left are
+// dropped.
+func parseClientResources(configs []*csdspb_v3.ClientConfig, view *endpointView, opts client.ClientOptions) ([]clientResources, error) {
+	var withNode []*csdspb_v3.ClientConfig
+	for _, config := range configs {
+		if config.GetNode() != nil {
+			withNode = append(withNode, config)
+		}
+	}
+	if opts.ResourceName != "" {
+		var err error
+		if withNode, err = filterResourceNames(withNode, opts.ResourceNameMode, opts.ResourceName); err != nil {
+			return nil, err
+		}
+	}
+	// parseClientStatuses keeps the clients and the resources in order, as all of them have a node
+	statuses := parseClientStatuses(withNode, view, true, false)
+	clients := make([]clientResources, 0, len(statuses))
+	for i, status := range statuses {
+		if len(status.Configs) == 0 {
+			continue
+		}
+		resources := make([]resourceConfig, 0, len(status.Configs))
+		for j, genericXdsConfig := range withNode[i].GetGenericXdsConfigs() {
+			resource := resourceConfig{Name: genericXdsConfig.GetName(), xdsStatus: status.Configs[j]}
+			if genericXdsConfig.GetXdsConfig() != nil && !opts.ExcludeContents {
+				config, err := marshalXdsConfig(genericXdsConfig, opts)
+				if err != nil {
+					return nil, fmt.Errorf("unable to marshal %s of %s: %v", genericXdsConfig.GetName(), status.ClientId, err)
+				}
+				resource.Config = config
+			}
+			resources = append(resources, resource)
+		}
+		clients = append(clients, clientResources{Endpoint: status.Endpoint, ClientId: status.ClientId, Resources: resources})
+	}
+	return clients, nil
+}
+
+// marshalXdsConfig returns the decoded xds_config of genericXdsConfig, redacted with -redact, like the
+// detailed config prints it
+func marshalXdsConfig(genericXdsConfig *csdspb_v3.ClientConfig_GenericXdsConfig, opts client.ClientOptions) (json.RawMessage, error) {
+	var msg proto.Message = genericXdsConfig
+	if opts.Redact {
+		msg = clientutil.Redact(msg)
+	}
+	out, err := clientutil.MarshalDetailedConfig(msg, 1, !opts.RawAny)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(out, &fields); err != nil {
+		return nil, err
+	}
+	return fields["xdsConfig"], nil
+}
+
+// printJsonl prints the status of each client as a compact JSON object on a line of its own, with the time
+// of the poll now, so that the clients of the monitor cycles appended to the output can be tailed and ordered
+func printJsonl(w io.Writer, configs []*csdspb_v3.ClientConfig, view *endpointView, now time.Time, showVersion bool, showLocality bool) error {
+	pollTime := now.UTC().Format(time.RFC3339Nano)
+	for _, status := range parseClientStatuses(configs, view, showVersion, showLocality) {
+		status.PollTime = pollTime
+		out, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(out))
+	}
+	return nil
+}
+
+// printYaml prints the same document as printJson as YAML. Keys are sorted so that the output of the same
+// status is stable across runs, and multi-line strings of the configs are encoded as block scalars.
+func printYaml(w io.Writer, configs []*csdspb_v3.ClientConfig, counts []filterCount, page []*csdspb_v3.ClientConfig, view *endpointView, identity metadata.MD, opts client.ClientOptions) error {
+	doc, err := parseJsonDocument(configs, counts, page, view, identity, opts)
+	if err != nil {
+		return err
+	}
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(w, string(out))
+	return nil
+}
+
+// printPrototext prints the configs as a ClientStatusResponse in the multiline protobuf text format, e.g. for
+// tools diffing protos, with all their fields like -dump_raw but only the resources of -xds_type and
+// -status_filter like the detailed config. Nothing is printed without any client, which is the text format
+// of an empty response.
+func printPrototext(w io.Writer, configs []*csdspb_v3.ClientConfig, opts client.ClientOptions) error {
+	if opts.XdsType != "" {
+		configs = filterXdsTypes(configs, parseXdsTypes(opts.XdsType))
+	}
+	if opts.StatusFilter != "" {
+		configs = filterConfigStatuses(configs, parseStatusList(opts.StatusFilter))
+	}
+	var msg proto.Message = &csdspb_v3.ClientStatusResponse{Config: configs}
+	if opts.Redact {
+		msg = clientutil.Redact(msg)
+	}
+	out, err := clientutil.MarshalPrototext(msg, !opts.RawAny)
+	if err != nil {
+		return fmt.Errorf("unable to marshal the response: %v", err)
+	}
+	fmt.Fprint(w, string(out))
+	return nil
+}
+
+// csvHeader is the header row of the csv output format
+var csvHeader = []string{"client_id", "xds_stream_type", "xds", "config_status", "client_status", "type_url"}
+
+// printCsv prints one row per xDS resource of each client, repeating the client on each row.
+// Clients without any resource get a single row with empty xDS columns, so that every client is counted.
+// The response merged from several endpoints gets a leading endpoint column, -show_version a trailing version column
+// and -show_locality trailing region, zone and sub_zone columns.
+func printCsv(w io.Writer, configs []*csdspb_v3.ClientConfig, view *endpointView, showVersion bool, showLocality bool) error {
+	writer := csv.NewWriter(w)
+	if err := writeCsvHeader(writer, view, showVersion, showLocality); err != nil {
+		return err
+	}
+	if err := writeCsvRows(writer, configs, view, showVersion, showLocality); err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeCsvHeader writes the header row of the csv output format
+func writeCsvHeader(writer *csv.Writer, view *endpointView, showVersion bool, showLocality bool) error {
+	header := csvHeader
+	if view != nil {
+		header = append([]string{"endpoint"}, csvHeader...)
+	}
+	if showVersion {
+		header = append(append([]string{}, header...), "version")
+	}
+	if showLocality {
+		header = append(append([]string{}, header...), "region", "zone", "sub_zone")
+	}
+	return writer.Write(header)
+}
+
+// writeCsvRows writes the rows of the resources of configs, without flushing them
+func writeCsvRows(writer *csv.Writer, configs []*csdspb_v3.ClientConfig, view *endpointView, showVersion bool, showLocality bool) error {
+	write := func(status clientStatus, version string, row ...string) error {
+		if view != nil {
+			row = append([]string{status.Endpoint}, row...)
+		}
+		if showVersion {
+			row = append(row, version)
+		}
+		if showLocality {
+			row = append(row, status.Locality.Region, status.Locality.Zone, status.Locality.SubZone)
+		}
+		return writer.Write(row)
+	}
+	for _, status := range parseClientStatuses(configs, view, showVersion, showLocality) {
+		if len(status.Configs) == 0 {
+			if err := write(status, "", status.ClientId, status.XdsStreamType, "", "", "", ""); err != nil {
+				return err
+			}
+		}
+		for _, config := range status.Configs {
+			if err := write(status, config.Version, status.ClientId, status.XdsStreamType, config.Xds, config.Status, config.ClientStatus, config.TypeUrl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
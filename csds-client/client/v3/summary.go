@@ -0,0 +1,137 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"envoy-tools/csds-client/client"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// otherXds is the bucket of the summary counting the resources of the xDS types without a short name
+const otherXds = "OTHER"
+
+// Result is the tally of the matched clients of a response, which the summary line of the client status
+// prints. Library callers get it from Tally to act on the health of the clients without parsing the output.
+type Result struct {
+	// Clients is the number of clients
+	Clients int
+	// Statuses is the number of resources of each config status across the clients, the statuses no
+	// resource reports being left out
+	Statuses map[csdspb_v3.ConfigStatus]int
+	// Types is the number of resources of each xDS type across the clients by short name, e.g. CDS, with
+	// the types without a short name counted as OTHER
+	Types map[string]int
+	// HasErrors tells whether a resource is in the ERROR config status or reports the error state of a
+	// rejected update
+	HasErrors bool
+}
+
+// tallyConfigs counts the clients of configs and the config statuses and xDS types of their resources,
+// including the resources of unsupported xDS types, as parseConfigStatus shows them too
+func tallyConfigs(configs []*csdspb_v3.ClientConfig) Result {
+	r := Result{Statuses: make(map[csdspb_v3.ConfigStatus]int), Types: make(map[string]int)}
+	for _, config := range configs {
+		if config.GetNode() == nil {
+			continue
+		}
+		r.Clients++
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			r.Statuses[genericXdsConfig.GetConfigStatus()]++
+			if genericXdsConfig.GetConfigStatus() == csdspb_v3.ConfigStatus_ERROR || genericXdsConfig.GetErrorState() != nil {
+				r.HasErrors = true
+			}
+			xds, err := xdsShortName(genericXdsConfig.GetTypeUrl())
+			if err != nil {
+				xds = otherXds
+			}
+			r.Types[xds]++
+		}
+	}
+	return r
+}
+
+// tallyResponse is the tally of the clients of response matched by the filters of opts, which fails like
+// the client status with -strict_types
+func tallyResponse(response *csdspb_v3.ClientStatusResponse, opts client.ClientOptions) (Result, error) {
+	configs, _, err := filterClientConfigs(response.GetConfig(), opts)
+	if err != nil {
+		return Result{}, err
+	}
+	if opts.StrictTypes {
+		if err := checkXdsTypes(configs); err != nil {
+			return Result{}, err
+		}
+	}
+	return tallyConfigs(configs), nil
+}
+
+// Tally returns the tally of the clients of resp matched by the filters of the client, e.g. of a response
+// returned by Fetch, without printing anything. It counts the same clients as the summary line, before
+// -limit and -offset.
+func (c *ClientV3) Tally(resp *csdspb_v3.ClientStatusResponse) (Result, error) {
+	return tallyResponse(resp, c.opts)
+}
+
+// matchStatuses returns the sorted statuses of failOn that are reported by a resource
+func (r Result) matchStatuses(failOn []string) []string {
+	var matched []string
+	for _, status := range failOn {
+		if r.Statuses[csdspb_v3.ConfigStatus(csdspb_v3.ConfigStatus_value[status])] != 0 {
+			matched = append(matched, status)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+// tolerateStale returns copies of configs without the STALE resources last updated less than window before
+// now, which -fail_on tolerates as transient during a rollout. The STALE resources without a last updated
+// time are kept, as how long they have been stale is unknown.
+func tolerateStale(configs []*csdspb_v3.ClientConfig, window time.Duration, now time.Time) []*csdspb_v3.ClientConfig {
+	tolerated := make([]*csdspb_v3.ClientConfig, 0, len(configs))
+	for _, config := range configs {
+		var xdsConfigs []*csdspb_v3.ClientConfig_GenericXdsConfig
+		for _, genericXdsConfig := range config.GetGenericXdsConfigs() {
+			lastUpdated := genericXdsConfig.GetLastUpdated()
+			if genericXdsConfig.GetConfigStatus() == csdspb_v3.ConfigStatus_STALE && lastUpdated != nil && now.Sub(lastUpdated.AsTime()) < window {
+				continue
+			}
+			xdsConfigs = append(xdsConfigs, genericXdsConfig)
+		}
+		tolerated = append(tolerated, &csdspb_v3.ClientConfig{
+			Node:              config.GetNode(),
+			XdsConfig:         config.GetXdsConfig(),
+			GenericXdsConfigs: xdsConfigs,
+		})
+	}
+	return tolerated
+}
+
+// printSummary prints the number of clients and of resources per config status of r on a single line,
+// e.g. "Clients: 42  SYNCED: 40  STALE: 1  ERROR: 1". Statuses no resource reports are left out.
+// If the clients have resources, their number per xDS type follows on a second line, e.g.
+// "CDS:120 LDS:40 RDS:60 EDS:300", with the types without a short name counted as OTHER.
+func printSummary(w io.Writer, r Result) {
+	fields := []string{fmt.Sprintf("Clients: %d", r.Clients)}
+	for status := csdspb_v3.ConfigStatus_UNKNOWN; status <= csdspb_v3.ConfigStatus_ERROR; status++ {
+		if r.Statuses[status] != 0 {
+			fields = append(fields, fmt.Sprintf("%s: %d", status, r.Statuses[status]))
+		}
+	}
+	fmt.Fprintln(w, strings.Join(fields, "  "))
+
+	var types []string
+	for _, xds := range append(append([]string{}, knownXds...), otherXds) {
+		if r.Types[xds] != 0 {
+			types = append(types, fmt.Sprintf("%s:%d", xds, r.Types[xds]))
+		}
+	}
+	if len(types) != 0 {
+		fmt.Fprintln(w, strings.Join(types, " "))
+	}
+}
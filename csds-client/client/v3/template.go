@@ -0,0 +1,166 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"envoy-tools/csds-client/client"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+)
+
+// templateData is the root of the data -template is executed against
+type templateData struct {
+	// PollTime is the time the response is printed at
+	PollTime time.Time
+	Clients  []templateClient
+}
+
+// templateClient is a client as executed by -template: the client of the structured output formats, with
+// the name and the last updated time of its resources
+type templateClient struct {
+	Endpoint      string
+	ClientId      string
+	XdsStreamType string
+	Configs       []templateResource
+}
+
+// templateResource is an xDS resource of a client as executed by -template, e.g. {{.Xds}} or {{.Name}}
+type templateResource struct {
+	xdsStatus
+	Name string
+	// LastUpdated is the zero time for the resources without a last updated time
+	LastUpdated time.Time
+}
+
+// templateErrorLine matches the line of the template an error of text/template occurred at
+var templateErrorLine = regexp.MustCompile(`^template: template:(\d+)`)
+
+// loadTemplate returns the text of -template, which is the template itself or, if it starts with "@", the
+// path of the file holding it
+func loadTemplate(spec string) (string, error) {
+	if !strings.HasPrefix(spec, "@") {
+		return spec, nil
+	}
+	text, err := ioutil.ReadFile(spec[1:])
+	if err != nil {
+		return "", fmt.Errorf("unable to read template file: %v", err)
+	}
+	return string(text), nil
+}
+
+// parseTemplate parses the text of -template with the template functions. Colors are only rendered by the
+// status function if color is set.
+func parseTemplate(text string, color bool, utc bool) (*template.Template, error) {
+	tmpl, err := template.New("template").Funcs(templateFuncs(color, utc)).Parse(text)
+	if err != nil {
+		return nil, templateError(text, err)
+	}
+	return tmpl, nil
+}
+
+// templateFuncs returns the functions available to -template:
+//   - status colorizes a config status like the table does, e.g. {{status .Status}}
+//   - time formats a time like the Last Updated column, in RFC 3339 and the local time zone unless utc
+//     is set, or "-" for the zero time, e.g. {{time .LastUpdated}}
+//   - formatTime formats a time with a Go layout, e.g. {{.LastUpdated | formatTime "15:04:05"}}
+//   - since returns the time elapsed since a time rounded to the second, e.g. {{since .LastUpdated}}
+func templateFuncs(color bool, utc bool) template.FuncMap {
+	zone := func(t time.Time) time.Time {
+		if utc {
+			return t.UTC()
+		}
+		return t.Local()
+	}
+	return template.FuncMap{
+		"status": func(status string) string {
+			statusColor := configStatusColor(csdspb_v3.ConfigStatus(csdspb_v3.ConfigStatus_value[status]))
+			if !color || statusColor == "" {
+				return status
+			}
+			return statusColor + status + colorReset
+		},
+		"time": func(t time.Time) string {
+			if t.IsZero() {
+				return "-"
+			}
+			return zone(t).Format(time.RFC3339)
+		},
+		"formatTime": func(layout string, t time.Time) string {
+			return zone(t).Format(layout)
+		},
+		"since": func(t time.Time) time.Duration {
+			return time.Since(t).Round(time.Second)
+		},
+	}
+}
+
+// templateError appends the line of text an error of text/template occurred at, if the error names one
+func templateError(text string, err error) error {
+	m := templateErrorLine.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	n, _ := strconv.Atoi(m[1])
+	lines := strings.Split(text, "\n")
+	if n < 1 || n > len(lines) {
+		return err
+	}
+	return fmt.Errorf("%v\n%4d | %s", err, n, lines[n-1])
+}
+
+// parseTemplateClients converts configs to the clients -template is executed against
+func parseTemplateClients(configs []*csdspb_v3.ClientConfig, view *endpointView) []templateClient {
+	// parseClientStatuses skips the clients without a node, keeping the order of the others
+	var withNode []*csdspb_v3.ClientConfig
+	for _, config := range configs {
+		if config.GetNode() != nil {
+			withNode = append(withNode, config)
+		}
+	}
+	statuses := parseClientStatuses(withNode, view, true, false)
+	clients := make([]templateClient, 0, len(statuses))
+	for i, status := range statuses {
+		resources := make([]templateResource, 0, len(status.Configs))
+		for j, genericXdsConfig := range withNode[i].GetGenericXdsConfigs() {
+			resource := templateResource{xdsStatus: status.Configs[j], Name: genericXdsConfig.GetName()}
+			if ts := genericXdsConfig.GetLastUpdated(); ts.GetSeconds() != 0 || ts.GetNanos() != 0 {
+				resource.LastUpdated = ts.AsTime()
+			}
+			resources = append(resources, resource)
+		}
+		clients = append(clients, templateClient{
+			Endpoint:      status.Endpoint,
+			ClientId:      status.ClientId,
+			XdsStreamType: status.XdsStreamType,
+			Configs:       resources,
+		})
+	}
+	return clients
+}
+
+// printTemplate executes -template against configs and prints its output. Nothing is printed if the
+// template fails, so that a partial output isn't mistaken for a complete one.
+func printTemplate(w io.Writer, configs []*csdspb_v3.ClientConfig, view *endpointView, opts client.ClientOptions, now time.Time) error {
+	text, err := loadTemplate(opts.Template)
+	if err != nil {
+		return err
+	}
+	tmpl, err := parseTemplate(text, useColor(opts.Color, w), opts.UTC)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, templateData{PollTime: now, Clients: parseTemplateClients(configs, view)}); err != nil {
+		return templateError(text, err)
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
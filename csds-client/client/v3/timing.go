@@ -0,0 +1,68 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// requestTiming is only used by -timing to report the dial time and the round trip time of each request,
+// and their min, avg and max across the requests of the monitor mode. The durations are measured with
+// the monotonic clock of time.Now, so that changes to the wall clock don't skew them.
+type requestTiming struct {
+	// label tells apart the endpoints of several uris, "" for a single one
+	label string
+	// w is where the timings are printed, stderr so that they don't mix with the output
+	w io.Writer
+
+	count int
+	min   time.Duration
+	max   time.Duration
+	total time.Duration
+}
+
+// newRequestTiming creates the timing of the requests to the endpoint named by label
+func newRequestTiming(label string) *requestTiming {
+	return &requestTiming{label: label, w: os.Stderr}
+}
+
+// prefix returns the start of each timing line
+func (t *requestTiming) prefix() string {
+	if t.label == "" {
+		return "Timing:"
+	}
+	return fmt.Sprintf("Timing of %s:", t.label)
+}
+
+// dialed prints the time it took to connect to the server, which the round trips exclude
+func (t *requestTiming) dialed(d time.Duration) {
+	if t == nil {
+		return
+	}
+	fmt.Fprintf(t.w, "%s dial %v\n", t.prefix(), d.Round(time.Microsecond))
+}
+
+// observe prints the round trip time of a request, from sending it to receiving its response,
+// followed by the min, avg and max round trips so far once there are several
+func (t *requestTiming) observe(d time.Duration) {
+	if t == nil {
+		return
+	}
+	if t.count == 0 || d < t.min {
+		t.min = d
+	}
+	if d > t.max {
+		t.max = d
+	}
+	t.count++
+	t.total += d
+
+	line := fmt.Sprintf("%s request %v", t.prefix(), d.Round(time.Microsecond))
+	if t.count > 1 {
+		avg := t.total / time.Duration(t.count)
+		line += fmt.Sprintf(" (min %v, avg %v, max %v over %d requests)",
+			t.min.Round(time.Microsecond), avg.Round(time.Microsecond), t.max.Round(time.Microsecond), t.count)
+	}
+	fmt.Fprintln(t.w, line)
+}
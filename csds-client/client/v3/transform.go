@@ -0,0 +1,46 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	csdspb_v3 "github.com/envoyproxy/go-control-plane/envoy/service/status/v3"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// transformResponse pipes the protojson encoded response to the shell command of -transform and parses
+// its output back as a ClientStatusResponse. The output must be a valid protojson ClientStatusResponse,
+// unknown fields are rejected so that a broken transform doesn't silently drop data.
+func transformResponse(ctx context.Context, command string, response *csdspb_v3.ClientStatusResponse) (*csdspb_v3.ClientStatusResponse, error) {
+	input, err := protojson.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	var output bytes.Buffer
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &output
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("transform %q failed: %v", command, err)
+	}
+	if len(bytes.TrimSpace(output.Bytes())) == 0 {
+		return nil, fmt.Errorf("transform %q returned no output", command)
+	}
+
+	transformed := &csdspb_v3.ClientStatusResponse{}
+	if err := protojson.Unmarshal(output.Bytes(), transformed); err != nil {
+		return nil, fmt.Errorf("transform %q returned an invalid ClientStatusResponse: %v", command, err)
+	}
+	return transformed, nil
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"envoy-tools/csds-client/client"
+	clientutil "envoy-tools/csds-client/client/util"
 	client_v2 "envoy-tools/csds-client/client/v2"
 	client_v3 "envoy-tools/csds-client/client/v3"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 )
 
@@ -22,54 +28,430 @@ var monitorInterval time.Duration
 var visualization bool
 var filterMode string
 var filterPattern string
+var otelEndpoint string
+var outputFormat string
+var drainStream bool
+var drainTimeout time.Duration
+var metaMissing string
+var verbose bool
+var monitorOutputDir string
+var onlyLastCycle bool
+var selfDiff time.Duration
+var selfDiffFail bool
+var routeTable bool
+var probePath string
+var probeMethod string
+var probeHeaders stringList
+var sqliteOut string
+var assertConsistent bool
+var transform string
+var requestMode string
+var color string
+var failOn string
+var summaryOnly bool
+var xdsType string
+var filterInvert bool
+var filterIgnoreCase bool
+var monitorCount int
+var monitorDiff bool
+var clientCert string
+var clientKey string
+var caCert string
+var serviceAccountFile string
+var connectTimeout time.Duration
+var proxyUri string
+var strictTypes bool
+var utc bool
+var showErrors bool
+var dumpRaw bool
+var maxRetries int
+var sortMode string
+var limit int
+var offset int
+var inputFile string
+var metricsAddr string
+var timing bool
+var concurrency int
+var stream bool
+var quiet bool
+var jwtEnv string
+var dryRun bool
+var waitForClients time.Duration
+var waitInterval time.Duration
+var failOnNoClients bool
+var statusFilter string
+var watch bool
+var resolveAny bool
+var redact bool
+var columns string
+var fixedWidth bool
+var noTruncate bool
+var compress bool
+var maxRecvBytes int
+var keepaliveInterval time.Duration
+var keepaliveTimeout time.Duration
+var checkConnectivity bool
+var showVersion bool
+var requestTimeout time.Duration
+var excludeContents bool
+var showVersionInfo bool
+var versionSkew bool
+var dumpDir string
+var resourceName string
+var resourceNameMode string
+var lenientYaml bool
+var templateSpec string
+var tolerateStaleFor time.Duration
+var nodeId string
+var requestRetries int
+var showLocality bool
+
+// stringList is a flag value that collects the values of a repeatable flag
+type stringList []string
+
+// String implements flag.Value
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set implements flag.Value
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// exit codes for conditions detected by a successful run
+const (
+	exitCodeChangesDetected      = 3
+	exitCodeInconsistentVersions = 4
+	exitCodeStatusMatched        = 5
+	exitCodeNoClients            = 6
+	exitCodeAuthFailed           = 7
+	exitCodeUnreachable          = 8
+)
 
 // const default values for flag vars
 const (
-	uriDefault             string        = "trafficdirector.googleapis.com:443"
-	platformDefault        string        = "gcp"
-	authnModeDefault       string        = "auto"
-	apiVersionDefault      string        = "v2"
-	requestFileDefault     string        = ""
-	requestYamlDefault     string        = ""
-	jwtDefault             string        = ""
-	configFileDefault      string        = ""
-	monitorIntervalDefault time.Duration = 0
-	visualizationDefault   bool          = false
-	filterModeDefault      string        = ""
-	filterPatternDefault   string        = ""
+	uriDefault                string        = "trafficdirector.googleapis.com:443"
+	platformDefault           string        = "gcp"
+	authnModeDefault          string        = "auto"
+	apiVersionDefault         string        = "v2"
+	requestFileDefault        string        = ""
+	requestYamlDefault        string        = ""
+	jwtDefault                string        = ""
+	configFileDefault         string        = ""
+	monitorIntervalDefault    time.Duration = 0
+	visualizationDefault      bool          = false
+	filterModeDefault         string        = ""
+	filterPatternDefault      string        = ""
+	otelEndpointDefault       string        = ""
+	outputFormatDefault       string        = "text"
+	drainStreamDefault        bool          = false
+	drainTimeoutDefault       time.Duration = time.Second
+	metaMissingDefault        string        = ""
+	verboseDefault            bool          = false
+	monitorOutputDirDefault   string        = ""
+	onlyLastCycleDefault      bool          = false
+	selfDiffDefault           time.Duration = 0
+	selfDiffFailDefault       bool          = true
+	routeTableDefault         bool          = false
+	probePathDefault          string        = ""
+	probeMethodDefault        string        = "GET"
+	sqliteOutDefault          string        = ""
+	assertConsistentDefault   bool          = false
+	transformDefault          string        = ""
+	requestModeDefault        string        = "both"
+	colorDefault              string        = "auto"
+	failOnDefault             string        = ""
+	summaryOnlyDefault        bool          = false
+	xdsTypeDefault            string        = ""
+	filterInvertDefault       bool          = false
+	filterIgnoreCaseDefault   bool          = false
+	monitorCountDefault       int           = 0
+	monitorDiffDefault        bool          = false
+	clientCertDefault         string        = ""
+	clientKeyDefault          string        = ""
+	caCertDefault             string        = ""
+	serviceAccountFileDefault string        = ""
+	connectTimeoutDefault     time.Duration = 10 * time.Second
+	proxyUriDefault           string        = ""
+	strictTypesDefault        bool          = false
+	utcDefault                bool          = false
+	showErrorsDefault         bool          = false
+	dumpRawDefault            bool          = false
+	maxRetriesDefault         int           = 5
+	sortModeDefault           string        = "id"
+	limitDefault              int           = 0
+	offsetDefault             int           = 0
+	inputFileDefault          string        = ""
+	metricsAddrDefault        string        = ""
+	timingDefault             bool          = false
+	concurrencyDefault        int           = 0
+	streamDefault             bool          = false
+	quietDefault              bool          = false
+	jwtEnvDefault             string        = ""
+	dryRunDefault             bool          = false
+	waitForClientsDefault     time.Duration = 0
+	waitIntervalDefault       time.Duration = time.Second
+	failOnNoClientsDefault    bool          = false
+	statusFilterDefault       string        = ""
+	watchDefault              bool          = false
+	resolveAnyDefault         bool          = true
+	redactDefault             bool          = false
+	columnsDefault            string        = ""
+	fixedWidthDefault         bool          = false
+	noTruncateDefault         bool          = false
+	compressDefault           bool          = false
+	maxRecvBytesDefault       int           = 64 << 20
+	keepaliveIntervalDefault  time.Duration = 5 * time.Minute
+	keepaliveTimeoutDefault   time.Duration = 20 * time.Second
+	checkConnectivityDefault  bool          = false
+	showVersionDefault        bool          = false
+	requestTimeoutDefault     time.Duration = 30 * time.Second
+	excludeContentsDefault    bool          = false
+	showVersionInfoDefault    bool          = false
+	versionSkewDefault        bool          = false
+	dumpDirDefault            string        = ""
+	resourceNameDefault       string        = ""
+	resourceNameModeDefault   string        = "exact"
+	lenientYamlDefault        bool          = false
+	templateDefault           string        = ""
+	tolerateStaleForDefault   time.Duration = 0
+	nodeIdDefault             string        = ""
+	requestRetriesDefault     int           = 0
+	showLocalityDefault       bool          = false
 )
 
 // init binds flags with variables
 func init() {
-	flag.StringVar(&uri, "service_uri", uriDefault, "the uri of the service to connect to")
+	flag.StringVar(&uri, "service_uri", uriDefault, "the uri of the service to connect to, or a comma-separated list of uris to merge the responses of (v3 only)")
 	flag.StringVar(&platform, "platform", platformDefault, "the platform (e.g. gcp, aws,  ...)")
-	flag.StringVar(&authnMode, "authn_mode", authnModeDefault, "the method to use for authentication (e.g. auto, jwt, ...)")
+	flag.StringVar(&authnMode, "authn_mode", authnModeDefault, "the method to use for authentication (e.g. auto, adc, jwt, sa, mtls, insecure, ...)")
 	flag.StringVar(&apiVersion, "api_version", apiVersionDefault, "which xds api major version to use (e.g. v2, v3, ...)")
-	flag.StringVar(&requestFile, "request_file", requestFileDefault, "yaml file that defines the csds request")
+	flag.StringVar(&requestFile, "request_file", requestFileDefault, "yaml file that defines the csds request, or - to read it from stdin")
 	flag.StringVar(&requestYaml, "request_yaml", requestYamlDefault, "yaml string that defines the csds request")
-	flag.StringVar(&jwt, "jwt_file", jwtDefault, "path of the -jwt_file")
-	flag.StringVar(&configFile, "output_file", configFileDefault, "file name to save configs returned by csds response")
+	flag.StringVar(&jwt, "jwt_file", jwtDefault, "path of the jwt_file, or - to read the jwt key from stdin")
+	flag.StringVar(&configFile, "output_file", configFileDefault, "file name to save the output of the csds responses to, instead of stdout")
 	flag.DurationVar(&monitorInterval, "monitor_interval", monitorIntervalDefault, "the interval of sending request in monitor mode (e.g. 500ms, 2s, 1m ...)")
+	flag.StringVar(&monitorOutputDir, "monitor_output_dir", monitorOutputDirDefault, "directory to save the configs returned by each csds response in monitor mode")
+	flag.BoolVar(&onlyLastCycle, "only_last_cycle", onlyLastCycleDefault, "option to only keep the configs of the latest monitor cycle as latest.json in -monitor_output_dir")
 	flag.BoolVar(&visualization, "visualization", visualizationDefault, "option to visualize the relationship between xDS")
-	flag.StringVar(&filterMode, "filter_mode", filterModeDefault, "the filter mode for the filter on xDS nodes to be returned (e.g. prefix, suffix, regex, ...)")
-	flag.StringVar(&filterPattern, "filter_pattern", filterPatternDefault, "the filter pattern for the filter on xDS nodes to be returned")
+	flag.StringVar(&filterMode, "filter_mode", filterModeDefault, "the filter mode for the filter on xDS nodes to be returned (e.g. prefix, suffix, exact, regex, glob, ...)")
+	flag.StringVar(&filterPattern, "filter_pattern", filterPatternDefault, "the filter pattern for the filter on xDS nodes to be returned, a comma-separated list matches a node if any of its patterns matches")
+	flag.StringVar(&outputFormat, "output_format", outputFormatDefault, "the format of the client status output (e.g. text, compact, matrix, json, jsonl, yaml, csv, prototext, ...)")
+	flag.StringVar(&metaMissing, "meta_missing", metaMissingDefault, "only return xDS nodes whose node metadata lacks this key")
+	flag.BoolVar(&drainStream, "drain_stream", drainStreamDefault, "option to keep receiving responses for a request until EOF or -drain_timeout passes without a response, and merge them")
+	flag.DurationVar(&drainTimeout, "drain_timeout", drainTimeoutDefault, "the quiescence timeout after which -drain_stream stops receiving (e.g. 500ms, 2s, ...)")
+	flag.BoolVar(&verbose, "verbose", verboseDefault, "option to print diagnostic information to stderr (v3 only)")
+	flag.StringVar(&otelEndpoint, "otel_endpoint", otelEndpointDefault, "the OTLP/gRPC endpoint to export OpenTelemetry traces to (e.g. localhost:4317)")
+	flag.DurationVar(&selfDiff, "self_diff", selfDiffDefault, "fetch twice this interval apart, print the changes between the two responses and exit (e.g. 10s, 1m ...)")
+	flag.BoolVar(&selfDiffFail, "self_diff_fail", selfDiffFailDefault, "option to exit with code 3 if -self_diff detected changes")
+	flag.BoolVar(&routeTable, "route_table", routeTableDefault, "option to print the effective route table (virtual host, route match, target cluster) of the only matched client")
+	flag.StringVar(&probePath, "probe_path", probePathDefault, "the <host>/<path> of a request to evaluate against the route configs of the only matched client, printing the selected route, cluster and rewrites")
+	flag.StringVar(&probeMethod, "probe_method", probeMethodDefault, "the method of the -probe_path request, matched as the :method header")
+	flag.Var(&probeHeaders, "probe_header", "a name=value header of the -probe_path request, can be repeated")
+	flag.StringVar(&sqliteOut, "sqlite_out", sqliteOutDefault, "the SQLite database file to record the clients, configs and statuses of each csds response to (v3 only)")
+	flag.BoolVar(&assertConsistent, "assert_consistent", assertConsistentDefault, "option to check that all matched clients report the same version_info of each xDS type, and exit with code 4 if they diverge")
+	flag.StringVar(&transform, "transform", transformDefault, "the shell command to pipe each csds response to as protojson, whose output replaces the response before printing")
+	flag.StringVar(&requestMode, "request_mode", requestModeDefault, "what the csds request carries: both the node matchers and the node id, matchers_only or node_only")
+	flag.StringVar(&color, "color", colorDefault, "when to colorize the config statuses: auto (only on a terminal), always or never; NO_COLOR disables colors (v3 only)")
+	flag.StringVar(&failOn, "fail_on", failOnDefault, "comma-separated config statuses (e.g. ERROR,STALE) that make the client exit with code 5 if any matched client reports them")
+	flag.BoolVar(&summaryOnly, "summary_only", summaryOnlyDefault, "print only the summary line with the number of clients and of resources per config status, instead of the per-client table")
+	flag.StringVar(&xdsType, "xds_type", xdsTypeDefault, "comma-separated xDS types (e.g. LDS,RDS) to restrict the client status and the detailed config to")
+	flag.BoolVar(&filterInvert, "filter_invert", filterInvertDefault, "option to return the xDS nodes that do not match the filter pattern instead")
+	flag.BoolVar(&filterIgnoreCase, "filter_ignore_case", filterIgnoreCaseDefault, "option to match the filter pattern against xDS nodes ignoring case")
+	flag.IntVar(&monitorCount, "monitor_count", monitorCountDefault, "the number of requests to send in monitor mode before exiting, 0 to run until interrupted")
+	flag.BoolVar(&monitorDiff, "monitor_diff", monitorDiffDefault, "option to print only the changes since the previous request in monitor mode, after printing the first response in full")
+	flag.StringVar(&clientCert, "client_cert", clientCertDefault, "path of the client certificate used by the mtls authn_mode")
+	flag.StringVar(&clientKey, "client_key", clientKeyDefault, "path of the private key of client_cert")
+	flag.StringVar(&caCert, "ca_cert", caCertDefault, "path of the CA certificate bundle used to verify the server instead of the system roots")
+	flag.StringVar(&serviceAccountFile, "service_account_file", serviceAccountFileDefault, "path of the service account JSON key file used by the sa authn_mode")
+	flag.DurationVar(&connectTimeout, "connect_timeout", connectTimeoutDefault, "the timeout of connecting to the server (e.g. 5s, 1m, ...)")
+	flag.StringVar(&proxyUri, "proxy", proxyUriDefault, "the http:// or socks5:// proxy to connect to the server through, instead of HTTPS_PROXY or ALL_PROXY")
+	flag.BoolVar(&strictTypes, "strict_types", strictTypesDefault, "fail on resources of unsupported xDS types instead of listing them by their type url")
+	flag.BoolVar(&utc, "utc", utcDefault, "print the last_updated time of the resources in UTC instead of the local time zone")
+	flag.BoolVar(&showErrors, "show_errors", showErrorsDefault, "print the error details and the failed version beneath the resources in ERROR")
+	flag.BoolVar(&dumpRaw, "dump_raw", dumpRawDefault, "print the csds responses as received, as JSON with every field, instead of the client status")
+	flag.IntVar(&maxRetries, "max_retries", maxRetriesDefault, "the number of times a request failing with a transient error is retried on a new stream, with exponential backoff (0 to disable)")
+	flag.StringVar(&sortMode, "sort", sortModeDefault, "the order of the clients in the output (e.g. id, status, type, none)")
+	flag.IntVar(&limit, "limit", limitDefault, "the maximum number of clients to print, after filtering and sorting (0 for no limit)")
+	flag.IntVar(&offset, "offset", offsetDefault, "the number of clients to skip before printing, after filtering and sorting, to page through the clients with -limit")
+	flag.StringVar(&inputFile, "input_file", inputFileDefault, "the path of a saved ClientStatusResponse to render instead of sending a request, e.g. from -dump_raw (- for stdin)")
+	flag.StringVar(&metricsAddr, "metrics_addr", metricsAddrDefault, "the address to serve the Prometheus metrics of the monitor mode on, e.g. :9090 (disabled if empty)")
+	flag.BoolVar(&timing, "timing", timingDefault, "print the dial time and the round trip time of each request to stderr, with their min, avg and max in monitor mode")
+	flag.IntVar(&concurrency, "concurrency", concurrencyDefault, "the number of workers marshaling the detailed config of large responses (0 for GOMAXPROCS)")
+	flag.BoolVar(&stream, "stream", streamDefault, "option to render the clients of each response as soon as it is received with -drain_stream, instead of once the stream is drained")
+	flag.BoolVar(&quiet, "quiet", quietDefault, "option to print nothing but errors, e.g. for a readiness probe that only checks the exit code of -fail_on")
+	flag.StringVar(&jwtEnv, "jwt_env", jwtEnvDefault, "the environment variable holding the jwt key of the jwt authentication mode, instead of -jwt_file")
+	flag.BoolVar(&dryRun, "dry_run", dryRunDefault, "option to print the csds request built from the request yaml as JSON and exit, without connecting to the server")
+	flag.DurationVar(&waitForClients, "wait_for_clients", waitForClientsDefault, "how long to keep polling while the response has no client, e.g. 30s, before printing the empty response (disabled if 0)")
+	flag.DurationVar(&waitInterval, "wait_interval", waitIntervalDefault, "the interval between the polls of -wait_for_clients (e.g. 500ms, 2s, ...)")
+	flag.BoolVar(&failOnNoClients, "fail_on_no_clients", failOnNoClientsDefault, "option to exit with code 6 if the response has no client, e.g. once -wait_for_clients times out")
+	flag.StringVar(&statusFilter, "status_filter", statusFilterDefault, "comma-separated config statuses, e.g. STALE,ERROR, to only show the resources reporting them and the clients having any")
+	flag.BoolVar(&watch, "watch", watchDefault, "option to clear the screen and redraw the client status in place each cycle of monitor mode, like the watch utility")
+	flag.BoolVar(&resolveAny, "resolve_any", resolveAnyDefault, "option to decode the google.protobuf.Any and TypedStruct configs of the detailed config, e.g. the http filters, instead of printing them in base64")
+	flag.BoolVar(&redact, "redact", redactDefault, "option to replace the values of the sensitive fields of the detailed config, e.g. the TLS private keys and the inline secrets, with ***REDACTED***")
+	flag.StringVar(&columns, "columns", columnsDefault, "ordered comma-separated columns of the text table among id, stream_type, xds, status, type_url, last_updated and client_status, e.g. id,xds,status,last_updated, empty for the default table")
+	flag.BoolVar(&fixedWidth, "fixed_width", fixedWidthDefault, "print the text table exactly as the former releases did, with the fixed-width Client ID, xDS stream type and Config Status columns only and no summary, e.g. to parse it positionally (v3 only)")
+	flag.BoolVar(&noTruncate, "no_truncate", noTruncateDefault, "do not truncate the cells of the text table wider than the maximum column width (v3 only)")
+	flag.BoolVar(&compress, "compress", compressDefault, "compress the requests and the responses with gzip, which the server must support, e.g. for large responses over slow links (v3 only)")
+	flag.IntVar(&maxRecvBytes, "max_recv_bytes", maxRecvBytesDefault, "the maximum size in bytes of a response received from the server, e.g. for the large responses of big meshes")
+	flag.DurationVar(&keepaliveInterval, "keepalive_interval", keepaliveIntervalDefault, "the time after which an idle connection to the server is pinged to keep it alive (e.g. 5m, 1h, ...)")
+	flag.DurationVar(&keepaliveTimeout, "keepalive_timeout", keepaliveTimeoutDefault, "the time waited for the server to acknowledge a keepalive ping before closing the connection (e.g. 20s, 1m, ...)")
+	flag.BoolVar(&checkConnectivity, "check_connectivity", checkConnectivityDefault, "only check that the server can be reached and authenticates a request, exiting with code 7 on an authentication failure and 8 if it is unreachable (v3 only)")
+	flag.BoolVar(&showVersion, "version", showVersionDefault, "print the version of the client, of go-control-plane, of the xDS api and of Go, and exit")
+	flag.DurationVar(&requestTimeout, "request_timeout", requestTimeoutDefault, "the timeout of the request to each endpoint of several uris once connected, an endpoint not responding within connect_timeout plus this timeout being shown as timed out (v3 only)")
+	flag.BoolVar(&excludeContents, "exclude_contents", excludeContentsDefault, "ask the server to omit the contents of the xDS resources for status-only checks, and skip the detailed config; not sent by this build, see the README (v3 only)")
+	flag.BoolVar(&showVersionInfo, "show_version", showVersionInfoDefault, "print the version_info of each resource as a Version column of the table, or a version field of the structured output formats (v3 only)")
+	flag.BoolVar(&versionSkew, "version_skew", versionSkewDefault, "print the number of distinct versions of each xDS type across the matched clients instead of the table, flagging the types whose clients are skewed (v3 only)")
+	flag.StringVar(&dumpDir, "dump_dir", dumpDirDefault, "directory to write the decoded config of each resource to, as <dir>/<client_id>/<xDS>/<resource_name>.json, instead of printing the detailed config (v3 only)")
+	flag.StringVar(&resourceName, "resource_name", resourceNameDefault, "the comma-separated patterns of the resource names to restrict the detailed config to, e.g. 0.0.0.0_8080 (v3 only)")
+	flag.StringVar(&resourceNameMode, "resource_name_mode", resourceNameModeDefault, "the filter mode of -resource_name (e.g. prefix, suffix, exact, regex, glob) (v3 only)")
+	flag.BoolVar(&lenientYaml, "lenient_yaml", lenientYamlDefault, "option to ignore the unknown fields of the request yaml, e.g. misspelled ones, instead of rejecting them (v3 only)")
+	flag.StringVar(&templateSpec, "template", templateDefault, "Go text/template to print the matched clients with instead of the table, or @<path> of the file holding it (v3 only)")
+	flag.DurationVar(&tolerateStaleFor, "tolerate_stale_for", tolerateStaleForDefault, "how long a STALE resource is tolerated by -fail_on since its last update, e.g. 5m during a rollout (disabled if 0) (v3 only)")
+	flag.StringVar(&nodeId, "node_id", nodeIdDefault, "the id of the node of the csds request, overriding the id of the node of the request yaml (v3 only)")
+	flag.IntVar(&requestRetries, "request_retries", requestRetriesDefault, "the number of times a request failing with a transient error is retried on a new stream of the same connection, before -max_retries reconnects (0 to disable) (v3 only)")
+	flag.BoolVar(&showLocality, "show_locality", showLocalityDefault, "print the region/zone/sub_zone locality of each client as a Locality column of the table, or a locality field of the structured output formats (v3 only)")
 }
 
 func main() {
 	flag.Parse()
 
+	// -version neither connects nor needs a request, so it's printed before any validation
+	if showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	// the default uri is not connected to when the response is read from -input_file
+	if inputFile != "" {
+		uriSet := false
+		flag.Visit(func(f *flag.Flag) {
+			uriSet = uriSet || f.Name == "service_uri"
+		})
+		if !uriSet {
+			uri = ""
+		}
+	}
+	// an empty -node_id would silently send the id of the request yaml instead
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "node_id" && strings.TrimSpace(nodeId) == "" {
+			log.Fatal("node_id must not be empty")
+		}
+	})
+	// the v3 only flags with a non-zero default are only passed to the v2 api version when set explicitly,
+	// so that it can reject them rather than silently ignore them
+	if apiVersion == "v2" {
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+		if !set["self_diff_fail"] {
+			selfDiffFail = false
+		}
+		if !set["drain_timeout"] {
+			drainTimeout = 0
+		}
+		if !set["probe_method"] {
+			probeMethod = ""
+		}
+		if !set["color"] {
+			color = ""
+		}
+		if !set["request_timeout"] {
+			requestTimeout = 0
+		}
+	}
+
 	clientOpts := client.ClientOptions{
-		Uri:             uri,
-		Platform:        platform,
-		AuthnMode:       authnMode,
-		RequestFile:     requestFile,
-		RequestYaml:     requestYaml,
-		Jwt:             jwt,
-		ConfigFile:      configFile,
-		MonitorInterval: monitorInterval,
-		Visualization:   visualization,
-		FilterMode:      filterMode,
-		FilterPattern:   filterPattern,
+		Uri:                uri,
+		Platform:           platform,
+		AuthnMode:          authnMode,
+		RequestFile:        requestFile,
+		RequestYaml:        requestYaml,
+		Jwt:                jwt,
+		ConfigFile:         configFile,
+		MonitorInterval:    monitorInterval,
+		Visualization:      visualization,
+		FilterMode:         filterMode,
+		FilterPattern:      filterPattern,
+		OtelEndpoint:       otelEndpoint,
+		OutputFormat:       outputFormat,
+		DrainStream:        drainStream,
+		DrainTimeout:       drainTimeout,
+		MetaMissing:        metaMissing,
+		Verbose:            verbose,
+		MonitorOutputDir:   monitorOutputDir,
+		OnlyLastCycle:      onlyLastCycle,
+		SelfDiff:           selfDiff,
+		SelfDiffFail:       selfDiffFail,
+		RouteTable:         routeTable,
+		ProbePath:          probePath,
+		ProbeMethod:        probeMethod,
+		ProbeHeaders:       probeHeaders,
+		SqliteOut:          sqliteOut,
+		AssertConsistent:   assertConsistent,
+		Transform:          transform,
+		RequestMode:        requestMode,
+		Color:              color,
+		FailOn:             failOn,
+		SummaryOnly:        summaryOnly,
+		XdsType:            xdsType,
+		FilterInvert:       filterInvert,
+		FilterIgnoreCase:   filterIgnoreCase,
+		MonitorCount:       monitorCount,
+		MonitorDiff:        monitorDiff,
+		ClientCert:         clientCert,
+		ClientKey:          clientKey,
+		CaCert:             caCert,
+		ServiceAccountFile: serviceAccountFile,
+		ConnectTimeout:     connectTimeout,
+		Proxy:              proxyUri,
+		StrictTypes:        strictTypes,
+		UTC:                utc,
+		ShowErrors:         showErrors,
+		DumpRaw:            dumpRaw,
+		MaxRetries:         maxRetries,
+		Sort:               sortMode,
+		Limit:              limit,
+		Offset:             offset,
+		InputFile:          inputFile,
+		MetricsAddr:        metricsAddr,
+		Timing:             timing,
+		Concurrency:        concurrency,
+		Stream:             stream,
+		Quiet:              quiet,
+		JwtEnv:             jwtEnv,
+		DryRun:             dryRun,
+		WaitForClients:     waitForClients,
+		WaitInterval:       waitInterval,
+		FailOnNoClients:    failOnNoClients,
+		StatusFilter:       statusFilter,
+		Watch:              watch,
+		RawAny:             !resolveAny,
+		Redact:             redact,
+		Columns:            columns,
+		FixedWidth:         fixedWidth,
+		NoTruncate:         noTruncate,
+		Compress:           compress,
+		MaxRecvBytes:       maxRecvBytes,
+		KeepaliveInterval:  keepaliveInterval,
+		KeepaliveTimeout:   keepaliveTimeout,
+		CheckConnectivity:  checkConnectivity,
+		RequestTimeout:     requestTimeout,
+		ExcludeContents:    excludeContents,
+		ShowVersion:        showVersionInfo,
+		VersionSkew:        versionSkew,
+		DumpDir:            dumpDir,
+		ResourceName:       resourceName,
+		ResourceNameMode:   resourceNameMode,
+		LenientYaml:        lenientYaml,
+		Template:           templateSpec,
+		TolerateStaleFor:   tolerateStaleFor,
+		NodeId:             nodeId,
+		RequestRetries:     requestRetries,
+		ShowLocality:       showLocality,
 	}
 
 	var c client.Client
@@ -87,7 +469,42 @@ func main() {
 		log.Fatal(err)
 	}
 
-	if err := c.Run(); err != nil {
+	shutdownTracing, err := clientutil.InitTracing(context.Background(), otelEndpoint)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// flush the traces before exiting, also when the run fails
+	err = c.Run()
+	if shutdownErr := shutdownTracing(context.Background()); shutdownErr != nil {
+		log.Printf("Failed to export traces: %v", shutdownErr)
+	}
+	if errors.Is(err, client.ErrChangesDetected) {
+		log.Print(err)
+		os.Exit(exitCodeChangesDetected)
+	}
+	if errors.Is(err, client.ErrInconsistentVersions) {
+		log.Print(err)
+		os.Exit(exitCodeInconsistentVersions)
+	}
+	if errors.Is(err, client.ErrNoClients) {
+		log.Print(err)
+		os.Exit(exitCodeNoClients)
+	}
+	if errors.Is(err, client.ErrAuthFailed) {
+		log.Print(err)
+		os.Exit(exitCodeAuthFailed)
+	}
+	if errors.Is(err, client.ErrUnreachable) {
+		log.Print(err)
+		os.Exit(exitCodeUnreachable)
+	}
+	var statusErr *client.StatusError
+	if errors.As(err, &statusErr) {
+		log.Print(err)
+		os.Exit(exitCodeStatusMatched)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,22 @@
+package main
+
+import (
+	clientutil "envoy-tools/csds-client/client/util"
+	"fmt"
+	"runtime"
+)
+
+// version is the version of the csds client, set at build time with
+// -ldflags "-X main.version=<version>", e.g. by make
+var version = "dev"
+
+// xdsApiVersion is the latest xDS transport api version supported by the client
+const xdsApiVersion = "v3"
+
+// versionString returns the line printed by -version, space-separated key=value pairs whose keys and order
+// don't change so that scripts can parse it, e.g.
+// csds-client version=v1.2.0 go-control-plane=v0.10.3 xds_api=v3 go=go1.19.4
+func versionString() string {
+	return fmt.Sprintf("csds-client version=%s go-control-plane=%s xds_api=%s go=%s",
+		version, clientutil.ModuleVersion(clientutil.GoControlPlaneModule), xdsApiVersion, runtime.Version())
+}